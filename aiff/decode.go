@@ -0,0 +1,503 @@
+package aiff
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/rickcollette/megasound"
+)
+
+func init() {
+	megasound.RegisterFormat("aiff", func(head []byte) bool {
+		return len(head) >= 12 && string(head[:4]) == "FORM" &&
+			(string(head[8:12]) == "AIFF" || string(head[8:12]) == "AIFC")
+	}, func(rc io.ReadCloser) (megasound.StreamSeekCloser, megasound.Format, error) {
+		return Decode(rc)
+	})
+}
+
+// Decode takes a Reader containing audio data in AIFF or AIFF-C format and returns a
+// StreamSeekCloser, which streams that audio. The Seek method returns an error if r is not
+// io.Seeker.
+//
+// Uncompressed AIFF-C data is supported: big-endian ("NONE", "twos", "in24", "in32") and
+// little-endian ("sowt", "23ni", "42ni") integer samples, unsigned 8 bit samples ("raw ") and 32
+// or 64 bit floating point samples ("fl32", "fl64"). As Format describes integer samples, the
+// Precision of the returned Format is 4 bytes for floating point data, and the samples are
+// converted to 32 bit integers when encoded with that Format, for example by wav.Encode.
+//
+// Do not close the supplied Reader, instead, use the Close method of the returned
+// StreamSeekCloser when you want to release the resources.
+func Decode(r io.Reader) (s megasound.StreamSeekCloser, format megasound.Format, err error) {
+	d, format, err := Open(r)
+	if err != nil {
+		return nil, megasound.Format{}, err
+	}
+	return d, format, nil
+}
+
+// Open is like Decode, but returns a *Decoder, which gives access to the markers and the
+// instrument chunk with its loops.
+//
+// Markers and instrument data stored after the sound data are only read if r is io.Seeker.
+func Open(r io.Reader) (d *Decoder, format megasound.Format, err error) {
+	d = &Decoder{r: r}
+	defer func() { // hacky way to always close r if an error occurred
+		if err != nil {
+			if closer, ok := r.(io.Closer); ok {
+				closer.Close()
+			}
+			err = pkgerrors.Wrap(err, "aiff")
+		}
+	}()
+	if err := d.readChunks(); err != nil {
+		return nil, megasound.Format{}, err
+	}
+	return d, d.format, nil
+}
+
+// LoopMode is the play mode of a Loop.
+type LoopMode int
+
+// Loop play modes.
+const (
+	NoLooping LoopMode = iota
+	ForwardLooping
+	ForwardBackwardLooping
+)
+
+// Marker is a named position in the sound data.
+type Marker struct {
+	ID       int
+	Position int // position in samples
+	Name     string
+}
+
+// Loop is a loop of an Instrument. Start and End are positions in samples, the sample at End
+// is not a part of the loop.
+type Loop struct {
+	Mode       LoopMode
+	Start, End int
+}
+
+// Instrument holds the data of the instrument chunk, which tells how to play the sound as a
+// sampled instrument.
+type Instrument struct {
+	BaseNote     int // MIDI note of the sound as recorded
+	Detune       int // in cents, -50 to 50
+	LowNote      int // lowest MIDI note to play the sound at
+	HighNote     int // highest MIDI note to play the sound at
+	LowVelocity  int
+	HighVelocity int
+	Gain         int // in decibels
+
+	// Sustain is played while the note is held, Release after the note is released.
+	Sustain, Release Loop
+}
+
+// Decoder is a StreamSeekCloser decoding AIFF and AIFF-C data. It is returned by Open.
+type Decoder struct {
+	r          io.Reader
+	format     megasound.Format
+	enc        encoding
+	frames     int   // number of samples
+	dataOffset int64 // offset of the first sample
+	markers    []Marker
+	inst       *Instrument
+	buf        []byte
+	pos        int
+	err        error
+}
+
+// encoding is a sample encoding of AIFF-C.
+type encoding struct {
+	width  int // bytes per sample
+	little bool
+	float  bool
+	signed bool
+}
+
+var compressionTypes = map[string]encoding{
+	"NONE": {signed: true},
+	"twos": {signed: true},
+	"sowt": {signed: true, little: true},
+	"raw ": {width: 1},
+	"in24": {width: 3, signed: true},
+	"in32": {width: 4, signed: true},
+	"23ni": {width: 3, signed: true, little: true},
+	"42ni": {width: 4, signed: true, little: true},
+	"fl32": {width: 4, float: true},
+	"FL32": {width: 4, float: true},
+	"fl64": {width: 8, float: true},
+	"FL64": {width: 8, float: true},
+}
+
+// readChunks reads the chunks up to the sound data, or all of them if the reader is io.Seeker,
+// and positions the reader at the first sample.
+func (d *Decoder) readChunks() error {
+	var form [12]byte
+	if _, err := io.ReadFull(d.r, form[:]); err != nil {
+		return pkgerrors.Wrap(err, "missing FORM header")
+	}
+	if string(form[:4]) != "FORM" {
+		return fmt.Errorf("missing FORM at the beginning > %s", string(form[:4]))
+	}
+	aifc := false
+	switch string(form[8:]) {
+	case "AIFF":
+	case "AIFC":
+		aifc = true
+	default:
+		return pkgerrors.New("unsupported file type")
+	}
+	formEnd := 8 + int64(binary.BigEndian.Uint32(form[4:8]))
+
+	seeker, seekable := d.r.(io.Seeker)
+	var (
+		off               = int64(len(form))
+		comm, ssnd        bool
+		markers           []Marker
+		inst              *Instrument
+		loopIDs           [2][2]int // marker IDs of the sustain and release loops
+		dataSize          int64
+		sampleBits, width int
+	)
+	for off+8 <= formEnd {
+		var ch [8]byte
+		if _, err := io.ReadFull(d.r, ch[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break // tolerate a wrong FORM size
+			}
+			return err
+		}
+		id, size := string(ch[:4]), int64(binary.BigEndian.Uint32(ch[4:]))
+		off += 8
+		next := off + size + size%2
+		consumed := size // bytes of the chunk read below
+		switch id {
+		case "COMM":
+			p, err := readChunk(d.r, size)
+			if err != nil {
+				return pkgerrors.Wrap(err, "missing common chunk body")
+			}
+			if len(p) < 18 || aifc && len(p) < 22 {
+				return pkgerrors.New("common chunk too short")
+			}
+			channels := int(int16(binary.BigEndian.Uint16(p)))
+			d.frames = int(binary.BigEndian.Uint32(p[2:]))
+			sampleBits = int(int16(binary.BigEndian.Uint16(p[6:])))
+			var ext [10]byte
+			copy(ext[:], p[8:18])
+			rate := decodeExtended(ext)
+
+			d.enc = compressionTypes["NONE"]
+			if aifc {
+				ct := string(p[18:22])
+				enc, ok := compressionTypes[ct]
+				if !ok {
+					return fmt.Errorf("unsupported compression type %q", ct)
+				}
+				d.enc = enc
+			}
+			width = d.enc.width
+			if width == 0 {
+				width = (sampleBits + 7) / 8
+			}
+			if channels <= 0 {
+				return pkgerrors.New("invalid number of channels (less than 1)")
+			}
+			if width < 1 || width > 4 && !d.enc.float {
+				return fmt.Errorf("unsupported number of bits per sample %d, 1 to 32 are supported", sampleBits)
+			}
+			if rate < 1 || rate > math.MaxInt32 {
+				return fmt.Errorf("invalid sample rate %v", rate)
+			}
+			d.enc.width = width
+			precision := width
+			if d.enc.float && precision > 4 {
+				precision = 4
+			}
+			d.format = megasound.Format{
+				SampleRate:  megasound.SampleRate(math.Round(rate)),
+				NumChannels: channels,
+				Precision:   precision,
+			}
+			comm = true
+		case "SSND":
+			if !comm {
+				return pkgerrors.New("sound data before the common chunk")
+			}
+			var h [8]byte
+			if _, err := io.ReadFull(d.r, h[:]); err != nil {
+				return pkgerrors.Wrap(err, "missing sound data chunk header")
+			}
+			skip := int64(binary.BigEndian.Uint32(h[:4])) // block alignment offset
+			if skip > 0 {
+				if _, err := io.CopyN(io.Discard, d.r, skip); err != nil {
+					return pkgerrors.Wrap(err, "missing sound data")
+				}
+			}
+			d.dataOffset = off + 8 + skip
+			dataSize = size - 8 - skip
+			ssnd = true
+			if !seekable {
+				d.limitFrames(dataSize)
+				return nil
+			}
+			// skip the sound data, markers and instrument data may follow
+			if _, err := seeker.Seek(next, io.SeekStart); err != nil {
+				return err
+			}
+			consumed = next - off
+		case "MARK":
+			p, err := readChunk(d.r, size)
+			if err != nil {
+				return pkgerrors.Wrap(err, "missing marker chunk body")
+			}
+			if markers, err = parseMarkers(p); err != nil {
+				return err
+			}
+		case "INST":
+			p, err := readChunk(d.r, size)
+			if err != nil {
+				return pkgerrors.Wrap(err, "missing instrument chunk body")
+			}
+			if len(p) < 20 {
+				return pkgerrors.New("instrument chunk too short")
+			}
+			inst = &Instrument{
+				BaseNote:     int(p[0]),
+				Detune:       int(int8(p[1])),
+				LowNote:      int(p[2]),
+				HighNote:     int(p[3]),
+				LowVelocity:  int(p[4]),
+				HighVelocity: int(p[5]),
+				Gain:         int(int16(binary.BigEndian.Uint16(p[6:]))),
+			}
+			for i, l := range []*Loop{&inst.Sustain, &inst.Release} {
+				q := p[8+6*i:]
+				l.Mode = LoopMode(binary.BigEndian.Uint16(q))
+				loopIDs[i][0] = int(int16(binary.BigEndian.Uint16(q[2:])))
+				loopIDs[i][1] = int(int16(binary.BigEndian.Uint16(q[4:])))
+			}
+		default:
+			consumed = 0
+		}
+		if _, err := io.CopyN(io.Discard, d.r, next-off-consumed); err != nil {
+			if err == io.EOF && next-off-consumed == 1 {
+				break // the last chunk without its pad byte
+			}
+			return pkgerrors.Wrap(err, "missing chunk body")
+		}
+		off = next
+	}
+	if !comm {
+		return pkgerrors.New("missing common chunk")
+	}
+	if !ssnd {
+		if d.frames > 0 {
+			return pkgerrors.New("missing sound data chunk")
+		}
+		d.dataOffset = off
+	}
+	d.limitFrames(dataSize)
+
+	d.markers = markers
+	if inst != nil {
+		for i, l := range []*Loop{&inst.Sustain, &inst.Release} {
+			start, ok1 := findMarker(markers, loopIDs[i][0])
+			end, ok2 := findMarker(markers, loopIDs[i][1])
+			if !ok1 || !ok2 || start >= end {
+				*l = Loop{}
+				continue
+			}
+			l.Start, l.End = start, end
+		}
+		d.inst = inst
+	}
+	if seekable {
+		if _, err := seeker.Seek(d.dataOffset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// limitFrames limits the number of samples to what fits in size bytes of sound data.
+func (d *Decoder) limitFrames(size int64) {
+	if n := size / int64(d.width()); n < int64(d.frames) {
+		d.frames = int(n)
+	}
+}
+
+// width returns the number of bytes per sample in all channels.
+func (d *Decoder) width() int {
+	return d.format.NumChannels * d.enc.width
+}
+
+// readChunk reads the body of a chunk of the given size.
+func readChunk(r io.Reader, size int64) ([]byte, error) {
+	if size > 1<<24 {
+		return nil, fmt.Errorf("chunk too large (%d bytes)", size)
+	}
+	p := make([]byte, size)
+	_, err := io.ReadFull(r, p)
+	return p, err
+}
+
+// parseMarkers parses the body of a marker chunk.
+func parseMarkers(p []byte) ([]Marker, error) {
+	if len(p) < 2 {
+		return nil, pkgerrors.New("marker chunk too short")
+	}
+	n := int(binary.BigEndian.Uint16(p))
+	p = p[2:]
+	markers := make([]Marker, 0, n)
+	for i := 0; i < n; i++ {
+		if len(p) < 7 {
+			return nil, pkgerrors.New("marker chunk too short")
+		}
+		m := Marker{
+			ID:       int(int16(binary.BigEndian.Uint16(p))),
+			Position: int(binary.BigEndian.Uint32(p[2:])),
+		}
+		l := int(p[6])
+		if len(p) < 7+l {
+			return nil, pkgerrors.New("marker chunk too short")
+		}
+		m.Name = string(p[7 : 7+l])
+		// the name is a Pascal string, padded to an even length including its count byte
+		p = p[7+l+(1+l)%2:]
+		markers = append(markers, m)
+	}
+	return markers, nil
+}
+
+func findMarker(markers []Marker, id int) (int, bool) {
+	for _, m := range markers {
+		if m.ID == id {
+			return m.Position, true
+		}
+	}
+	return 0, false
+}
+
+// Markers returns the markers of the sound data.
+func (d *Decoder) Markers() []Marker {
+	return d.markers
+}
+
+// Instrument returns the data of the instrument chunk, or false if there is none. Loops
+// referring to missing markers have the NoLooping mode.
+func (d *Decoder) Instrument() (Instrument, bool) {
+	if d.inst == nil {
+		return Instrument{}, false
+	}
+	return *d.inst, true
+}
+
+// Stream streams the decoded audio.
+func (d *Decoder) Stream(samples [][2]float64) (n int, ok bool) {
+	if d.err != nil || d.pos >= d.frames {
+		return 0, false
+	}
+	if remains := d.frames - d.pos; len(samples) > remains {
+		samples = samples[:remains]
+	}
+	width := d.width()
+	if size := len(samples) * width; cap(d.buf) < size {
+		d.buf = make([]byte, size)
+	}
+	p := d.buf[:len(samples)*width]
+	nb, err := io.ReadFull(d.r, p)
+	n = nb / width
+	switch {
+	case err != nil && err != io.EOF && err != io.ErrUnexpectedEOF:
+		d.err = pkgerrors.Wrap(err, "aiff")
+	case n < len(samples):
+		d.frames = d.pos + n // truncated sound data
+	}
+	right := 0
+	switch {
+	case d.format.NumChannels == 6:
+		right = 3 * d.enc.width // left, left center, center, right, ...
+	case d.format.NumChannels >= 2:
+		right = d.enc.width
+	}
+	for i := range samples[:n] {
+		frame := p[i*width:]
+		samples[i][0] = d.enc.decode(frame)
+		samples[i][1] = d.enc.decode(frame[right:])
+	}
+	d.pos += n
+	return n, n > 0
+}
+
+// decode decodes a single sample from p.
+func (e encoding) decode(p []byte) float64 {
+	var x uint64
+	for i := 0; i < e.width; i++ {
+		b := p[i]
+		if e.little {
+			b = p[e.width-1-i]
+		}
+		x = x<<8 | uint64(b)
+	}
+	switch {
+	case e.float && e.width == 4:
+		return float64(math.Float32frombits(uint32(x)))
+	case e.float:
+		return math.Float64frombits(x)
+	case !e.signed:
+		return float64(x)/(math.Exp2(float64(e.width)*8)-1)*2 - 1
+	}
+	bits := uint(e.width * 8)
+	v := int64(x<<(64-bits)) >> (64 - bits)
+	return float64(v) / (math.Exp2(float64(bits)-1) - 1)
+}
+
+// Err returns an error which occurred during decoding.
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+// Len returns the total number of samples.
+func (d *Decoder) Len() int {
+	return d.frames
+}
+
+// Position returns the current position.
+func (d *Decoder) Position() int {
+	return d.pos
+}
+
+// Seek sets the position.
+func (d *Decoder) Seek(p int) error {
+	seeker, ok := d.r.(io.Seeker)
+	if !ok {
+		return pkgerrors.New("aiff: seek: resource is not io.Seeker")
+	}
+	if p < 0 || d.Len() < p {
+		return fmt.Errorf("aiff: seek position %v out of range [%v, %v]", p, 0, d.Len())
+	}
+	if _, err := seeker.Seek(d.dataOffset+int64(p*d.width()), io.SeekStart); err != nil {
+		return pkgerrors.Wrap(err, "aiff: seek error")
+	}
+	d.pos = p
+	d.err = nil
+	return nil
+}
+
+// Close closes the underlying resource, if it is io.Closer.
+func (d *Decoder) Close() error {
+	if closer, ok := d.r.(io.Closer); ok {
+		err := closer.Close()
+		if err != nil {
+			return pkgerrors.Wrap(err, "aiff")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package aiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rickcollette/megasound"
+)
+
+// testSignal returns the value of the left and right channels of the test signal at sample i.
+func testSignal(i int) [2]float64 {
+	v := math.Sin(float64(i) / 10)
+	return [2]float64{v, -v / 2}
+}
+
+// chunk returns a chunk with the given id and body, padded to an even length.
+func chunk(id string, body ...[]byte) []byte {
+	p := bytes.Join(body, nil)
+	c := append([]byte(id), 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(c[4:], uint32(len(p)))
+	c = append(c, p...)
+	if len(p)%2 != 0 {
+		c = append(c, 0)
+	}
+	return c
+}
+
+// testAIFC returns an AIFF-C file of stereo sound data with the compression type ct, bits per
+// sample and samples frames, followed by the extra chunks.
+func testAIFC(ct string, bits, frames int, data []byte, extra ...[]byte) []byte {
+	comm := binary.BigEndian.AppendUint16(nil, 2)
+	comm = binary.BigEndian.AppendUint32(comm, uint32(frames))
+	comm = binary.BigEndian.AppendUint16(comm, uint16(bits))
+	rate := encodeExtended(48000)
+	comm = append(comm, rate[:]...)
+	comm = append(comm, ct...)
+	comm = append(comm, 0, 0) // empty compression name
+	body := [][]byte{
+		[]byte("AIFC"),
+		chunk("FVER", []byte{0xa2, 0x80, 0x51, 0x40}),
+		chunk("COMM", comm),
+		chunk("SSND", make([]byte, 8), data),
+	}
+	return chunk("FORM", append(body, extra...)...)
+}
+
+func TestExtended(t *testing.T) {
+	// 44100 Hz as written by most encoders
+	p := [10]byte{0x40, 0x0e, 0xac, 0x44, 0, 0, 0, 0, 0, 0}
+	if x := decodeExtended(p); x != 44100 {
+		t.Errorf("decoded %v, want 44100", x)
+	}
+	if q := encodeExtended(44100); q != p {
+		t.Errorf("encoded 44100 as % x, want % x", q, p)
+	}
+	for _, x := range []float64{0, 1, 8000, 11025, 22050, 48000, 96000, 192000, 0.5, -44100} {
+		if y := decodeExtended(encodeExtended(x)); y != x {
+			t.Errorf("%v decoded as %v", x, y)
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	const n = 1001
+	for _, precision := range []int{1, 2, 3, 4} {
+		for _, channels := range []int{1, 2} {
+			path := filepath.Join(t.TempDir(), "test.aiff")
+			f, err := os.Create(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			i := 0
+			s := megasound.Take(n, megasound.StreamerFunc(func(samples [][2]float64) (int, bool) {
+				for j := range samples {
+					samples[j] = testSignal(i)
+					if channels == 1 {
+						samples[j][1] = samples[j][0]
+					}
+					i++
+				}
+				return len(samples), true
+			}))
+			want := megasound.Format{SampleRate: 44100, NumChannels: channels, Precision: precision}
+			if err := Encode(f, s, want); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+
+			d, format, err := Open(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != want || d.Len() != n {
+				t.Fatalf("decoded %+v with %d samples, want %+v with %d", format, d.Len(), want, n)
+			}
+			tol := 1 / (math.Exp2(float64(precision*8-1)) - 1)
+			for _, p := range []int{500, 0} {
+				if err := d.Seek(p); err != nil {
+					t.Fatal(err)
+				}
+				buf := make([][2]float64, n)
+				k, _ := d.Stream(buf)
+				if k != n-p {
+					t.Fatalf("%d bits, %d channels: streamed %d samples after Seek(%d), want %d", precision*8, channels, k, p, n-p)
+				}
+				for j, x := range buf[:k] {
+					w := testSignal(p + j)
+					if channels == 1 {
+						w[1] = w[0]
+					}
+					if math.Abs(x[0]-w[0]) > tol || math.Abs(x[1]-w[1]) > tol {
+						t.Fatalf("%d bits, %d channels: sample %d is %v, want %v", precision*8, channels, p+j, x, w)
+					}
+				}
+			}
+			if err := d.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestAIFC(t *testing.T) {
+	const n = 100
+	for _, tc := range []struct {
+		ct        string
+		bits      int
+		precision int
+		tol       float64
+		put       func(p []byte, x float64) []byte
+	}{
+		{"sowt", 16, 2, 1.0 / 32767, func(p []byte, x float64) []byte {
+			return binary.LittleEndian.AppendUint16(p, uint16(int16(math.Round(x*32767))))
+		}},
+		{"fl32", 32, 4, 1e-7, func(p []byte, x float64) []byte {
+			return binary.BigEndian.AppendUint32(p, math.Float32bits(float32(x)))
+		}},
+		{"fl64", 64, 4, 0, func(p []byte, x float64) []byte {
+			return binary.BigEndian.AppendUint64(p, math.Float64bits(x))
+		}},
+	} {
+		var data []byte
+		for i := 0; i < n; i++ {
+			x := testSignal(i)
+			data = tc.put(tc.put(data, x[0]), x[1])
+		}
+		file := testAIFC(tc.ct, tc.bits, n, data)
+		// without io.Seeker, the chunks are only read up to the sound data
+		for _, r := range []io.Reader{bytes.NewReader(file), bytes.NewBuffer(file)} {
+			s, format, err := Decode(r)
+			if err != nil {
+				t.Fatalf("%s: %v", tc.ct, err)
+			}
+			if want := (megasound.Format{SampleRate: 48000, NumChannels: 2, Precision: tc.precision}); format != want {
+				t.Errorf("%s: decoded %+v, want %+v", tc.ct, format, want)
+			}
+			buf := make([][2]float64, 2*n)
+			k, _ := s.Stream(buf)
+			if k != n {
+				t.Fatalf("%s: streamed %d samples, want %d", tc.ct, k, n)
+			}
+			for i, x := range buf[:k] {
+				w := testSignal(i)
+				if math.Abs(x[0]-w[0]) > tc.tol || math.Abs(x[1]-w[1]) > tc.tol {
+					t.Fatalf("%s: sample %d is %v, want %v", tc.ct, i, x, w)
+				}
+			}
+		}
+	}
+}
+
+func TestMarkersInstrument(t *testing.T) {
+	mark := binary.BigEndian.AppendUint16(nil, 3)
+	for _, m := range []Marker{{1, 10, "ls"}, {2, 90, "lex"}, {3, 50, ""}} {
+		mark = binary.BigEndian.AppendUint16(mark, uint16(m.ID))
+		mark = binary.BigEndian.AppendUint32(mark, uint32(m.Position))
+		mark = append(mark, byte(len(m.Name)))
+		mark = append(mark, m.Name...)
+		if len(m.Name)%2 == 0 {
+			mark = append(mark, 0)
+		}
+	}
+	inst := []byte{
+		60, 0xfb, 0, 127, 1, 127, 0xff, 0xfa, // base note, detune, notes, velocities, gain
+		0, 1, 0, 1, 0, 2, // sustain loop: forward from marker 1 to 2
+		0, 2, 0, 3, 0, 4, // release loop: marker 4 is missing
+	}
+	file := testAIFC("NONE", 16, 100, make([]byte, 4*100), chunk("MARK", mark), chunk("INST", inst))
+	d, _, err := Open(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	markers := d.Markers()
+	if len(markers) != 3 || markers[0] != (Marker{1, 10, "ls"}) || markers[1] != (Marker{2, 90, "lex"}) || markers[2] != (Marker{3, 50, ""}) {
+		t.Errorf("markers %+v", markers)
+	}
+	in, ok := d.Instrument()
+	want := Instrument{
+		BaseNote: 60, Detune: -5, LowNote: 0, HighNote: 127, LowVelocity: 1, HighVelocity: 127, Gain: -6,
+		Sustain: Loop{ForwardLooping, 10, 90},
+	}
+	if !ok || in != want {
+		t.Errorf("instrument %+v, want %+v", in, want)
+	}
+
+	// markers after the sound data are not read without io.Seeker
+	d, _, err = Open(bytes.NewBuffer(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.Instrument(); ok || len(d.Markers()) != 0 {
+		t.Error("read chunks after the sound data of a reader without io.Seeker")
+	}
+}
+
+// failingReader fails to read once fail is set.
+type failingReader struct {
+	*bytes.Reader
+	fail bool
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.fail {
+		return 0, errors.New("read error")
+	}
+	return r.Reader.Read(p)
+}
+
+func TestSeekClearsError(t *testing.T) {
+	var data []byte
+	for i := 0; i < 100; i++ {
+		data = binary.BigEndian.AppendUint32(data, uint32(i))
+	}
+	r := &failingReader{Reader: bytes.NewReader(testAIFC("NONE", 16, 100, data))}
+	d, _, err := Open(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.fail = true
+	buf := make([][2]float64, 10)
+	if _, ok := d.Stream(buf); ok || d.Err() == nil {
+		t.Fatal("no error after a failed read")
+	}
+	r.fail = false
+	if err := d.Seek(50); err != nil {
+		t.Fatal(err)
+	}
+	if d.Err() != nil {
+		t.Errorf("Err() = %v after Seek", d.Err())
+	}
+	if n, ok := d.Stream(buf); n != len(buf) || !ok {
+		t.Fatalf("streamed %d samples after Seek", n)
+	}
+	if want := 50.0 / 32767; buf[0][1] != want {
+		t.Errorf("sample 50 is %v, want right channel %v", buf[0], want)
+	}
+}
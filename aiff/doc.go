@@ -0,0 +1,2 @@
+// Package aiff implements audio data decoding and encoding in AIFF and AIFF-C format.
+package aiff
@@ -0,0 +1,111 @@
+package aiff
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/rickcollette/megasound"
+	pkgerrors "github.com/pkg/errors"
+)
+
+// headerSize is the size of the FORM header, the common chunk and the sound data chunk header
+// written by Encode.
+const headerSize = 12 + 8 + 18 + 8 + 8
+
+// Encode writes all audio streamed from s to w in AIFF format, as big-endian signed integer
+// samples.
+//
+// Format precision must be 1, 2, 3 or 4 bytes.
+func Encode(w io.WriteSeeker, s megasound.Streamer, format megasound.Format) (err error) {
+	defer func() {
+		if err != nil {
+			err = pkgerrors.Wrap(err, "aiff")
+		}
+	}()
+
+	if format.NumChannels <= 0 {
+		return pkgerrors.New("invalid number of channels (less than 1)")
+	}
+	if format.Precision < 1 || format.Precision > 4 {
+		return pkgerrors.New("unsupported precision, 1, 2, 3 or 4 is supported")
+	}
+
+	if err := writeHeader(w, format, 0); err != nil {
+		return err
+	}
+	var (
+		bw      = bufio.NewWriter(w)
+		samples = make([][2]float64, 512)
+		buffer  = make([]byte, len(samples)*format.Width())
+		frames  int
+	)
+	for {
+		n, ok := s.Stream(samples)
+		if !ok {
+			break
+		}
+		buf := buffer
+		for _, sample := range samples[:n] {
+			buf = buf[format.EncodeSigned(buf, sample):]
+		}
+		p := buffer[:n*format.Width()]
+		// EncodeSigned writes little-endian samples
+		for i := 0; i < len(p); i += format.Precision {
+			for a, b := i, i+format.Precision-1; a < b; a, b = a+1, b-1 {
+				p[a], p[b] = p[b], p[a]
+			}
+		}
+		if _, err := bw.Write(p); err != nil {
+			return err
+		}
+		frames += n
+	}
+	dataSize := frames * format.Width()
+	if dataSize%2 != 0 {
+		if err := bw.WriteByte(0); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	// finalize header
+	if _, err := w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := writeHeader(w, format, frames); err != nil {
+		return err
+	}
+	if _, err := w.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	return nil
+}
+
+// writeHeader writes the FORM header, the common chunk and the sound data chunk header for the
+// given number of samples.
+func writeHeader(w io.Writer, format megasound.Format, frames int) error {
+	dataSize := frames * format.Width()
+	h := make([]byte, 0, headerSize)
+	be := binary.BigEndian
+	h = append(h, "FORM"...)
+	h = be.AppendUint32(h, uint32(headerSize-8+dataSize+dataSize%2))
+	h = append(h, "AIFF"...)
+
+	h = append(h, "COMM"...)
+	h = be.AppendUint32(h, 18)
+	h = be.AppendUint16(h, uint16(format.NumChannels))
+	h = be.AppendUint32(h, uint32(frames))
+	h = be.AppendUint16(h, uint16(format.Precision*8))
+	rate := encodeExtended(float64(format.SampleRate))
+	h = append(h, rate[:]...)
+
+	h = append(h, "SSND"...)
+	h = be.AppendUint32(h, uint32(8+dataSize))
+	h = be.AppendUint32(h, 0) // offset
+	h = be.AppendUint32(h, 0) // block size
+	_, err := w.Write(h)
+	return err
+}
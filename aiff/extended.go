@@ -0,0 +1,42 @@
+package aiff
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// decodeExtended decodes an IEEE 754 80 bit extended precision number, which AIFF uses for the
+// sample rate.
+func decodeExtended(p [10]byte) float64 {
+	exp := int(binary.BigEndian.Uint16(p[:2]))
+	mant := binary.BigEndian.Uint64(p[2:])
+	sign := 1.0
+	if exp&0x8000 != 0 {
+		sign = -1
+		exp &= 0x7fff
+	}
+	switch {
+	case exp == 0 && mant == 0:
+		return 0
+	case exp == 0x7fff:
+		return sign * math.Inf(1)
+	}
+	return sign * math.Ldexp(float64(mant), exp-16383-63)
+}
+
+// encodeExtended encodes x as an IEEE 754 80 bit extended precision number.
+func encodeExtended(x float64) (p [10]byte) {
+	var sign uint16
+	if x < 0 {
+		sign = 0x8000
+		x = -x
+	}
+	if x == 0 {
+		binary.BigEndian.PutUint16(p[:2], sign)
+		return p
+	}
+	frac, exp := math.Frexp(x) // x = frac * 2^exp, 0.5 <= frac < 1
+	binary.BigEndian.PutUint16(p[:2], sign|uint16(exp-1+16383))
+	binary.BigEndian.PutUint64(p[2:], uint64(math.Ldexp(frac, 64)))
+	return p
+}
@@ -0,0 +1,575 @@
+package alac
+
+import (
+	"encoding/binary"
+	"math/bits"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+// config is the ALACSpecificConfig, the "magic cookie" of an ALAC stream.
+type config struct {
+	frameLength   uint32 // samples per frame
+	bitDepth      uint
+	pb, mb, kb    uint32 // adaptive Golomb coding parameters
+	numChannels   int
+	maxRun        uint16
+	maxFrameBytes uint32
+	avgBitRate    uint32
+	sampleRate    uint32
+}
+
+// parseConfig parses the ALACSpecificConfig in p. The version and flags of the "alac" box may
+// precede it.
+func parseConfig(p []byte) (config, error) {
+	if len(p) >= 28 && binary.BigEndian.Uint32(p) == 0 {
+		p = p[4:] // version and flags, the frame length is never 0
+	}
+	if len(p) < 24 {
+		return config{}, pkgerrors.New("invalid codec configuration")
+	}
+	be := binary.BigEndian
+	c := config{
+		frameLength:   be.Uint32(p),
+		bitDepth:      uint(p[5]),
+		pb:            uint32(p[6]),
+		mb:            uint32(p[7]),
+		kb:            uint32(p[8]),
+		numChannels:   int(p[9]),
+		maxRun:        be.Uint16(p[10:]),
+		maxFrameBytes: be.Uint32(p[12:]),
+		avgBitRate:    be.Uint32(p[16:]),
+		sampleRate:    be.Uint32(p[20:]),
+	}
+	switch {
+	case p[4] != 0:
+		return config{}, pkgerrors.Errorf("unsupported version %d", p[4])
+	case c.bitDepth != 16 && c.bitDepth != 20 && c.bitDepth != 24 && c.bitDepth != 32:
+		return config{}, pkgerrors.Errorf("unsupported bit depth %d", c.bitDepth)
+	case c.numChannels < 1 || c.numChannels > 8:
+		return config{}, pkgerrors.Errorf("unsupported number of channels %d", c.numChannels)
+	case c.frameLength == 0 || c.frameLength > 1<<16:
+		return config{}, pkgerrors.Errorf("invalid frame length %d", c.frameLength)
+	case c.kb > 32:
+		return config{}, pkgerrors.New("invalid codec configuration")
+	}
+	return c, nil
+}
+
+// Element types of a frame.
+const (
+	idSCE = 0 // single channel element
+	idCPE = 1 // channel pair element
+	idCCE = 2 // coupling channel element
+	idLFE = 3 // LFE channel element
+	idDSE = 4 // data stream element
+	idPCE = 5 // program config element
+	idFIL = 6 // fill element
+	idEND = 7
+)
+
+// packetDecoder decodes ALAC packets, each holding a frame.
+type packetDecoder struct {
+	c           config
+	mixU, mixV  []int32
+	predictor   []int32
+	shiftBuffer []uint16
+	coefsU      [32]int16
+	coefsV      [32]int16
+}
+
+func newPacketDecoder(c config) *packetDecoder {
+	n := int(c.frameLength)
+	return &packetDecoder{
+		c:           c,
+		mixU:        make([]int32, n),
+		mixV:        make([]int32, n),
+		predictor:   make([]int32, n),
+		shiftBuffer: make([]uint16, 2*n),
+	}
+}
+
+// decode decodes the frame in packet to out, which holds a slice of at least frameLength
+// samples for each channel. The samples are signed integers of the bit depth of the stream. It
+// returns the number of samples per channel.
+func (d *packetDecoder) decode(packet []byte, out [][]int32) (int, error) {
+	br := newBitReader(packet)
+	ch := 0
+	numSamples := 0
+	for ch < d.c.numChannels {
+		if br.overrun() {
+			return 0, pkgerrors.New("truncated frame")
+		}
+		switch tag := br.read(3); tag {
+		case idSCE, idLFE:
+			n, err := d.decodeMono(br, out[ch])
+			if err != nil {
+				return 0, err
+			}
+			numSamples = n
+			ch++
+		case idCPE:
+			if ch+1 >= d.c.numChannels {
+				return 0, pkgerrors.New("invalid channel pair element")
+			}
+			n, err := d.decodeStereo(br, out[ch], out[ch+1])
+			if err != nil {
+				return 0, err
+			}
+			numSamples = n
+			ch += 2
+		case idDSE:
+			br.read(4) // element instance tag
+			align := br.read(1)
+			count := br.read(8)
+			if count == 255 {
+				count += br.read(8)
+			}
+			if align != 0 {
+				br.align()
+			}
+			br.pos += uint(count) * 8
+		case idFIL:
+			count := br.read(4)
+			if count == 15 {
+				count += br.read(8) - 1
+			}
+			br.pos += uint(count) * 8
+		case idEND:
+			br.align()
+			if ch == 0 {
+				return 0, pkgerrors.New("empty frame")
+			}
+			return numSamples, nil
+		default:
+			return 0, pkgerrors.Errorf("unsupported element type %d", tag)
+		}
+	}
+	if br.overrun() {
+		return 0, pkgerrors.New("truncated frame")
+	}
+	return numSamples, nil
+}
+
+// header is the header of a single channel or channel pair element.
+type header struct {
+	numSamples   int
+	bytesShifted uint
+	escape       bool // the samples are not compressed
+}
+
+func (d *packetDecoder) readHeader(br *bitReader) (header, error) {
+	br.read(4) // element instance tag
+	if br.read(12) != 0 {
+		return header{}, pkgerrors.New("invalid element header")
+	}
+	b := br.read(4)
+	h := header{
+		numSamples:   int(d.c.frameLength),
+		bytesShifted: uint(b>>1) & 3,
+		escape:       b&1 != 0,
+	}
+	if b>>3 != 0 { // partial frame
+		h.numSamples = int(br.read(16)<<16 | br.read(16))
+	}
+	if h.numSamples > int(d.c.frameLength) {
+		return header{}, pkgerrors.New("invalid number of samples")
+	}
+	if h.bytesShifted == 3 || h.bytesShifted*8 >= d.c.bitDepth {
+		return header{}, pkgerrors.New("invalid element header")
+	}
+	return h, nil
+}
+
+// channelParams are the prediction parameters of a compressed channel.
+type channelParams struct {
+	mode     uint32
+	denShift uint
+	pbFactor uint32
+	numCoefs int
+	coefs    []int16
+}
+
+func (d *packetDecoder) readParams(br *bitReader, coefs *[32]int16) channelParams {
+	var p channelParams
+	b := br.read(8)
+	p.mode, p.denShift = b>>4, uint(b&0xf)
+	b = br.read(8)
+	p.pbFactor, p.numCoefs = b>>5, int(b&0x1f)
+	for i := 0; i < p.numCoefs; i++ {
+		coefs[i] = int16(br.read(16))
+	}
+	p.coefs = coefs[:p.numCoefs]
+	return p
+}
+
+// decompress decodes the residuals of a channel and runs the predictor, writing the samples to
+// out.
+func (d *packetDecoder) decompress(br *bitReader, p channelParams, n int, chanBits uint, out []int32) error {
+	ag := agParams{
+		mb: d.c.mb,
+		pb: d.c.pb * p.pbFactor / 4,
+		kb: d.c.kb,
+		wb: 1<<d.c.kb - 1,
+	}
+	if err := ag.decompress(br, d.predictor[:n], chanBits); err != nil {
+		return err
+	}
+	if p.mode != 0 {
+		// a first order predictor was run before the adaptive one
+		unpcBlock(d.predictor[:n], d.predictor[:n], nil, 31, chanBits, 0)
+	}
+	unpcBlock(d.predictor[:n], out[:n], p.coefs, p.numCoefs, chanBits, p.denShift)
+	return nil
+}
+
+func (d *packetDecoder) decodeMono(br *bitReader, out []int32) (int, error) {
+	h, err := d.readHeader(br)
+	if err != nil {
+		return 0, err
+	}
+	n := h.numSamples
+	if !h.escape {
+		chanBits := d.c.bitDepth - h.bytesShifted*8
+		br.read(16) // mix bits and mix residual, unused for a single channel
+		p := d.readParams(br, &d.coefsU)
+		shiftPos := br.pos
+		br.pos += h.bytesShifted * 8 * uint(n)
+		if err := d.decompress(br, p, n, chanBits, d.mixU); err != nil {
+			return 0, err
+		}
+		if h.bytesShifted != 0 {
+			pos := br.pos
+			br.pos = shiftPos
+			for i := 0; i < n; i++ {
+				d.shiftBuffer[i] = uint16(br.read(h.bytesShifted * 8))
+			}
+			br.pos = pos
+		}
+	} else {
+		readVerbatim(br, d.mixU[:n], nil, d.c.bitDepth)
+		h.bytesShifted = 0
+	}
+	shift := h.bytesShifted * 8
+	for i := 0; i < n; i++ {
+		v := d.mixU[i]
+		if shift != 0 {
+			v = v<<shift | int32(d.shiftBuffer[i])
+		}
+		out[i] = v
+	}
+	return n, nil
+}
+
+func (d *packetDecoder) decodeStereo(br *bitReader, left, right []int32) (int, error) {
+	h, err := d.readHeader(br)
+	if err != nil {
+		return 0, err
+	}
+	n := h.numSamples
+	var mixBits uint
+	var mixRes int32
+	if !h.escape {
+		chanBits := d.c.bitDepth - h.bytesShifted*8 + 1
+		if chanBits > 32 {
+			return 0, pkgerrors.New("unsupported sample size")
+		}
+		mixBits, mixRes = uint(br.read(8)), int32(int8(br.read(8)))
+		pu := d.readParams(br, &d.coefsU)
+		pv := d.readParams(br, &d.coefsV)
+		shiftPos := br.pos
+		br.pos += h.bytesShifted * 8 * 2 * uint(n)
+		if err := d.decompress(br, pu, n, chanBits, d.mixU); err != nil {
+			return 0, err
+		}
+		if err := d.decompress(br, pv, n, chanBits, d.mixV); err != nil {
+			return 0, err
+		}
+		if h.bytesShifted != 0 {
+			pos := br.pos
+			br.pos = shiftPos
+			for i := 0; i < 2*n; i++ {
+				d.shiftBuffer[i] = uint16(br.read(h.bytesShifted * 8))
+			}
+			br.pos = pos
+		}
+	} else {
+		readVerbatim(br, d.mixU[:n], d.mixV[:n], d.c.bitDepth)
+		h.bytesShifted = 0
+	}
+	shift := h.bytesShifted * 8
+	for i := 0; i < n; i++ {
+		u, v := d.mixU[i], d.mixV[i]
+		l, r := u, v
+		if mixRes != 0 {
+			l = u + v - (mixRes*v)>>mixBits
+			r = l - v
+		}
+		if shift != 0 {
+			l = l<<shift | int32(d.shiftBuffer[2*i])
+			r = r<<shift | int32(d.shiftBuffer[2*i+1])
+		}
+		left[i], right[i] = l, r
+	}
+	return n, nil
+}
+
+// readVerbatim reads uncompressed samples of one or two (interleaved) channels.
+func readVerbatim(br *bitReader, u, v []int32, chanBits uint) {
+	shift := 32 - chanBits
+	read := func() int32 {
+		if chanBits <= 16 {
+			return int32(br.read(chanBits)) << shift >> shift
+		}
+		x := int32(br.read(16)) << 16 >> shift
+		return x | int32(br.read(chanBits-16))
+	}
+	for i := range u {
+		u[i] = read()
+		if v != nil {
+			v[i] = read()
+		}
+	}
+}
+
+// Adaptive Golomb coding constants.
+const (
+	qbShift       = 9
+	qb            = 1 << qbShift
+	mmulShift     = 2
+	mdenShift     = qbShift - mmulShift - 1
+	moff          = 1 << (mdenShift - 2)
+	bitOff        = 24
+	maxPrefix     = 9
+	maxMeanClamp  = 0xffff
+	meanClampVal  = 0xffff
+	maxRunEscape  = 65535
+	escapeRunBits = 16
+)
+
+// agParams are the parameters of the adaptive Golomb decoder.
+type agParams struct {
+	mb, pb, kb, wb uint32
+}
+
+// decompress decodes len(out) residuals of chanBits bits each.
+func (p *agParams) decompress(br *bitReader, out []int32, chanBits uint) error {
+	var (
+		mb    = p.mb
+		zmode uint32
+		n     = len(out)
+	)
+	for c := 0; c < n; {
+		if br.overrun() {
+			return pkgerrors.New("truncated frame")
+		}
+		k := lg3a(mb >> qbShift)
+		if k > p.kb {
+			k = p.kb
+		}
+		m := uint32(1)<<k - 1
+		x := br.readRice(m, k, chanBits)
+
+		// the least significant bit is the sign
+		nd := x + zmode
+		sign := -int32(nd&1) | 1
+		out[c] = int32((nd+1)>>1) * sign
+		c++
+
+		mb = p.pb*(x+zmode) + mb - (p.pb*mb)>>qbShift
+		if x > maxMeanClamp {
+			mb = meanClampVal
+		}
+		zmode = 0
+
+		if mb<<mmulShift < qb && c < n {
+			// run of zeros
+			zmode = 1
+			k := uint32(bits.LeadingZeros32(mb)) - bitOff + (mb+moff)>>mdenShift
+			mz := (uint32(1)<<k - 1) & p.wb
+			run := int(br.readRun(mz, k))
+			if c+run > n {
+				return pkgerrors.New("invalid zero run")
+			}
+			for j := 0; j < run; j++ {
+				out[c] = 0
+				c++
+			}
+			if run >= maxRunEscape {
+				zmode = 0
+			}
+			mb = 0
+		}
+	}
+	return nil
+}
+
+// lg3a returns floor(log2(x+3)).
+func lg3a(x uint32) uint32 {
+	return 31 - uint32(bits.LeadingZeros32(x+3))
+}
+
+// unpcBlock runs the adaptive linear predictor over the residuals in pc, writing the samples
+// to out. pc and out may be the same slice if numActive is 31, which selects a first order
+// predictor. coefs are adapted in place.
+func unpcBlock(pc, out []int32, coefs []int16, numActive int, chanBits, denShift uint) {
+	num := len(pc)
+	if num == 0 {
+		return
+	}
+	chanShift := 32 - chanBits
+	var denHalf int32
+	if denShift > 0 {
+		denHalf = 1 << (denShift - 1)
+	}
+
+	out[0] = pc[0]
+	switch numActive {
+	case 0:
+		copy(out[1:num], pc[1:num])
+		return
+	case 31:
+		prev := out[0]
+		for j := 1; j < num; j++ {
+			del := pc[j] + prev
+			prev = del << chanShift >> chanShift
+			out[j] = prev
+		}
+		return
+	}
+
+	for j := 1; j <= numActive && j < num; j++ {
+		del := pc[j] + out[j-1]
+		out[j] = del << chanShift >> chanShift
+	}
+	lim := numActive + 1
+	for j := lim; j < num; j++ {
+		var sum int32
+		top := out[j-lim]
+		for k := 0; k < numActive; k++ {
+			sum += int32(coefs[k]) * (out[j-1-k] - top)
+		}
+		del := pc[j]
+		del0 := del
+		sg := sign(del)
+		del += top + (sum+denHalf)>>denShift
+		out[j] = del << chanShift >> chanShift
+
+		switch {
+		case sg > 0:
+			for k := numActive - 1; k >= 0; k-- {
+				dd := top - out[j-1-k]
+				sgn := sign(dd)
+				coefs[k] -= int16(sgn)
+				del0 -= int32(numActive-k) * ((sgn * dd) >> denShift)
+				if del0 <= 0 {
+					break
+				}
+			}
+		case sg < 0:
+			for k := numActive - 1; k >= 0; k-- {
+				dd := top - out[j-1-k]
+				sgn := sign(dd)
+				coefs[k] += int16(sgn)
+				del0 -= int32(numActive-k) * ((-sgn * dd) >> denShift)
+				if del0 >= 0 {
+					break
+				}
+			}
+		}
+	}
+}
+
+func sign(x int32) int32 {
+	switch {
+	case x > 0:
+		return 1
+	case x < 0:
+		return -1
+	}
+	return 0
+}
+
+// bitReader reads a big-endian bit stream.
+type bitReader struct {
+	p   []byte // the data padded with zeros
+	n   uint   // number of bits of the data
+	pos uint
+}
+
+func newBitReader(p []byte) *bitReader {
+	q := make([]byte, len(p)+8)
+	copy(q, p)
+	return &bitReader{p: q, n: uint(len(p)) * 8}
+}
+
+// overrun reports whether the reader is past the end of the data.
+func (br *bitReader) overrun() bool {
+	return br.pos > br.n
+}
+
+// peek returns the next 32 bits.
+func (br *bitReader) peek() uint32 {
+	i := br.pos >> 3
+	if i+8 > uint(len(br.p)) {
+		return 0
+	}
+	return uint32(binary.BigEndian.Uint64(br.p[i:]) << (br.pos & 7) >> 32)
+}
+
+// read reads n bits, n <= 32.
+func (br *bitReader) read(n uint) uint32 {
+	if n == 0 {
+		return 0
+	}
+	x := br.peek() >> (32 - n)
+	br.pos += n
+	return x
+}
+
+func (br *bitReader) align() {
+	br.pos = (br.pos + 7) &^ 7
+}
+
+// readRice reads a residual coded with parameter k, m is 2^k-1. Escaped values have
+// escapeBits bits.
+func (br *bitReader) readRice(m, k uint32, escapeBits uint) uint32 {
+	stream := br.peek()
+	pre := uint32(bits.LeadingZeros32(^stream))
+	if pre >= maxPrefix {
+		br.pos += maxPrefix
+		return br.read(escapeBits)
+	}
+	br.pos += uint(pre) + 1
+	if k == 1 {
+		return pre
+	}
+	v := stream << (pre + 1) >> (32 - k)
+	br.pos += uint(k) - 1
+	x := pre * m
+	if v >= 2 {
+		x += v - 1
+		br.pos++
+	}
+	return x
+}
+
+// readRun reads the length of a zero run coded with parameter k, m is 2^k-1 limited to the
+// maximum code value.
+func (br *bitReader) readRun(m, k uint32) uint32 {
+	stream := br.peek()
+	pre := uint32(bits.LeadingZeros32(^stream))
+	if pre >= maxPrefix {
+		br.pos += maxPrefix
+		return br.read(escapeRunBits)
+	}
+	br.pos += uint(pre) + 1
+	v := stream << (pre + 1) >> (32 - k)
+	br.pos += uint(k)
+	x := pre*m + v - 1
+	if v < 2 {
+		x -= v - 1
+		br.pos--
+	}
+	return x
+}
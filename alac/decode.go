@@ -0,0 +1,316 @@
+package alac
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/rickcollette/megasound"
+	"github.com/rickcollette/megasound/internal/mp4"
+	"github.com/rickcollette/megasound/tags"
+)
+
+func init() {
+	megasound.RegisterFormat("alac", match, func(rc io.ReadCloser) (megasound.StreamSeekCloser, megasound.Format, error) {
+		return Decode(rc)
+	})
+}
+
+// match reports whether head starts with an MP4 ftyp box and holds the sample descriptions of
+// an ALAC track. Files with their moov box after the media data are not recognised, as the
+// track can't be told from AAC or other codecs in the head; decode those with Decode.
+func match(head []byte) bool {
+	if len(head) < 12 || string(head[4:8]) != "ftyp" {
+		return false
+	}
+	i := bytes.Index(head, []byte("stsd"))
+	return i >= 0 && bytes.Contains(head[i:], []byte("alac"))
+}
+
+// Decode takes a Reader containing an Apple Lossless (ALAC) track in an MP4 file, usually with
+// the .m4a extension, and returns a StreamSeekCloser, which streams that audio. The Seek method
+// returns an error if r is not io.Seeker.
+//
+// The returned Format reports the channel count and bit depth of the stream. Mono streams are
+// streamed with both channels equal, only the front left and right channels of streams with
+// more channels are streamed. Seeking is sample accurate.
+//
+// If r is not io.Seeker, the moov box holding the sample tables must come before the media
+// data, as in files optimized for streaming.
+//
+// The returned StreamSeekCloser implements tags.Tagger, its Tags method returns the iTunes
+// metadata of the file.
+//
+// Do not close the supplied Reader, instead, use the Close method of the returned
+// StreamSeekCloser when you want to release the resources.
+func Decode(r io.Reader) (s megasound.StreamSeekCloser, format megasound.Format, err error) {
+	d, format, err := Open(r)
+	if err != nil {
+		return nil, megasound.Format{}, err
+	}
+	return d, format, nil
+}
+
+// Open is like Decode, but returns a *Decoder, which gives access to the stream parameters.
+func Open(r io.Reader) (d *Decoder, format megasound.Format, err error) {
+	d = &Decoder{r: r}
+	defer func() { // hacky way to always close r if an error occurred
+		if err != nil {
+			if closer, ok := r.(io.Closer); ok {
+				closer.Close()
+			}
+			err = pkgerrors.Wrap(err, "alac")
+		}
+	}()
+
+	var f *mp4.File
+	if rs, ok := r.(io.ReadSeeker); ok {
+		d.rs = rs
+		d.start, err = rs.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, megasound.Format{}, err
+		}
+		if d.tags, err = tags.Read(rs); err != nil {
+			d.tags = tags.Tags{}
+		}
+		if _, err := rs.Seek(d.start, io.SeekStart); err != nil {
+			return nil, megasound.Format{}, err
+		}
+		if f, err = mp4.Read(rs); err != nil {
+			return nil, megasound.Format{}, err
+		}
+	} else {
+		// the boxes read for the sample tables are parsed again for the tags
+		var head bytes.Buffer
+		if f, err = mp4.Read(io.TeeReader(r, &head)); err != nil {
+			return nil, megasound.Format{}, err
+		}
+		d.off = int64(head.Len())
+		d.tags, _ = tags.Read(bytes.NewReader(head.Bytes()))
+	}
+
+	for _, t := range f.Tracks {
+		if t.Handler == "soun" && t.Entry.Format == "alac" {
+			d.track = t
+			break
+		}
+	}
+	if d.track == nil {
+		return nil, megasound.Format{}, pkgerrors.New("no ALAC track found")
+	}
+	c, err := parseConfig(d.track.Entry.Boxes["alac"])
+	if err != nil {
+		return nil, megasound.Format{}, err
+	}
+	if c.sampleRate == 0 {
+		c.sampleRate = d.track.Entry.SampleRate
+	}
+	if d.track.Timescale == 0 || c.sampleRate == 0 {
+		return nil, megasound.Format{}, pkgerrors.New("invalid sample rate")
+	}
+	d.c = c
+	d.dec = newPacketDecoder(c)
+	d.out = make([][]int32, c.numChannels)
+	for i := range d.out {
+		d.out[i] = make([]int32, c.frameLength)
+	}
+	d.scale = 1 / float64(int64(1)<<(c.bitDepth-1))
+	// uncompressed frames with their element headers are the largest
+	d.maxPacket = int64(c.frameLength)*int64(c.numChannels)*4 + 1024
+
+	// ALAC channel layouts: C L R Ls Rs ... for 3 to 7 channels, C Lc Rc L R ... for 8
+	switch {
+	case c.numChannels == 1:
+	case c.numChannels == 2:
+		d.right = 1
+	case c.numChannels < 8:
+		d.left, d.right = 1, 2
+	default:
+		d.left, d.right = 3, 4
+	}
+
+	d.starts = make([]int, len(d.track.Samples)+1)
+	for i, s := range d.track.Samples {
+		d.starts[i] = d.toSamples(s.Time)
+	}
+	if n := len(d.track.Samples); n > 0 {
+		last := d.track.Samples[n-1]
+		d.starts[n] = d.toSamples(last.Time + uint64(last.Duration))
+	}
+	d.length = d.starts[len(d.starts)-1]
+
+	format = megasound.Format{
+		SampleRate:  megasound.SampleRate(c.sampleRate),
+		NumChannels: c.numChannels,
+		Precision:   int(c.bitDepth+7) / 8,
+	}
+	return d, format, nil
+}
+
+// Decoder is an ALAC decoder returned by Open.
+type Decoder struct {
+	r     io.Reader
+	rs    io.ReadSeeker // nil if the source is not seekable
+	start int64         // position of rs at the start of the file
+	off   int64         // offset of r if it is not seekable
+	track *mp4.Track
+	c     config
+	dec   *packetDecoder
+	tags  tags.Tags
+
+	maxPacket int64
+
+	left, right int // streamed channels
+	scale       float64
+
+	starts []int // first sample of each packet, and the length
+	packet int   // next packet to decode
+	out    [][]int32
+	buf    int // position in out
+	bufLen int
+
+	pos, length int
+	err         error
+}
+
+// toSamples converts a time in units of the track timescale to samples.
+func (d *Decoder) toSamples(t uint64) int {
+	if d.track.Timescale == d.c.sampleRate {
+		return int(t)
+	}
+	return int(t * uint64(d.c.sampleRate) / uint64(d.track.Timescale))
+}
+
+// BitDepth returns the bit depth of the stream, 16, 20, 24 or 32.
+func (d *Decoder) BitDepth() int {
+	return int(d.c.bitDepth)
+}
+
+// Tags returns the iTunes metadata of the file.
+func (d *Decoder) Tags() tags.Tags {
+	return d.tags
+}
+
+func (d *Decoder) Stream(samples [][2]float64) (n int, ok bool) {
+	if d.err != nil {
+		return 0, false
+	}
+	for n < len(samples) && d.pos < d.length {
+		if d.buf >= d.bufLen {
+			if !d.decodePacket() {
+				break
+			}
+			continue
+		}
+		k := d.bufLen - d.buf
+		if k > len(samples)-n {
+			k = len(samples) - n
+		}
+		if k > d.length-d.pos {
+			k = d.length - d.pos
+		}
+		l, r := d.out[d.left][d.buf:], d.out[d.right][d.buf:]
+		for i := 0; i < k; i++ {
+			samples[n+i][0] = float64(l[i]) * d.scale
+			samples[n+i][1] = float64(r[i]) * d.scale
+		}
+		n += k
+		d.buf += k
+		d.pos += k
+	}
+	return n, n > 0
+}
+
+// decodePacket decodes the next packet. It returns false at the end of the stream or on
+// error.
+func (d *Decoder) decodePacket() bool {
+	if d.packet >= len(d.track.Samples) {
+		return false
+	}
+	s := d.track.Samples[d.packet]
+	if int64(s.Size) > d.maxPacket {
+		d.err = pkgerrors.New("alac: invalid packet size")
+		return false
+	}
+	p := make([]byte, s.Size)
+	if d.rs != nil {
+		if _, err := d.rs.Seek(d.start+s.Offset, io.SeekStart); err != nil {
+			d.err = pkgerrors.Wrap(err, "alac")
+			return false
+		}
+	} else {
+		if s.Offset < d.off {
+			d.err = pkgerrors.New("alac: packets out of order require io.Seeker")
+			return false
+		}
+		if _, err := io.CopyN(io.Discard, d.r, s.Offset-d.off); err != nil {
+			d.err = pkgerrors.Wrap(err, "alac")
+			return false
+		}
+		d.off = s.Offset + int64(s.Size)
+	}
+	if _, err := io.ReadFull(d.r, p); err != nil {
+		d.err = pkgerrors.Wrap(err, "alac")
+		return false
+	}
+	n, err := d.dec.decode(p, d.out)
+	if err != nil {
+		d.err = pkgerrors.Wrap(err, "alac")
+		return false
+	}
+	d.packet++
+	d.buf, d.bufLen = 0, n
+	return true
+}
+
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+func (d *Decoder) Len() int {
+	return d.length
+}
+
+func (d *Decoder) Position() int {
+	return d.pos
+}
+
+func (d *Decoder) Seek(p int) error {
+	if p < 0 || d.length < p {
+		return fmt.Errorf("alac: seek position %v out of range [%v, %v]", p, 0, d.length)
+	}
+	if d.rs == nil {
+		return pkgerrors.New("alac: seek: resource is not io.Seeker")
+	}
+	// the last packet starting at or before p
+	i := sort.Search(len(d.track.Samples), func(i int) bool { return d.starts[i] > p }) - 1
+	if i < 0 {
+		i = 0
+	}
+	d.packet, d.buf, d.bufLen = i, 0, 0
+	d.pos = p
+	d.err = nil
+	if p == d.length {
+		d.packet = len(d.track.Samples)
+		return nil
+	}
+	if !d.decodePacket() {
+		return d.err
+	}
+	d.buf = p - d.starts[i]
+	if d.buf > d.bufLen {
+		d.buf = d.bufLen
+	}
+	return nil
+}
+
+func (d *Decoder) Close() error {
+	if closer, ok := d.r.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			return pkgerrors.Wrap(err, "alac")
+		}
+	}
+	return nil
+}
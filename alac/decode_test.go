@@ -0,0 +1,165 @@
+package alac
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// bitWriter writes big-endian bit fields.
+type bitWriter struct {
+	p   []byte
+	pos uint
+}
+
+func (w *bitWriter) write(v uint32, n uint) {
+	for i := int(n) - 1; i >= 0; i-- {
+		if w.pos/8 >= uint(len(w.p)) {
+			w.p = append(w.p, 0)
+		}
+		if v>>uint(i)&1 != 0 {
+			w.p[w.pos/8] |= 0x80 >> (w.pos % 8)
+		}
+		w.pos++
+	}
+}
+
+// testPacket returns an ALAC packet holding the stereo samples l and r uncompressed, in a
+// channel pair element with the escape flag set.
+func testPacket(c config, l, r []int32) []byte {
+	w := &bitWriter{}
+	w.write(idCPE, 3)
+	w.write(0, 4+12)
+	partial := uint32(0)
+	if len(l) != int(c.frameLength) {
+		partial = 1
+	}
+	w.write(partial<<3|1, 4)
+	if partial != 0 {
+		w.write(uint32(len(l)), 32)
+	}
+	for i := range l {
+		w.write(uint32(l[i])&(1<<c.bitDepth-1), c.bitDepth)
+		w.write(uint32(r[i])&(1<<c.bitDepth-1), c.bitDepth)
+	}
+	w.write(idEND, 3)
+	return w.p
+}
+
+func box(typ string, parts ...[]byte) []byte {
+	b := make([]byte, 8)
+	copy(b[4:], typ)
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+	return b
+}
+
+func u32(xs ...uint32) []byte {
+	var b []byte
+	for _, x := range xs {
+		b = binary.BigEndian.AppendUint32(b, x)
+	}
+	return b
+}
+
+// testFile returns an M4A file with a stereo 16 bit ALAC track of total samples, the moov box
+// first and each packet in its own chunk, and the samples of its channels.
+func testFile(total int) ([]byte, [2][]int32) {
+	c := config{frameLength: 4096, bitDepth: 16, pb: 40, mb: 10, kb: 14, numChannels: 2, maxRun: 255, sampleRate: 44100}
+	var sig [2][]int32
+	for i := 0; i < total; i++ {
+		sig[0] = append(sig[0], int32(i%30000-15000))
+		sig[1] = append(sig[1], int32(15000-i%20000))
+	}
+	var packets [][]byte
+	var sizes []byte
+	for i := 0; i < total; i += int(c.frameLength) {
+		end := i + int(c.frameLength)
+		if end > total {
+			end = total
+		}
+		p := testPacket(c, sig[0][i:end], sig[1][i:end])
+		packets = append(packets, p)
+		sizes = append(sizes, u32(uint32(len(p)))...)
+	}
+	last := total - (len(packets)-1)*int(c.frameLength)
+	moov := func(offset uint32) []byte {
+		stco := u32(0, uint32(len(packets)))
+		for _, p := range packets {
+			stco = append(stco, u32(offset)...)
+			offset += uint32(len(p))
+		}
+		cookie := u32(c.frameLength)
+		cookie = append(cookie, 0, byte(c.bitDepth), byte(c.pb), byte(c.mb), byte(c.kb), byte(c.numChannels))
+		cookie = binary.BigEndian.AppendUint16(cookie, c.maxRun)
+		cookie = append(cookie, u32(c.maxFrameBytes, c.avgBitRate, c.sampleRate)...)
+		entry := make([]byte, 6)
+		entry = append(entry, 0, 1) // data reference index
+		entry = append(entry, u32(0, 0)...)
+		entry = append(entry, 0, byte(c.numChannels), 0, 16, 0, 0, 0, 0)
+		entry = append(entry, u32(c.sampleRate<<16)...)
+		entry = append(entry, box("alac", u32(0), cookie)...)
+		stbl := box("stbl",
+			box("stsd", u32(0, 1), box("alac", entry)),
+			box("stts", u32(0, 2, uint32(len(packets)-1), c.frameLength, 1, uint32(last))),
+			box("stsc", u32(0, 1, 1, 1, 1)),
+			box("stsz", u32(0, 0, uint32(len(packets))), sizes),
+			box("stco", stco),
+		)
+		mdhd := append(u32(0, 0, 0, c.sampleRate, uint32(total)), 0, 0, 0, 0)
+		return box("moov", box("trak",
+			box("tkhd", make([]byte, 84)),
+			box("mdia",
+				box("mdhd", mdhd),
+				box("hdlr", u32(0, 0), []byte("soun"), make([]byte, 13)),
+				box("minf", stbl),
+			),
+		))
+	}
+	ftyp := box("ftyp", []byte("M4A "), u32(0), []byte("M4A mp42isom"))
+	mdat := box("mdat", bytes.Join(packets, nil))
+	offset := uint32(len(ftyp) + len(moov(0)) + 8)
+	return bytes.Join([][]byte{ftyp, moov(offset), mdat}, nil), sig
+}
+
+func TestSeek(t *testing.T) {
+	const total = 3*4096 + 1000
+	file, sig := testFile(total)
+	s, _, err := Decode(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != total {
+		t.Fatalf("Len() = %d, want %d", s.Len(), total)
+	}
+	buf := make([][2]float64, 100)
+	for _, p := range []int{0, 1, 4095, 4096, 4097, 5000, 3 * 4096, total - 1, 1234} {
+		if err := s.Seek(p); err != nil {
+			t.Fatalf("Seek(%d): %v", p, err)
+		}
+		if s.Position() != p {
+			t.Errorf("Seek(%d): Position() = %d", p, s.Position())
+		}
+		n, _ := s.Stream(buf)
+		if want := total - p; n != len(buf) && n != want {
+			t.Fatalf("Seek(%d): streamed %d samples", p, n)
+		}
+		for i, x := range buf[:n] {
+			l, r := int32(x[0]*(1<<15)), int32(x[1]*(1<<15))
+			if l != sig[0][p+i] || r != sig[1][p+i] {
+				t.Fatalf("Seek(%d): sample %d is %d %d, want %d %d", p, p+i, l, r, sig[0][p+i], sig[1][p+i])
+			}
+		}
+	}
+	if err := s.Seek(total); err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := s.Stream(buf); n != 0 || ok {
+		t.Errorf("Stream at the end returned %d, %v", n, ok)
+	}
+	if err := s.Seek(total + 1); err == nil {
+		t.Error("Seek past the end succeeded")
+	}
+}
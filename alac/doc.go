@@ -0,0 +1,2 @@
+// Package alac implements audio data decoding of Apple Lossless (ALAC) audio in MP4 (M4A) files.
+package alac
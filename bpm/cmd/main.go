@@ -1,15 +1,19 @@
 package main
 
 import (
-	"encoding/binary"
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"os"
 
+	"github.com/rickcollette/megasound"
 	"github.com/rickcollette/megasound/bpm/audio"
 	"github.com/rickcollette/megasound/bpm/detection"
 	"github.com/rickcollette/megasound/bpm/utils"
+	"github.com/rickcollette/megasound/keydetector"
+	"github.com/rickcollette/megasound/pcm"
+	"github.com/rickcollette/megasound/tags"
 )
 
 var (
@@ -17,8 +21,13 @@ var (
 	max                 = flag.Float64("max", 200, "max BPM you are expecting")
 	progressive         = flag.Bool("progressive", false, "Print the BPM for every period")
 	progressiveInterval = flag.Int("interval", 10, "How many seconds for every progressive chunk printed")
+	write               = flag.Bool("write", false, "Write the final BPM, and the key with -key, to the tags of the file")
+	key                 = flag.Bool("key", false, "Detect the musical key of the file")
 )
 
+// finalBPM is the BPM of the whole file, set by readProgressiveVars when not progressive.
+var finalBPM float64
+
 func main() {
 	flag.Parse()
 
@@ -26,6 +35,9 @@ func main() {
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
+	if *write && *progressive {
+		log.Fatal("-write needs the final BPM and can't be combined with -progressive")
+	}
 
 	filePath := flag.Arg(0)
 	log.Printf("Processing file: %s\n", filePath)
@@ -35,7 +47,7 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to extract audio metadata: %v", err)
 	}
-	log.Printf("Sample Rate: %d Hz, Channels: %d\n", metadata.Rate, metadata.Channels)
+	log.Printf("Format: %s, Sample Rate: %d Hz, Channels: %d, Bit Depth: %d\n", metadata.Codec, metadata.Rate, metadata.Channels, metadata.BitDepth)
 
 	// Set dynamic RATE and INTERVAL
 	utils.RATE = metadata.Rate
@@ -45,7 +57,19 @@ func main() {
 	if err != nil {
 		log.Fatalf("Unable to open file: %v", err)
 	}
-	defer file.Close()
+	var streamer megasound.StreamSeekCloser
+	if *write {
+		// the BPM written to the tags must come from the decoded audio, not the raw bytes
+		streamer, _, _, err = megasound.Decode(file)
+	} else {
+		// the samples are raw little-endian float32 values
+		format := megasound.Format{SampleRate: megasound.SampleRate(metadata.Rate), NumChannels: 1, Precision: 4}
+		streamer, err = pcm.Decode(file, format, pcm.FloatLE)
+	}
+	if err != nil {
+		log.Fatalf("Unable to read file: %v", err)
+	}
+	defer streamer.Close()
 
 	in := make(chan float32)
 	out := make(chan float32)
@@ -57,21 +81,63 @@ func main() {
 	go readProgressiveVars(out, done, *progressive, *progressiveInterval)
 
 	// Read data from file and send to the input channel
+	samples := make([][2]float64, 512)
 	for {
-		var f float32
-		err = binary.Read(file, binary.LittleEndian, &f)
-		if err != nil {
+		n, ok := streamer.Stream(samples)
+		for _, sample := range samples[:n] {
+			in <- float32((sample[0] + sample[1]) / 2)
+		}
+		if !ok {
 			break
 		}
-		in <- f
 	}
 	close(in)
 
 	// Wait for the processing to complete
 	<-done
 	log.Println("Processing complete.")
+
+	var keyResult keydetector.KeyResult
+	if *key {
+		keyResult, err = detectKey(filePath)
+		if err != nil {
+			log.Fatalf("Failed to detect the key: %v", err)
+		}
+		fmt.Printf("Key: %s (%s)\n", keyResult.Key, keyResult.CamelotNotation)
+	}
+
+	if *write {
+		err := tags.UpdateFile(filePath, func(t *tags.Tags) {
+			t.BPM = math.Round(finalBPM*100) / 100
+			if *key {
+				t.Key = keyResult.TagKey()
+			}
+		})
+		if err != nil {
+			log.Fatalf("Failed to write tags: %v", err)
+		}
+		log.Printf("Wrote BPM %.2f to %s\n", finalBPM, filePath)
+		if *key {
+			log.Printf("Wrote key %s to %s\n", keyResult.TagKey(), filePath)
+		}
+	}
 }
 
+// detectKey decodes the audio file at filePath and detects its musical key.
+func detectKey(filePath string) (keydetector.KeyResult, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return keydetector.KeyResult{}, err
+	}
+	// the streamer closes the file
+	streamer, format, _, err := megasound.Decode(file)
+	if err != nil {
+		file.Close()
+		return keydetector.KeyResult{}, err
+	}
+	defer streamer.Close()
+	return keydetector.NewKeyDetector(streamer, int(format.SampleRate)).DetectKey()
+}
 
 func readProgressiveVars(input chan float32, done chan bool, progressive bool, pint int) {
 	if progressive {
@@ -93,6 +159,7 @@ func readProgressiveVars(input chan float32, done chan bool, progressive bool, p
 		}
 		bpm := detection.ScanForBpm(nrg, *min, *max, 1024, 1024)
 		fmt.Printf("Final BPM: %.2f\n", bpm)
+		finalBPM = bpm
 		done <- true
 	}
 }
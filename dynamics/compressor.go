@@ -0,0 +1,189 @@
+package dynamics
+
+import (
+	"time"
+
+	"github.com/rickcollette/megasound"
+)
+
+// Compressor is a feed-forward compressor. It reduces the gain of the wrapped Streamer when
+// the level of its sidechain, the Streamer itself unless Sidechain is set, rises above
+// Threshold. The fields may be changed while streaming, with the speaker locked.
+type Compressor struct {
+	Streamer megasound.Streamer
+
+	// Sidechain, if not nil, is streamed along with Streamer and its level controls the gain
+	// instead, for example to duck music under a voice.
+	Sidechain megasound.Streamer
+
+	// Threshold is the level in dB above which the gain is reduced.
+	Threshold float64
+
+	// Ratio is the ratio of the rise of the input level above Threshold to the rise of the
+	// output level, at least 1. A Ratio of 4 turns 8 dB above Threshold into 2 dB.
+	Ratio float64
+
+	// Knee is the width in dB of the range around Threshold where the ratio changes gradually,
+	// 0 for a hard knee.
+	Knee float64
+
+	// Attack is the time constant of gain reductions, and Release of their recovery.
+	Attack, Release time.Duration
+
+	// Makeup is a gain in dB applied after the compression.
+	Makeup float64
+
+	sr  megasound.SampleRate
+	env envelope
+	key [][2]float64
+}
+
+// NewCompressor returns a Compressor of s at the sample rate sr, with a threshold of -20 dB,
+// a ratio of 4, a 6 dB knee, an attack of 10 ms, a release of 100 ms and no makeup gain.
+func NewCompressor(s megasound.Streamer, sr megasound.SampleRate) *Compressor {
+	return &Compressor{
+		Streamer:  s,
+		Threshold: -20,
+		Ratio:     4,
+		Knee:      6,
+		Attack:    10 * time.Millisecond,
+		Release:   100 * time.Millisecond,
+		sr:        sr,
+	}
+}
+
+// curve returns the gain change in dB of the input level x in dB.
+func (c *Compressor) curve(x float64) float64 {
+	slope := 1 - 1/c.Ratio
+	if c.Ratio < 1 {
+		slope = 0
+	}
+	d := x - c.Threshold
+	switch {
+	case 2*d <= -c.Knee:
+		return 0
+	case 2*d < c.Knee:
+		d += c.Knee / 2
+		return -slope * d * d / (2 * c.Knee)
+	}
+	return -slope * d
+}
+
+// Stream streams the wrapped Streamer compressed.
+func (c *Compressor) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = c.Streamer.Stream(samples)
+	key := sidechain(c.Sidechain, samples[:n], &c.key)
+	attack, release := coef(c.Attack, c.sr), coef(c.Release, c.sr)
+	for i := range samples[:n] {
+		g := gain(c.env.follow(c.curve(level(key[i])), release, attack) + c.Makeup)
+		samples[i][0] *= g
+		samples[i][1] *= g
+	}
+	return n, ok
+}
+
+// Err propagates the errors of the wrapped Streamer and the sidechain.
+func (c *Compressor) Err() error {
+	return errs(c.Streamer, c.Sidechain)
+}
+
+// GainReduction returns the current gain reduction in dB, positive when compressing, without
+// the makeup gain.
+func (c *Compressor) GainReduction() float64 {
+	return -float64(c.env)
+}
+
+// Expander is a downward expander. It reduces the gain of the wrapped Streamer when the level
+// of its sidechain, the Streamer itself unless Sidechain is set, falls below Threshold, which
+// makes quiet parts quieter. The fields may be changed while streaming, with the speaker
+// locked.
+type Expander struct {
+	Streamer megasound.Streamer
+
+	// Sidechain, if not nil, is streamed along with Streamer and its level controls the gain
+	// instead.
+	Sidechain megasound.Streamer
+
+	// Threshold is the level in dB below which the gain is reduced.
+	Threshold float64
+
+	// Ratio is the ratio of the fall of the output level below Threshold to the fall of the
+	// input level, at least 1. A Ratio of 2 turns 10 dB below Threshold into 20 dB.
+	Ratio float64
+
+	// Knee is the width in dB of the range around Threshold where the ratio changes gradually,
+	// 0 for a hard knee.
+	Knee float64
+
+	// Range is the largest gain reduction in dB, a negative number.
+	Range float64
+
+	// Attack is the time constant of gain recoveries when the level rises, and Release of gain
+	// reductions.
+	Attack, Release time.Duration
+
+	sr  megasound.SampleRate
+	env envelope
+	key [][2]float64
+}
+
+// NewExpander returns an Expander of s at the sample rate sr, with a threshold of -40 dB, a
+// ratio of 2, a 6 dB knee, a range of -40 dB, an attack of 1 ms and a release of 100 ms.
+func NewExpander(s megasound.Streamer, sr megasound.SampleRate) *Expander {
+	return &Expander{
+		Streamer:  s,
+		Threshold: -40,
+		Ratio:     2,
+		Knee:      6,
+		Range:     -40,
+		Attack:    time.Millisecond,
+		Release:   100 * time.Millisecond,
+		sr:        sr,
+	}
+}
+
+// curve returns the gain change in dB of the input level x in dB.
+func (e *Expander) curve(x float64) float64 {
+	slope := e.Ratio - 1
+	if e.Ratio < 1 {
+		slope = 0
+	}
+	d := x - e.Threshold
+	var g float64
+	switch {
+	case 2*d >= e.Knee:
+		g = 0
+	case 2*d > -e.Knee:
+		d -= e.Knee / 2
+		g = -slope * d * d / (2 * e.Knee)
+	default:
+		g = slope * d
+	}
+	if g < e.Range {
+		return e.Range
+	}
+	return g
+}
+
+// Stream streams the wrapped Streamer expanded.
+func (e *Expander) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = e.Streamer.Stream(samples)
+	key := sidechain(e.Sidechain, samples[:n], &e.key)
+	attack, release := coef(e.Attack, e.sr), coef(e.Release, e.sr)
+	for i := range samples[:n] {
+		g := gain(e.env.follow(e.curve(level(key[i])), attack, release))
+		samples[i][0] *= g
+		samples[i][1] *= g
+	}
+	return n, ok
+}
+
+// Err propagates the errors of the wrapped Streamer and the sidechain.
+func (e *Expander) Err() error {
+	return errs(e.Streamer, e.Sidechain)
+}
+
+// GainReduction returns the current gain reduction in dB, positive when expanding.
+func (e *Expander) GainReduction() float64 {
+	return -float64(e.env)
+}
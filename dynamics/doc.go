@@ -0,0 +1,6 @@
+// Package dynamics implements dynamics processors, which change the gain of a Streamer with
+// its level: a compressor, an expander, a noise gate and a look-ahead brickwall limiter.
+//
+// Each processor detects the level of its Streamer, or of another Streamer set as its
+// sidechain, and reports its current gain reduction for metering.
+package dynamics
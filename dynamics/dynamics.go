@@ -0,0 +1,83 @@
+package dynamics
+
+import (
+	"math"
+	"time"
+
+	"github.com/rickcollette/megasound"
+)
+
+// silence is the level in dB of silent samples.
+const silence = -200
+
+// level returns the peak level in dB of a stereo sample, linking both channels.
+func level(s [2]float64) float64 {
+	p := math.Max(math.Abs(s[0]), math.Abs(s[1]))
+	if p <= 0 {
+		return silence
+	}
+	return math.Max(20*math.Log10(p), silence)
+}
+
+// gain returns the amplitude factor of a gain in dB.
+func gain(db float64) float64 {
+	return math.Pow(10, db/20)
+}
+
+// coef returns the one-pole smoothing coefficient of the time constant d at the sample rate
+// sr, 0 to follow changes immediately.
+func coef(d time.Duration, sr megasound.SampleRate) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return math.Exp(-1 / (d.Seconds() * float64(sr)))
+}
+
+// envelope smooths a gain change in dB.
+type envelope float64
+
+// follow moves e towards target, with the coefficient up when the gain rises and down when it
+// falls, and returns the new gain.
+func (e *envelope) follow(target, up, down float64) float64 {
+	c := down
+	if target > float64(*e) {
+		c = up
+	}
+	*e = envelope(target + (float64(*e)-target)*c)
+	return float64(*e)
+}
+
+// sidechain returns the samples from which the level of samples is detected: the next
+// len(samples) samples of sc, read into buf and padded with silence, or samples if sc is nil.
+func sidechain(sc megasound.Streamer, samples [][2]float64, buf *[][2]float64) [][2]float64 {
+	if sc == nil {
+		return samples
+	}
+	if cap(*buf) < len(samples) {
+		*buf = make([][2]float64, len(samples))
+	}
+	key := (*buf)[:len(samples)]
+	n := 0
+	for n < len(key) {
+		m, ok := sc.Stream(key[n:])
+		if !ok {
+			break
+		}
+		n += m
+	}
+	for i := range key[n:] {
+		key[n+i] = [2]float64{}
+	}
+	return key
+}
+
+// errs returns the error of s, or else of the sidechain sc.
+func errs(s, sc megasound.Streamer) error {
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if sc != nil {
+		return sc.Err()
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package dynamics
+
+import (
+	"time"
+
+	"github.com/rickcollette/megasound"
+)
+
+// Gate is a noise gate. It mutes the wrapped Streamer, down to Range, while the level of its
+// sidechain, the Streamer itself unless Sidechain is set, stays below Threshold. The fields may
+// be changed while streaming, with the speaker locked.
+type Gate struct {
+	Streamer megasound.Streamer
+
+	// Sidechain, if not nil, is streamed along with Streamer and its level opens the gate
+	// instead.
+	Sidechain megasound.Streamer
+
+	// Threshold is the level in dB above which the gate opens.
+	Threshold float64
+
+	// Range is the gain in dB of the closed gate, a negative number.
+	Range float64
+
+	// Attack is the time constant of the opening of the gate, and Release of its closing.
+	Attack, Release time.Duration
+
+	// Hold is how long the gate stays open after the level falls below Threshold.
+	Hold time.Duration
+
+	sr   megasound.SampleRate
+	env  envelope
+	hold int // remaining samples of the hold
+	key  [][2]float64
+}
+
+// NewGate returns a Gate of s at the sample rate sr, with a threshold of -50 dB, a range of
+// -80 dB, an attack of 1 ms, a hold of 50 ms and a release of 100 ms. The gate starts closed.
+func NewGate(s megasound.Streamer, sr megasound.SampleRate) *Gate {
+	return &Gate{
+		Streamer:  s,
+		Threshold: -50,
+		Range:     -80,
+		Attack:    time.Millisecond,
+		Release:   100 * time.Millisecond,
+		Hold:      50 * time.Millisecond,
+		sr:        sr,
+		env:       -80,
+	}
+}
+
+// Stream streams the wrapped Streamer gated.
+func (g *Gate) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = g.Streamer.Stream(samples)
+	key := sidechain(g.Sidechain, samples[:n], &g.key)
+	attack, release := coef(g.Attack, g.sr), coef(g.Release, g.sr)
+	hold := g.sr.N(g.Hold)
+	for i := range samples[:n] {
+		target := g.Range
+		if level(key[i]) >= g.Threshold {
+			g.hold = hold
+			target = 0
+		} else if g.hold > 0 {
+			g.hold--
+			target = 0
+		}
+		v := gain(g.env.follow(target, attack, release))
+		samples[i][0] *= v
+		samples[i][1] *= v
+	}
+	return n, ok
+}
+
+// Err propagates the errors of the wrapped Streamer and the sidechain.
+func (g *Gate) Err() error {
+	return errs(g.Streamer, g.Sidechain)
+}
+
+// GainReduction returns the current gain reduction in dB, 0 when the gate is open.
+func (g *Gate) GainReduction() float64 {
+	return -float64(g.env)
+}
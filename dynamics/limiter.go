@@ -0,0 +1,169 @@
+package dynamics
+
+import (
+	"math"
+	"time"
+
+	"github.com/rickcollette/megasound"
+)
+
+// Limiter is a look-ahead brickwall limiter. It delays the wrapped Streamer by the look-ahead
+// time, so that it can reduce the gain smoothly before the peaks of its sidechain, the
+// Streamer itself unless Sidechain is set, would exceed Ceiling. The output never exceeds
+// Ceiling. The fields may be changed while streaming, with the speaker locked.
+type Limiter struct {
+	Streamer megasound.Streamer
+
+	// Sidechain, if not nil, is streamed along with Streamer and its peaks control the gain
+	// instead.
+	Sidechain megasound.Streamer
+
+	// Ceiling is the highest output level in dB.
+	Ceiling float64
+
+	// Release is the time constant of gain recoveries.
+	Release time.Duration
+
+	sr  megasound.SampleRate
+	key [][2]float64
+
+	delay [][2]float64 // the delayed samples
+	pos   int          // position in delay and box
+
+	// The minimum gain needed by the samples of the look-ahead window, kept in a monotonic
+	// queue of increasing gains with their indices, then averaged over the window in box.
+	queue         []float64
+	indices       []int
+	head, size, i int
+	box           []float64
+	sum           float64
+
+	gain  float64
+	done  bool
+	drain int // remaining delayed samples after the end of Streamer
+}
+
+// NewLimiter returns a Limiter of s at the sample rate sr, with a ceiling of -0.3 dB and a
+// release of 50 ms. lookahead is the time over which the gain is reduced before a peak,
+// usually a few milliseconds, and the latency of the Limiter.
+func NewLimiter(s megasound.Streamer, sr megasound.SampleRate, lookahead time.Duration) *Limiter {
+	n := sr.N(lookahead)
+	if n < 1 {
+		n = 1
+	}
+	box := make([]float64, n)
+	for i := range box {
+		box[i] = 1
+	}
+	return &Limiter{
+		Streamer: s,
+		Ceiling:  -0.3,
+		Release:  50 * time.Millisecond,
+		sr:       sr,
+		delay:    make([][2]float64, n),
+		queue:    make([]float64, n+1),
+		indices:  make([]int, n+1),
+		box:      box,
+		sum:      float64(n),
+		gain:     1,
+	}
+}
+
+// Latency returns the delay of the output of l in samples, the look-ahead.
+func (l *Limiter) Latency() int {
+	return len(l.delay)
+}
+
+// Stream streams the wrapped Streamer limited and delayed. After the end of the wrapped
+// Streamer, it streams the remaining delayed samples.
+func (l *Limiter) Stream(samples [][2]float64) (n int, ok bool) {
+	if !l.done {
+		n, ok = l.Streamer.Stream(samples)
+		if !ok {
+			l.done = true
+			l.drain = len(l.delay)
+		}
+	}
+	if l.done {
+		n = len(samples)
+		if n > l.drain {
+			n = l.drain
+		}
+		if n == 0 {
+			return 0, false
+		}
+		l.drain -= n
+		for i := range samples[:n] {
+			samples[i] = [2]float64{}
+		}
+	}
+	key := sidechain(l.Sidechain, samples[:n], &l.key)
+	ceiling := gain(l.Ceiling)
+	release := coef(l.Release, l.sr)
+	for i := range samples[:n] {
+		need := 1.0
+		if p := math.Max(math.Abs(key[i][0]), math.Abs(key[i][1])); p > ceiling {
+			need = ceiling / p
+		}
+		g := l.average(l.minimum(need))
+		if g < l.gain {
+			l.gain = g
+		} else {
+			l.gain = g + (l.gain-g)*release
+		}
+
+		out := l.delay[l.pos]
+		l.delay[l.pos] = samples[i]
+		if l.pos++; l.pos == len(l.delay) {
+			l.pos = 0
+		}
+		for c := range out {
+			out[c] *= l.gain
+		}
+		samples[i] = out
+	}
+	return n, true
+}
+
+// minimum adds the gain needed by the next sample to the window and returns the minimum gain
+// needed by the last len(l.delay)+1 samples, the delayed sample and the look-ahead.
+func (l *Limiter) minimum(need float64) float64 {
+	size := len(l.queue)
+	// drop the gain leaving the window, then the larger gains, which can never be the minimum
+	if l.size > 0 && l.indices[l.head] <= l.i-size {
+		l.head = (l.head + 1) % size
+		l.size--
+	}
+	for l.size > 0 && l.queue[(l.head+l.size-1)%size] >= need {
+		l.size--
+	}
+	tail := (l.head + l.size) % size
+	l.queue[tail], l.indices[tail] = need, l.i
+	l.size++
+	l.i++
+	return l.queue[l.head]
+}
+
+// average adds g to the box and returns its average over the look-ahead.
+func (l *Limiter) average(g float64) float64 {
+	l.sum += g - l.box[l.pos]
+	l.box[l.pos] = g
+	if l.pos == len(l.box)-1 {
+		// recompute the sum to cancel rounding errors
+		l.sum = 0
+		for _, v := range l.box {
+			l.sum += v
+		}
+	}
+	return l.sum / float64(len(l.box))
+}
+
+// Err propagates the errors of the wrapped Streamer and the sidechain.
+func (l *Limiter) Err() error {
+	return errs(l.Streamer, l.Sidechain)
+}
+
+// GainReduction returns the current gain reduction in dB, positive when limiting.
+func (l *Limiter) GainReduction() float64 {
+	return -20 * math.Log10(l.gain)
+}
@@ -0,0 +1,65 @@
+package effects
+
+import (
+	"math"
+
+	"github.com/rickcollette/megasound"
+)
+
+// Bitcrusher lowers the resolution and the sample rate of the wrapped Streamer, for the lo-fi
+// sound of early samplers and game consoles. Each sample is rounded to Bits bits, and held
+// for Downsample samples. The aliasing of the lowered sample rate is the point of the effect,
+// so Bitcrusher does not oversample.
+//
+// The fields may be changed while streaming, with the speaker locked.
+type Bitcrusher struct {
+	Streamer megasound.Streamer
+
+	// Bits is the resolution of the samples, from 1 up, and may be fractional.
+	Bits float64
+
+	// Downsample is the factor from 1 up by which the sample rate is lowered, and may be
+	// fractional.
+	Downsample float64
+
+	// Wet and Dry are the gains of the crushed and of the direct sound.
+	Wet, Dry float64
+
+	hold  [2]float64
+	phase float64
+}
+
+// NewBitcrusher returns a Bitcrusher of s with the resolution bits and the sample rate lowered
+// by downsample, a Wet gain of 1 and a Dry gain of 0.
+func NewBitcrusher(s megasound.Streamer, bits, downsample float64) *Bitcrusher {
+	return &Bitcrusher{
+		Streamer:   s,
+		Bits:       bits,
+		Downsample: downsample,
+		Wet:        1,
+	}
+}
+
+// Stream streams the wrapped Streamer crushed.
+func (b *Bitcrusher) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = b.Streamer.Stream(samples)
+	levels := math.Pow(2, math.Max(1, b.Bits)-1)
+	factor := math.Max(1, b.Downsample)
+	for i := range samples[:n] {
+		x := samples[i]
+		if b.phase <= 0 {
+			b.hold[0] = math.Round(x[0]*levels) / levels
+			b.hold[1] = math.Round(x[1]*levels) / levels
+			b.phase += factor
+		}
+		b.phase--
+		samples[i][0] = x[0]*b.Dry + b.hold[0]*b.Wet
+		samples[i][1] = x[1]*b.Dry + b.hold[1]*b.Wet
+	}
+	return n, ok
+}
+
+// Err propagates the wrapped Streamer's errors.
+func (b *Bitcrusher) Err() error {
+	return b.Streamer.Err()
+}
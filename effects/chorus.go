@@ -0,0 +1,143 @@
+package effects
+
+import (
+	"math"
+	"time"
+
+	"github.com/rickcollette/megasound"
+)
+
+// maxModulationDelay is the longest delay of a Chorus or a Flanger, Delay plus Depth.
+const maxModulationDelay = 100 * time.Millisecond
+
+// modulation is a delay line read at a delay modulated by an lfo, the core of Chorus and
+// Flanger.
+type modulation struct {
+	sr   megasound.SampleRate
+	line *DelayLine
+	lfo  lfo
+	tail tail
+}
+
+func newModulation(sr megasound.SampleRate) modulation {
+	return modulation{sr: sr, line: NewDelayLine(sr.N(maxModulationDelay))}
+}
+
+// stream streams s with the delayed sound mixed in. The delay sweeps from delay-depth to
+// delay+depth rate times per second, the right channel spread cycles after the left.
+func (m *modulation) stream(s megasound.Streamer, samples [][2]float64, rate float64, delay, depth time.Duration, spread, feedback, wet, dry float64) (n int, ok bool) {
+	n, ok = m.tail.stream(s, samples, m.line.Max())
+	if !ok {
+		return 0, false
+	}
+	sr := float64(m.sr)
+	center, sweep := delay.Seconds()*sr, depth.Seconds()*sr
+	fb := math.Max(-0.99, math.Min(feedback, 0.99))
+	for i := range samples[:n] {
+		mod := m.lfo.next(rate/sr, spread)
+		x := samples[i]
+		d := [2]float64{
+			m.line.Read(center + sweep*mod[0])[0],
+			m.line.Read(center + sweep*mod[1])[1],
+		}
+		m.line.Write([2]float64{x[0] + fb*d[0], x[1] + fb*d[1]})
+		samples[i][0] = x[0]*dry + d[0]*wet
+		samples[i][1] = x[1]*dry + d[1]*wet
+		m.tail.observe(d)
+	}
+	return n, true
+}
+
+// Chorus thickens the wrapped Streamer by mixing it with a copy whose delay slowly sweeps,
+// like several voices slightly out of tune. The delays of both channels sweep a quarter cycle
+// apart, which widens the sound.
+//
+// The fields may be changed while streaming, with the speaker locked. Delay plus Depth is at
+// most 100 ms.
+type Chorus struct {
+	Streamer megasound.Streamer
+
+	// Rate is the frequency in Hz of the sweep.
+	Rate float64
+
+	// Delay is the center of the sweep, and Depth how far the delay sweeps around it.
+	Delay, Depth time.Duration
+
+	// Wet and Dry are the gains of the delayed sound and of the direct sound.
+	Wet, Dry float64
+
+	m modulation
+}
+
+// NewChorus returns a Chorus of s at the sample rate sr, with a Rate of 0.8 Hz, a Delay of
+// 15 ms, a Depth of 5 ms, a Wet gain of 0.5 and a Dry gain of 1.
+func NewChorus(s megasound.Streamer, sr megasound.SampleRate) *Chorus {
+	return &Chorus{
+		Streamer: s,
+		Rate:     0.8,
+		Delay:    15 * time.Millisecond,
+		Depth:    5 * time.Millisecond,
+		Wet:      0.5,
+		Dry:      1,
+		m:        newModulation(sr),
+	}
+}
+
+// Stream streams the wrapped Streamer with chorus, then the tail.
+func (c *Chorus) Stream(samples [][2]float64) (n int, ok bool) {
+	return c.m.stream(c.Streamer, samples, c.Rate, c.Delay, c.Depth, 0.25, 0, c.Wet, c.Dry)
+}
+
+// Err propagates the wrapped Streamer's errors.
+func (c *Chorus) Err() error {
+	return c.Streamer.Err()
+}
+
+// Flanger mixes the wrapped Streamer with a copy whose short delay sweeps, fed back with the
+// gain Feedback, which sweeps a comb of notches across the spectrum.
+//
+// The fields may be changed while streaming, with the speaker locked. Delay plus Depth is at
+// most 100 ms.
+type Flanger struct {
+	Streamer megasound.Streamer
+
+	// Rate is the frequency in Hz of the sweep.
+	Rate float64
+
+	// Delay is the center of the sweep, and Depth how far the delay sweeps around it.
+	Delay, Depth time.Duration
+
+	// Feedback is the gain of the delayed sound fed back, from -1 to 1 excluded. Negative
+	// feedback gives a hollower sound.
+	Feedback float64
+
+	// Wet and Dry are the gains of the delayed sound and of the direct sound.
+	Wet, Dry float64
+
+	m modulation
+}
+
+// NewFlanger returns a Flanger of s at the sample rate sr, with a Rate of 0.25 Hz, a Delay
+// and a Depth of 2 ms, a Feedback of 0.5, and Wet and Dry gains of 0.5.
+func NewFlanger(s megasound.Streamer, sr megasound.SampleRate) *Flanger {
+	return &Flanger{
+		Streamer: s,
+		Rate:     0.25,
+		Delay:    2 * time.Millisecond,
+		Depth:    2 * time.Millisecond,
+		Feedback: 0.5,
+		Wet:      0.5,
+		Dry:      0.5,
+		m:        newModulation(sr),
+	}
+}
+
+// Stream streams the wrapped Streamer flanged, then the tail.
+func (f *Flanger) Stream(samples [][2]float64) (n int, ok bool) {
+	return f.m.stream(f.Streamer, samples, f.Rate, f.Delay, f.Depth, 0, f.Feedback, f.Wet, f.Dry)
+}
+
+// Err propagates the wrapped Streamer's errors.
+func (f *Flanger) Err() error {
+	return f.Streamer.Err()
+}
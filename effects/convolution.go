@@ -0,0 +1,274 @@
+package effects
+
+import (
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"gonum.org/v1/gonum/dsp/fourier"
+
+	"github.com/rickcollette/megasound"
+)
+
+// MaxImpulseResponse is the longest impulse response loaded by LoadImpulseResponse and
+// LoadTrueStereoImpulseResponse.
+const MaxImpulseResponse = 30 * time.Second
+
+// ImpulseResponse is the impulse response of a space, a cabinet or any linear system, which a
+// Convolver applies to a Streamer.
+type ImpulseResponse struct {
+	sr megasound.SampleRate
+
+	// h[in][out] is the response of the output channel out to the input channel in, nil if
+	// the input does not reach the output.
+	h [2][2][]float64
+}
+
+// LoadImpulseResponse reads a stereo impulse response from s, for example decoded with
+// wav.Decode, and resamples it from its sample rate from to the sample rate to of the
+// streams it will be applied to. The left input is convolved with the left channel of the
+// response and the right input with the right channel; a mono response has equal channels.
+func LoadImpulseResponse(s megasound.Streamer, from, to megasound.SampleRate) (*ImpulseResponse, error) {
+	samples, err := readImpulseResponse(s, from, to)
+	if err != nil {
+		return nil, err
+	}
+	ir := &ImpulseResponse{sr: to}
+	ir.h[0][0], ir.h[1][1] = channel(samples, 0), channel(samples, 1)
+	return ir, nil
+}
+
+// LoadTrueStereoImpulseResponse reads a true stereo impulse response, with four channels,
+// from two stereo streams: the response of both outputs to the left input, and to the right
+// input. Both are resampled from the sample rate from to the sample rate to of the streams it
+// will be applied to. True stereo responses reproduce how a sound on one side of a room
+// reaches both ears.
+func LoadTrueStereoImpulseResponse(left, right megasound.Streamer, from, to megasound.SampleRate) (*ImpulseResponse, error) {
+	ir := &ImpulseResponse{sr: to}
+	for in, s := range []megasound.Streamer{left, right} {
+		samples, err := readImpulseResponse(s, from, to)
+		if err != nil {
+			return nil, err
+		}
+		ir.h[in][0], ir.h[in][1] = channel(samples, 0), channel(samples, 1)
+	}
+	return ir, nil
+}
+
+// Len returns the length of ir in samples.
+func (ir *ImpulseResponse) Len() int {
+	n := 0
+	for _, h := range ir.h {
+		for _, h := range h {
+			if len(h) > n {
+				n = len(h)
+			}
+		}
+	}
+	return n
+}
+
+// SampleRate returns the sample rate of ir, the sample rate of the streams it applies to.
+func (ir *ImpulseResponse) SampleRate() megasound.SampleRate {
+	return ir.sr
+}
+
+func readImpulseResponse(s megasound.Streamer, from, to megasound.SampleRate) ([][2]float64, error) {
+	if from <= 0 || to <= 0 {
+		return nil, pkgerrors.New("effects: invalid impulse response sample rate")
+	}
+	if from != to {
+		s = megasound.Resample(6, from, to, s)
+	}
+	limit := to.N(MaxImpulseResponse)
+	var samples [][2]float64
+	buf := make([][2]float64, 4096)
+	for {
+		n, ok := s.Stream(buf)
+		if !ok {
+			break
+		}
+		samples = append(samples, buf[:n]...)
+		if len(samples) > limit {
+			return nil, pkgerrors.Errorf("effects: impulse response longer than %v", MaxImpulseResponse)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, pkgerrors.Wrap(err, "effects")
+	}
+	if len(samples) == 0 {
+		return nil, pkgerrors.New("effects: empty impulse response")
+	}
+	return samples, nil
+}
+
+func channel(samples [][2]float64, c int) []float64 {
+	h := make([]float64, len(samples))
+	for i := range samples {
+		h[i] = samples[i][c]
+	}
+	return h
+}
+
+// Convolver convolves the wrapped Streamer with an ImpulseResponse, for convolution reverbs
+// and cabinet simulations. It uses uniformly partitioned FFT convolution: the response is
+// split into blocks, so the output is delayed by one block only, whatever the length of the
+// response. The direct sound is delayed as much to stay aligned.
+//
+// Wet and Dry may be changed while streaming, with the speaker locked. When the wrapped
+// Streamer ends, Convolver keeps streaming the tail of the response.
+type Convolver struct {
+	Streamer megasound.Streamer
+
+	// Wet and Dry are the gains of the convolved sound and of the direct sound.
+	Wet, Dry float64
+
+	block int
+	fft   *fourier.FFT
+	h     [2][2][][]complex128 // spectra of the partitions of the response, by h[in][out]
+
+	// Spectra of the last input blocks, a ring by input channel, from which the partitions
+	// of the response are applied.
+	fdl    [2][][]complex128
+	fdlPos int
+
+	in  [2][]float64 // the previous and current input blocks of each channel
+	out [2][]float64 // the convolved output of the last block
+	dry [][2]float64 // the direct sound of the last block
+	acc []complex128
+	seq []float64
+	pos int
+
+	done bool
+	tail int // remaining samples after the end of Streamer
+}
+
+// NewConvolver returns a Convolver of s with the impulse response ir, with a Wet gain of 1 and
+// a Dry gain of 0. blockSize is the latency in samples: shorter blocks cost more processing,
+// and powers of two are the fastest, for example 256. The sample rate of ir must be the
+// sample rate of s.
+func NewConvolver(s megasound.Streamer, ir *ImpulseResponse, blockSize int) (*Convolver, error) {
+	if blockSize < 1 {
+		return nil, pkgerrors.New("effects: convolution block size must be positive")
+	}
+	B := blockSize
+	parts := (ir.Len() + B - 1) / B
+	c := &Convolver{
+		Streamer: s,
+		Wet:      1,
+		block:    B,
+		fft:      fourier.NewFFT(2 * B),
+		dry:      make([][2]float64, B),
+		acc:      make([]complex128, B+1),
+		seq:      make([]float64, 2*B),
+	}
+	for in := range ir.h {
+		for out, h := range ir.h[in] {
+			if h == nil {
+				continue
+			}
+			c.h[in][out] = make([][]complex128, parts)
+			for p := range c.h[in][out] {
+				for i := range c.seq {
+					c.seq[i] = 0
+				}
+				if p*B < len(h) {
+					copy(c.seq, h[p*B:])
+				}
+				for i := B; i < 2*B; i++ {
+					c.seq[i] = 0
+				}
+				c.h[in][out][p] = c.fft.Coefficients(nil, c.seq)
+			}
+		}
+		c.fdl[in] = make([][]complex128, parts)
+		for p := range c.fdl[in] {
+			c.fdl[in][p] = make([]complex128, B+1)
+		}
+		c.in[in] = make([]float64, 2*B)
+		c.out[in] = make([]float64, B)
+	}
+	c.tail = B + ir.Len()
+	return c, nil
+}
+
+// Latency returns the delay of the output of c in samples, its block size.
+func (c *Convolver) Latency() int {
+	return c.block
+}
+
+// Stream streams the wrapped Streamer convolved and delayed, then the tail of the response.
+func (c *Convolver) Stream(samples [][2]float64) (n int, ok bool) {
+	if !c.done {
+		n, ok = c.Streamer.Stream(samples)
+		if !ok {
+			c.done = true
+		}
+	}
+	if c.done {
+		n = len(samples)
+		if n > c.tail {
+			n = c.tail
+		}
+		if n == 0 {
+			return 0, false
+		}
+		c.tail -= n
+		for i := range samples[:n] {
+			samples[i] = [2]float64{}
+		}
+	}
+	B := c.block
+	for i := range samples[:n] {
+		x := samples[i]
+		samples[i][0] = c.out[0][c.pos]*c.Wet + c.dry[c.pos][0]*c.Dry
+		samples[i][1] = c.out[1][c.pos]*c.Wet + c.dry[c.pos][1]*c.Dry
+		c.dry[c.pos] = x
+		c.in[0][B+c.pos], c.in[1][B+c.pos] = x[0], x[1]
+		if c.pos++; c.pos == B {
+			c.process()
+			c.pos = 0
+		}
+	}
+	return n, true
+}
+
+// process convolves the current input blocks into the output blocks, by overlap-save.
+func (c *Convolver) process() {
+	B := c.block
+	parts := len(c.fdl[0])
+	if parts == 0 {
+		return
+	}
+	for in := range c.in {
+		c.fft.Coefficients(c.fdl[in][c.fdlPos], c.in[in])
+		copy(c.in[in], c.in[in][B:])
+	}
+	scale := 1 / float64(2*B)
+	for out := range c.out {
+		for k := range c.acc {
+			c.acc[k] = 0
+		}
+		for in := range c.h {
+			h := c.h[in][out]
+			if h == nil {
+				continue
+			}
+			for p := range h {
+				x := c.fdl[in][(c.fdlPos-p+parts)%parts]
+				for k, v := range h[p] {
+					c.acc[k] += x[k] * v
+				}
+			}
+		}
+		c.fft.Sequence(c.seq, c.acc)
+		for i := range c.out[out] {
+			c.out[out][i] = c.seq[B+i] * scale
+		}
+	}
+	c.fdlPos = (c.fdlPos + 1) % parts
+}
+
+// Err propagates the wrapped Streamer's errors.
+func (c *Convolver) Err() error {
+	return c.Streamer.Err()
+}
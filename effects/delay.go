@@ -0,0 +1,121 @@
+package effects
+
+import (
+	"math"
+
+	"github.com/rickcollette/megasound"
+)
+
+// DelayLine is a delay line of stereo samples, which can be read at fractional delays, to
+// build time-based effects.
+type DelayLine struct {
+	buf [][2]float64
+	pos int // where the next sample is written
+}
+
+// NewDelayLine returns a DelayLine of silence, able to delay samples up to max samples.
+func NewDelayLine(max int) *DelayLine {
+	if max < 1 {
+		max = 1
+	}
+	return &DelayLine{buf: make([][2]float64, max+1)}
+}
+
+// Max returns the longest delay of d in samples.
+func (d *DelayLine) Max() int {
+	return len(d.buf) - 1
+}
+
+// Write writes the next sample of d.
+func (d *DelayLine) Write(s [2]float64) {
+	d.buf[d.pos] = s
+	if d.pos++; d.pos == len(d.buf) {
+		d.pos = 0
+	}
+}
+
+// Read returns the sample written delay samples ago, a delay of 1 being the last sample
+// written, interpolating linearly between samples at fractional delays. The delay is limited
+// to the range from 1 to Max.
+func (d *DelayLine) Read(delay float64) [2]float64 {
+	delay = math.Max(1, math.Min(delay, float64(d.Max())))
+	i := int(delay)
+	frac := delay - float64(i)
+	a := d.at(i)
+	if frac == 0 {
+		return a
+	}
+	b := d.at(i + 1)
+	return [2]float64{a[0] + (b[0]-a[0])*frac, a[1] + (b[1]-a[1])*frac}
+}
+
+// at returns the sample written i samples ago.
+func (d *DelayLine) at(i int) [2]float64 {
+	j := d.pos - i
+	if j < 0 {
+		j += len(d.buf)
+	}
+	return d.buf[j]
+}
+
+// Reset fills d with silence.
+func (d *DelayLine) Reset() {
+	for i := range d.buf {
+		d.buf[i] = [2]float64{}
+	}
+}
+
+// tailQuiet is the level below which the tail of an effect is over.
+const tailQuiet = 1e-5
+
+// tail streams the source of an effect, then silence after its end for the tail of the
+// effect, until the effect has been quiet for long enough.
+type tail struct {
+	done  bool
+	quiet int // samples of the tail below tailQuiet
+}
+
+// stream streams s into samples, or silence once s has ended. It returns false once the tail
+// has been quiet for more than length samples, the memory of the effect.
+func (t *tail) stream(s megasound.Streamer, samples [][2]float64, length int) (n int, ok bool) {
+	if !t.done {
+		if n, ok = s.Stream(samples); ok {
+			return n, true
+		}
+		t.done = true
+	}
+	if t.quiet > length {
+		return 0, false
+	}
+	for i := range samples {
+		samples[i] = [2]float64{}
+	}
+	return len(samples), true
+}
+
+// observe counts the quiet samples of the tail from the output s of the effect.
+func (t *tail) observe(s [2]float64) {
+	if !t.done {
+		return
+	}
+	if math.Abs(s[0]) < tailQuiet && math.Abs(s[1]) < tailQuiet {
+		t.quiet++
+	} else {
+		t.quiet = 0
+	}
+}
+
+// lfo is a sine low-frequency oscillator.
+type lfo float64
+
+// next returns the values of l for both channels, the right channel shifted by spread cycles,
+// and advances l by rate cycles per sample.
+func (l *lfo) next(rate, spread float64) [2]float64 {
+	p := 2 * math.Pi * float64(*l)
+	_, f := math.Modf(float64(*l) + rate)
+	if f < 0 {
+		f++
+	}
+	*l = lfo(f)
+	return [2]float64{math.Sin(p), math.Sin(p + 2*math.Pi*spread)}
+}
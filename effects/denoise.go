@@ -0,0 +1,267 @@
+package effects
+
+import (
+	"math"
+	"math/cmplx"
+
+	pkgerrors "github.com/pkg/errors"
+	"gonum.org/v1/gonum/dsp/fourier"
+
+	"github.com/rickcollette/megasound"
+)
+
+// NoiseProfile is the spectrum of the noise of a recording, learnt from a part of it with
+// only noise, which a NoiseReducer removes.
+type NoiseProfile struct {
+	mag [2][]float64 // mean magnitude of each frequency bin of each channel
+}
+
+// LearnNoiseProfile learns the NoiseProfile of s, streaming it to its end, with frames of
+// frameSize samples. The frame size is a multiple of 4, for example 2048 samples at 44100 or
+// 48000 Hz: longer frames separate noise from sound more finely in frequency, and shorter
+// ones in time. s must be at least one frame long.
+func LearnNoiseProfile(s megasound.Streamer, frameSize int) (*NoiseProfile, error) {
+	if frameSize < 16 || frameSize%4 != 0 {
+		return nil, pkgerrors.Errorf("effects: invalid noise profile frame size %d, must be a multiple of 4 from 16", frameSize)
+	}
+	hop := frameSize / 4
+	win := stftWindow(frameSize)
+	fft := fourier.NewFFT(frameSize)
+	seq := make([]float64, frameSize)
+	coeff := make([]complex128, frameSize/2+1)
+	p := &NoiseProfile{}
+	var frame [2][]float64
+	for c := range frame {
+		frame[c] = make([]float64, frameSize)
+		p.mag[c] = make([]float64, frameSize/2+1)
+	}
+	frames, fill := 0, 0
+	buf := make([][2]float64, 4096)
+	for {
+		n, ok := s.Stream(buf)
+		if !ok {
+			break
+		}
+		for _, x := range buf[:n] {
+			frame[0][fill], frame[1][fill] = x[0], x[1]
+			if fill++; fill < frameSize {
+				continue
+			}
+			for c := range frame {
+				for i := range seq {
+					seq[i] = frame[c][i] * win[i]
+				}
+				fft.Coefficients(coeff, seq)
+				for k := range coeff {
+					p.mag[c][k] += cmplx.Abs(coeff[k])
+				}
+				copy(frame[c], frame[c][hop:])
+			}
+			frames++
+			fill -= hop
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, pkgerrors.Wrap(err, "effects")
+	}
+	if frames == 0 {
+		return nil, pkgerrors.New("effects: noise profile shorter than a frame")
+	}
+	for c := range p.mag {
+		for k := range p.mag[c] {
+			p.mag[c][k] /= float64(frames)
+		}
+	}
+	return p, nil
+}
+
+// LearnNoiseProfileSegment learns the NoiseProfile of the samples of s from the position from
+// to the position to, as LearnNoiseProfile, then seeks s back to its position.
+func LearnNoiseProfileSegment(s megasound.StreamSeeker, from, to, frameSize int) (*NoiseProfile, error) {
+	if from < 0 || to <= from || to > s.Len() {
+		return nil, pkgerrors.Errorf("effects: invalid noise profile segment from %d to %d", from, to)
+	}
+	pos := s.Position()
+	if err := s.Seek(from); err != nil {
+		return nil, pkgerrors.Wrap(err, "effects")
+	}
+	p, err := LearnNoiseProfile(megasound.Take(to-from, s), frameSize)
+	if serr := s.Seek(pos); serr != nil && err == nil {
+		return nil, pkgerrors.Wrap(serr, "effects")
+	}
+	return p, err
+}
+
+// FrameSize returns the size in samples of the frames p was learnt with.
+func (p *NoiseProfile) FrameSize() int {
+	return 2 * (len(p.mag[0]) - 1)
+}
+
+// stftWindow returns the square root of a periodic Hann window of n samples, applied both
+// before and after the processing of each frame. Frames overlapping by three quarters then
+// add up to twice the input.
+func stftWindow(n int) []float64 {
+	w := make([]float64, n)
+	for i := range w {
+		w[i] = math.Sin(math.Pi * float64(i) / float64(n))
+	}
+	return w
+}
+
+// NoiseReducer removes the noise of a NoiseProfile from the wrapped Streamer by spectral
+// gating: the short-time spectrum of each channel is computed over overlapping frames, and the
+// frequency bins not louder than the noise by Threshold are attenuated by Reduction. The gains
+// are smoothed over neighbouring bins and over time, which avoids the chirps of "musical noise".
+//
+// The fields may be changed while streaming, with the speaker locked. The output is delayed by
+// Latency samples, a frame, and NoiseReducer streams the remaining delayed samples after the
+// end of the wrapped Streamer.
+type NoiseReducer struct {
+	Streamer megasound.Streamer
+
+	// Reduction is the attenuation of the noise in dB.
+	Reduction float64
+
+	// Threshold is how much louder in dB than the noise a frequency bin must be to be kept.
+	Threshold float64
+
+	// Smoothing from 0 to less than 1 smooths the gains over time, 0 being no smoothing.
+	Smoothing float64
+
+	// FrequencySmoothing is the number of neighbouring bins on each side the gains are averaged
+	// over.
+	FrequencySmoothing int
+
+	profile *NoiseProfile
+	fft     *fourier.FFT
+	win     []float64
+	seq     []float64
+	coeff   []complex128
+	mask    []float64
+	gains   [2][]float64 // smoothed gains of the bins
+	in      [2][]float64 // last frame of the input
+	out     [2][]float64 // overlap-add of the processed frames
+	ready   [2][]float64 // output of the last hop
+	pos     int          // position in the hop
+	tail    tail
+}
+
+// NewNoiseReducer returns a NoiseReducer of s removing the noise of p, with a Reduction of 12
+// dB, a Threshold of 6 dB, a Smoothing of 0.5 and a FrequencySmoothing of 1 bin.
+func NewNoiseReducer(s megasound.Streamer, p *NoiseProfile) *NoiseReducer {
+	n := p.FrameSize()
+	r := &NoiseReducer{
+		Streamer:           s,
+		Reduction:          12,
+		Threshold:          6,
+		Smoothing:          0.5,
+		FrequencySmoothing: 1,
+		profile:            p,
+		fft:                fourier.NewFFT(n),
+		win:                stftWindow(n),
+		seq:                make([]float64, n),
+		coeff:              make([]complex128, n/2+1),
+		mask:               make([]float64, n/2+1),
+	}
+	for c := range r.in {
+		r.gains[c] = make([]float64, n/2+1)
+		for k := range r.gains[c] {
+			r.gains[c][k] = 1
+		}
+		r.in[c] = make([]float64, n)
+		r.out[c] = make([]float64, n)
+		r.ready[c] = make([]float64, n/4)
+	}
+	return r
+}
+
+// Latency returns the delay of the output of r in samples, its frame size.
+func (r *NoiseReducer) Latency() int {
+	return r.profile.FrameSize()
+}
+
+// Stream streams the wrapped Streamer with the noise reduced, then the remaining delayed
+// samples.
+func (r *NoiseReducer) Stream(samples [][2]float64) (n int, ok bool) {
+	size := len(r.win)
+	n, ok = r.tail.stream(r.Streamer, samples, size)
+	if !ok {
+		return 0, false
+	}
+	hop := size / 4
+	for i := range samples[:n] {
+		for c := range samples[i] {
+			r.in[c][size-hop+r.pos] = samples[i][c]
+			samples[i][c] = r.ready[c][r.pos]
+		}
+		r.tail.observe(samples[i])
+		if r.pos++; r.pos == hop {
+			r.frame()
+			r.pos = 0
+		}
+	}
+	return n, true
+}
+
+// frame processes the last frame of the input and makes the output of the next hop ready.
+func (r *NoiseReducer) frame() {
+	size := len(r.win)
+	hop := size / 4
+	floor := math.Pow(10, -math.Max(0, r.Reduction)/20)
+	threshold := math.Pow(10, r.Threshold/20)
+	smoothing := math.Max(0, math.Min(r.Smoothing, 0.999))
+	spread := r.FrequencySmoothing
+	if spread < 0 {
+		spread = 0
+	}
+	// the windows add up to 2, and the inverse FFT is not normalized
+	scale := 0.5 / float64(size)
+	for c := range r.in {
+		for i := range r.seq {
+			r.seq[i] = r.in[c][i] * r.win[i]
+		}
+		r.fft.Coefficients(r.coeff, r.seq)
+		noise := r.profile.mag[c]
+		for k := range r.coeff {
+			r.mask[k] = floor
+			if cmplx.Abs(r.coeff[k]) > noise[k]*threshold {
+				r.mask[k] = 1
+			}
+		}
+		// running sum of the mask over the bins from k-spread to k+spread
+		sum, count := 0.0, 0
+		for k := 0; k < spread && k < len(r.mask); k++ {
+			sum += r.mask[k]
+			count++
+		}
+		for k := range r.coeff {
+			if j := k + spread; j < len(r.mask) {
+				sum += r.mask[j]
+				count++
+			}
+			if j := k - spread - 1; j >= 0 {
+				sum -= r.mask[j]
+				count--
+			}
+			g := &r.gains[c][k]
+			*g = smoothing**g + (1-smoothing)*sum/float64(count)
+			r.coeff[k] *= complex(*g, 0)
+		}
+		r.fft.Sequence(r.seq, r.coeff)
+		out := r.out[c]
+		for i := range out {
+			out[i] += r.seq[i] * r.win[i] * scale
+		}
+		copy(r.ready[c], out[:hop])
+		copy(out, out[hop:])
+		for i := size - hop; i < size; i++ {
+			out[i] = 0
+		}
+		copy(r.in[c], r.in[c][hop:])
+	}
+}
+
+// Err propagates the wrapped Streamer's errors.
+func (r *NoiseReducer) Err() error {
+	return r.Streamer.Err()
+}
@@ -0,0 +1,158 @@
+package effects
+
+import (
+	"math"
+
+	pkgerrors "github.com/pkg/errors"
+
+	"github.com/rickcollette/megasound"
+)
+
+// Curve is the transfer curve of a waveshaper: the output sample for an input sample. Curves
+// usually map the range from -1 to 1 onto itself, and bend it to add harmonics.
+type Curve func(x float64) float64
+
+// SoftClip saturates smoothly towards -1 and 1, as an overdriven analog amplifier.
+func SoftClip(x float64) float64 {
+	return math.Tanh(x)
+}
+
+// HardClip cuts the input above 1 and below -1, as an overdriven digital signal.
+func HardClip(x float64) float64 {
+	return math.Max(-1, math.Min(x, 1))
+}
+
+// Tube saturates asymmetrically, the negative half of the wave sooner than the positive one,
+// which adds the even harmonics of a tube amplifier.
+func Tube(x float64) float64 {
+	if x >= 0 {
+		return 1 - math.Exp(-x)
+	}
+	return (math.Exp(2*x) - 1) / 2
+}
+
+// Foldback folds the input above 1 and below -1 back into the range, over and over, for
+// harsh and metallic sounds.
+func Foldback(x float64) float64 {
+	x--
+	return math.Abs(x-4*math.Floor(x/4)-2) - 1
+}
+
+// CurveTable returns a Curve interpolating linearly between the values of table, evenly
+// spaced over the inputs from -1 to 1. The output is the first value below -1 and the last one
+// above 1. A table with a single value is constant, and an empty one is silent.
+func CurveTable(table []float64) Curve {
+	t := append([]float64(nil), table...)
+	return func(x float64) float64 {
+		switch len(t) {
+		case 0:
+			return 0
+		case 1:
+			return t[0]
+		}
+		p := (math.Max(-1, math.Min(x, 1)) + 1) / 2 * float64(len(t)-1)
+		i := int(p)
+		if i >= len(t)-1 {
+			return t[len(t)-1]
+		}
+		return t[i] + (t[i+1]-t[i])*(p-float64(i))
+	}
+}
+
+// dcBlockFrequency is the cutoff frequency in Hz of the high-pass filter removing the DC
+// offset added by asymmetric curves.
+const dcBlockFrequency = 10
+
+// Distortion shapes the wrapped Streamer with a Curve. The input is amplified by Drive before
+// the curve, so the louder the drive the stronger the distortion, and the output by Output
+// after it. The curve runs at a multiple of the sample rate to keep the aliasing of the
+// harmonics it adds down, and the DC offset of asymmetric curves is removed.
+//
+// The fields may be changed while streaming, with the speaker locked. The output is delayed
+// by Latency samples, the direct sound included, and Distortion streams the remaining delayed
+// samples after the end of the wrapped Streamer.
+type Distortion struct {
+	Streamer megasound.Streamer
+
+	// Curve is the transfer curve, for example SoftClip, Tube or a CurveTable.
+	Curve Curve
+
+	// Drive and Output are the gains in dB before and after the curve.
+	Drive, Output float64
+
+	// Wet and Dry are the gains of the distorted and of the direct sound.
+	Wet, Dry float64
+
+	os     *oversampler
+	line   *DelayLine // delays the direct sound by the latency of the oversampler
+	dc     float64    // coefficient of the DC blocking filter
+	dcIn   [2]float64
+	dcOut  [2]float64
+	tail   tail
+	factor int
+}
+
+// NewDistortion returns a Distortion of s at the sample rate sr with the Curve c, running it at
+// oversampling times the sample rate: 1, 2 or 4. The Drive and the Output are 0 dB, the Wet
+// gain is 1 and the Dry gain is 0.
+func NewDistortion(s megasound.Streamer, sr megasound.SampleRate, c Curve, oversampling int) (*Distortion, error) {
+	stages := 0
+	switch oversampling {
+	case 1:
+	case 2:
+		stages = 1
+	case 4:
+		stages = 2
+	default:
+		return nil, pkgerrors.Errorf("effects: invalid oversampling %d, must be 1, 2 or 4", oversampling)
+	}
+	o := newOversampler(stages)
+	return &Distortion{
+		Streamer: s,
+		Curve:    c,
+		Wet:      1,
+		os:       o,
+		line:     NewDelayLine(o.latency() + 1),
+		dc:       1 - 2*math.Pi*dcBlockFrequency/float64(sr),
+		factor:   oversampling,
+	}, nil
+}
+
+// Oversampling returns the multiple of the sample rate at which the Curve of d runs.
+func (d *Distortion) Oversampling() int {
+	return d.factor
+}
+
+// Latency returns the delay of the output of d in samples, that of the oversampling filters.
+func (d *Distortion) Latency() int {
+	return d.os.latency()
+}
+
+// Stream streams the wrapped Streamer distorted, then the remaining delayed samples.
+func (d *Distortion) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = d.tail.stream(d.Streamer, samples, d.os.latency())
+	if !ok {
+		return 0, false
+	}
+	drive := math.Pow(10, d.Drive/20)
+	out := math.Pow(10, d.Output/20) * d.Wet
+	delay := float64(d.os.latency() + 1)
+	for i := range samples[:n] {
+		x := samples[i]
+		d.line.Write(x)
+		dry := d.line.Read(delay)
+		y := d.os.process([2]float64{x[0] * drive, x[1] * drive}, d.Curve)
+		for c := range y {
+			d.dcOut[c] = y[c] - d.dcIn[c] + d.dc*d.dcOut[c]
+			d.dcIn[c] = y[c]
+			samples[i][c] = dry[c]*d.Dry + d.dcOut[c]*out
+		}
+		d.tail.observe(d.dcOut)
+	}
+	return n, true
+}
+
+// Err propagates the wrapped Streamer's errors.
+func (d *Distortion) Err() error {
+	return d.Streamer.Err()
+}
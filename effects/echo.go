@@ -0,0 +1,99 @@
+package effects
+
+import (
+	"math"
+	"time"
+
+	"github.com/rickcollette/megasound"
+)
+
+// echoGlide is the time constant in seconds over which an Echo follows changes of its Time,
+// like a tape delay, instead of clicking.
+const echoGlide = 0.05
+
+// Echo repeats the wrapped Streamer after a delay, each repeat fed back with the gain
+// Feedback, so the repeats die out. With PingPong, the repeats of both channels mixed bounce
+// between the left and the right channel.
+//
+// The fields may be changed while streaming, with the speaker locked. When the wrapped
+// Streamer ends, Echo keeps streaming the repeats until they die out.
+type Echo struct {
+	Streamer megasound.Streamer
+
+	// Time is the delay between repeats, up to the longest delay given to NewEcho.
+	Time time.Duration
+
+	// Feedback is the gain of each repeat, from 0 to less than 1.
+	Feedback float64
+
+	// PingPong makes the repeats alternate between the channels.
+	PingPong bool
+
+	// Wet and Dry are the gains of the repeats and of the direct sound.
+	Wet, Dry float64
+
+	sr    megasound.SampleRate
+	line  *DelayLine
+	delay float64 // current delay in samples, gliding to Time
+	glide float64
+	tail  tail
+}
+
+// NewEcho returns an Echo of s at the sample rate sr, able to delay up to max, with a Time of
+// 375 ms, a Feedback of 0.4, a Wet gain of 0.5 and a Dry gain of 1.
+func NewEcho(s megasound.Streamer, sr megasound.SampleRate, max time.Duration) *Echo {
+	e := &Echo{
+		Streamer: s,
+		Time:     375 * time.Millisecond,
+		Feedback: 0.4,
+		Wet:      0.5,
+		Dry:      1,
+		sr:       sr,
+		line:     NewDelayLine(sr.N(max)),
+		glide:    1 - math.Exp(-1/(echoGlide*float64(sr))),
+	}
+	e.delay = e.target()
+	return e
+}
+
+// SetTempo sets the Time of e to a number of beats at the tempo bpm in beats per minute, for
+// example 0.75 for a dotted eighth note when a beat is a quarter note.
+func (e *Echo) SetTempo(bpm, beats float64) {
+	if bpm > 0 {
+		e.Time = time.Duration(beats * 60 / bpm * float64(time.Second))
+	}
+}
+
+// target returns the delay in samples of Time.
+func (e *Echo) target() float64 {
+	return math.Max(1, math.Min(e.Time.Seconds()*float64(e.sr), float64(e.line.Max())))
+}
+
+// Stream streams the wrapped Streamer with the repeats, then the tail.
+func (e *Echo) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = e.tail.stream(e.Streamer, samples, e.line.Max())
+	if !ok {
+		return 0, false
+	}
+	target := e.target()
+	fb := math.Max(-0.99, math.Min(e.Feedback, 0.99))
+	for i := range samples[:n] {
+		e.delay += (target - e.delay) * e.glide
+		x := samples[i]
+		d := e.line.Read(e.delay)
+		if e.PingPong {
+			e.line.Write([2]float64{(x[0]+x[1])/2 + fb*d[1], fb * d[0]})
+		} else {
+			e.line.Write([2]float64{x[0] + fb*d[0], x[1] + fb*d[1]})
+		}
+		samples[i][0] = x[0]*e.Dry + d[0]*e.Wet
+		samples[i][1] = x[1]*e.Dry + d[1]*e.Wet
+		e.tail.observe(d)
+	}
+	return n, true
+}
+
+// Err propagates the wrapped Streamer's errors.
+func (e *Echo) Err() error {
+	return e.Streamer.Err()
+}
@@ -2,6 +2,7 @@ package effects
 
 import (
 	"math"
+	"math/cmplx"
 
 	"github.com/rickcollette/megasound"
 )
@@ -26,14 +27,14 @@ type (
 	}
 
 	StereoEqualizerSection struct {
-		Left  MonoEqualizerSection
-		Right MonoEqualizerSection
+		Left  MonoEqualizerSection `json:"left"`
+		Right MonoEqualizerSection `json:"right"`
 	}
 
 	MonoEqualizerSection struct {
 		// F0 (center frequency) sets the mid-point of the section’s
 		// frequency range and is given in Hertz [Hz].
-		F0 float64
+		F0 float64 `json:"f0"`
 
 		// Bf (bandwidth) represents the width of the section across
 		// frequency and is measured in Hertz [Hz]. A low bandwidth
@@ -43,24 +44,24 @@ type (
 		// a high bandwidth yields a section of wide frequency range —
 		// affecting a broader range of frequencies surrounding the
 		// center frequency.
-		Bf float64
+		Bf float64 `json:"bf"`
 
 		// GB (bandwidth gain) is given in decibels [dB] and represents
 		// the level at which the bandwidth is measured. That is, to
 		// have a meaningful measure of bandwidth, we must define the
 		// level at which it is measured.
-		GB float64
+		GB float64 `json:"gb"`
 
 		// G0 (reference gain) is given in decibels [dB] and simply
 		// represents the level of the section’s offset.
-		G0 float64
+		G0 float64 `json:"g0"`
 
 		// G (boost/cut gain) is given in decibels [dB] and prescribes
 		// the effect imposed on the audio loudness for the section’s
 		// frequency range. A boost/cut level of 0 dB corresponds to
 		// unity (no operation), whereas negative numbers corresponds to
 		// cut (volume down) and positive numbers to boost (volume up).
-		G float64
+		G float64 `json:"g"`
 	}
 
 	// StereoEqualizerSections implements EqualizerSections and can be passed into NewEqualizer
@@ -95,11 +96,51 @@ func (m StereoEqualizerSections) sections(fs float64) []section {
 	return out
 }
 
+// Response returns the combined frequency response of the sections at the frequency freq in
+// Hz for the SampleRate sr: the gain in decibels [dB] and the phase shift in radians.
+func (m MonoEqualizerSections) Response(sr megasound.SampleRate, freq float64) (gain, phase float64) {
+	h := response(m.sections(float64(sr)), float64(sr), freq)
+	return decibels(h[0]), cmplx.Phase(h[0])
+}
+
+// Response returns the combined frequency response of the sections at the frequency freq in
+// Hz for the SampleRate sr, for the left and right channels: the gains in decibels [dB] and
+// the phase shifts in radians.
+func (m StereoEqualizerSections) Response(sr megasound.SampleRate, freq float64) (gain, phase [2]float64) {
+	h := response(m.sections(float64(sr)), float64(sr), freq)
+	for c := range h {
+		gain[c], phase[c] = decibels(h[c]), cmplx.Phase(h[c])
+	}
+	return gain, phase
+}
+
+func decibels(h complex128) float64 {
+	return 20 * math.Log10(cmplx.Abs(h))
+}
+
+// response returns the product of the transfer functions of sections at freq.
+func response(sections []section, fs, freq float64) [2]complex128 {
+	h := [2]complex128{1, 1}
+	z := cmplx.Exp(complex(0, -2*math.Pi*freq/fs)) // z^-1
+	for _, s := range sections {
+		for c := range h {
+			var num, den, zk complex128 = 0, 0, 1
+			for k := range s.b[c] {
+				num += complex(s.b[c][k], 0) * zk
+				den += complex(s.a[c][k], 0) * zk
+				zk *= z
+			}
+			h[c] *= num / den
+		}
+	}
+	return h
+}
+
 // Stream streams the wrapped Streamer modified by Equalizer.
 func (e *equalizer) Stream(samples [][2]float64) (n int, ok bool) {
 	n, ok = e.streamer.Stream(samples)
-	for _, s := range e.sections {
-		s.apply(samples)
+	for i := range e.sections {
+		e.sections[i].apply(samples[:n])
 	}
 	return n, ok
 }
@@ -115,6 +156,10 @@ func (m MonoEqualizerSection) section(fs float64) section {
 			math.Pow(math.Pow(10.0, m.G0/20.0), 2.0))) /
 		math.Sqrt(math.Abs(math.Pow(math.Pow(10.0, m.G/20.0), 2.0)-
 			math.Pow(math.Pow(10.0, m.GB/20.0), 2.0)))
+	if math.IsNaN(beta) || math.IsInf(beta, 0) {
+		// G, GB and G0 are equal: the section is flat whatever its bandwidth
+		beta = math.Tan(m.Bf / 2.0 * math.Pi / (fs / 2.0))
+	}
 
 	b := []float64{
 		(math.Pow(10.0, m.G0/20.0) + math.Pow(10.0, m.G/20.0)*beta) / (1 + beta),
@@ -144,51 +189,33 @@ func (s StereoEqualizerSection) section(fs float64) section {
 	}
 }
 
+// apply filters x in place. xPast and yPast hold the last inputs and outputs, the most recent
+// first, across calls.
 func (s *section) apply(x [][2]float64) {
 	ord := len(s.a[0]) - 1
-	np := len(x) - 1
-
-	if np < ord {
-		x = append(x, make([][2]float64, ord-np)...)
-		np = ord
-	}
-
-	y := make([][2]float64, len(x))
-
-	if len(s.xPast) < len(x) {
-		s.xPast = append(s.xPast, make([][2]float64, len(x)-len(s.xPast))...)
+	if len(s.xPast) != ord {
+		s.xPast = make([][2]float64, ord)
+		s.yPast = make([][2]float64, ord)
 	}
 
-	if len(s.yPast) < len(x) {
-		s.yPast = append(s.yPast, make([][2]float64, len(x)-len(s.yPast))...)
-	}
-
-	for i := 0; i < len(x); i++ {
-		for j := 0; j < ord+1; j++ {
-			if i-j < 0 {
-				y[i][0] = y[i][0] + s.b[0][j]*s.xPast[len(s.xPast)-j][0]
-				y[i][1] = y[i][1] + s.b[1][j]*s.xPast[len(s.xPast)-j][1]
-			} else {
-				y[i][0] = y[i][0] + s.b[0][j]*x[i-j][0]
-				y[i][1] = y[i][1] + s.b[1][j]*x[i-j][1]
+	for i := range x {
+		for c := 0; c < 2; c++ {
+			in := x[i][c]
+			y := s.b[c][0] * in
+			for j := 0; j < ord; j++ {
+				y += s.b[c][j+1]*s.xPast[j][c] - s.a[c][j+1]*s.yPast[j][c]
 			}
-		}
+			y /= s.a[c][0]
 
-		for j := 0; j < ord; j++ {
-			if i-j-1 < 0 {
-				y[i][0] = y[i][0] - s.a[0][j+1]*s.yPast[len(s.yPast)-j-1][0]
-				y[i][1] = y[i][1] - s.a[1][j+1]*s.yPast[len(s.yPast)-j-1][1]
-			} else {
-				y[i][0] = y[i][0] - s.a[0][j+1]*y[i-j-1][0]
-				y[i][1] = y[i][1] - s.a[1][j+1]*y[i-j-1][1]
+			for j := ord - 1; j > 0; j-- {
+				s.xPast[j][c] = s.xPast[j-1][c]
+				s.yPast[j][c] = s.yPast[j-1][c]
+			}
+			if ord > 0 {
+				s.xPast[0][c] = in
+				s.yPast[0][c] = y
 			}
+			x[i][c] = y
 		}
-
-		y[i][0] = y[i][0] / s.a[0][0]
-		y[i][1] = y[i][1] / s.a[1][0]
 	}
-
-	s.xPast = x[:]
-	s.yPast = y[:]
-	copy(x, y)
 }
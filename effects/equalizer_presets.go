@@ -0,0 +1,119 @@
+package effects
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+// OctaveBands are the ISO center frequencies in Hertz [Hz] of the bands of a 10-band graphic
+// equalizer, one octave apart.
+var OctaveBands = [10]float64{31.5, 63, 125, 250, 500, 1000, 2000, 4000, 8000, 16000}
+
+// ThirdOctaveBands are the ISO center frequencies in Hertz [Hz] of the bands of a 31-band
+// graphic equalizer, a third of an octave apart.
+var ThirdOctaveBands = [31]float64{
+	20, 25, 31.5, 40, 50, 63, 80, 100, 125, 160,
+	200, 250, 315, 400, 500, 630, 800, 1000, 1250, 1600,
+	2000, 2500, 3150, 4000, 5000, 6300, 8000, 10000, 12500, 16000,
+	20000,
+}
+
+// EqualizerPresets are gains in decibels [dB] of the OctaveBands for GraphicEqualizer10.
+var EqualizerPresets = map[string][10]float64{
+	"flat":         {},
+	"bass boost":   {6, 5, 4, 2, 0, 0, 0, 0, 0, 0},
+	"treble boost": {0, 0, 0, 0, 0, 0, 2, 4, 5, 6},
+	"vocal":        {-3, -2, -1, 0, 2, 3, 3, 2, 0, -1},
+	"loudness":     {6, 4, 2, 0, -1, -1, 0, 2, 4, 5},
+}
+
+// GraphicEqualizer10 returns the sections of a 10-band graphic equalizer with the gains in
+// decibels [dB] of the OctaveBands, for example one of the EqualizerPresets. Each section
+// spans an octave, and its gain at the edges of the octave is half its boost or cut.
+func GraphicEqualizer10(gains [10]float64) MonoEqualizerSections {
+	return graphicEqualizer(OctaveBands[:], gains[:], 1)
+}
+
+// GraphicEqualizer31 returns the sections of a 31-band graphic equalizer with the gains in
+// decibels [dB] of the ThirdOctaveBands. Each section spans a third of an octave.
+func GraphicEqualizer31(gains [31]float64) MonoEqualizerSections {
+	return graphicEqualizer(ThirdOctaveBands[:], gains[:], 1.0/3.0)
+}
+
+// graphicEqualizer returns sections at the center frequencies bands, each spanning the
+// given number of octaves.
+func graphicEqualizer(bands, gains []float64, octaves float64) MonoEqualizerSections {
+	width := math.Pow(2, octaves/2) - math.Pow(2, -octaves/2)
+	s := make(MonoEqualizerSections, len(bands))
+	for i, f := range bands {
+		s[i] = MonoEqualizerSection{F0: f, Bf: f * width, GB: gains[i] / 2, G: gains[i]}
+	}
+	return s
+}
+
+// equalizerJSON is the JSON representation of EqualizerSections, with either field set.
+type equalizerJSON struct {
+	Mono   MonoEqualizerSections   `json:"mono,omitempty"`
+	Stereo StereoEqualizerSections `json:"stereo,omitempty"`
+}
+
+// EncodeEqualizerSections writes the sections s to w as JSON, to save or share equalizer
+// settings. DecodeEqualizerSections reads them back.
+func EncodeEqualizerSections(w io.Writer, s EqualizerSections) error {
+	var v equalizerJSON
+	switch s := s.(type) {
+	case MonoEqualizerSections:
+		v.Mono = s
+	case StereoEqualizerSections:
+		v.Stereo = s
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return pkgerrors.Wrap(err, "effects")
+	}
+	return nil
+}
+
+// DecodeEqualizerSections reads sections written by EncodeEqualizerSections from r. It returns
+// MonoEqualizerSections or StereoEqualizerSections, as they were written.
+func DecodeEqualizerSections(r io.Reader) (EqualizerSections, error) {
+	var v equalizerJSON
+	if err := json.NewDecoder(r).Decode(&v); err != nil {
+		return nil, pkgerrors.Wrap(err, "effects")
+	}
+	if v.Stereo != nil {
+		if v.Mono != nil {
+			return nil, pkgerrors.New("effects: both mono and stereo equalizer sections")
+		}
+		for _, s := range v.Stereo {
+			if err := s.Left.check(); err != nil {
+				return nil, err
+			}
+			if err := s.Right.check(); err != nil {
+				return nil, err
+			}
+		}
+		return v.Stereo, nil
+	}
+	for _, s := range v.Mono {
+		if err := s.check(); err != nil {
+			return nil, err
+		}
+	}
+	if v.Mono == nil {
+		return MonoEqualizerSections{}, nil
+	}
+	return v.Mono, nil
+}
+
+// check returns an error if the frequencies of m are not positive.
+func (m MonoEqualizerSection) check() error {
+	if !(m.F0 > 0) || !(m.Bf > 0) {
+		return pkgerrors.Errorf("effects: invalid equalizer section frequencies F0 %v and Bf %v", m.F0, m.Bf)
+	}
+	return nil
+}
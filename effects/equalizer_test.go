@@ -0,0 +1,119 @@
+package effects_test
+
+import (
+	"bytes"
+	"math"
+	"math/cmplx"
+	"testing"
+
+	"github.com/rickcollette/megasound"
+	"github.com/rickcollette/megasound/effects"
+	"github.com/rickcollette/megasound/generators"
+)
+
+// sine returns a Streamer of n samples of a sine at freq Hz for the sample rate sr.
+func sine(sr megasound.SampleRate, freq float64, n int) megasound.Streamer {
+	i := 0
+	return megasound.Take(n, megasound.StreamerFunc(func(samples [][2]float64) (int, bool) {
+		for j := range samples {
+			x := math.Sin(2 * math.Pi * freq * float64(i) / float64(sr))
+			samples[j] = [2]float64{x, x}
+			i++
+		}
+		return len(samples), true
+	}))
+}
+
+// streamChunks streams all of s in chunks of the sizes in sizes, over and over.
+func streamChunks(s megasound.Streamer, sizes ...int) [][2]float64 {
+	var out [][2]float64
+	buf := make([][2]float64, 512)
+	for k := 0; ; k++ {
+		n, ok := s.Stream(buf[:sizes[k%len(sizes)]])
+		out = append(out, buf[:n]...)
+		if !ok {
+			return out
+		}
+	}
+}
+
+// TestEqualizerState checks that the filter state is kept across calls to Stream, so that the
+// output doesn't depend on how the stream is chunked, including chunks shorter than the order
+// of the filters, and that a section boosts its center frequency by its gain.
+func TestEqualizerState(t *testing.T) {
+	const sr, freq, n = megasound.SampleRate(44100), 1000.0, 44100
+	sections := effects.MonoEqualizerSections{
+		{F0: freq, Bf: 200, GB: 3, G0: 0, G: 6},
+	}
+	whole := streamChunks(effects.NewEqualizer(sine(sr, freq, n), sr, sections), 512)
+	chunked := streamChunks(effects.NewEqualizer(sine(sr, freq, n), sr, sections), 1, 2, 7, 100)
+	if len(whole) != n || len(chunked) != n {
+		t.Fatalf("streamed %d and %d samples, want %d", len(whole), len(chunked), n)
+	}
+	for i := range whole {
+		if math.Abs(whole[i][0]-chunked[i][0]) > 1e-12 || math.Abs(whole[i][1]-chunked[i][1]) > 1e-12 {
+			t.Fatalf("sample %d is %v streamed whole and %v streamed in chunks", i, whole[i], chunked[i])
+		}
+	}
+
+	peak := 0.0
+	for _, x := range chunked[n/2:] {
+		peak = math.Max(peak, math.Abs(x[0]))
+	}
+	want := sections[0].G
+	if got := 20 * math.Log10(peak); math.Abs(got-want) > 0.1 {
+		t.Errorf("gain at %v Hz is %.2f dB, want %.2f dB", freq, got, want)
+	}
+}
+
+// measure returns the response of the sections s at freq Hz for the channel c, from the
+// Fourier transform of their impulse response.
+func measure(s effects.EqualizerSections, freq float64, c int) complex128 {
+	const sr = 48000
+	out := streamChunks(effects.NewEqualizer(generators.Impulse(sr), sr, s), 512)
+	var h complex128
+	for i, x := range out {
+		h += complex(x[c], 0) * cmplx.Exp(complex(0, -2*math.Pi*freq*float64(i)/sr))
+	}
+	return h
+}
+
+func TestEqualizerResponse(t *testing.T) {
+	gains := effects.EqualizerPresets["vocal"]
+	m := effects.GraphicEqualizer10(gains)
+	for _, f := range []float64{50, 440, 1000, 3000, 12000} {
+		gain, phase := m.Response(48000, f)
+		h := measure(m, f, 0)
+		if math.Abs(gain-20*math.Log10(cmplx.Abs(h))) > 0.05 || math.Abs(math.Remainder(phase-cmplx.Phase(h), 2*math.Pi)) > 0.01 {
+			t.Errorf("response at %v Hz is %.2f dB %.3f rad, measured %.2f dB %.3f rad", f, gain, phase, 20*math.Log10(cmplx.Abs(h)), cmplx.Phase(h))
+		}
+	}
+	for _, f := range []float64{20, 1000, 15000} {
+		if gain, _ := effects.GraphicEqualizer31([31]float64{}).Response(48000, f); math.Abs(gain) > 1e-9 {
+			t.Errorf("flat equalizer has a gain of %v dB at %v Hz", gain, f)
+		}
+	}
+
+	st := effects.StereoEqualizerSections{{
+		Left:  effects.MonoEqualizerSection{F0: 1000, Bf: 500, GB: 3, G: 6},
+		Right: effects.MonoEqualizerSection{F0: 200, Bf: 100, GB: -3, G: -6},
+	}}
+	if gain, _ := st.Response(48000, 1000); math.Abs(gain[0]-6) > 0.01 || math.Abs(gain[1]) > 0.5 {
+		t.Errorf("stereo response at 1000 Hz is %v dB", gain)
+	}
+
+	var b bytes.Buffer
+	if err := effects.EncodeEqualizerSections(&b, st); err != nil {
+		t.Fatal(err)
+	}
+	d, err := effects.DecodeEqualizerSections(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := d.(effects.StereoEqualizerSections); !ok || len(got) != 1 || got[0] != st[0] {
+		t.Errorf("decoded %v, want %v", d, st)
+	}
+	if _, err := effects.DecodeEqualizerSections(bytes.NewBufferString(`{"mono":[{"f0":0}]}`)); err == nil {
+		t.Error("decoding a section without bandwidth succeeded")
+	}
+}
@@ -0,0 +1,67 @@
+package effects
+
+import "github.com/rickcollette/megasound"
+
+// MidSideEncode converts the left and right channels of the wrapped Streamer to mid and side
+// channels: the mid channel (L+R)/2 in the left channel and the side channel (L-R)/2 in the
+// right channel. Effects applied in between process the center and the sides of the stereo
+// image separately. MidSideDecode converts them back.
+//
+// The returned Streamer propagates s's errors through Err.
+func MidSideEncode(s megasound.Streamer) megasound.Streamer {
+	return &midSide{s, true}
+}
+
+// MidSideDecode converts the mid and side channels of the wrapped Streamer, as produced by
+// MidSideEncode, back to left and right channels: L = M+S and R = M-S.
+//
+// The returned Streamer propagates s's errors through Err.
+func MidSideDecode(s megasound.Streamer) megasound.Streamer {
+	return &midSide{s, false}
+}
+
+type midSide struct {
+	Streamer megasound.Streamer
+	encode   bool
+}
+
+func (m *midSide) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = m.Streamer.Stream(samples)
+	for i := range samples[:n] {
+		a, b := samples[i][0], samples[i][1]
+		if m.encode {
+			samples[i][0], samples[i][1] = (a+b)/2, (a-b)/2
+		} else {
+			samples[i][0], samples[i][1] = a+b, a-b
+		}
+	}
+	return n, ok
+}
+
+func (m *midSide) Err() error {
+	return m.Streamer.Err()
+}
+
+// Width changes the stereo width of the wrapped Streamer by scaling its side channel, the
+// difference between the left and right channels. The Width field value of 0 means mono, 1
+// changes nothing and 2 doubles the side channel.
+type Width struct {
+	Streamer megasound.Streamer
+	Width    float64
+}
+
+// Stream streams the wrapped Streamer with its width changed.
+func (w *Width) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = w.Streamer.Stream(samples)
+	for i := range samples[:n] {
+		mid := (samples[i][0] + samples[i][1]) / 2
+		side := (samples[i][0] - samples[i][1]) / 2 * w.Width
+		samples[i][0], samples[i][1] = mid+side, mid-side
+	}
+	return n, ok
+}
+
+// Err propagates the wrapped Streamer's errors.
+func (w *Width) Err() error {
+	return w.Streamer.Err()
+}
@@ -0,0 +1,123 @@
+package effects
+
+import "math"
+
+// halfBandCenters are the delays in samples of the half-band filters of the successive
+// stages of an oversampler, at the sample rate of the input of each stage. The first stage
+// needs the sharpest filter. The later ones have wider transition bands, and a delay that is
+// a multiple of 2 keeps the latency a whole number of samples.
+var halfBandCenters = [...]int{31, 16}
+
+// halfBand is a windowed-sinc half-band low-pass filter, which keeps the lower half of the
+// band of a stream, to double or halve its sample rate without images or aliasing.
+type halfBand struct {
+	center int
+	taps   []float64    // coefficients at the odd offsets 1, 3, 5... from the center
+	hist   [][2]float64 // ring of the last 2·center+1 inputs
+	pos    int
+}
+
+func newHalfBand(center int) *halfBand {
+	h := &halfBand{
+		center: center,
+		hist:   make([][2]float64, 2*center+1),
+	}
+	sum := 0.0
+	for k := 1; k <= center; k += 2 {
+		x := float64(k)
+		w := 0.42 + 0.5*math.Cos(math.Pi*x/float64(center+1)) + 0.08*math.Cos(2*math.Pi*x/float64(center+1))
+		t := math.Sin(math.Pi*x/2) / (math.Pi * x) * w
+		h.taps = append(h.taps, t)
+		sum += 2 * t
+	}
+	// normalize to a gain of exactly 1 at 0 Hz, the center coefficient being 1/2
+	for i := range h.taps {
+		h.taps[i] *= 0.5 / sum
+	}
+	return h
+}
+
+func (h *halfBand) push(x [2]float64) {
+	h.hist[h.pos] = x
+	if h.pos++; h.pos == len(h.hist) {
+		h.pos = 0
+	}
+}
+
+// at returns the input pushed i samples ago, 0 being the last one.
+func (h *halfBand) at(i int) [2]float64 {
+	j := h.pos - 1 - i
+	if j < 0 {
+		j += len(h.hist)
+	}
+	return h.hist[j]
+}
+
+func (h *halfBand) output() [2]float64 {
+	c := h.at(h.center)
+	y := [2]float64{c[0] / 2, c[1] / 2}
+	for i, t := range h.taps {
+		k := 2*i + 1
+		a, b := h.at(h.center-k), h.at(h.center+k)
+		y[0] += t * (a[0] + b[0])
+		y[1] += t * (a[1] + b[1])
+	}
+	return y
+}
+
+// upsample returns the two samples at twice the rate following x.
+func (h *halfBand) upsample(x [2]float64) (a, b [2]float64) {
+	h.push(x)
+	a = h.output()
+	h.push([2]float64{})
+	b = h.output()
+	return [2]float64{2 * a[0], 2 * a[1]}, [2]float64{2 * b[0], 2 * b[1]}
+}
+
+// downsample returns the sample at half the rate of the two samples a and b.
+func (h *halfBand) downsample(a, b [2]float64) [2]float64 {
+	h.push(a)
+	y := h.output()
+	h.push(b)
+	return y
+}
+
+// oversampler runs a Curve at a multiple of the sample rate, so the harmonics it creates
+// above the Nyquist frequency are filtered out instead of aliasing back into the band. Each
+// stage doubles the sample rate.
+type oversampler struct {
+	up, down []*halfBand
+}
+
+func newOversampler(stages int) *oversampler {
+	o := &oversampler{}
+	for i := 0; i < stages; i++ {
+		o.up = append(o.up, newHalfBand(halfBandCenters[i]))
+		o.down = append(o.down, newHalfBand(halfBandCenters[i]))
+	}
+	return o
+}
+
+// latency returns the delay of the output of o in samples.
+func (o *oversampler) latency() int {
+	l := 0
+	for i := range o.up {
+		l += halfBandCenters[i] >> i
+	}
+	return l
+}
+
+// process returns the next output sample of o for the input x, shaped by c.
+func (o *oversampler) process(x [2]float64, c Curve) [2]float64 {
+	return o.run(0, x, c)
+}
+
+func (o *oversampler) run(stage int, x [2]float64, c Curve) [2]float64 {
+	if stage == len(o.up) {
+		return [2]float64{c(x[0]), c(x[1])}
+	}
+	a, b := o.up[stage].upsample(x)
+	a = o.run(stage+1, a, c)
+	b = o.run(stage+1, b, c)
+	return o.down[stage].downsample(a, b)
+}
@@ -5,6 +5,8 @@ import "github.com/rickcollette/megasound"
 // Pan balances the wrapped Streamer between the left and the right channel. The Pan field value of
 // -1 means that both original channels go through the left channel. The value of +1 means the same
 // for the right channel. The value of 0 changes nothing.
+//
+// Pan follows no pan law: see Panner to position a sound and Balance to balance stereo sound.
 type Pan struct {
 	Streamer megasound.Streamer
 	Pan      float64
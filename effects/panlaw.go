@@ -0,0 +1,96 @@
+package effects
+
+import (
+	"math"
+
+	"github.com/rickcollette/megasound"
+)
+
+// PanLaw sets how the gains of the channels change as a Panner moves a sound, named by the
+// attenuation of both channels at the center.
+type PanLaw int
+
+const (
+	// LinearPanLaw changes the gains linearly, -6 dB at the center. The sum of the channels
+	// stays constant, which suits sounds played in mono.
+	LinearPanLaw PanLaw = iota
+
+	// ConstantPowerPanLaw follows a sine and a cosine, -3 dB at the center. The power of the
+	// channels stays constant, so the loudness does not change over stereo speakers.
+	ConstantPowerPanLaw
+
+	// CompromisePanLaw is the geometric mean of the two others, -4.5 dB at the center.
+	CompromisePanLaw
+)
+
+// Gains returns the gains of the left and right channels at the pan position pan, from -1
+// for left to +1 for right. The gain of the channel panned to is 1.
+func (l PanLaw) Gains(pan float64) (left, right float64) {
+	pan = math.Max(-1, math.Min(pan, 1))
+	linLeft, linRight := (1-pan)/2, (1+pan)/2
+	theta := (pan + 1) * math.Pi / 4
+	powLeft, powRight := math.Cos(theta), math.Sin(theta)
+	switch l {
+	case ConstantPowerPanLaw:
+		return powLeft, powRight
+	case CompromisePanLaw:
+		return math.Sqrt(linLeft * powLeft), math.Sqrt(linRight * powRight)
+	}
+	return linLeft, linRight
+}
+
+// Panner positions the wrapped Streamer, downmixed to mono, between the left and the right
+// channel following a pan law, like the pan control of a mixing console. The Pan field value
+// of -1 means left, +1 right and 0 the center. For stereo sources, see Balance.
+type Panner struct {
+	Streamer megasound.Streamer
+	Pan      float64
+	Law      PanLaw
+}
+
+// Stream streams the wrapped Streamer panned.
+func (p *Panner) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = p.Streamer.Stream(samples)
+	left, right := p.Law.Gains(p.Pan)
+	for i := range samples[:n] {
+		mix := (samples[i][0] + samples[i][1]) / 2
+		samples[i][0], samples[i][1] = mix*left, mix*right
+	}
+	return n, ok
+}
+
+// Err propagates the wrapped Streamer's errors.
+func (p *Panner) Err() error {
+	return p.Streamer.Err()
+}
+
+// Balance balances the wrapped Streamer between the left and the right channel by attenuating
+// one of them, keeping the stereo image, like the balance control of an amplifier. The
+// Balance field value of -1 mutes the right channel, +1 mutes the left channel and 0 changes
+// nothing.
+type Balance struct {
+	Streamer megasound.Streamer
+	Balance  float64
+}
+
+// Stream streams the wrapped Streamer balanced.
+func (b *Balance) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = b.Streamer.Stream(samples)
+	balance := math.Max(-1, math.Min(b.Balance, 1))
+	left, right := 1.0, 1.0
+	if balance > 0 {
+		left = 1 - balance
+	} else {
+		right = 1 + balance
+	}
+	for i := range samples[:n] {
+		samples[i][0] *= left
+		samples[i][1] *= right
+	}
+	return n, ok
+}
+
+// Err propagates the wrapped Streamer's errors.
+func (b *Balance) Err() error {
+	return b.Streamer.Err()
+}
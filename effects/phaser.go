@@ -0,0 +1,111 @@
+package effects
+
+import (
+	"math"
+	"time"
+
+	"github.com/rickcollette/megasound"
+)
+
+// MaxPhaserStages is the largest number of Stages of a Phaser.
+const MaxPhaserStages = 12
+
+// phaserTail is how long a Phaser keeps streaming silence before its tail is over.
+const phaserTail = 50 * time.Millisecond
+
+// Phaser mixes the wrapped Streamer with a copy passed through a chain of allpass filters,
+// whose frequency sweeps, fed back with the gain Feedback. The phase shifts of the filters
+// sweep notches across the spectrum.
+//
+// The fields may be changed while streaming, with the speaker locked.
+type Phaser struct {
+	Streamer megasound.Streamer
+
+	// Rate is the frequency in Hz of the sweep.
+	Rate float64
+
+	// MinFrequency and MaxFrequency in Hz are the range of the sweep. Depth from 0 to 1 is the
+	// part of the range swept, up from MinFrequency.
+	MinFrequency, MaxFrequency float64
+	Depth                      float64
+
+	// Stages is the number of first-order allpass filters, each pair making a notch, from 1
+	// to MaxPhaserStages.
+	Stages int
+
+	// Feedback is the gain of the filtered sound fed back, from -1 to 1 excluded.
+	Feedback float64
+
+	// Wet and Dry are the gains of the filtered sound and of the direct sound.
+	Wet, Dry float64
+
+	sr    megasound.SampleRate
+	lfo   lfo
+	state [MaxPhaserStages][2]float64
+	last  [2]float64 // the last filtered sample, fed back
+	tail  tail
+}
+
+// NewPhaser returns a Phaser of s at the sample rate sr, with a Rate of 0.5 Hz, a sweep from
+// 200 Hz to 2000 Hz at a Depth of 1, 4 Stages, a Feedback of 0.5, and Wet and Dry gains of
+// 0.5.
+func NewPhaser(s megasound.Streamer, sr megasound.SampleRate) *Phaser {
+	return &Phaser{
+		Streamer:     s,
+		Rate:         0.5,
+		MinFrequency: 200,
+		MaxFrequency: 2000,
+		Depth:        1,
+		Stages:       4,
+		Feedback:     0.5,
+		Wet:          0.5,
+		Dry:          0.5,
+		sr:           sr,
+	}
+}
+
+// Stream streams the wrapped Streamer through the phaser, then the tail.
+func (p *Phaser) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = p.tail.stream(p.Streamer, samples, p.sr.N(phaserTail))
+	if !ok {
+		return 0, false
+	}
+	sr := float64(p.sr)
+	stages := p.Stages
+	if stages < 1 {
+		stages = 1
+	} else if stages > MaxPhaserStages {
+		stages = MaxPhaserStages
+	}
+	fb := math.Max(-0.99, math.Min(p.Feedback, 0.99))
+	lo := math.Max(1, math.Min(p.MinFrequency, 0.49*sr))
+	hi := math.Max(lo, math.Min(p.MaxFrequency, 0.49*sr))
+	// the frequency sweeps exponentially, by octaves
+	octaves := math.Log2(hi/lo) * clamp01(p.Depth)
+
+	for i := range samples[:n] {
+		mod := p.lfo.next(p.Rate/sr, 0)
+		f := lo * math.Exp2(octaves*(mod[0]+1)/2)
+		t := math.Tan(math.Pi * f / sr)
+		a := (t - 1) / (t + 1)
+		x := samples[i]
+		for c := range x {
+			y := x[c] + fb*p.last[c]
+			for k := 0; k < stages; k++ {
+				s := &p.state[k][c]
+				out := a*y + *s
+				*s = y - a*out
+				y = out
+			}
+			p.last[c] = y
+			samples[i][c] = x[c]*p.Dry + y*p.Wet
+		}
+		p.tail.observe(p.last)
+	}
+	return n, true
+}
+
+// Err propagates the wrapped Streamer's errors.
+func (p *Phaser) Err() error {
+	return p.Streamer.Err()
+}
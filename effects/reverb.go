@@ -0,0 +1,180 @@
+package effects
+
+import (
+	"math"
+	"time"
+
+	"github.com/rickcollette/megasound"
+)
+
+// MaxPreDelay is the longest PreDelay of a Reverb.
+const MaxPreDelay = 500 * time.Millisecond
+
+// Delays in samples at 44100 Hz of the Freeverb comb and allpass filters of the left channel.
+// The right channel adds reverbSpread samples to decorrelate both channels.
+var (
+	reverbCombs     = [8]int{1116, 1188, 1277, 1356, 1422, 1491, 1557, 1617}
+	reverbAllpasses = [4]int{556, 441, 341, 225}
+)
+
+const (
+	reverbSpread    = 23
+	reverbInputGain = 0.015
+)
+
+// Reverb simulates the reverberation of a room, with the Freeverb algorithm by Jezar at
+// Dreampoint: a Schroeder-Moorer reverb of eight damped comb filters in parallel followed by
+// four allpass filters in series for each channel.
+//
+// The fields may be changed while streaming, with the speaker locked. When the wrapped
+// Streamer ends, Reverb keeps streaming the tail of the reverberation until it dies out.
+type Reverb struct {
+	Streamer megasound.Streamer
+
+	// RoomSize from 0 to 1 sets the length of the reverberation.
+	RoomSize float64
+
+	// Damping from 0 to 1 sets how fast the high frequencies die out, as in a room with soft
+	// walls.
+	Damping float64
+
+	// PreDelay delays the reverberation after the direct sound, up to MaxPreDelay.
+	PreDelay time.Duration
+
+	// Width from 0 to 1 sets the stereo width of the reverberation, 0 being mono.
+	Width float64
+
+	// Wet and Dry are the gains of the reverberation and of the direct sound.
+	Wet, Dry float64
+
+	sr        megasound.SampleRate
+	combs     [2][8]reverbComb
+	allpasses [2][4]reverbAllpass
+	pre       []float64 // pre-delay line of the mono input
+	prePos    int
+	tail      tail
+}
+
+// NewReverb returns a Reverb of s at the sample rate sr, with a medium room: a RoomSize and
+// a Damping of 0.5, no PreDelay, a Width of 1, a Wet gain of 0.3 and a Dry gain of 1.
+func NewReverb(s megasound.Streamer, sr megasound.SampleRate) *Reverb {
+	r := &Reverb{
+		Streamer: s,
+		RoomSize: 0.5,
+		Damping:  0.5,
+		Width:    1,
+		Wet:      0.3,
+		Dry:      1,
+		sr:       sr,
+		pre:      make([]float64, sr.N(MaxPreDelay)+1),
+	}
+	scale := float64(sr) / 44100
+	for c := range r.combs {
+		spread := c * reverbSpread
+		for i, d := range reverbCombs {
+			r.combs[c][i].buf = make([]float64, reverbDelay(d+spread, scale))
+		}
+		for i, d := range reverbAllpasses {
+			r.allpasses[c][i].buf = make([]float64, reverbDelay(d+spread, scale))
+		}
+	}
+	return r
+}
+
+// reverbDelay returns the delay d in samples at 44100 Hz scaled to another sample rate.
+func reverbDelay(d int, scale float64) int {
+	n := int(math.Round(float64(d) * scale))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// Stream streams the wrapped Streamer with reverberation, then the tail.
+func (r *Reverb) Stream(samples [][2]float64) (n int, ok bool) {
+	// the tail is over once it has been quiet for the whole pre-delay and comb delays
+	n, ok = r.tail.stream(r.Streamer, samples, len(r.pre)+len(r.combs[1][7].buf))
+	if !ok {
+		return 0, false
+	}
+
+	feedback := 0.7 + 0.28*clamp01(r.RoomSize)
+	damp := 0.4 * clamp01(r.Damping)
+	width := clamp01(r.Width)
+	wet1 := r.Wet * (width/2 + 0.5)
+	wet2 := r.Wet * (1 - width) / 2
+	delay := r.sr.N(r.PreDelay)
+	if delay < 0 {
+		delay = 0
+	} else if delay >= len(r.pre) {
+		delay = len(r.pre) - 1
+	}
+
+	for i := range samples[:n] {
+		r.pre[r.prePos] = (samples[i][0] + samples[i][1]) * reverbInputGain
+		j := r.prePos - delay
+		if j < 0 {
+			j += len(r.pre)
+		}
+		in := r.pre[j]
+		if r.prePos++; r.prePos == len(r.pre) {
+			r.prePos = 0
+		}
+
+		var out [2]float64
+		for c := range out {
+			for k := range r.combs[c] {
+				out[c] += r.combs[c][k].process(in, feedback, damp)
+			}
+			for k := range r.allpasses[c] {
+				out[c] = r.allpasses[c][k].process(out[c])
+			}
+		}
+
+		samples[i][0] = out[0]*wet1 + out[1]*wet2 + samples[i][0]*r.Dry
+		samples[i][1] = out[1]*wet1 + out[0]*wet2 + samples[i][1]*r.Dry
+		r.tail.observe(out)
+	}
+	return n, true
+}
+
+// Err propagates the wrapped Streamer's errors.
+func (r *Reverb) Err() error {
+	return r.Streamer.Err()
+}
+
+func clamp01(x float64) float64 {
+	return math.Max(0, math.Min(x, 1))
+}
+
+// reverbComb is a comb filter with a low-pass filter in its feedback loop.
+type reverbComb struct {
+	buf   []float64
+	pos   int
+	store float64 // state of the low-pass filter
+}
+
+func (c *reverbComb) process(x, feedback, damp float64) float64 {
+	y := c.buf[c.pos]
+	c.store = y*(1-damp) + c.store*damp
+	c.buf[c.pos] = x + c.store*feedback
+	if c.pos++; c.pos == len(c.buf) {
+		c.pos = 0
+	}
+	return y
+}
+
+// reverbAllpass is a Schroeder allpass filter.
+type reverbAllpass struct {
+	buf []float64
+	pos int
+}
+
+func (a *reverbAllpass) process(x float64) float64 {
+	y := a.buf[a.pos]
+	a.buf[a.pos] = x + y*0.5
+	if a.pos++; a.pos == len(a.buf) {
+		a.pos = 0
+	}
+	return y - x
+}
@@ -0,0 +1,231 @@
+package filter
+
+import (
+	"math"
+	"math/cmplx"
+
+	"github.com/rickcollette/megasound"
+)
+
+// Type is the type of a Biquad.
+type Type int
+
+// Biquad types, following the Audio EQ Cookbook of Robert Bristow-Johnson. The gain only
+// applies to shelves and peaking filters; the band-pass filter has a peak gain of 0 dB.
+const (
+	LowPass Type = iota
+	HighPass
+	BandPass
+	Notch
+	AllPass
+	LowShelf
+	HighShelf
+	Peaking
+
+	// first-order sections of odd-order Butterworth filters, Q is ignored
+	lowPass1
+	highPass1
+)
+
+// smoothTime is the time constant in seconds of parameter changes, and smoothBlock the
+// number of samples between coefficient updates while they are applied.
+const (
+	smoothTime  = 0.01
+	smoothBlock = 16
+)
+
+// Biquad is a second-order IIR filter processing both channels of stereo samples. Its
+// frequency, Q and gain can be changed while processing: the changes are applied smoothly
+// over about 10 milliseconds to avoid clicks. Processing does not allocate.
+type Biquad struct {
+	typ Type
+	sr  float64
+
+	// current parameters, with the frequency in octaves (log2 Hz), and targets
+	oct, q, gain    float64
+	toOct, toQ, toG float64
+	freq            float64 // target frequency in Hz
+	smooth          float64 // one-pole coefficient of smoothing per block
+
+	b0, b1, b2, a1, a2 float64
+	z1, z2             [2]float64 // state of the transposed direct form II of each channel
+}
+
+// NewBiquad returns a Biquad of type typ at the sample rate sr, with the cutoff or center
+// frequency freq in Hz, the quality factor q and, for shelves and peaking filters, the gain
+// in dB. A q of 1/√2 gives the flattest low-pass and high-pass filters.
+func NewBiquad(sr megasound.SampleRate, typ Type, freq, q, gain float64) *Biquad {
+	b := &Biquad{
+		typ:    typ,
+		sr:     float64(sr),
+		smooth: 1 - math.Exp(-smoothBlock/(smoothTime*float64(sr))),
+	}
+	b.SetFrequency(freq)
+	b.toQ, b.toG = clampQ(q), gain
+	b.oct, b.q, b.gain = b.toOct, b.toQ, b.toG
+	b.update()
+	return b
+}
+
+func clampQ(q float64) float64 {
+	return math.Max(q, 0.01)
+}
+
+// Type returns the type of b.
+func (b *Biquad) Type() Type {
+	return b.typ
+}
+
+// Frequency returns the frequency of b in Hz, the target of a change in progress.
+func (b *Biquad) Frequency() float64 {
+	return b.freq
+}
+
+// Q returns the quality factor of b.
+func (b *Biquad) Q() float64 {
+	return b.toQ
+}
+
+// Gain returns the gain of b in dB.
+func (b *Biquad) Gain() float64 {
+	return b.toG
+}
+
+// SetFrequency changes the frequency of b to freq in Hz, limited from 1 Hz to just below half
+// the sample rate.
+func (b *Biquad) SetFrequency(freq float64) {
+	b.freq = math.Max(1, math.Min(freq, 0.49*b.sr))
+	b.toOct = math.Log2(b.freq)
+}
+
+// SetQ changes the quality factor of b.
+func (b *Biquad) SetQ(q float64) {
+	b.toQ = clampQ(q)
+}
+
+// SetGain changes the gain of b to gain in dB.
+func (b *Biquad) SetGain(gain float64) {
+	b.toG = gain
+}
+
+// Reset clears the state of b, as if it had only processed silence, and completes parameter
+// changes in progress.
+func (b *Biquad) Reset() {
+	b.z1, b.z2 = [2]float64{}, [2]float64{}
+	b.oct, b.q, b.gain = b.toOct, b.toQ, b.toG
+	b.update()
+}
+
+// settled reports whether the parameters of b have reached their targets.
+func (b *Biquad) settled() bool {
+	return b.oct == b.toOct && b.q == b.toQ && b.gain == b.toG
+}
+
+// step moves the parameters of b towards their targets and updates the coefficients.
+func (b *Biquad) step() {
+	approach := func(x, to, eps float64) float64 {
+		if x += (to - x) * b.smooth; math.Abs(to-x) < eps {
+			return to
+		}
+		return x
+	}
+	b.oct = approach(b.oct, b.toOct, 1e-4)
+	b.q = approach(b.q, b.toQ, 1e-4)
+	b.gain = approach(b.gain, b.toG, 1e-3)
+	b.update()
+}
+
+// update computes the coefficients of b from its current parameters.
+func (b *Biquad) update() {
+	w := 2 * math.Pi * math.Exp2(b.oct) / b.sr
+	sin, cos := math.Sincos(w)
+	alpha := sin / (2 * b.q)
+	A := math.Pow(10, b.gain/40)
+	var b0, b1, b2, a0, a1, a2 float64
+	switch b.typ {
+	case LowPass:
+		b0, b1, b2 = (1-cos)/2, 1-cos, (1-cos)/2
+		a0, a1, a2 = 1+alpha, -2*cos, 1-alpha
+	case HighPass:
+		b0, b1, b2 = (1+cos)/2, -(1 + cos), (1+cos)/2
+		a0, a1, a2 = 1+alpha, -2*cos, 1-alpha
+	case BandPass:
+		b0, b1, b2 = alpha, 0, -alpha
+		a0, a1, a2 = 1+alpha, -2*cos, 1-alpha
+	case Notch:
+		b0, b1, b2 = 1, -2*cos, 1
+		a0, a1, a2 = 1+alpha, -2*cos, 1-alpha
+	case AllPass:
+		b0, b1, b2 = 1-alpha, -2*cos, 1+alpha
+		a0, a1, a2 = 1+alpha, -2*cos, 1-alpha
+	case Peaking:
+		b0, b1, b2 = 1+alpha*A, -2*cos, 1-alpha*A
+		a0, a1, a2 = 1+alpha/A, -2*cos, 1-alpha/A
+	case LowShelf:
+		s := 2 * math.Sqrt(A) * alpha
+		b0 = A * ((A + 1) - (A-1)*cos + s)
+		b1 = 2 * A * ((A - 1) - (A+1)*cos)
+		b2 = A * ((A + 1) - (A-1)*cos - s)
+		a0 = (A + 1) + (A-1)*cos + s
+		a1 = -2 * ((A - 1) + (A+1)*cos)
+		a2 = (A + 1) + (A-1)*cos - s
+	case HighShelf:
+		s := 2 * math.Sqrt(A) * alpha
+		b0 = A * ((A + 1) + (A-1)*cos + s)
+		b1 = -2 * A * ((A - 1) + (A+1)*cos)
+		b2 = A * ((A + 1) + (A-1)*cos - s)
+		a0 = (A + 1) - (A-1)*cos + s
+		a1 = 2 * ((A - 1) - (A+1)*cos)
+		a2 = (A + 1) - (A-1)*cos - s
+	case lowPass1, highPass1:
+		k := math.Tan(w / 2)
+		a0, a1 = 1+k, k-1
+		if b.typ == lowPass1 {
+			b0, b1 = k, k
+		} else {
+			b0, b1 = 1, -1
+		}
+	default:
+		b0, a0 = 1, 1
+	}
+	b.b0, b.b1, b.b2, b.a1, b.a2 = b0/a0, b1/a0, b2/a0, a1/a0, a2/a0
+}
+
+// Process filters samples in place.
+func (b *Biquad) Process(samples [][2]float64) {
+	for len(samples) > 0 {
+		n := len(samples)
+		if !b.settled() {
+			b.step()
+			if n > smoothBlock {
+				n = smoothBlock
+			}
+		}
+		b.process(samples[:n])
+		samples = samples[n:]
+	}
+}
+
+func (b *Biquad) process(samples [][2]float64) {
+	b0, b1, b2, a1, a2 := b.b0, b.b1, b.b2, b.a1, b.a2
+	for c := 0; c < 2; c++ {
+		z1, z2 := b.z1[c], b.z2[c]
+		for i := range samples {
+			x := samples[i][c]
+			y := b0*x + z1
+			z1 = b1*x - a1*y + z2
+			z2 = b2*x - a2*y
+			samples[i][c] = y
+		}
+		b.z1[c], b.z2[c] = z1, z2
+	}
+}
+
+// Response returns the complex frequency response of b at freq in Hz, with its current
+// coefficients. Its magnitude is cmplx.Abs and its phase cmplx.Phase.
+func (b *Biquad) Response(freq float64) complex128 {
+	z := cmplx.Exp(complex(0, -2*math.Pi*freq/b.sr)) // z^-1
+	num := complex(b.b0, 0) + complex(b.b1, 0)*z + complex(b.b2, 0)*z*z
+	den := 1 + complex(b.a1, 0)*z + complex(b.a2, 0)*z*z
+	return num / den
+}
@@ -0,0 +1,112 @@
+package filter
+
+import (
+	"math"
+
+	pkgerrors "github.com/pkg/errors"
+
+	"github.com/rickcollette/megasound"
+)
+
+// Processor processes stereo samples in place. Biquad and Cascade are Processors.
+type Processor interface {
+	Process(samples [][2]float64)
+}
+
+// Cascade is a series of biquads, processed in order. Higher-order filters are cascades of
+// biquads.
+type Cascade []*Biquad
+
+// Process filters samples in place with each biquad of c.
+func (c Cascade) Process(samples [][2]float64) {
+	for _, b := range c {
+		b.Process(samples)
+	}
+}
+
+// SetFrequency changes the frequency of all biquads of c.
+func (c Cascade) SetFrequency(freq float64) {
+	for _, b := range c {
+		b.SetFrequency(freq)
+	}
+}
+
+// Reset resets all biquads of c.
+func (c Cascade) Reset() {
+	for _, b := range c {
+		b.Reset()
+	}
+}
+
+// Response returns the complex frequency response of c at freq in Hz, the product of the
+// responses of its biquads.
+func (c Cascade) Response(freq float64) complex128 {
+	h := complex(1, 0)
+	for _, b := range c {
+		h *= b.Response(freq)
+	}
+	return h
+}
+
+// Butterworth returns a low-pass or high-pass Butterworth filter of order from 1 to 16, with
+// its cutoff (-3 dB) at freq in Hz. Its slope is 6 dB per octave per order.
+func Butterworth(sr megasound.SampleRate, typ Type, order int, freq float64) (Cascade, error) {
+	if typ != LowPass && typ != HighPass {
+		return nil, pkgerrors.New("filter: Butterworth filters are low-pass or high-pass")
+	}
+	if order < 1 || order > 16 {
+		return nil, pkgerrors.Errorf("filter: invalid Butterworth order %d", order)
+	}
+	var c Cascade
+	if order%2 == 1 {
+		first := lowPass1
+		if typ == HighPass {
+			first = highPass1
+		}
+		c = append(c, NewBiquad(sr, first, freq, 1, 0))
+	}
+	for k := 1; k <= order/2; k++ {
+		q := 1 / (2 * math.Cos(float64(2*k-1+order%2)*math.Pi/float64(2*order)))
+		c = append(c, NewBiquad(sr, typ, freq, q, 0))
+	}
+	return c, nil
+}
+
+// LinkwitzRiley returns a low-pass or high-pass Linkwitz-Riley filter of even order from 2 to
+// 16, two Butterworth filters of half the order in series, with its cutoff (-6 dB) at freq in
+// Hz. The low-pass and high-pass filters of the same order and frequency sum to a flat
+// magnitude response, as crossovers; for orders 2, 6, 10 and 14 the high-pass output must be
+// inverted.
+func LinkwitzRiley(sr megasound.SampleRate, typ Type, order int, freq float64) (Cascade, error) {
+	if order < 2 || order > 16 || order%2 != 0 {
+		return nil, pkgerrors.Errorf("filter: invalid Linkwitz-Riley order %d", order)
+	}
+	a, err := Butterworth(sr, typ, order/2, freq)
+	if err != nil {
+		return nil, err
+	}
+	b, _ := Butterworth(sr, typ, order/2, freq)
+	return append(a, b...), nil
+}
+
+type filtered struct {
+	s megasound.Streamer
+	p Processor
+}
+
+// Apply returns a Streamer streaming s filtered by p. The parameters of the filters of p may
+// be changed while streaming, with the speaker locked.
+func Apply(s megasound.Streamer, p Processor) megasound.Streamer {
+	return &filtered{s, p}
+}
+
+func (f *filtered) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = f.s.Stream(samples)
+	f.p.Process(samples[:n])
+	return n, ok
+}
+
+// Err propagates the wrapped Streamer's errors.
+func (f *filtered) Err() error {
+	return f.s.Err()
+}
@@ -0,0 +1,7 @@
+// Package filter implements biquad filters: low-pass, high-pass, band-pass, notch, all-pass,
+// shelving and peaking filters, and Butterworth and Linkwitz-Riley filters of higher orders
+// made of cascaded biquads.
+//
+// Filters process samples in place without allocating, and their parameters can be changed
+// while streaming without clicks. Apply wraps a Streamer with a filter.
+package filter
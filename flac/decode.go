@@ -1,17 +1,34 @@
 package flac
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 
 	"github.com/rickcollette/megasound"
+	"github.com/rickcollette/megasound/tags"
 	"github.com/mewkiz/flac"
 	pkgerrors "github.com/pkg/errors"
 )
 
+func init() {
+	megasound.RegisterFormat("flac", func(head []byte) bool {
+		// some taggers put an ID3v2 tag in front of FLAC files
+		if size, ok := tags.ID3v2Size(head); ok && size <= len(head) {
+			head = head[size:]
+		}
+		return len(head) >= 4 && string(head[:4]) == "fLaC"
+	}, func(rc io.ReadCloser) (megasound.StreamSeekCloser, megasound.Format, error) {
+		return Decode(rc)
+	})
+}
+
 // Decode takes a Reader containing audio data in FLAC format and returns a StreamSeekCloser,
 // which streams that audio. The Seek method will panic if r is not io.Seeker.
 //
+// The returned StreamSeekCloser implements tags.Tagger, its Tags method returns the tags of the
+// VORBIS_COMMENT and PICTURE metadata blocks.
+//
 // Do not close the supplied Reader, instead, use the Close method of the returned
 // StreamSeekCloser when you want to release the resources.
 func Decode(r io.Reader) (s megasound.StreamSeekCloser, format megasound.Format, err error) {
@@ -26,10 +43,21 @@ func Decode(r io.Reader) (s megasound.StreamSeekCloser, format megasound.Format,
 
 	rs, seeker := r.(io.ReadSeeker)
 	if seeker {
+		var start int64
+		if start, err = rs.Seek(0, io.SeekCurrent); err != nil {
+			return nil, megasound.Format{}, pkgerrors.Wrap(err, "flac")
+		}
+		d.tags, _ = tags.Read(rs)
+		if _, err = rs.Seek(start, io.SeekStart); err != nil {
+			return nil, megasound.Format{}, pkgerrors.Wrap(err, "flac")
+		}
 		d.stream, err = flac.NewSeek(rs)
 		d.seekEnabled = true
 	} else {
-		d.stream, err = flac.New(r)
+		// the metadata blocks read for the tags are fed to the decoder again
+		var head bytes.Buffer
+		d.tags, _ = tags.Read(io.TeeReader(r, &head))
+		d.stream, err = flac.New(io.MultiReader(&head, r))
 	}
 
 	if err != nil {
@@ -50,6 +78,7 @@ type decoder struct {
 	pos         int
 	err         error
 	seekEnabled bool
+	tags        tags.Tags
 }
 
 func (d *decoder) Stream(samples [][2]float64) (n int, ok bool) {
@@ -158,6 +187,11 @@ func (d *decoder) Seek(p int) error {
 	return err
 }
 
+// Tags returns the tags of the VORBIS_COMMENT and PICTURE metadata blocks.
+func (d *decoder) Tags() tags.Tags {
+	return d.tags
+}
+
 func (d *decoder) Close() error {
 	if closer, ok := d.r.(io.Closer); ok {
 		err := closer.Close()
@@ -0,0 +1,67 @@
+package generators
+
+import (
+	"math/rand"
+
+	"github.com/rickcollette/megasound"
+)
+
+type noiseColor int
+
+const (
+	white noiseColor = iota
+	pink
+	brown
+)
+
+type noiseGenerator struct {
+	rng   *rand.Rand
+	color noiseColor
+	b     [7]float64 // filter state
+}
+
+// WhiteNoise creates a streamer which will produce infinite white noise, uniformly distributed
+// between -1 and 1. The same seed produces the same noise. Both channels are the same.
+func WhiteNoise(seed int64) megasound.Streamer {
+	return &noiseGenerator{rng: rand.New(rand.NewSource(seed)), color: white}
+}
+
+// PinkNoise creates a streamer which will produce infinite pink noise, whose power falls by
+// 3 dB per octave, with peaks around 1. The same seed produces the same noise.
+func PinkNoise(seed int64) megasound.Streamer {
+	return &noiseGenerator{rng: rand.New(rand.NewSource(seed)), color: pink}
+}
+
+// BrownNoise creates a streamer which will produce infinite brown (red) noise, whose power
+// falls by 6 dB per octave, with peaks around 1. The same seed produces the same noise.
+func BrownNoise(seed int64) megasound.Streamer {
+	return &noiseGenerator{rng: rand.New(rand.NewSource(seed)), color: brown}
+}
+
+func (g *noiseGenerator) Stream(samples [][2]float64) (n int, ok bool) {
+	b := &g.b
+	for i := range samples {
+		v := g.rng.Float64()*2 - 1
+		switch g.color {
+		case pink:
+			// Paul Kellet's refined pink noise filter
+			b[0] = 0.99886*b[0] + v*0.0555179
+			b[1] = 0.99332*b[1] + v*0.0750759
+			b[2] = 0.96900*b[2] + v*0.1538520
+			b[3] = 0.86650*b[3] + v*0.3104856
+			b[4] = 0.55000*b[4] + v*0.5329522
+			b[5] = -0.7616*b[5] - v*0.0168980
+			v, b[6] = (b[0]+b[1]+b[2]+b[3]+b[4]+b[5]+b[6]+v*0.5362)*0.11, v*0.115926
+		case brown:
+			b[0] = (b[0] + 0.02*v) / 1.02
+			v = b[0] * 3.5
+		}
+		samples[i][0] = v
+		samples[i][1] = v
+	}
+	return len(samples), true
+}
+
+func (*noiseGenerator) Err() error {
+	return nil
+}
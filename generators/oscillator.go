@@ -0,0 +1,200 @@
+package generators
+
+import (
+	"math"
+
+	pkgerrors "github.com/pkg/errors"
+
+	"github.com/rickcollette/megasound"
+)
+
+// Waveform is the waveform of an Oscillator.
+type Waveform int
+
+const (
+	Sine Waveform = iota
+	Square
+	Sawtooth
+	Triangle
+)
+
+// glideTime is the time in seconds over which phase and pulse width changes are applied.
+const glideTime = 0.005
+
+// Oscillator is a band-limited oscillator. Unlike the tones of SquareTone, SawtoothTone and
+// TriangleTone, the discontinuities of its waveforms are smoothed with polynomial band-limited
+// steps (PolyBLEP and PolyBLAMP), which removes most of the aliasing of high notes.
+//
+// The frequency, phase and pulse width can be changed while streaming without discontinuities:
+// the phase is accumulated across frequency changes, and phase and pulse width changes are
+// applied gradually over a few milliseconds. Oscillator is not safe for concurrent use, so
+// lock the speaker when changing a playing oscillator.
+type Oscillator struct {
+	sr    float64
+	wave  Waveform
+	dt    float64 // phase increment per sample
+	phase float64
+
+	offset      float64 // phase offset
+	offsetStep  float64 // change of the offset per sample while gliding
+	offsetSteps int     // remaining samples of the glide
+
+	width       float64 // pulse width of the square
+	targetWidth float64
+	widthCoef   float64
+}
+
+// NewOscillator returns an Oscillator of the waveform wave at the frequency freq. The square
+// has a pulse width of 0.5.
+// sampleRate must be at least two times greater than frequency, otherwise this function will return an error.
+func NewOscillator(sr megasound.SampleRate, wave Waveform, freq float64) (*Oscillator, error) {
+	o := &Oscillator{
+		sr:          float64(sr),
+		wave:        wave,
+		width:       0.5,
+		targetWidth: 0.5,
+		widthCoef:   1 - math.Exp(-1/(glideTime*float64(sr))),
+	}
+	if err := o.SetFrequency(freq); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// BandLimitedSquareTone creates a streamer which will produce an infinite band-limited square
+// wave with the given frequency. See Oscillator.
+func BandLimitedSquareTone(sr megasound.SampleRate, freq float64) (megasound.Streamer, error) {
+	return NewOscillator(sr, Square, freq)
+}
+
+// BandLimitedSawtoothTone creates a streamer which will produce an infinite band-limited
+// sawtooth wave with the given frequency. See Oscillator.
+func BandLimitedSawtoothTone(sr megasound.SampleRate, freq float64) (megasound.Streamer, error) {
+	return NewOscillator(sr, Sawtooth, freq)
+}
+
+// BandLimitedTriangleTone creates a streamer which will produce an infinite band-limited
+// triangle wave with the given frequency. See Oscillator.
+func BandLimitedTriangleTone(sr megasound.SampleRate, freq float64) (megasound.Streamer, error) {
+	return NewOscillator(sr, Triangle, freq)
+}
+
+// SetFrequency sets the frequency of o, keeping its phase. It returns an error if freq is
+// negative or not below half the sample rate.
+func (o *Oscillator) SetFrequency(freq float64) error {
+	dt := freq / o.sr
+	if dt >= 1.0/2.0 {
+		return pkgerrors.New("megasound oscillator: samplerate must be at least 2 times greater than frequency")
+	}
+	if dt < 0 {
+		return pkgerrors.New("megasound oscillator: frequency must not be negative")
+	}
+	o.dt = dt
+	return nil
+}
+
+// Frequency returns the frequency of o.
+func (o *Oscillator) Frequency() float64 {
+	return o.dt * o.sr
+}
+
+// SetPhase sets the phase offset of o, in cycles from 0 to 1. The offset is reached gradually
+// by the shortest way around the cycle.
+func (o *Oscillator) SetPhase(phase float64) {
+	d := phase - o.Phase()
+	d -= math.Floor(d + 0.5) // shortest way, from -0.5 to 0.5
+	o.offsetSteps = int(math.Ceil(glideTime * o.sr))
+	o.offsetStep = d / float64(o.offsetSteps)
+}
+
+// Phase returns the phase offset of o set with SetPhase, including a glide in progress.
+func (o *Oscillator) Phase() float64 {
+	target := o.offset + o.offsetStep*float64(o.offsetSteps)
+	return target - math.Floor(target)
+}
+
+// SetPulseWidth sets the pulse width of the square waveform, the fraction of the cycle where
+// it is high, between 0.01 and 0.99.
+func (o *Oscillator) SetPulseWidth(width float64) {
+	o.targetWidth = math.Max(0.01, math.Min(width, 0.99))
+}
+
+// Stream streams the waveform of o.
+func (o *Oscillator) Stream(samples [][2]float64) (n int, ok bool) {
+	for i := range samples {
+		dt := o.dt
+		if o.offsetSteps > 0 {
+			o.offset += o.offsetStep
+			o.offsetSteps--
+			dt += o.offsetStep
+		}
+		o.width += (o.targetWidth - o.width) * o.widthCoef
+		p := o.phase + o.offset
+		v := o.value(p-math.Floor(p), math.Abs(dt))
+		samples[i][0] = v
+		samples[i][1] = v
+		_, o.phase = math.Modf(o.phase + o.dt)
+	}
+	return len(samples), true
+}
+
+// value returns the value of the waveform at phase p for a phase increment of dt.
+func (o *Oscillator) value(p, dt float64) float64 {
+	switch o.wave {
+	case Square:
+		q := p - o.width
+		if q < 0 {
+			q++
+		}
+		v := 1.0
+		if p >= o.width {
+			v = -1
+		}
+		return v + polyBLEP(p, dt) - polyBLEP(q, dt)
+	case Sawtooth:
+		return 2*p - 1 - polyBLEP(p, dt)
+	case Triangle:
+		q := p + 0.5
+		if q >= 1 {
+			q--
+		}
+		return 4*math.Abs(p-0.5) - 1 - 4*dt*(polyBLAMP(p, dt)-polyBLAMP(q, dt))
+	}
+	return math.Sin(2 * math.Pi * p)
+}
+
+// polyBLEP returns the correction of a step of -2 at phase 0 of a waveform at phase t for a
+// phase increment of dt.
+func polyBLEP(t, dt float64) float64 {
+	switch {
+	case dt <= 0:
+		return 0
+	case t < dt:
+		t /= dt
+		return t + t - t*t - 1
+	case t > 1-dt:
+		t = (t - 1) / dt
+		return t*t + t + t + 1
+	}
+	return 0
+}
+
+// polyBLAMP returns the correction of a change of slope at phase 0, the integral of polyBLEP,
+// scaled for a slope change of 1 per phase increment.
+func polyBLAMP(t, dt float64) float64 {
+	switch {
+	case dt <= 0:
+		return 0
+	case t < dt:
+		t = t/dt - 1
+		return -t * t * t / 3
+	case t > 1-dt:
+		t = (t-1)/dt + 1
+		return t * t * t / 3
+	}
+	return 0
+}
+
+func (*Oscillator) Err() error {
+	return nil
+}
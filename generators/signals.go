@@ -0,0 +1,234 @@
+package generators
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+
+	"github.com/rickcollette/megasound"
+)
+
+// signal is a finite signal computed from the sample index, so it can seek.
+type signal struct {
+	n, pos int
+	value  func(i int) float64
+}
+
+func (s *signal) Stream(samples [][2]float64) (n int, ok bool) {
+	if s.pos >= s.n {
+		return 0, false
+	}
+	if len(samples) > s.n-s.pos {
+		samples = samples[:s.n-s.pos]
+	}
+	for i := range samples {
+		v := s.value(s.pos + i)
+		samples[i][0] = v
+		samples[i][1] = v
+	}
+	s.pos += len(samples)
+	return len(samples), true
+}
+
+func (*signal) Err() error {
+	return nil
+}
+
+func (s *signal) Len() int {
+	return s.n
+}
+
+func (s *signal) Position() int {
+	return s.pos
+}
+
+func (s *signal) Seek(p int) error {
+	if p < 0 || s.n < p {
+		return fmt.Errorf("generators: seek position %v out of range [%v, %v]", p, 0, s.n)
+	}
+	s.pos = p
+	return nil
+}
+
+// Impulse creates a streamer of n samples with a unit impulse at the first sample and silence
+// after, for example to capture the impulse response of an effect.
+func Impulse(n int) megasound.StreamSeeker {
+	if n < 0 {
+		n = 0
+	}
+	return &signal{n: n, value: func(i int) float64 {
+		if i == 0 {
+			return 1
+		}
+		return 0
+	}}
+}
+
+type impulseTrain struct {
+	period, i int
+}
+
+// ImpulseTrain creates a streamer which will produce an infinite train of unit impulses, one
+// every period samples, starting with the first sample.
+func ImpulseTrain(period int) (megasound.Streamer, error) {
+	if period < 1 {
+		return nil, pkgerrors.New("megasound impulse train generator: period must be at least 1")
+	}
+	return &impulseTrain{period: period}, nil
+}
+
+func (g *impulseTrain) Stream(samples [][2]float64) (n int, ok bool) {
+	for i := range samples {
+		v := 0.0
+		if g.i == 0 {
+			v = 1
+		}
+		samples[i][0] = v
+		samples[i][1] = v
+		g.i = (g.i + 1) % g.period
+	}
+	return len(samples), true
+}
+
+func (*impulseTrain) Err() error {
+	return nil
+}
+
+// checkSweep checks the parameters of a sweep and returns its length in samples.
+func checkSweep(sr megasound.SampleRate, from, to float64, d time.Duration) (int, error) {
+	n := sr.N(d)
+	switch {
+	case n <= 0:
+		return 0, pkgerrors.New("megasound sweep generator: duration must be positive")
+	case from <= 0 || to <= 0:
+		return 0, pkgerrors.New("megasound sweep generator: frequencies must be positive")
+	case from >= float64(sr)/2 || to >= float64(sr)/2:
+		return 0, pkgerrors.New("megasound sweep generator: samplerate must be at least 2 times greater than frequency")
+	}
+	return n, nil
+}
+
+// LinearSweep creates a streamer of duration d which will produce a sine sweep whose frequency
+// changes linearly from the frequency from to the frequency to.
+func LinearSweep(sr megasound.SampleRate, from, to float64, d time.Duration) (megasound.StreamSeeker, error) {
+	n, err := checkSweep(sr, from, to, d)
+	if err != nil {
+		return nil, err
+	}
+	T := float64(n) / float64(sr)
+	return &signal{n: n, value: func(i int) float64 {
+		t := float64(i) / float64(sr)
+		return math.Sin(2 * math.Pi * (from*t + (to-from)*t*t/(2*T)))
+	}}, nil
+}
+
+// LogSweep creates a streamer of duration d which will produce an exponential sine sweep,
+// whose frequency changes from the frequency from to the frequency to by the same number of
+// octaves per second, as used to measure impulse responses.
+func LogSweep(sr megasound.SampleRate, from, to float64, d time.Duration) (megasound.StreamSeeker, error) {
+	n, err := checkSweep(sr, from, to, d)
+	if err != nil {
+		return nil, err
+	}
+	T := float64(n) / float64(sr)
+	k := math.Log(to / from)
+	if k == 0 {
+		return LinearSweep(sr, from, to, d)
+	}
+	return &signal{n: n, value: func(i int) float64 {
+		t := float64(i) / float64(sr)
+		return math.Sin(2 * math.Pi * from * T / k * (math.Exp(t*k/T) - 1))
+	}}, nil
+}
+
+type multitoneGenerator struct {
+	dt, t, phase []float64
+	gain         float64
+}
+
+// Multitone creates a streamer which will produce an infinite sum of sine waves of the given
+// frequencies, each with an amplitude of 1/len(freqs). The phases of the sines follow
+// Schroeder's formula, which keeps the peaks of the sum low.
+func Multitone(sr megasound.SampleRate, freqs ...float64) (megasound.Streamer, error) {
+	if len(freqs) == 0 {
+		return nil, pkgerrors.New("megasound multitone generator: no frequencies")
+	}
+	n := len(freqs)
+	g := &multitoneGenerator{
+		dt:    make([]float64, n),
+		t:     make([]float64, n),
+		phase: make([]float64, n),
+		gain:  1 / float64(n),
+	}
+	for i, f := range freqs {
+		g.dt[i] = f / float64(sr)
+		if g.dt[i] >= 1.0/2.0 || g.dt[i] < 0 {
+			return nil, pkgerrors.New("megasound multitone generator: samplerate must be at least 2 times greater than frequency")
+		}
+		g.phase[i] = -math.Pi * float64(i*(i+1)) / float64(n)
+	}
+	return g, nil
+}
+
+func (g *multitoneGenerator) Stream(samples [][2]float64) (n int, ok bool) {
+	for i := range samples {
+		v := 0.0
+		for j := range g.dt {
+			v += math.Sin(2*math.Pi*g.t[j] + g.phase[j])
+			_, g.t[j] = math.Modf(g.t[j] + g.dt[j])
+		}
+		samples[i][0] = v * g.gain
+		samples[i][1] = v * g.gain
+	}
+	return len(samples), true
+}
+
+func (*multitoneGenerator) Err() error {
+	return nil
+}
+
+// dtmfKeys holds the keys of a DTMF keypad by row and column.
+var dtmfKeys = [4]string{"123A", "456B", "789C", "*0#D"}
+
+var (
+	dtmfRows    = [4]float64{697, 770, 852, 941}
+	dtmfColumns = [4]float64{1209, 1336, 1477, 1633}
+)
+
+// DTMF creates a streamer which will produce the dual-tone multi-frequency signals of digits,
+// the keys 0 to 9, *, #, and A to D of a telephone keypad. Each key sounds for the duration
+// tone and is followed by a pause of silence. The two sines of a key have an amplitude of 0.5.
+func DTMF(sr megasound.SampleRate, digits string, tone, pause time.Duration) (megasound.StreamSeeker, error) {
+	toneN, pauseN := sr.N(tone), sr.N(pause)
+	if toneN <= 0 || pauseN < 0 {
+		return nil, pkgerrors.New("megasound DTMF generator: invalid durations")
+	}
+	if float64(sr) <= 2*dtmfColumns[3] {
+		return nil, pkgerrors.New("megasound DTMF generator: samplerate must be at least 2 times greater than frequency")
+	}
+	type key struct{ low, high float64 }
+	var keys []key
+	for _, c := range strings.ToUpper(digits) {
+		n := len(keys)
+		for r, row := range dtmfKeys {
+			if col := strings.IndexRune(row, c); col >= 0 {
+				keys = append(keys, key{dtmfRows[r], dtmfColumns[col]})
+			}
+		}
+		if len(keys) == n {
+			return nil, pkgerrors.Errorf("megasound DTMF generator: invalid digit %q", c)
+		}
+	}
+	period := toneN + pauseN
+	return &signal{n: len(keys) * period, value: func(i int) float64 {
+		k, j := keys[i/period], i%period
+		if j >= toneN {
+			return 0
+		}
+		t := float64(j) / float64(sr)
+		return 0.5*math.Sin(2*math.Pi*k.low*t) + 0.5*math.Sin(2*math.Pi*k.high*t)
+	}}, nil
+}
@@ -0,0 +1,479 @@
+// Package mp4 implements reading of the ISO base media file format (MP4, M4A), as used by the
+// alac and tags packages.
+package mp4
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"strings"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+// maxBoxSize limits the size of boxes read into memory.
+const maxBoxSize = 64 << 20
+
+// File is the movie metadata of an MP4 file.
+type File struct {
+	Brand  string // major brand of the ftyp box
+	Tracks []*Track
+	Meta   []MetaItem // iTunes metadata items
+}
+
+// Track is a track of an MP4 file.
+type Track struct {
+	ID        uint32
+	Handler   string // handler type, "soun" for audio tracks
+	Timescale uint32 // time units per second
+	Duration  uint64 // in Timescale units
+	Entry     SampleEntry
+	Samples   []Sample
+}
+
+// SampleEntry is the first sample description of a track.
+type SampleEntry struct {
+	Format     string // coding name, like "alac" or "mp4a"
+	Channels   int
+	SampleSize int    // bits per sample
+	SampleRate uint32 // integer part of the sample rate
+
+	// Boxes holds the bodies of the child boxes of the sample entry by type, like the codec
+	// configuration.
+	Boxes map[string][]byte
+}
+
+// Sample is a sample of a track, which is a packet of encoded data for audio tracks.
+type Sample struct {
+	Offset   int64  // offset in the file
+	Size     uint32 // in bytes
+	Time     uint64 // decoding time in Timescale units
+	Duration uint32 // in Timescale units
+}
+
+// MetaItem is an item of the iTunes metadata list (ilst).
+type MetaItem struct {
+	// Name is the type of the item box, like "©nam" or "trkn", or "----:mean:name" for
+	// freeform items, like "----:com.apple.iTunes:replaygain_track_gain".
+	Name string
+
+	// Type is the well-known data type: 1 is UTF-8 text, 13 JPEG, 14 PNG, 21 a big-endian
+	// signed integer and 0 binary data.
+	Type uint32
+	Data []byte
+}
+
+// AudioTrack returns the first audio track of f, or nil if there is none.
+func (f *File) AudioTrack() *Track {
+	for _, t := range f.Tracks {
+		if t.Handler == "soun" {
+			return t
+		}
+	}
+	return nil
+}
+
+// Read reads the top level boxes of the MP4 file in r until it finds the moov box and parses
+// it. Boxes are skipped by seeking if r is io.Seeker, otherwise the moov box must come before
+// the media data. r is left after the moov box.
+func Read(r io.Reader) (*File, error) {
+	f := new(File)
+	var off int64
+	for {
+		typ, size, hsize, err := readHeader(r)
+		if err == io.EOF {
+			return nil, pkgerrors.New("mp4: no moov box")
+		}
+		if err != nil {
+			return nil, pkgerrors.Wrap(err, "mp4")
+		}
+		if off == 0 && typ != "ftyp" {
+			return nil, pkgerrors.New("mp4: not an MP4 file")
+		}
+		if size == 0 {
+			return nil, pkgerrors.New("mp4: no moov box") // the last box extends to the end
+		}
+		body := size - hsize
+		switch typ {
+		case "ftyp", "moov":
+			if body > maxBoxSize {
+				return nil, pkgerrors.New("mp4: box too large")
+			}
+			p := make([]byte, body)
+			if _, err := io.ReadFull(r, p); err != nil {
+				return nil, pkgerrors.Wrap(err, "mp4")
+			}
+			if typ == "ftyp" {
+				if len(p) >= 4 {
+					f.Brand = string(p[:4])
+				}
+				break
+			}
+			if err := f.parseMoov(p); err != nil {
+				return nil, pkgerrors.Wrap(err, "mp4")
+			}
+			return f, nil
+		default:
+			if _, ok := r.(io.Seeker); !ok && typ == "mdat" {
+				return nil, pkgerrors.New("mp4: moov box after the media data requires io.Seeker")
+			}
+			if err := skip(r, body); err != nil {
+				return nil, pkgerrors.Wrap(err, "mp4")
+			}
+		}
+		off += size
+	}
+}
+
+// readHeader reads a box header and returns the box type, the size of the whole box (0 if it
+// extends to the end of the file) and the size of the header.
+func readHeader(r io.Reader) (typ string, size, hsize int64, err error) {
+	var h [16]byte
+	if _, err := io.ReadFull(r, h[:8]); err != nil {
+		return "", 0, 0, err
+	}
+	typ = string(h[4:8])
+	size, hsize = int64(binary.BigEndian.Uint32(h[:4])), 8
+	if size == 1 {
+		if _, err := io.ReadFull(r, h[8:16]); err != nil {
+			return "", 0, 0, err
+		}
+		size, hsize = int64(binary.BigEndian.Uint64(h[8:])), 16
+	}
+	if size != 0 && size < hsize {
+		return "", 0, 0, pkgerrors.Errorf("invalid size of %q box", typ)
+	}
+	return typ, size, hsize, nil
+}
+
+func skip(r io.Reader, n int64) error {
+	if s, ok := r.(io.Seeker); ok {
+		_, err := s.Seek(n, io.SeekCurrent)
+		return err
+	}
+	_, err := io.CopyN(io.Discard, r, n)
+	return err
+}
+
+// box is a box in memory.
+type box struct {
+	typ  string
+	body []byte
+}
+
+// boxes splits p into boxes.
+func boxes(p []byte) ([]box, error) {
+	var bs []box
+	for len(p) >= 8 {
+		size, hsize := uint64(binary.BigEndian.Uint32(p)), uint64(8)
+		typ := string(p[4:8])
+		switch size {
+		case 0:
+			size = uint64(len(p))
+		case 1:
+			if len(p) < 16 {
+				return nil, pkgerrors.New("truncated box")
+			}
+			size, hsize = binary.BigEndian.Uint64(p[8:]), 16
+		}
+		if size < hsize || size > uint64(len(p)) {
+			return nil, pkgerrors.Errorf("invalid size of %q box", typ)
+		}
+		bs = append(bs, box{typ, p[hsize:size]})
+		p = p[size:]
+	}
+	return bs, nil
+}
+
+// child returns the body of the first child box of p with the given type, or nil.
+func child(p []byte, typ string) []byte {
+	bs, _ := boxes(p)
+	for _, b := range bs {
+		if b.typ == typ {
+			return b.body
+		}
+	}
+	return nil
+}
+
+// path returns the body of the box at the given path of box types, or nil.
+func path(p []byte, types ...string) []byte {
+	for _, typ := range types {
+		if p = child(p, typ); p == nil {
+			return nil
+		}
+	}
+	return p
+}
+
+func (f *File) parseMoov(p []byte) error {
+	bs, err := boxes(p)
+	if err != nil {
+		return err
+	}
+	for _, b := range bs {
+		switch b.typ {
+		case "trak":
+			t, err := parseTrak(b.body)
+			if err != nil {
+				return err
+			}
+			f.Tracks = append(f.Tracks, t)
+		case "udta":
+			if meta := child(b.body, "meta"); meta != nil {
+				f.Meta = parseMeta(meta)
+			}
+		}
+	}
+	return nil
+}
+
+func parseTrak(p []byte) (*Track, error) {
+	t := new(Track)
+	if tkhd := child(p, "tkhd"); len(tkhd) >= 24 {
+		if tkhd[0] == 1 {
+			t.ID = binary.BigEndian.Uint32(tkhd[20:])
+		} else {
+			t.ID = binary.BigEndian.Uint32(tkhd[12:])
+		}
+	}
+	mdia := child(p, "mdia")
+	if mdhd := child(mdia, "mdhd"); len(mdhd) >= 24 {
+		if mdhd[0] == 1 {
+			if len(mdhd) < 32 {
+				return nil, pkgerrors.New("truncated mdhd box")
+			}
+			t.Timescale = binary.BigEndian.Uint32(mdhd[20:])
+			t.Duration = binary.BigEndian.Uint64(mdhd[24:])
+		} else {
+			t.Timescale = binary.BigEndian.Uint32(mdhd[12:])
+			t.Duration = uint64(binary.BigEndian.Uint32(mdhd[16:]))
+		}
+	}
+	if hdlr := child(mdia, "hdlr"); len(hdlr) >= 12 {
+		t.Handler = string(hdlr[8:12])
+	}
+	stbl := path(mdia, "minf", "stbl")
+	if stbl == nil {
+		return t, nil
+	}
+	if stsd := child(stbl, "stsd"); len(stsd) >= 8 && binary.BigEndian.Uint32(stsd[4:]) > 0 {
+		entries, err := boxes(stsd[8:])
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) > 0 {
+			t.Entry = parseSampleEntry(entries[0], t.Handler)
+		}
+	}
+	var err error
+	t.Samples, err = parseSampleTable(stbl)
+	return t, err
+}
+
+// parseSampleEntry parses an audio sample entry, or just the coding name for other handlers.
+func parseSampleEntry(b box, handler string) SampleEntry {
+	e := SampleEntry{Format: b.typ}
+	p := b.body
+	if handler != "soun" || len(p) < 28 {
+		return e
+	}
+	version := binary.BigEndian.Uint16(p[8:])
+	e.Channels = int(binary.BigEndian.Uint16(p[16:]))
+	e.SampleSize = int(binary.BigEndian.Uint16(p[18:]))
+	e.SampleRate = binary.BigEndian.Uint32(p[24:]) >> 16
+	p = p[28:]
+	switch version { // QuickTime sound description versions
+	case 1:
+		if len(p) < 16 {
+			return e
+		}
+		p = p[16:]
+	case 2:
+		if len(p) < 36 {
+			return e
+		}
+		e.SampleRate = uint32(math.Float64frombits(binary.BigEndian.Uint64(p[4:])))
+		e.Channels = int(binary.BigEndian.Uint32(p[12:]))
+		p = p[36:]
+	}
+	e.Boxes = make(map[string][]byte)
+	bs, _ := boxes(p)
+	for _, c := range bs {
+		if c.typ == "wave" { // QuickTime wraps the codec configuration
+			inner, _ := boxes(c.body)
+			for _, w := range inner {
+				e.Boxes[w.typ] = w.body
+			}
+			continue
+		}
+		e.Boxes[c.typ] = c.body
+	}
+	return e
+}
+
+// parseSampleTable builds the samples of a track from its sample table boxes: stsz or stz2 for
+// the sizes, stsc and stco or co64 for the locations and stts for the timing.
+func parseSampleTable(stbl []byte) ([]Sample, error) {
+	be := binary.BigEndian
+	// sizes
+	var sizes []uint32
+	if stsz := child(stbl, "stsz"); len(stsz) >= 12 {
+		fixed, n := be.Uint32(stsz[4:]), int(be.Uint32(stsz[8:]))
+		if fixed == 0 && len(stsz) < 12+4*n {
+			return nil, pkgerrors.New("truncated stsz box")
+		}
+		if n > maxBoxSize {
+			return nil, pkgerrors.New("too many samples")
+		}
+		sizes = make([]uint32, n)
+		for i := range sizes {
+			if fixed != 0 {
+				sizes[i] = fixed
+			} else {
+				sizes[i] = be.Uint32(stsz[12+4*i:])
+			}
+		}
+	} else if stz2 := child(stbl, "stz2"); len(stz2) >= 12 {
+		field, n := int(stz2[7]), int(be.Uint32(stz2[8:]))
+		if field != 4 && field != 8 && field != 16 || len(stz2) < 12+(n*field+7)/8 {
+			return nil, pkgerrors.New("invalid stz2 box")
+		}
+		sizes = make([]uint32, n)
+		for i := range sizes {
+			switch field {
+			case 4:
+				sizes[i] = uint32(stz2[12+i/2]>>(4*(1-i%2))) & 0xf
+			case 8:
+				sizes[i] = uint32(stz2[12+i])
+			case 16:
+				sizes[i] = uint32(be.Uint16(stz2[12+2*i:]))
+			}
+		}
+	}
+
+	// chunk offsets
+	var chunks []int64
+	if stco := child(stbl, "stco"); len(stco) >= 8 {
+		n := int(be.Uint32(stco[4:]))
+		if len(stco) < 8+4*n {
+			return nil, pkgerrors.New("truncated stco box")
+		}
+		for i := 0; i < n; i++ {
+			chunks = append(chunks, int64(be.Uint32(stco[8+4*i:])))
+		}
+	} else if co64 := child(stbl, "co64"); len(co64) >= 8 {
+		n := int(be.Uint32(co64[4:]))
+		if len(co64) < 8+8*n {
+			return nil, pkgerrors.New("truncated co64 box")
+		}
+		for i := 0; i < n; i++ {
+			chunks = append(chunks, int64(be.Uint64(co64[8+8*i:])))
+		}
+	}
+
+	if len(sizes) == 0 {
+		return nil, nil
+	}
+	samples := make([]Sample, len(sizes))
+	for i := range samples {
+		samples[i].Size = sizes[i]
+	}
+
+	// sample to chunk
+	stsc := child(stbl, "stsc")
+	if len(stsc) < 8 {
+		return nil, pkgerrors.New("missing stsc box")
+	}
+	n := int(be.Uint32(stsc[4:]))
+	if len(stsc) < 8+12*n {
+		return nil, pkgerrors.New("truncated stsc box")
+	}
+	s := 0
+	for i := 0; i < n && s < len(samples); i++ {
+		e := stsc[8+12*i:]
+		first, per := int(be.Uint32(e)), int(be.Uint32(e[4:]))
+		last := len(chunks) + 1
+		if i+1 < n {
+			last = int(be.Uint32(stsc[8+12*(i+1):]))
+		}
+		if first < 1 || last > len(chunks)+1 {
+			return nil, pkgerrors.New("invalid stsc box")
+		}
+		for c := first; c < last && s < len(samples); c++ {
+			off := chunks[c-1]
+			for j := 0; j < per && s < len(samples); j++ {
+				samples[s].Offset = off
+				off += int64(samples[s].Size)
+				s++
+			}
+		}
+	}
+	if s < len(samples) {
+		return nil, pkgerrors.New("samples without chunk")
+	}
+
+	// time to sample
+	stts := child(stbl, "stts")
+	if len(stts) < 8 {
+		return nil, pkgerrors.New("missing stts box")
+	}
+	n = int(be.Uint32(stts[4:]))
+	if len(stts) < 8+8*n {
+		return nil, pkgerrors.New("truncated stts box")
+	}
+	var t uint64
+	s = 0
+	for i := 0; i < n && s < len(samples); i++ {
+		count, delta := int(be.Uint32(stts[8+8*i:])), be.Uint32(stts[12+8*i:])
+		for j := 0; j < count && s < len(samples); j++ {
+			samples[s].Time = t
+			samples[s].Duration = delta
+			t += uint64(delta)
+			s++
+		}
+	}
+	return samples, nil
+}
+
+// parseMeta parses the items of the ilst box in the body of a meta box.
+func parseMeta(p []byte) []MetaItem {
+	// the meta box is a full box in MP4, but not in QuickTime files
+	if len(p) >= 8 && string(p[4:8]) != "hdlr" {
+		p = p[4:]
+	}
+	ilst := child(p, "ilst")
+	items, _ := boxes(ilst)
+	var meta []MetaItem
+	for _, item := range items {
+		name := latin1(item.typ)
+		if name == "----" {
+			mean, key := child(item.body, "mean"), child(item.body, "name")
+			if len(mean) < 4 || len(key) < 4 {
+				continue
+			}
+			name = "----:" + string(mean[4:]) + ":" + string(key[4:])
+		}
+		cs, _ := boxes(item.body)
+		for _, c := range cs {
+			if c.typ != "data" || len(c.body) < 8 {
+				continue
+			}
+			meta = append(meta, MetaItem{
+				Name: name,
+				Type: binary.BigEndian.Uint32(c.body) & 0xffffff,
+				Data: c.body[8:],
+			})
+		}
+	}
+	return meta
+}
+
+// latin1 converts box types, which are ISO-8859-1 like the © in "©nam", to UTF-8.
+func latin1(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		b.WriteRune(rune(s[i]))
+	}
+	return b.String()
+}
@@ -0,0 +1,336 @@
+// Package ogg implements reading of Ogg pages and packets, as used by the vorbis and tags packages.
+package ogg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+// Header type flags of a page.
+const (
+	FlagContinued = 0x1
+	FlagBOS       = 0x2
+	FlagEOS       = 0x4
+)
+
+const (
+	headerSize  = 27
+	maxPageSize = headerSize + 255 + 255*255
+)
+
+var capture = []byte("OggS")
+
+// Page is a single Ogg page.
+type Page struct {
+	Offset  int64  // offset of the page in the stream
+	Flags   byte   // header type flags
+	Granule int64  // granule position, -1 if no packet ends on the page
+	Serial  uint32 // serial number of the logical stream
+	Seq     uint32 // page sequence number
+	Lacing  []byte // segment table
+	Body    []byte
+}
+
+// Size returns the size of the whole page in bytes.
+func (p *Page) Size() int64 {
+	return int64(headerSize + len(p.Lacing) + len(p.Body))
+}
+
+// Packet is a packet of a logical stream.
+type Packet struct {
+	Data   []byte
+	Serial uint32
+
+	// BOS is true for the first packet of a logical stream, EOS for the last one.
+	BOS, EOS bool
+
+	// Granule is the granule position of the page this packet ends on if it is the last packet
+	// ending on that page, -1 otherwise.
+	Granule int64
+}
+
+// Reader reads pages and packets from an Ogg stream.
+type Reader struct {
+	r       io.Reader
+	br      *bufio.Reader
+	off     int64
+	partial map[uint32][]byte
+	queue   []Packet
+}
+
+// NewReader returns a Reader reading from r. SeekPage only works if r is an io.Seeker.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		r:       r,
+		br:      bufio.NewReaderSize(r, maxPageSize),
+		partial: make(map[uint32][]byte),
+	}
+}
+
+// Offset returns the offset of the next page to be read.
+func (r *Reader) Offset() int64 {
+	return r.off
+}
+
+// SeekPage positions the Reader at offset off, which should be the start of a page. Any partially
+// read packets are dropped, as is the continued packet at the start of the next page.
+func (r *Reader) SeekPage(off int64) error {
+	s, ok := r.r.(io.Seeker)
+	if !ok {
+		return pkgerrors.New("ogg: seek: resource is not io.Seeker")
+	}
+	if _, err := s.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	r.br.Reset(r.r)
+	r.off = off
+	r.partial = make(map[uint32][]byte)
+	r.queue = r.queue[:0]
+	return nil
+}
+
+// ReadPage reads the next page. Garbage before the page and pages with an invalid checksum are
+// skipped.
+func (r *Reader) ReadPage() (*Page, error) {
+	for {
+		h, err := r.br.Peek(headerSize)
+		if err != nil {
+			if err == io.EOF && len(h) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if !bytes.Equal(h[:4], capture) {
+			// lost sync, skip to the next capture pattern
+			skip := 1
+			if i := bytes.Index(h[1:], capture[:1]); i >= 0 {
+				skip = i + 1
+			} else {
+				skip = len(h)
+			}
+			r.br.Discard(skip)
+			r.off += int64(skip)
+			continue
+		}
+		nseg := int(h[26])
+		full, err := r.br.Peek(headerSize + nseg)
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		size := headerSize + nseg
+		for _, l := range full[headerSize:] {
+			size += int(l)
+		}
+		full, err = r.br.Peek(size)
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if checksum(full) != binary.LittleEndian.Uint32(full[22:]) {
+			r.br.Discard(1)
+			r.off++
+			continue
+		}
+		p := &Page{
+			Offset:  r.off,
+			Flags:   full[5],
+			Granule: int64(binary.LittleEndian.Uint64(full[6:])),
+			Serial:  binary.LittleEndian.Uint32(full[14:]),
+			Seq:     binary.LittleEndian.Uint32(full[18:]),
+			Lacing:  append([]byte(nil), full[headerSize:headerSize+nseg]...),
+			Body:    append([]byte(nil), full[headerSize+nseg:]...),
+		}
+		r.br.Discard(size)
+		r.off += int64(size)
+		return p, nil
+	}
+}
+
+// ReadPacket reads the next packet of any logical stream.
+func (r *Reader) ReadPacket() (Packet, error) {
+	for len(r.queue) == 0 {
+		p, err := r.ReadPage()
+		if err != nil {
+			return Packet{}, err
+		}
+		r.split(p)
+	}
+	pkt := r.queue[0]
+	r.queue = r.queue[1:]
+	return pkt, nil
+}
+
+// split appends the packets ending on page p to the queue.
+func (r *Reader) split(p *Page) {
+	data, ok := r.partial[p.Serial]
+	delete(r.partial, p.Serial)
+	drop := p.Flags&FlagContinued != 0 && !ok // the start of the packet was not read
+	first := len(r.queue)
+	body := p.Body
+	for _, l := range p.Lacing {
+		data = append(data, body[:l]...)
+		body = body[l:]
+		if l == 255 {
+			continue
+		}
+		if !drop {
+			r.queue = append(r.queue, Packet{Data: data, Serial: p.Serial, Granule: -1})
+		}
+		drop = false
+		data = nil
+	}
+	if n := len(p.Lacing); n > 0 && p.Lacing[n-1] == 255 && !drop {
+		r.partial[p.Serial] = data
+	}
+	if len(r.queue) > first {
+		if p.Flags&FlagBOS != 0 {
+			r.queue[first].BOS = true
+		}
+		last := &r.queue[len(r.queue)-1]
+		last.Granule = p.Granule
+		if p.Flags&FlagEOS != 0 {
+			last.EOS = true
+		}
+	}
+}
+
+// FirstBody returns the body of the page at the beginning of p, possibly truncated, or nil if p
+// does not start with a page. It is used to identify the codec of an Ogg stream from its first
+// bytes.
+func FirstBody(p []byte) []byte {
+	if len(p) < headerSize || !bytes.Equal(p[:4], capture) {
+		return nil
+	}
+	off := headerSize + int(p[26])
+	if len(p) < off {
+		return nil
+	}
+	return p[off:]
+}
+
+// PageInfo describes a page found by Scan.
+type PageInfo struct {
+	Offset  int64
+	Size    int64
+	Flags   byte
+	Granule int64
+	Serial  uint32
+
+	// Head holds the beginning of the body of a page with the BOS flag, enough to identify the
+	// codec of the logical stream.
+	Head []byte
+}
+
+// Scan reads all pages in rs and returns their descriptions. The position of rs is undefined
+// afterwards.
+func Scan(rs io.ReadSeeker) ([]PageInfo, error) {
+	r := NewReader(rs)
+	if err := r.SeekPage(0); err != nil {
+		return nil, err
+	}
+	var pages []PageInfo
+	for {
+		p, err := r.ReadPage()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return pages, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		info := PageInfo{
+			Offset:  p.Offset,
+			Size:    p.Size(),
+			Flags:   p.Flags,
+			Granule: p.Granule,
+			Serial:  p.Serial,
+		}
+		if p.Flags&FlagBOS != 0 {
+			n := len(p.Body)
+			if n > 16 {
+				n = 16
+			}
+			info.Head = p.Body[:n]
+		}
+		pages = append(pages, info)
+	}
+}
+
+// AppendPage appends page p encoded with its checksum to b. The Offset of p is ignored.
+func AppendPage(b []byte, p *Page) []byte {
+	start := len(b)
+	b = append(b, capture...)
+	b = append(b, 0, p.Flags)
+	b = binary.LittleEndian.AppendUint64(b, uint64(p.Granule))
+	b = binary.LittleEndian.AppendUint32(b, p.Serial)
+	b = binary.LittleEndian.AppendUint32(b, p.Seq)
+	b = append(b, 0, 0, 0, 0, byte(len(p.Lacing)))
+	b = append(b, p.Lacing...)
+	b = append(b, p.Body...)
+	binary.LittleEndian.PutUint32(b[start+22:], checksum(b[start:]))
+	return b
+}
+
+// Paginate lays out packets on pages of the logical stream serial, numbered from seq. The last
+// packet ends the last page. The granule position of the pages on which a packet ends is set to
+// granule, and flags are set on the first page.
+func Paginate(packets [][]byte, serial, seq uint32, granule int64, flags byte) []*Page {
+	var (
+		pages     []*Page
+		cur       = &Page{Flags: flags, Granule: -1, Serial: serial, Seq: seq}
+		continued bool // a packet is split at the end of the current page
+	)
+	for _, pkt := range packets {
+		for {
+			if len(cur.Lacing) == 255 {
+				pages = append(pages, cur)
+				seq++
+				cur = &Page{Granule: -1, Serial: serial, Seq: seq}
+				if continued {
+					cur.Flags = FlagContinued
+				}
+			}
+			if len(pkt) < 255 {
+				cur.Lacing = append(cur.Lacing, byte(len(pkt)))
+				cur.Body = append(cur.Body, pkt...)
+				cur.Granule = granule
+				continued = false
+				break
+			}
+			cur.Lacing = append(cur.Lacing, 255)
+			cur.Body = append(cur.Body, pkt[:255]...)
+			pkt = pkt[255:]
+			continued = true
+		}
+	}
+	return append(pages, cur)
+}
+
+var crcTable = func() (t [256]uint32) {
+	for i := range t {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return t
+}()
+
+// checksum computes the CRC of a whole page, treating its checksum field as zero.
+func checksum(page []byte) uint32 {
+	var crc uint32
+	for i, b := range page {
+		if 22 <= i && i < 26 {
+			b = 0
+		}
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^b]
+	}
+	return crc
+}
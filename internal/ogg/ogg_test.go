@@ -0,0 +1,147 @@
+package ogg
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// testPackets returns packets of n bytes for each n in sizes, filled with their index.
+func testPackets(sizes ...int) [][]byte {
+	var packets [][]byte
+	for i, n := range sizes {
+		packets = append(packets, bytes.Repeat([]byte{byte(i)}, n))
+	}
+	return packets
+}
+
+// testStream returns a stream of two chained logical streams, each with a header page holding
+// the first packet and pages of the other packets.
+func testStream() ([]byte, [2][][]byte) {
+	var (
+		b       []byte
+		packets [2][][]byte
+	)
+	for i, serial := range []uint32{100, 200} {
+		packets[i] = testPackets(30, 0, 254, 255, 600, 70000, 10)
+		b = AppendPage(b, Paginate(packets[i][:1], serial, 0, 0, FlagBOS)[0])
+		pages := Paginate(packets[i][1:], serial, 1, 1000*int64(i+1), 0)
+		pages[len(pages)-1].Flags |= FlagEOS
+		for _, p := range pages {
+			b = AppendPage(b, p)
+		}
+	}
+	return b, packets
+}
+
+func TestReadPacket(t *testing.T) {
+	data, packets := testStream()
+	// garbage before the stream and a page with a bad checksum are skipped
+	bad := AppendPage(nil, &Page{Serial: 300, Lacing: []byte{3}, Body: []byte("bad")})
+	bad[len(bad)-1]++
+	data = append(append([]byte("garbage"), bad...), data...)
+
+	r := NewReader(bytes.NewReader(data))
+	for i, serial := range []uint32{100, 200} {
+		for j, want := range packets[i] {
+			pkt, err := r.ReadPacket()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pkt.Serial != serial || !bytes.Equal(pkt.Data, want) {
+				t.Fatalf("packet %d of stream %d: %d bytes of stream %d, want %d bytes", j, serial, len(pkt.Data), pkt.Serial, len(want))
+			}
+			if pkt.BOS != (j == 0) || pkt.EOS != (j == len(packets[i])-1) {
+				t.Errorf("packet %d of stream %d: BOS %v, EOS %v", j, serial, pkt.BOS, pkt.EOS)
+			}
+			if j == len(packets[i])-1 && pkt.Granule != 1000*int64(i+1) {
+				t.Errorf("last packet of stream %d: granule position %d", serial, pkt.Granule)
+			}
+		}
+	}
+	if _, err := r.ReadPacket(); err != io.EOF {
+		t.Errorf("read past the end: %v, want EOF", err)
+	}
+}
+
+func TestScan(t *testing.T) {
+	data, _ := testStream()
+	pages, err := Scan(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewReader(bytes.NewReader(data))
+	var bos []PageInfo
+	for i, info := range pages {
+		p, err := r.ReadPage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Offset != p.Offset || info.Size != p.Size() || info.Flags != p.Flags || info.Granule != p.Granule || info.Serial != p.Serial {
+			t.Errorf("page %d: scanned %+v, read %+v", i, info, p)
+		}
+		if info.Flags&FlagBOS != 0 {
+			bos = append(bos, info)
+		}
+	}
+	if _, err := r.ReadPage(); err != io.EOF {
+		t.Errorf("%d pages scanned, but more were read", len(pages))
+	}
+	if len(bos) != 2 || bos[0].Serial != 100 || bos[1].Serial != 200 || !bytes.Equal(bos[1].Head, make([]byte, 16)) {
+		t.Errorf("BOS pages %+v", bos)
+	}
+	if last := pages[len(pages)-1]; last.Offset+last.Size != int64(len(data)) {
+		t.Errorf("last page ends at %d, want %d", last.Offset+last.Size, len(data))
+	}
+	// the body runs to the end of the data
+	if body := FirstBody(data); len(body) < 30 || !bytes.Equal(body[:30], make([]byte, 30)) || !bytes.HasPrefix(body[30:], capture) {
+		t.Error("FirstBody did not return the body of the first page")
+	}
+}
+
+func TestSeekPage(t *testing.T) {
+	data, packets := testStream()
+	pages, err := Scan(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewReader(bytes.NewReader(data))
+	for i, info := range pages {
+		if err := r.SeekPage(info.Offset); err != nil {
+			t.Fatal(err)
+		}
+		if r.Offset() != info.Offset {
+			t.Errorf("Offset() = %d after seeking to %d", r.Offset(), info.Offset)
+		}
+		p, err := r.ReadPage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Offset != info.Offset || p.Serial != info.Serial {
+			t.Errorf("page %d: read page at %d of stream %d", i, p.Offset, p.Serial)
+		}
+	}
+
+	// the 70000 byte packet continued on the page after its first one is dropped
+	var cont PageInfo
+	for _, info := range pages {
+		if info.Flags&FlagContinued != 0 {
+			cont = info
+			break
+		}
+	}
+	if err := r.SeekPage(cont.Offset); err != nil {
+		t.Fatal(err)
+	}
+	pkt, err := r.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := packets[0][6]; pkt.Serial != 100 || !bytes.Equal(pkt.Data, want) {
+		t.Errorf("read %d bytes of stream %d after seeking, want the %d byte packet following the split one", len(pkt.Data), pkt.Serial, len(want))
+	}
+
+	if err := NewReader(io.MultiReader(bytes.NewReader(data))).SeekPage(0); err == nil {
+		t.Error("seeking a reader without io.Seeker succeeded")
+	}
+}
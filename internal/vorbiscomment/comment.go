@@ -0,0 +1,174 @@
+// Package vorbiscomment implements the Vorbis comment header, shared by the vorbis and tags
+// packages.
+package vorbiscomment
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+// Comments is a Vorbis comment header.
+type Comments struct {
+	// Vendor identifies the encoder.
+	Vendor string
+
+	// Fields holds the user comments in the "NAME=value" form, in the order they are stored.
+	Fields []string
+}
+
+// Get returns the values of all fields with the given name. Field names are case-insensitive.
+func (c Comments) Get(name string) []string {
+	var values []string
+	for _, f := range c.Fields {
+		i := strings.IndexByte(f, '=')
+		if i < 0 {
+			continue
+		}
+		if strings.EqualFold(f[:i], name) {
+			values = append(values, f[i+1:])
+		}
+	}
+	return values
+}
+
+// Picture is an image embedded in a METADATA_BLOCK_PICTURE comment field. The layout is the same
+// as of the FLAC picture metadata block.
+type Picture struct {
+	// Type is the picture type as defined by ID3v2 APIC frames, 3 is the front cover.
+	Type        uint32
+	MIME        string
+	Description string
+	Width       uint32
+	Height      uint32
+	Depth       uint32 // color depth in bits per pixel
+	Colors      uint32 // number of colors of indexed images, 0 otherwise
+	Data        []byte
+}
+
+// Pictures decodes all METADATA_BLOCK_PICTURE fields.
+func (c Comments) Pictures() ([]Picture, error) {
+	var pics []Picture
+	for _, v := range c.Get("METADATA_BLOCK_PICTURE") {
+		p, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return pics, pkgerrors.Wrap(err, "vorbiscomment: picture")
+		}
+		pic, err := ParsePicture(p)
+		if err != nil {
+			return pics, err
+		}
+		pics = append(pics, pic)
+	}
+	return pics, nil
+}
+
+// Parse parses a comment header without its codec specific magic and framing, that is, starting
+// with the vendor string length.
+func Parse(p []byte) (Comments, error) {
+	var (
+		c   Comments
+		err error
+	)
+	str := func() string {
+		if err != nil {
+			return ""
+		}
+		if len(p) < 4 {
+			err = pkgerrors.New("vorbiscomment: header too short")
+			return ""
+		}
+		n := binary.LittleEndian.Uint32(p)
+		p = p[4:]
+		if uint32(len(p)) < n {
+			err = pkgerrors.New("vorbiscomment: header too short")
+			return ""
+		}
+		s := string(p[:n])
+		p = p[n:]
+		return s
+	}
+	c.Vendor = str()
+	if err != nil {
+		return Comments{}, err
+	}
+	if len(p) < 4 {
+		return Comments{}, pkgerrors.New("vorbiscomment: header too short")
+	}
+	count := binary.LittleEndian.Uint32(p)
+	p = p[4:]
+	for i := uint32(0); i < count; i++ {
+		f := str()
+		if err != nil {
+			return Comments{}, err
+		}
+		c.Fields = append(c.Fields, f)
+	}
+	return c, nil
+}
+
+// ParsePicture parses a picture in the layout of the FLAC picture metadata block.
+func ParsePicture(p []byte) (pic Picture, err error) {
+	short := pkgerrors.New("vorbiscomment: picture: block too short")
+	u32 := func() uint32 {
+		if len(p) < 4 {
+			err = short
+			return 0
+		}
+		x := binary.BigEndian.Uint32(p)
+		p = p[4:]
+		return x
+	}
+	str := func() []byte {
+		n := u32()
+		if err != nil || uint32(len(p)) < n {
+			err = short
+			return nil
+		}
+		s := p[:n]
+		p = p[n:]
+		return s
+	}
+	pic.Type = u32()
+	pic.MIME = string(str())
+	pic.Description = string(str())
+	pic.Width = u32()
+	pic.Height = u32()
+	pic.Depth = u32()
+	pic.Colors = u32()
+	pic.Data = append([]byte(nil), str()...)
+	if err != nil {
+		return Picture{}, err
+	}
+	return pic, nil
+}
+
+// Append appends the comment header c to b, in the layout parsed by Parse.
+func Append(b []byte, c Comments) []byte {
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(c.Vendor)))
+	b = append(b, c.Vendor...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(c.Fields)))
+	for _, f := range c.Fields {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(f)))
+		b = append(b, f...)
+	}
+	return b
+}
+
+// AppendPicture appends pic to b, in the layout parsed by ParsePicture.
+func AppendPicture(b []byte, pic Picture) []byte {
+	be := binary.BigEndian
+	b = be.AppendUint32(b, pic.Type)
+	b = be.AppendUint32(b, uint32(len(pic.MIME)))
+	b = append(b, pic.MIME...)
+	b = be.AppendUint32(b, uint32(len(pic.Description)))
+	b = append(b, pic.Description...)
+	b = be.AppendUint32(b, pic.Width)
+	b = be.AppendUint32(b, pic.Height)
+	b = be.AppendUint32(b, pic.Depth)
+	b = be.AppendUint32(b, pic.Colors)
+	b = be.AppendUint32(b, uint32(len(pic.Data)))
+	return append(b, pic.Data...)
+}
@@ -0,0 +1,87 @@
+package vorbiscomment
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	c := Comments{
+		Vendor: "test encoder",
+		Fields: []string{"TITLE=Tïtle", "artist=One", "ARTIST=Two", "NOVALUE", "EMPTY="},
+	}
+	p := Append(nil, c)
+	got, err := Parse(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Vendor != c.Vendor || len(got.Fields) != len(c.Fields) {
+		t.Fatalf("parsed %+v, want %+v", got, c)
+	}
+	for i := range c.Fields {
+		if got.Fields[i] != c.Fields[i] {
+			t.Errorf("field %d is %q, want %q", i, got.Fields[i], c.Fields[i])
+		}
+	}
+	for _, tc := range []struct {
+		name string
+		want []string
+	}{
+		{"Title", []string{"Tïtle"}},
+		{"ARTIST", []string{"One", "Two"}},
+		{"empty", []string{""}},
+		{"NOVALUE", nil},
+	} {
+		if v := got.Get(tc.name); fmt.Sprintf("%q", v) != fmt.Sprintf("%q", tc.want) {
+			t.Errorf("Get(%q) = %q, want %q", tc.name, v, tc.want)
+		}
+	}
+	for n := 0; n < len(p); n++ {
+		if _, err := Parse(p[:n]); err == nil {
+			t.Fatalf("parsed a header truncated to %d bytes", n)
+		}
+	}
+}
+
+func TestPicture(t *testing.T) {
+	pic := Picture{
+		Type:        3,
+		MIME:        "image/png",
+		Description: "cover",
+		Width:       600,
+		Height:      400,
+		Depth:       24,
+		Data:        []byte("\x89PNG\r\n\x1a\n"),
+	}
+	p := AppendPicture(nil, pic)
+	got, err := ParsePicture(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != pic.Type || got.MIME != pic.MIME || got.Description != pic.Description || got.Width != pic.Width ||
+		got.Height != pic.Height || got.Depth != pic.Depth || got.Colors != pic.Colors || !bytes.Equal(got.Data, pic.Data) {
+		t.Errorf("parsed %+v, want %+v", got, pic)
+	}
+	if _, err := ParsePicture(p[:len(p)-1]); err == nil {
+		t.Error("parsed a truncated picture")
+	}
+
+	c := Comments{Fields: []string{
+		"TITLE=x",
+		"METADATA_BLOCK_PICTURE=" + base64.StdEncoding.EncodeToString(p),
+		"metadata_block_picture=" + base64.StdEncoding.EncodeToString(AppendPicture(nil, Picture{Type: 4})),
+	}}
+	pics, err := c.Pictures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pics) != 2 || pics[0].Description != "cover" || pics[1].Type != 4 {
+		t.Errorf("pictures %+v", pics)
+	}
+	c.Fields = append(c.Fields, "METADATA_BLOCK_PICTURE=not base64")
+	if _, err := c.Pictures(); err == nil {
+		t.Error("decoded a picture field which is not base64")
+	}
+}
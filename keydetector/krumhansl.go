@@ -3,6 +3,7 @@ package keydetector
 
 import (
 	"math"
+	"strings"
 )
 
 // KeyResult represents the detected key with additional information like Camelot Wheel notation.
@@ -12,6 +13,21 @@ type KeyResult struct {
 	Confidence      float64
 }
 
+// TagKey returns the key in the short notation used by the initial key tags of audio files, like
+// "Am" for A minor or "F#" for F# major. It returns "" if the key is unknown.
+func (r KeyResult) TagKey() string {
+	tonic, mode, ok := strings.Cut(strings.TrimSpace(r.Key), " ")
+	if !ok || tonic == "" {
+		return ""
+	}
+	tonic = strings.ReplaceAll(tonic, "♭", "b")
+	tonic = strings.ReplaceAll(tonic, "♯", "#")
+	if strings.EqualFold(mode, "minor") {
+		return tonic + "m"
+	}
+	return tonic
+}
+
 // KrumhanslSchmuckler computes the most likely key based on PCP and includes Camelot Wheel notation.
 func KrumhanslSchmuckler(pcp []float64) KeyResult {
 	profiles := KrumhanslKeyProfiles()
@@ -0,0 +1,7 @@
+// Package midi implements reading of Standard MIDI Files and a sequencer playing them with an
+// instrument.
+//
+// The events of a File can be used on their own, with its TempoMap converting ticks to time,
+// for example to drive tones of the generators package. A Sequencer sends the events to an
+// Instrument at the right samples and streams the audio of the instrument.
+package midi
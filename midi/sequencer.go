@@ -0,0 +1,160 @@
+package midi
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/rickcollette/megasound"
+)
+
+// Instrument produces the audio of the events played by a Sequencer.
+type Instrument interface {
+	megasound.Streamer
+
+	// HandleEvent handles an event. The Sequencer sends all events of the file, including
+	// meta and system exclusive events, at the sample they occur at.
+	HandleEvent(e Event)
+
+	// Reset silences all notes at once and resets the instrument to its initial state. It is
+	// called when the Sequencer seeks.
+	Reset()
+}
+
+// DefaultTail is the time a Sequencer keeps streaming after the last event of the file, for
+// the release of the last notes and the decay of effects to be heard.
+const DefaultTail = 2 * time.Second
+
+// Sequencer plays the events of a File with an Instrument. It implements
+// megasound.StreamSeeker; the stream ends a tail after the last event of the file, DefaultTail
+// unless set with SetTail.
+type Sequencer struct {
+	ins     Instrument
+	sr      megasound.SampleRate
+	tempo   *TempoMap
+	events  []Event
+	samples []int // sample position of each event
+	end     int   // sample position of the last event
+	length  int   // end and the tail
+	next    int   // index of the next event
+	pos     int
+}
+
+// NewSequencer returns a Sequencer playing f with ins at the sample rate sr. All tracks are
+// played at once, which for files of format 2 plays the patterns simultaneously.
+func NewSequencer(f *File, sr megasound.SampleRate, ins Instrument) *Sequencer {
+	s := &Sequencer{
+		ins:    ins,
+		sr:     sr,
+		tempo:  f.TempoMap(),
+		events: f.Events(),
+	}
+	s.samples = make([]int, len(s.events))
+	for i, e := range s.events {
+		s.samples[i] = int(math.Round(s.tempo.Seconds(e.Tick) * float64(sr)))
+	}
+	if n := len(s.samples); n > 0 {
+		s.end = s.samples[n-1]
+	}
+	s.SetTail(DefaultTail)
+	return s
+}
+
+// SetTail sets the time the stream goes on after the last event of the file, which changes
+// the length of the stream. A tail of 0 ends the stream with the last event.
+func (s *Sequencer) SetTail(d time.Duration) {
+	tail := s.sr.N(d)
+	if tail < 0 {
+		tail = 0
+	}
+	s.length = s.end + tail
+	if s.pos > s.length {
+		s.pos = s.length
+	}
+}
+
+// TempoMap returns the tempo map of the file played.
+func (s *Sequencer) TempoMap() *TempoMap {
+	return s.tempo
+}
+
+// Stream streams the audio of the instrument, sending it the events as they occur.
+func (s *Sequencer) Stream(samples [][2]float64) (n int, ok bool) {
+	for n < len(samples) {
+		for s.next < len(s.events) && s.samples[s.next] <= s.pos {
+			s.ins.HandleEvent(s.events[s.next])
+			s.next++
+		}
+		if s.pos >= s.length {
+			break
+		}
+		end := s.length
+		if s.next < len(s.events) {
+			end = s.samples[s.next]
+		}
+		chunk := samples[n:]
+		if len(chunk) > end-s.pos {
+			chunk = chunk[:end-s.pos]
+		}
+		sn, sok := s.ins.Stream(chunk)
+		if !sok {
+			sn = 0
+		}
+		for i := range chunk[sn:] {
+			chunk[sn+i] = [2]float64{}
+		}
+		n += len(chunk)
+		s.pos += len(chunk)
+	}
+	return n, n > 0
+}
+
+// Err propagates the errors of the instrument.
+func (s *Sequencer) Err() error {
+	return s.ins.Err()
+}
+
+// Len returns the length of the sequence in samples, including the tail.
+func (s *Sequencer) Len() int {
+	return s.length
+}
+
+// Position returns the current position in samples.
+func (s *Sequencer) Position() int {
+	return s.pos
+}
+
+// Tick returns the tick of the current position.
+func (s *Sequencer) Tick() int {
+	// event positions are rounded to samples
+	secs := (float64(s.pos) + 0.5) / float64(s.sr)
+	return s.tempo.Tick(time.Duration(secs * float64(time.Second)))
+}
+
+// Seek sets the position to p in samples. The instrument is reset and receives all events
+// before p except notes, so controllers and programs are set as if the sequence was played.
+func (s *Sequencer) Seek(p int) error {
+	if p < 0 || s.length < p {
+		return fmt.Errorf("midi: seek position %v out of range [%v, %v]", p, 0, s.length)
+	}
+	s.ins.Reset()
+	s.next = 0
+	for s.next < len(s.events) && s.samples[s.next] < p {
+		if e := s.events[s.next]; e.Kind != NoteOn && e.Kind != NoteOff && e.Kind != PolyPressure {
+			s.ins.HandleEvent(e)
+		}
+		s.next++
+	}
+	s.pos = p
+	return nil
+}
+
+// SeekTick sets the position to tick.
+func (s *Sequencer) SeekTick(tick int) error {
+	return s.Seek(int(math.Round(s.tempo.Seconds(tick) * float64(s.sr))))
+}
+
+// SeekTime sets the position to the time d.
+func (s *Sequencer) SeekTime(d time.Duration) error {
+	return s.Seek(s.sr.N(d))
+}
@@ -0,0 +1,59 @@
+package midi
+
+import (
+	"testing"
+	"time"
+)
+
+// constInstrument streams a constant signal, standing in for notes ringing after their release.
+type constInstrument struct{}
+
+func (constInstrument) Stream(samples [][2]float64) (int, bool) {
+	for i := range samples {
+		samples[i] = [2]float64{1, 1}
+	}
+	return len(samples), true
+}
+
+func (constInstrument) Err() error        { return nil }
+func (constInstrument) HandleEvent(Event) {}
+func (constInstrument) Reset()            {}
+
+func TestSequencerTail(t *testing.T) {
+	// a note of a quarter note, 500 ms at the default tempo
+	f := &File{Division: 480, Tracks: []Track{{Events: []Event{
+		{Tick: 0, Kind: NoteOn, Data1: 60, Data2: 100},
+		{Tick: 480, Kind: NoteOff, Data1: 60},
+	}}}}
+	for _, tc := range []struct {
+		tail time.Duration
+		want int
+	}{
+		{DefaultTail, 500 + 2000},
+		{0, 500},
+		{100 * time.Millisecond, 500 + 100},
+	} {
+		s := NewSequencer(f, 1000, constInstrument{})
+		s.SetTail(tc.tail)
+		if s.Len() != tc.want {
+			t.Errorf("tail %v: Len() = %d, want %d", tc.tail, s.Len(), tc.want)
+		}
+		total, sound := 0, 0
+		buf := make([][2]float64, 300)
+		for {
+			n, ok := s.Stream(buf)
+			if !ok {
+				break
+			}
+			for _, x := range buf[:n] {
+				if x[0] != 0 {
+					sound++
+				}
+			}
+			total += n
+		}
+		if total != tc.want || sound != tc.want {
+			t.Errorf("tail %v: streamed %d samples, %d of them from the instrument, want %d", tc.tail, total, sound, tc.want)
+		}
+	}
+}
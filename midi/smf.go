@@ -0,0 +1,383 @@
+package midi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+	"strconv"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+// File is a Standard MIDI File.
+type File struct {
+	// Format is the SMF format: 0 for a single track, 1 for simultaneous tracks and 2 for
+	// independent patterns.
+	Format int
+
+	// Division is the number of ticks per quarter note. It is 0 for files with SMPTE timing,
+	// which use FramesPerSecond and TicksPerFrame instead.
+	Division        int
+	FramesPerSecond int
+	TicksPerFrame   int
+
+	Tracks []Track
+}
+
+// Track is a track of a File.
+type Track struct {
+	// Events holds the events of the track in order, with absolute ticks.
+	Events []Event
+}
+
+// Name returns the name of t, the text of its first track name meta event.
+func (t *Track) Name() string {
+	for _, e := range t.Events {
+		if e.Kind == Meta && e.Meta == MetaTrackName {
+			return string(e.Data)
+		}
+	}
+	return ""
+}
+
+// Kind is the kind of an Event.
+type Kind int
+
+// Event kinds. Channel messages come first.
+const (
+	NoteOff Kind = iota
+	NoteOn
+	PolyPressure
+	ControlChange
+	ProgramChange
+	ChannelPressure
+	PitchBend
+	SysEx
+	Meta
+)
+
+var kindNames = [...]string{"NoteOff", "NoteOn", "PolyPressure", "ControlChange", "ProgramChange", "ChannelPressure", "PitchBend", "SysEx", "Meta"}
+
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return "Kind(" + strconv.Itoa(int(k)) + ")"
+	}
+	return kindNames[k]
+}
+
+// Meta event types.
+const (
+	MetaSequenceNumber = 0x00
+	MetaText           = 0x01
+	MetaCopyright      = 0x02
+	MetaTrackName      = 0x03
+	MetaInstrumentName = 0x04
+	MetaLyric          = 0x05
+	MetaMarker         = 0x06
+	MetaCuePoint       = 0x07
+	MetaChannelPrefix  = 0x20
+	MetaEndOfTrack     = 0x2f
+	MetaTempo          = 0x51
+	MetaSMPTEOffset    = 0x54
+	MetaTimeSignature  = 0x58
+	MetaKeySignature   = 0x59
+	MetaSequencer      = 0x7f
+)
+
+// Event is a MIDI event. A note on with velocity 0 is read as a note off with velocity 64.
+type Event struct {
+	Tick  int // absolute time in ticks
+	Track int // index of the track in File.Tracks
+	Kind  Kind
+
+	// Channel, 0 to 15, and data of channel messages: the key and velocity of notes, the
+	// controller and value of control changes, the program of program changes, the pressure
+	// of channel pressure and the key and pressure of poly pressure messages. Pitch bends
+	// have a value from -8192 to 8191 in Data1.
+	Channel int
+	Data1   int
+	Data2   int
+
+	// Meta is the type of meta events, Data holds the data of meta and system exclusive
+	// events.
+	Meta int
+	Data []byte
+}
+
+// Tempo returns the tempo of a tempo meta event in microseconds per quarter note.
+func (e *Event) Tempo() (int, bool) {
+	if e.Kind != Meta || e.Meta != MetaTempo || len(e.Data) < 3 {
+		return 0, false
+	}
+	return int(e.Data[0])<<16 | int(e.Data[1])<<8 | int(e.Data[2]), true
+}
+
+// TimeSignature is a time signature of a File.
+type TimeSignature struct {
+	Tick          int
+	Numerator     int
+	Denominator   int // 2, 4, 8...
+	Clocks        int // MIDI clocks per metronome click
+	ThirtySeconds int // notated 32nd notes per quarter note
+}
+
+// TimeSignature returns the time signature of a time signature meta event.
+func (e *Event) TimeSignature() (TimeSignature, bool) {
+	if e.Kind != Meta || e.Meta != MetaTimeSignature || len(e.Data) < 4 || e.Data[1] > 30 {
+		return TimeSignature{}, false
+	}
+	return TimeSignature{
+		Tick:          e.Tick,
+		Numerator:     int(e.Data[0]),
+		Denominator:   1 << e.Data[1],
+		Clocks:        int(e.Data[2]),
+		ThirtySeconds: int(e.Data[3]),
+	}, true
+}
+
+// KeySignature returns the key signature of a key signature meta event, the number of sharps
+// (positive) or flats (negative) and whether the key is minor.
+func (e *Event) KeySignature() (sharps int, minor bool, ok bool) {
+	if e.Kind != Meta || e.Meta != MetaKeySignature || len(e.Data) < 2 {
+		return 0, false, false
+	}
+	return int(int8(e.Data[0])), e.Data[1] == 1, true
+}
+
+// maxFileSize is the size limit of MIDI files.
+const maxFileSize = 64 << 20
+
+// Read reads a Standard MIDI File of format 0, 1 or 2 from r. RIFF MIDI (RMID) files are
+// supported too.
+func Read(r io.Reader) (*File, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxFileSize+1))
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "midi")
+	}
+	if len(data) > maxFileSize {
+		return nil, pkgerrors.New("midi: file too large")
+	}
+	f, err := parse(data)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "midi")
+	}
+	return f, nil
+}
+
+func parse(data []byte) (*File, error) {
+	if len(data) >= 20 && string(data[:4]) == "RIFF" && string(data[8:12]) == "RMID" {
+		var err error
+		if data, err = rmidData(data[12:]); err != nil {
+			return nil, err
+		}
+	}
+	if len(data) < 14 || string(data[:4]) != "MThd" {
+		return nil, pkgerrors.New("not a MIDI file")
+	}
+	size := int(binary.BigEndian.Uint32(data[4:8]))
+	if size < 6 || 8+size > len(data) {
+		return nil, pkgerrors.New("invalid header")
+	}
+	f := &File{Format: int(binary.BigEndian.Uint16(data[8:10]))}
+	numTracks := int(binary.BigEndian.Uint16(data[10:12]))
+	division := binary.BigEndian.Uint16(data[12:14])
+	if f.Format > 2 {
+		return nil, pkgerrors.Errorf("unsupported format %d", f.Format)
+	}
+	if division&0x8000 != 0 {
+		f.FramesPerSecond = -int(int8(division >> 8))
+		f.TicksPerFrame = int(division & 0xff)
+		if f.FramesPerSecond <= 0 || f.TicksPerFrame == 0 {
+			return nil, pkgerrors.New("invalid SMPTE division")
+		}
+	} else {
+		f.Division = int(division)
+		if f.Division == 0 {
+			return nil, pkgerrors.New("invalid division")
+		}
+	}
+
+	p := data[8+size:]
+	for len(f.Tracks) < numTracks && len(p) >= 8 {
+		id := string(p[:4])
+		size := int(binary.BigEndian.Uint32(p[4:8]))
+		p = p[8:]
+		if size > len(p) {
+			if id != "MTrk" {
+				break
+			}
+			size = len(p) // truncated files are common, read what is there
+		}
+		if id == "MTrk" {
+			events, err := parseTrack(p[:size], len(f.Tracks))
+			if err != nil {
+				return nil, pkgerrors.Wrapf(err, "track %d", len(f.Tracks))
+			}
+			f.Tracks = append(f.Tracks, Track{Events: events})
+		}
+		p = p[size:]
+	}
+	if len(f.Tracks) == 0 {
+		return nil, pkgerrors.New("no tracks")
+	}
+	return f, nil
+}
+
+// rmidData returns the data chunk of the chunks of a RIFF MIDI file.
+func rmidData(p []byte) ([]byte, error) {
+	for len(p) >= 8 {
+		size := int(binary.LittleEndian.Uint32(p[4:8]))
+		if size > len(p)-8 {
+			size = len(p) - 8
+		}
+		if string(p[:4]) == "data" {
+			return p[8 : 8+size], nil
+		}
+		skip := 8 + size + size&1 // chunks are padded to even sizes
+		if skip > len(p) {
+			break
+		}
+		p = p[skip:]
+	}
+	return nil, pkgerrors.New("no data chunk in RMID file")
+}
+
+// parseTrack parses the events of a track chunk.
+func parseTrack(p []byte, track int) ([]Event, error) {
+	var (
+		events  []Event
+		tick    int
+		running byte
+	)
+	r := bytes.NewReader(p)
+	for r.Len() > 0 {
+		delta, err := readVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		tick += delta
+		status, err := r.ReadByte()
+		if err != nil {
+			return nil, pkgerrors.New("truncated event")
+		}
+		if status < 0x80 {
+			if running == 0 {
+				return nil, pkgerrors.New("data byte without status")
+			}
+			r.UnreadByte()
+			status = running
+		}
+		e := Event{Tick: tick, Track: track}
+		switch {
+		case status == 0xff:
+			running = 0
+			typ, err := r.ReadByte()
+			if err != nil {
+				return nil, pkgerrors.New("truncated meta event")
+			}
+			e.Kind, e.Meta = Meta, int(typ)
+			if e.Data, err = readData(r); err != nil {
+				return nil, err
+			}
+		case status == 0xf0 || status == 0xf7:
+			running = 0
+			e.Kind = SysEx
+			if e.Data, err = readData(r); err != nil {
+				return nil, err
+			}
+		case status >= 0xf0:
+			return nil, pkgerrors.Errorf("invalid status byte %#x", status)
+		default:
+			running = status
+			e.Kind = Kind(status>>4 - 8)
+			e.Channel = int(status & 0xf)
+			d1, err1 := r.ReadByte()
+			var d2 byte
+			var err2 error
+			if e.Kind != ProgramChange && e.Kind != ChannelPressure {
+				d2, err2 = r.ReadByte()
+			}
+			if err1 != nil || err2 != nil {
+				return nil, pkgerrors.New("truncated channel message")
+			}
+			e.Data1, e.Data2 = int(d1&0x7f), int(d2&0x7f)
+			switch {
+			case e.Kind == NoteOn && e.Data2 == 0:
+				e.Kind, e.Data2 = NoteOff, 64
+			case e.Kind == PitchBend:
+				e.Data1, e.Data2 = e.Data1|e.Data2<<7-8192, 0
+			}
+		}
+		events = append(events, e)
+		if e.Kind == Meta && e.Meta == MetaEndOfTrack {
+			break
+		}
+	}
+	return events, nil
+}
+
+// readVarint reads a variable-length quantity of up to 4 bytes.
+func readVarint(r io.ByteReader) (int, error) {
+	v := 0
+	for i := 0; i < 4; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, pkgerrors.New("truncated variable-length quantity")
+		}
+		v = v<<7 | int(b&0x7f)
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, pkgerrors.New("invalid variable-length quantity")
+}
+
+// readData reads the length and the data of a meta or system exclusive event.
+func readData(r *bytes.Reader) ([]byte, error) {
+	n, err := readVarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > r.Len() {
+		return nil, pkgerrors.New("truncated event data")
+	}
+	data := make([]byte, n)
+	r.Read(data)
+	return data, nil
+}
+
+// Events returns the events of all tracks merged in order of time. Events at the same tick
+// keep the order of their tracks.
+func (f *File) Events() []Event {
+	var events []Event
+	for _, t := range f.Tracks {
+		events = append(events, t.Events...)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Tick < events[j].Tick
+	})
+	return events
+}
+
+// TimeSignatures returns the time signatures of f in order of time.
+func (f *File) TimeSignatures() []TimeSignature {
+	var sigs []TimeSignature
+	for _, e := range f.Events() {
+		if ts, ok := e.TimeSignature(); ok {
+			sigs = append(sigs, ts)
+		}
+	}
+	return sigs
+}
+
+// Ticks returns the length of f in ticks, the tick of its last event.
+func (f *File) Ticks() int {
+	n := 0
+	for _, t := range f.Tracks {
+		if len(t.Events) > 0 && t.Events[len(t.Events)-1].Tick > n {
+			n = t.Events[len(t.Events)-1].Tick
+		}
+	}
+	return n
+}
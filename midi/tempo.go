@@ -0,0 +1,113 @@
+package midi
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// DefaultTempo is the tempo of files until their first tempo event, in microseconds per
+// quarter note (120 beats per minute).
+const DefaultTempo = 500000
+
+// TempoMap converts between ticks and time with the tempo changes of a File.
+type TempoMap struct {
+	division int     // ticks per quarter note, 0 for SMPTE timing
+	tickTime float64 // seconds per tick with SMPTE timing
+	changes  []tempoChange
+}
+
+type tempoChange struct {
+	tick  int
+	time  float64 // in seconds
+	tempo int     // microseconds per quarter note
+}
+
+// TempoMap returns the tempo map of f, made of the tempo events of all tracks. Tempo events
+// are ignored in files with SMPTE timing, whose ticks have a fixed length.
+func (f *File) TempoMap() *TempoMap {
+	m := &TempoMap{division: f.Division}
+	if f.Division == 0 {
+		m.tickTime = 1 / float64(f.FramesPerSecond*f.TicksPerFrame)
+		return m
+	}
+	m.changes = []tempoChange{{tempo: DefaultTempo}}
+	for _, e := range f.Events() {
+		tempo, ok := e.Tempo()
+		if !ok || tempo == 0 {
+			continue
+		}
+		last := &m.changes[len(m.changes)-1]
+		if e.Tick == last.tick {
+			last.tempo = tempo
+			continue
+		}
+		m.changes = append(m.changes, tempoChange{
+			tick:  e.Tick,
+			time:  last.time + m.seconds(e.Tick-last.tick, last.tempo),
+			tempo: tempo,
+		})
+	}
+	return m
+}
+
+// seconds returns the duration of ticks at tempo.
+func (m *TempoMap) seconds(ticks, tempo int) float64 {
+	return float64(ticks) * float64(tempo) / 1e6 / float64(m.division)
+}
+
+// change returns the last tempo change at or before tick.
+func (m *TempoMap) change(tick int) tempoChange {
+	i := sort.Search(len(m.changes), func(i int) bool { return m.changes[i].tick > tick })
+	if i == 0 {
+		return m.changes[0]
+	}
+	return m.changes[i-1]
+}
+
+// Seconds returns the time of tick in seconds.
+func (m *TempoMap) Seconds(tick int) float64 {
+	if m.division == 0 {
+		return float64(tick) * m.tickTime
+	}
+	c := m.change(tick)
+	return c.time + m.seconds(tick-c.tick, c.tempo)
+}
+
+// Time returns the time of tick.
+func (m *TempoMap) Time(tick int) time.Duration {
+	return time.Duration(math.Round(m.Seconds(tick) * float64(time.Second)))
+}
+
+// Tick returns the tick at time d, rounded down.
+func (m *TempoMap) Tick(d time.Duration) int {
+	s := d.Seconds()
+	if m.division == 0 {
+		return int(s/m.tickTime + 1e-6)
+	}
+	i := sort.Search(len(m.changes), func(i int) bool { return m.changes[i].time > s })
+	c := m.changes[0]
+	if i > 0 {
+		c = m.changes[i-1]
+	}
+	return c.tick + int((s-c.time)*1e6*float64(m.division)/float64(c.tempo)+1e-6)
+}
+
+// Tempo returns the tempo at tick in microseconds per quarter note. Files with SMPTE timing
+// have no tempo and return DefaultTempo.
+func (m *TempoMap) Tempo(tick int) int {
+	if m.division == 0 {
+		return DefaultTempo
+	}
+	return m.change(tick).tempo
+}
+
+// BPM returns the tempo at tick in quarter notes per minute.
+func (m *TempoMap) BPM(tick int) float64 {
+	return 60e6 / float64(m.Tempo(tick))
+}
+
+// Duration returns the length of f, the time of its last event.
+func (f *File) Duration() time.Duration {
+	return f.TempoMap().Time(f.Ticks())
+}
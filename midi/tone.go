@@ -0,0 +1,161 @@
+package midi
+
+import (
+	"math"
+
+	"github.com/rickcollette/megasound"
+)
+
+// NoteFrequency returns the frequency of a MIDI key in equal temperament, with key 69 (A4) at
+// 440 Hz.
+func NoteFrequency(key int) float64 {
+	return 440 * math.Exp2(float64(key-69)/12)
+}
+
+// ToneFunc returns a streamer playing a tone of frequency freq at the sample rate sr. The
+// generators of the generators package, like generators.SineTone, are ToneFuncs.
+type ToneFunc func(sr megasound.SampleRate, freq float64) (megasound.Streamer, error)
+
+// NewToneInstrument returns a polyphonic Instrument playing each note with a streamer returned
+// by tone, at a gain from the velocity of the note and the volume (7) and expression (11)
+// controllers of its channel. The sustain pedal (64) holds released notes. Notes fade in and
+// out over a few milliseconds to avoid clicks.
+//
+// Notes of channel 10, the percussion channel of General MIDI, are not played, and so are notes
+// for which tone returns an error.
+func NewToneInstrument(sr megasound.SampleRate, tone ToneFunc) Instrument {
+	t := &toneInstrument{sr: sr, tone: tone, ramp: 1 / (0.005 * float64(sr))}
+	t.Reset()
+	return t
+}
+
+// toneGain is the gain of a note of maximum velocity, leaving room for chords.
+const toneGain = 0.25
+
+type toneInstrument struct {
+	sr         megasound.SampleRate
+	tone       ToneFunc
+	ramp       float64 // level change per sample
+	notes      []*toneNote
+	volume     [16]float64
+	expression [16]float64
+	sustain    [16]bool
+	buf        [][2]float64
+}
+
+type toneNote struct {
+	channel, key int
+	s            megasound.Streamer
+	gain         float64
+	level        float64
+	released     bool
+	held         bool // released while the sustain pedal is down
+}
+
+func (t *toneInstrument) HandleEvent(e Event) {
+	switch e.Kind {
+	case NoteOn:
+		if e.Channel == 9 {
+			return
+		}
+		t.release(e.Channel, e.Data1)
+		s, err := t.tone(t.sr, NoteFrequency(e.Data1))
+		if err != nil {
+			return
+		}
+		t.notes = append(t.notes, &toneNote{
+			channel: e.Channel,
+			key:     e.Data1,
+			s:       s,
+			gain:    float64(e.Data2) / 127,
+		})
+	case NoteOff:
+		t.release(e.Channel, e.Data1)
+	case ControlChange:
+		v := float64(e.Data2) / 127
+		switch e.Data1 {
+		case 7:
+			t.volume[e.Channel] = v
+		case 11:
+			t.expression[e.Channel] = v
+		case 64:
+			t.sustain[e.Channel] = e.Data2 >= 64
+			if !t.sustain[e.Channel] {
+				for _, n := range t.notes {
+					if n.channel == e.Channel && n.held {
+						n.held, n.released = false, true
+					}
+				}
+			}
+		case 120: // all sound off
+			notes := t.notes[:0]
+			for _, n := range t.notes {
+				if n.channel != e.Channel {
+					notes = append(notes, n)
+				}
+			}
+			t.notes = notes
+		case 121: // reset all controllers
+			t.volume[e.Channel], t.expression[e.Channel], t.sustain[e.Channel] = 100.0/127, 1, false
+		case 123: // all notes off
+			for _, n := range t.notes {
+				if n.channel == e.Channel {
+					n.released = true
+				}
+			}
+		}
+	}
+}
+
+// release releases the notes of key on channel.
+func (t *toneInstrument) release(channel, key int) {
+	for _, n := range t.notes {
+		if n.channel == channel && n.key == key && !n.released && !n.held {
+			if t.sustain[channel] {
+				n.held = true
+			} else {
+				n.released = true
+			}
+		}
+	}
+}
+
+func (t *toneInstrument) Reset() {
+	t.notes = nil
+	for i := range t.volume {
+		t.volume[i], t.expression[i], t.sustain[i] = 100.0/127, 1, false
+	}
+}
+
+func (t *toneInstrument) Stream(samples [][2]float64) (n int, ok bool) {
+	for i := range samples {
+		samples[i] = [2]float64{}
+	}
+	if cap(t.buf) < len(samples) {
+		t.buf = make([][2]float64, len(samples))
+	}
+	buf := t.buf[:len(samples)]
+	notes := t.notes[:0]
+	for _, note := range t.notes {
+		sn, sok := note.s.Stream(buf)
+		gain := toneGain * note.gain * t.volume[note.channel] * t.expression[note.channel]
+		for i := range buf[:sn] {
+			if note.released {
+				note.level = math.Max(0, note.level-t.ramp)
+			} else {
+				note.level = math.Min(1, note.level+t.ramp)
+			}
+			samples[i][0] += buf[i][0] * gain * note.level
+			samples[i][1] += buf[i][1] * gain * note.level
+		}
+		if sok && sn == len(buf) && !(note.released && note.level == 0) {
+			notes = append(notes, note)
+		}
+	}
+	t.notes = notes
+	return len(samples), true
+}
+
+func (t *toneInstrument) Err() error {
+	return nil
+}
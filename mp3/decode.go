@@ -2,10 +2,13 @@
 package mp3
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"math"
 
 	"github.com/rickcollette/megasound"
+	"github.com/rickcollette/megasound/tags"
 	gomp3 "github.com/hajimehoshi/go-mp3"
 	pkgerrors "github.com/pkg/errors"
 )
@@ -16,47 +19,209 @@ const (
 	gomp3BytesPerFrame = gomp3NumChannels * gomp3Precision
 )
 
+func init() {
+	megasound.RegisterFormat("mp3", match, Decode)
+}
+
+// match reports whether head starts with two consecutive layer III frames, after an ID3v2 tag
+// if there is one.
+func match(head []byte) bool {
+	if size, ok := tags.ID3v2Size(head); ok {
+		if size > len(head) {
+			return false
+		}
+		head = head[size:]
+	}
+	h, ok := parseFrameHeader(head)
+	if !ok {
+		return false
+	}
+	if size := h.size(); len(head) >= size+4 {
+		_, ok = parseFrameHeader(head[size:])
+	}
+	return ok
+}
+
 // Decode takes a ReadCloser containing audio data in MP3 format and returns a StreamSeekCloser,
-// which streams that audio. The Seek method will panic if rc is not io.Seeker.
+// which streams that audio. The Seek method returns an error if rc is not io.Seeker.
+//
+// If rc is not io.Seeker, the length of the stream is only known if it starts with a Xing/Info
+// frame telling its number of frames. Otherwise Len returns 0 and the stream plays to its end.
+//
+// The returned Format reports the channel count of the stream. Mono streams are streamed with
+// both channels equal. The precision is that of the decoder output, 16 bits.
+//
+// If the stream starts with a Xing/Info frame carrying a LAME tag, the encoder delay and padding
+// are trimmed, so that Len reports the exact number of encoded samples and consecutive tracks
+// played through megasound.Seq are gapless.
+//
+// Seeking is sample accurate. It uses a frame Index, which is built by scanning rc on the first
+// call to Seek. Use NewIndex and DecodeIndexed to build the Index up front or to reuse a
+// persisted one. Until the Index is built, the length of a stream without a Xing/Info frame
+// telling its number of frames is estimated from the size of rc and the bitrate of the first
+// frame, which is exact for constant bitrate streams.
+//
+// The returned StreamSeekCloser implements tags.Tagger, its Tags method returns the tags of the
+// ID3v2 tag at the beginning of the stream and, if rc is io.Seeker, of the ID3v1 tag at its end.
 //
 // Do not close the supplied ReadSeekCloser, instead, use the Close method of the returned
 // StreamSeekCloser when you want to release the resources.
 func Decode(rc io.ReadCloser) (s megasound.StreamSeekCloser, format megasound.Format, err error) {
+	return decode(rc, nil)
+}
+
+// DecodeIndexed is like Decode, but uses idx for seeking instead of scanning rc. The Index must
+// have been built by NewIndex from the same stream. rc must be io.ReadSeeker.
+func DecodeIndexed(rc io.ReadCloser, idx *Index) (s megasound.StreamSeekCloser, format megasound.Format, err error) {
+	if _, ok := rc.(io.ReadSeeker); !ok {
+		return nil, megasound.Format{}, pkgerrors.New("mp3: indexed decoding requires io.Seeker")
+	}
+	if idx == nil || len(idx.Offsets) == 0 {
+		return nil, megasound.Format{}, pkgerrors.New("mp3: empty index")
+	}
+	return decode(rc, idx)
+}
+
+func decode(rc io.ReadCloser, idx *Index) (s megasound.StreamSeekCloser, format megasound.Format, err error) {
 	defer func() {
 		if err != nil {
 			err = pkgerrors.Wrap(err, "mp3")
 		}
 	}()
-	d, err := gomp3.NewDecoder(rc)
+	hd, consumed, err := readHead(rc)
 	if err != nil {
 		return nil, megasound.Format{}, err
 	}
-	format = megasound.Format{
-		SampleRate:  megasound.SampleRate(d.SampleRate()),
+
+	dec := &decoder{closer: rc, hd: hd, idx: idx}
+	if hd.id3 != nil {
+		dec.tags, _ = tags.ParseID3v2(hd.id3)
+	}
+	total := 0 // number of samples in the go-mp3 output
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		dec.rs = rs
+		v1, hasV1 := tags.ReadID3v1(rs)
+		if hasV1 {
+			dec.tags.Merge(v1)
+		}
+		if idx != nil && idx.Offsets[0] != int64(hd.offset) {
+			return nil, megasound.Format{}, pkgerrors.New("index does not match the stream")
+		}
+		switch {
+		case idx != nil:
+			total = idx.NumSamples()
+		case hd.xing && hd.x.flags&xingFrames != 0:
+			total = (hd.x.frames + 1) * hd.h.samplesPerFrame()
+		case hd.h.sampleRate > 0:
+			// the index is built on the first seek, estimate the length until then
+			end, err := rs.Seek(0, io.SeekEnd)
+			if err != nil {
+				return nil, megasound.Format{}, err
+			}
+			if hasV1 {
+				end -= 128
+			}
+			frameSize := float64(hd.h.samplesPerFrame()/8*hd.h.bitrate*1000) / float64(hd.h.sampleRate)
+			total = int(math.Round(float64(end-int64(hd.offset))/frameSize)) * hd.h.samplesPerFrame()
+			dec.estimated = true
+		}
+		if err := dec.open(0); err != nil {
+			return nil, megasound.Format{}, err
+		}
+	} else {
+		dec.d, err = gomp3.NewDecoder(io.MultiReader(bytes.NewReader(consumed), rc))
+		if err != nil {
+			return nil, megasound.Format{}, err
+		}
+		switch l := int(dec.d.Length()); {
+		case hd.xing && hd.x.flags&xingFrames != 0:
+			total = (hd.x.frames + 1) * hd.h.samplesPerFrame()
+		case l > 0:
+			total = l / gomp3BytesPerFrame
+		default:
+			total = -1 // unknown, the stream plays to its end
+		}
+	}
+
+	// go-mp3 always decodes to 16 bit stereo, mono streams have both channels equal.
+	dec.f = megasound.Format{
+		SampleRate:  megasound.SampleRate(dec.d.SampleRate()),
 		NumChannels: gomp3NumChannels,
 		Precision:   gomp3Precision,
 	}
-	return &decoder{rc, d, format, 0, nil}, format, nil
+	format = dec.f
+	if hd.h.mono {
+		format.NumChannels = 1
+	}
+	dec.length = total
+	if hd.xing {
+		if start, length, ok := hd.x.gapless(hd.h); ok && start < total {
+			dec.start = start
+			dec.length = total - start
+			if length < dec.length {
+				dec.length = length
+			}
+		}
+	}
+	if dec.start > 0 {
+		if _, err := io.CopyN(io.Discard, dec.d, int64(dec.start)*gomp3BytesPerFrame); err != nil {
+			return nil, megasound.Format{}, err
+		}
+	}
+	return dec, format, nil
 }
 
 type decoder struct {
-	closer io.Closer
-	d      *gomp3.Decoder
-	f      megasound.Format
-	pos    int
-	err    error
+	closer    io.Closer
+	rs        io.ReadSeeker // nil if the source is not seekable
+	hd        head
+	idx       *Index
+	d         *gomp3.Decoder
+	f         megasound.Format // format of the go-mp3 output
+	start     int              // number of samples of the go-mp3 output to skip (delay)
+	length    int              // number of samples to stream after start, -1 if unknown
+	estimated bool             // length is estimated from the bitrate until the index is built
+	pos       int
+	err       error
+	tags      tags.Tags
+}
+
+// noSeek hides the Seek method of a reader, so that go-mp3 does not scan the whole stream when
+// the decoder is created.
+type noSeek struct {
+	io.Reader
+}
+
+// open starts a new go-mp3 decoder at the given frame.
+func (d *decoder) open(frame int) error {
+	off := int64(d.hd.offset)
+	if d.idx != nil {
+		off = d.idx.Offsets[frame]
+	}
+	if _, err := d.rs.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	md, err := gomp3.NewDecoder(noSeek{d.rs})
+	if err != nil {
+		return err
+	}
+	d.d = md
+	return nil
 }
 
 func (d *decoder) Stream(samples [][2]float64) (n int, ok bool) {
 	if d.err != nil {
 		return 0, false
 	}
+	if remains := d.length - d.pos; d.length >= 0 && !d.estimated && len(samples) > remains {
+		samples = samples[:remains]
+	}
 	var tmp [gomp3BytesPerFrame]byte
 	for i := range samples {
 		dn, err := d.d.Read(tmp[:])
 		if dn == len(tmp) {
 			samples[i], _ = d.f.DecodeSigned(tmp[:])
-			d.pos += dn
+			d.pos++
 			n++
 			ok = true
 		}
@@ -76,25 +241,50 @@ func (d *decoder) Err() error {
 }
 
 func (d *decoder) Len() int {
-	return int(d.d.Length()) / gomp3BytesPerFrame
+	if d.length < 0 {
+		return 0
+	}
+	return d.length
 }
 
 func (d *decoder) Position() int {
-	return d.pos / gomp3BytesPerFrame
+	return d.pos
 }
 
 func (d *decoder) Seek(p int) error {
+	if d.rs == nil {
+		return pkgerrors.New("mp3: seek: resource is not io.Seeker")
+	}
+	if d.idx == nil {
+		idx, err := NewIndex(d.rs)
+		if err != nil {
+			return err
+		}
+		d.idx = idx
+		if d.estimated {
+			d.length, d.estimated = idx.NumSamples()-d.start, false
+		}
+	}
 	if p < 0 || d.Len() < p {
 		return fmt.Errorf("mp3: seek position %v out of range [%v, %v]", p, 0, d.Len())
 	}
-	_, err := d.d.Seek(int64(p)*gomp3BytesPerFrame, io.SeekStart)
-	if err != nil {
+	frame, skip := d.idx.locate(d.start + p)
+	if err := d.open(frame); err != nil {
 		return pkgerrors.Wrap(err, "mp3")
 	}
-	d.pos = p * gomp3BytesPerFrame
+	if _, err := io.CopyN(io.Discard, d.d, int64(skip)*gomp3BytesPerFrame); err != nil && err != io.EOF {
+		return pkgerrors.Wrap(err, "mp3")
+	}
+	d.pos = p
+	d.err = nil
 	return nil
 }
 
+// Tags returns the tags of the ID3v2 and ID3v1 tags of the stream.
+func (d *decoder) Tags() tags.Tags {
+	return d.tags
+}
+
 func (d *decoder) Close() error {
 	err := d.closer.Close()
 	if err != nil {
@@ -0,0 +1,145 @@
+package mp3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/rickcollette/megasound/tags"
+)
+
+// testSlot is the average size in bytes of the frames of testStream: MPEG-1 layer III at 128
+// kbit/s and 44100 Hz. Frames are 417 bytes long, or 418 with the padding bit set.
+const testSlot = 1152.0 / 8 * 128000 / 44100
+
+// testStream returns a constant bitrate MP3 stream of frames silent frames, starting with an
+// ID3v2 tag if id3 is true and with an Info frame carrying a LAME tag with the encoder delay and
+// padding if lame is true.
+func testStream(frames int, id3, lame bool, delay, padding int) []byte {
+	var p []byte
+	if id3 {
+		p = tags.AppendID3v2(p, tags.Tags{Title: "Silence"})
+	}
+	k := 0
+	frame := func() []byte {
+		size := int(float64(k+1)*testSlot) - int(float64(k)*testSlot)
+		k++
+		f := make([]byte, size)
+		copy(f, []byte{0xff, 0xfb, 0x90, 0x00})
+		if size == 418 {
+			f[2] |= 0x02
+		}
+		return f
+	}
+	if lame {
+		f := frame()
+		x := f[4+32:]
+		copy(x, "Info")
+		binary.BigEndian.PutUint32(x[4:], xingFrames)
+		binary.BigEndian.PutUint32(x[8:], uint32(frames))
+		tag := x[12:]
+		copy(tag, "LAME3.100")
+		tag[21] = byte(delay >> 4)
+		tag[22] = byte(delay<<4) | byte(padding>>8)
+		tag[23] = byte(padding)
+		p = append(p, f...)
+	}
+	for i := 0; i < frames; i++ {
+		p = append(p, frame()...)
+	}
+	return p
+}
+
+type readSeekCloser struct {
+	*bytes.Reader
+}
+
+func (readSeekCloser) Close() error { return nil }
+
+type readCloser struct {
+	io.Reader
+}
+
+func (readCloser) Close() error { return nil }
+
+func streamAll(t *testing.T, s interface {
+	Stream([][2]float64) (int, bool)
+}) int {
+	t.Helper()
+	total := 0
+	buf := make([][2]float64, 1000)
+	for {
+		n, ok := s.Stream(buf)
+		total += n
+		if !ok {
+			return total
+		}
+	}
+}
+
+func TestGapless(t *testing.T) {
+	const frames, delay, padding = 10, 576, 1000
+	want := frames*1152 - delay - padding
+	data := testStream(frames, true, true, delay, padding)
+	for _, tc := range []struct {
+		name string
+		rc   io.ReadCloser
+	}{
+		{"seekable", readSeekCloser{bytes.NewReader(data)}},
+		{"reader", readCloser{bytes.NewBuffer(data)}},
+	} {
+		s, format, err := Decode(tc.rc)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if format.SampleRate != 44100 || format.NumChannels != 2 {
+			t.Errorf("%s: format %+v", tc.name, format)
+		}
+		if s.Len() != want {
+			t.Errorf("%s: Len() = %d, want %d", tc.name, s.Len(), want)
+		}
+		if n := streamAll(t, s); n != want {
+			t.Errorf("%s: streamed %d samples, want %d", tc.name, n, want)
+		}
+		if title := s.(tags.Tagger).Tags().Title; title != "Silence" {
+			t.Errorf("%s: title %q", tc.name, title)
+		}
+	}
+}
+
+func TestDecodeReaderUnknownLength(t *testing.T) {
+	const frames = 10
+	s, _, err := Decode(readCloser{bytes.NewBuffer(testStream(frames, false, false, 0, 0))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 0 {
+		t.Errorf("Len() = %d, want 0 for an unknown length", s.Len())
+	}
+	if n := streamAll(t, s); n != frames*1152 {
+		t.Errorf("streamed %d samples, want %d", n, frames*1152)
+	}
+	if err := s.Seek(0); err == nil {
+		t.Error("Seek succeeded on a reader which is not io.Seeker")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	id3 := tags.AppendID3v2(nil, tags.Tags{Title: "Silence"})
+	for _, tc := range []struct {
+		name string
+		head []byte
+		want bool
+	}{
+		{"frames", testStream(2, false, false, 0, 0), true},
+		{"tag and frames", testStream(2, true, false, 0, 0), true},
+		{"tag only", id3, false},
+		{"tag and FLAC", append(id3, "fLaC"...), false},
+		{"text", []byte("not an MP3 file"), false},
+	} {
+		if got := match(tc.head); got != tc.want {
+			t.Errorf("%s: match = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
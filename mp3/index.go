@@ -0,0 +1,188 @@
+package mp3
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+// maxReservoir is the largest number of bytes of main data a layer III frame may take from the
+// frames preceding it (the 9 bit main_data_begin field).
+const maxReservoir = 511
+
+// indexMagic starts the serialized form of an Index.
+const indexMagic = "MP3X\x01"
+
+// Index is a table of the byte offsets of all frames in an MP3 stream. With an Index, seeking to
+// any sample takes constant time and is sample accurate, even in VBR streams.
+//
+// Building an Index requires reading the whole stream once, so it can be persisted with
+// MarshalBinary (e.g. next to the file) and restored with UnmarshalBinary later. Pass it to
+// DecodeIndexed to avoid scanning the stream again.
+type Index struct {
+	// SampleRate is the sample rate of the stream.
+	SampleRate int
+
+	// SamplesPerFrame is the number of samples decoded from each frame, 1152 or 576.
+	SamplesPerFrame int
+
+	// Offsets holds the byte offset of each frame from the beginning of the stream, including
+	// the Xing/Info frame, if any.
+	Offsets []int64
+
+	// End is the byte offset just after the last frame.
+	End int64
+}
+
+// NewIndex scans the whole stream r and returns its frame Index. The position of r is undefined
+// afterwards.
+func NewIndex(r io.ReadSeeker) (*Index, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, pkgerrors.Wrap(err, "mp3")
+	}
+	hd, _, err := readHead(r)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "mp3")
+	}
+	if hd.h.sampleRate == 0 {
+		return nil, pkgerrors.New("mp3: no frame found")
+	}
+	if _, err := r.Seek(int64(hd.offset), io.SeekStart); err != nil {
+		return nil, pkgerrors.Wrap(err, "mp3")
+	}
+
+	idx := &Index{
+		SampleRate:      hd.h.sampleRate,
+		SamplesPerFrame: hd.h.samplesPerFrame(),
+	}
+	br := bufio.NewReaderSize(r, 64*1024)
+	off := int64(hd.offset)
+	for {
+		p, err := br.Peek(4)
+		if err != nil {
+			break // EOF or a truncated tail
+		}
+		h, ok := parseFrameHeader(p)
+		if !ok || h.sampleRate != idx.SampleRate {
+			if string(p[:3]) == "TAG" {
+				break // ID3v1 tag at the end
+			}
+			// lost sync, look for the next frame
+			br.Discard(1)
+			off++
+			continue
+		}
+		size := h.size()
+		n, _ := br.Discard(size)
+		if n < size {
+			break // truncated last frame
+		}
+		idx.Offsets = append(idx.Offsets, off)
+		off += int64(size)
+		idx.End = off
+	}
+	if len(idx.Offsets) == 0 {
+		return nil, pkgerrors.New("mp3: no frame found")
+	}
+	return idx, nil
+}
+
+// NumSamples returns the total number of samples decoded from all frames in the Index.
+func (idx *Index) NumSamples() int {
+	return len(idx.Offsets) * idx.SamplesPerFrame
+}
+
+// frameSize returns the size in bytes of the k-th frame.
+func (idx *Index) frameSize(k int) int64 {
+	if k+1 < len(idx.Offsets) {
+		return idx.Offsets[k+1] - idx.Offsets[k]
+	}
+	return idx.End - idx.Offsets[k]
+}
+
+// locate returns the frame from which decoding must start to produce the t-th sample of the
+// decoder output correctly, and the number of samples to discard after starting there. Enough
+// frames are decoded ahead to fill the bit reservoir and the MDCT overlap of the target frame.
+func (idx *Index) locate(t int) (frame, skip int) {
+	k := t / idx.SamplesPerFrame
+	if k >= len(idx.Offsets) {
+		k = len(idx.Offsets) - 1
+	}
+	j := k
+	for reservoir := int64(0); j > 0 && reservoir < maxReservoir; {
+		j--
+		reservoir += idx.frameSize(j)
+	}
+	if j > 0 {
+		j--
+	}
+	return j, t - j*idx.SamplesPerFrame
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (idx *Index) MarshalBinary() ([]byte, error) {
+	p := make([]byte, 0, len(indexMagic)+4*binary.MaxVarintLen64+2*len(idx.Offsets))
+	p = append(p, indexMagic...)
+	p = binary.AppendUvarint(p, uint64(idx.SampleRate))
+	p = binary.AppendUvarint(p, uint64(idx.SamplesPerFrame))
+	p = binary.AppendUvarint(p, uint64(len(idx.Offsets)))
+	prev := int64(0)
+	for _, off := range idx.Offsets {
+		p = binary.AppendUvarint(p, uint64(off-prev))
+		prev = off
+	}
+	p = binary.AppendUvarint(p, uint64(idx.End-prev))
+	return p, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (idx *Index) UnmarshalBinary(p []byte) error {
+	if len(p) < len(indexMagic) || string(p[:len(indexMagic)]) != indexMagic {
+		return pkgerrors.New("mp3: index: invalid header")
+	}
+	p = p[len(indexMagic):]
+	next := func() (uint64, error) {
+		x, n := binary.Uvarint(p)
+		if n <= 0 {
+			return 0, pkgerrors.New("mp3: index: truncated data")
+		}
+		p = p[n:]
+		return x, nil
+	}
+	var vals [3]uint64
+	for i := range vals {
+		x, err := next()
+		if err != nil {
+			return err
+		}
+		vals[i] = x
+	}
+	if vals[1] != 1152 && vals[1] != 576 {
+		return fmt.Errorf("mp3: index: invalid number of samples per frame: %d", vals[1])
+	}
+	if vals[2] > uint64(len(p)) {
+		return pkgerrors.New("mp3: index: truncated data")
+	}
+	offsets := make([]int64, vals[2])
+	prev := int64(0)
+	for i := range offsets {
+		d, err := next()
+		if err != nil {
+			return err
+		}
+		prev += int64(d)
+		offsets[i] = prev
+	}
+	d, err := next()
+	if err != nil {
+		return err
+	}
+	idx.SampleRate = int(vals[0])
+	idx.SamplesPerFrame = int(vals[1])
+	idx.Offsets = offsets
+	idx.End = prev + int64(d)
+	return nil
+}
@@ -0,0 +1,124 @@
+package mp3
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestIndexMarshalBinary(t *testing.T) {
+	data := testStream(50, true, true, 576, 1000)
+	idx, err := NewIndex(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Offsets) != 51 || idx.End != int64(len(data)) {
+		t.Fatalf("index of %d frames ending at %d, want 51 frames ending at %d", len(idx.Offsets), idx.End, len(data))
+	}
+	if idx.SampleRate != 44100 || idx.SamplesPerFrame != 1152 {
+		t.Errorf("index sample rate %d and samples per frame %d", idx.SampleRate, idx.SamplesPerFrame)
+	}
+	p, err := idx.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Index
+	if err := got.UnmarshalBinary(p); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&got, idx) {
+		t.Errorf("UnmarshalBinary(MarshalBinary()) = %+v, want %+v", got, *idx)
+	}
+	for _, bad := range [][]byte{nil, []byte("MP3X"), p[:len(p)-1], p[:len(p)/2]} {
+		if err := new(Index).UnmarshalBinary(bad); err == nil {
+			t.Errorf("UnmarshalBinary of %d bytes succeeded", len(bad))
+		}
+	}
+
+	// the restored index seeks like the one built on the first seek
+	s, _, err := DecodeIndexed(readSeekCloser{bytes.NewReader(data)}, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 50*1152 - 576 - 1000; s.Len() != want {
+		t.Errorf("Len() = %d, want %d", s.Len(), want)
+	}
+	if err := s.Seek(1000); err != nil {
+		t.Fatal(err)
+	}
+	if n := streamAll(t, s); n != s.Len()-1000 {
+		t.Errorf("streamed %d samples after seeking, want %d", n, s.Len()-1000)
+	}
+}
+
+func TestLocate(t *testing.T) {
+	idx, err := NewIndex(bytes.NewReader(testStream(20, false, false, 0, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Frames of 417 or 418 bytes: two frames fill the 511 byte bit reservoir, and one more
+	// primes the MDCT overlap.
+	for _, tc := range []struct{ t, frame, skip int }{
+		{0, 0, 0},
+		{10, 0, 10},
+		{1152 + 5, 0, 1152 + 5},
+		{3*1152 + 7, 0, 3*1152 + 7},
+		{5*1152 + 7, 2, 3*1152 + 7},
+		{19*1152 + 1151, 16, 3*1152 + 1151},
+		{30 * 1152, 16, 14 * 1152}, // past the end, from the last frame
+	} {
+		frame, skip := idx.locate(tc.t)
+		if frame != tc.frame || skip != tc.skip {
+			t.Errorf("locate(%d) = %d, %d, want %d, %d", tc.t, frame, skip, tc.frame, tc.skip)
+		}
+	}
+}
+
+// failingReader fails to read while fail is set.
+type failingReader struct {
+	readSeekCloser
+	fail bool
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.fail {
+		return 0, errors.New("transient error")
+	}
+	return r.readSeekCloser.Read(p)
+}
+
+func TestSeek(t *testing.T) {
+	const frames = 40
+	r := &failingReader{readSeekCloser: readSeekCloser{bytes.NewReader(testStream(frames, false, false, 0, 0))}}
+	s, _, err := Decode(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := s.(*decoder)
+	if d.idx != nil {
+		t.Error("the index was built before the first seek")
+	}
+	if s.Len() != frames*1152 {
+		t.Errorf("estimated Len() = %d, want %d", s.Len(), frames*1152)
+	}
+
+	r.fail = true
+	streamAll(t, s)
+	if s.Err() == nil {
+		t.Fatal("no error streaming from a failing reader")
+	}
+	if n, ok := s.Stream(make([][2]float64, 10)); n != 0 || ok {
+		t.Fatalf("Stream returned %d, %v after an error", n, ok)
+	}
+	r.fail = false
+	if err := s.Seek(12345); err != nil {
+		t.Fatal(err)
+	}
+	if s.Err() != nil || s.Position() != 12345 || d.idx == nil {
+		t.Errorf("after Seek: error %v, position %d", s.Err(), s.Position())
+	}
+	if n := streamAll(t, s); n != frames*1152-12345 {
+		t.Errorf("streamed %d samples after seeking, want %d", n, frames*1152-12345)
+	}
+}
@@ -0,0 +1,264 @@
+package mp3
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+const (
+	// decoderDelay is the number of samples an MDCT based layer III decoder outputs before the
+	// first sample actually fed into the encoder. The LAME tag does not include it in the encoder
+	// delay.
+	decoderDelay = 528 + 1
+
+	// maxID3Size is the size of the largest ID3v2 tag kept in memory, larger ones are skipped.
+	maxID3Size = 16 << 20
+
+	// maxHeadScan is how many bytes after the ID3v2 tag are searched for the first frame.
+	maxHeadScan = 4096
+)
+
+const (
+	xingFrames  = 0x1
+	xingBytes   = 0x2
+	xingTOC     = 0x4
+	xingQuality = 0x8
+
+	xingTOCSize = 100
+)
+
+var (
+	bitrates = [2][16]int{
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}, // MPEG-1
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},     // MPEG-2 and 2.5
+	}
+	sampleRates = [3][3]int{
+		{44100, 48000, 32000}, // MPEG-1
+		{22050, 24000, 16000}, // MPEG-2
+		{11025, 12000, 8000},  // MPEG-2.5
+	}
+)
+
+// frameHeader is a parsed layer III frame header.
+type frameHeader struct {
+	mpeg1      bool
+	sampleRate int
+	bitrate    int // kbit/s
+	padding    bool
+	crc        bool
+	mono       bool
+	version    int // 0 for MPEG-1, 1 for MPEG-2, 2 for MPEG-2.5
+}
+
+// parseFrameHeader parses the four byte frame header at the beginning of p. It reports false if p
+// does not start with a valid layer III header.
+func parseFrameHeader(p []byte) (h frameHeader, ok bool) {
+	if len(p) < 4 || p[0] != 0xff || p[1]&0xe0 != 0xe0 {
+		return h, false
+	}
+	switch (p[1] >> 3) & 0x3 {
+	case 3:
+		h.version = 0
+	case 2:
+		h.version = 1
+	case 0:
+		h.version = 2
+	default:
+		return h, false
+	}
+	if (p[1]>>1)&0x3 != 1 { // layer III
+		return h, false
+	}
+	h.mpeg1 = h.version == 0
+	h.crc = p[1]&0x1 == 0
+	bi := int(p[2] >> 4)
+	si := int((p[2] >> 2) & 0x3)
+	if bi == 0 || bi == 15 || si == 3 {
+		return h, false
+	}
+	if h.mpeg1 {
+		h.bitrate = bitrates[0][bi]
+	} else {
+		h.bitrate = bitrates[1][bi]
+	}
+	h.sampleRate = sampleRates[h.version][si]
+	h.padding = (p[2]>>1)&0x1 == 1
+	h.mono = p[3]>>6 == 3
+	return h, true
+}
+
+// samplesPerFrame returns the number of samples decoded from a single frame.
+func (h frameHeader) samplesPerFrame() int {
+	if h.mpeg1 {
+		return 1152
+	}
+	return 576
+}
+
+// size returns the length of the whole frame in bytes, including the header.
+func (h frameHeader) size() int {
+	n := h.samplesPerFrame() / 8 * h.bitrate * 1000 / h.sampleRate
+	if h.padding {
+		n++
+	}
+	return n
+}
+
+// sideInfoSize returns the length of the side information following the header (and CRC).
+func (h frameHeader) sideInfoSize() int {
+	switch {
+	case h.mpeg1 && h.mono:
+		return 17
+	case h.mpeg1:
+		return 32
+	case h.mono:
+		return 9
+	default:
+		return 17
+	}
+}
+
+// xingHeader holds the Xing/Info and LAME tags found in the first frame of a stream.
+type xingHeader struct {
+	flags   uint32
+	frames  int // number of audio frames, excluding the Info frame
+	bytes   int // number of bytes in the stream, including the Info frame
+	quality int
+
+	lame    bool // true if a LAME tag with delay and padding follows
+	delay   int  // encoder delay in samples
+	padding int  // encoder padding in samples
+}
+
+// parseXing looks for a Xing/Info tag (and an optional LAME tag) in frame, which must start with
+// the frame header h.
+func parseXing(h frameHeader, frame []byte) (x xingHeader, ok bool) {
+	off := 4 + h.sideInfoSize()
+	if h.crc {
+		off += 2
+	}
+	if len(frame) < off+8 {
+		return x, false
+	}
+	tag := string(frame[off : off+4])
+	if tag != "Xing" && tag != "Info" {
+		return x, false
+	}
+	x.flags = binary.BigEndian.Uint32(frame[off+4:])
+	p := frame[off+8:]
+	if x.flags&xingFrames != 0 {
+		if len(p) < 4 {
+			return x, false
+		}
+		x.frames = int(binary.BigEndian.Uint32(p))
+		p = p[4:]
+	}
+	if x.flags&xingBytes != 0 {
+		if len(p) < 4 {
+			return x, false
+		}
+		x.bytes = int(binary.BigEndian.Uint32(p))
+		p = p[4:]
+	}
+	if x.flags&xingTOC != 0 {
+		// the seek table is not needed, seeking uses an Index
+		if len(p) < xingTOCSize {
+			return x, false
+		}
+		p = p[xingTOCSize:]
+	}
+	if x.flags&xingQuality != 0 {
+		if len(p) < 4 {
+			return x, false
+		}
+		x.quality = int(binary.BigEndian.Uint32(p))
+		p = p[4:]
+	}
+
+	// The LAME tag is 36 bytes long, delay and padding are two 12 bit values at offset 21.
+	// Encoders built on libavcodec write the same layout.
+	if len(p) >= 36 {
+		enc := string(p[:4])
+		if enc == "LAME" || enc == "Lavf" || enc == "Lavc" || enc == "L3.9" {
+			x.lame = true
+			x.delay = int(p[21])<<4 | int(p[22])>>4
+			x.padding = int(p[22]&0xf)<<8 | int(p[23])
+		}
+	}
+	return x, true
+}
+
+// gapless returns the offset of the first real sample and the number of real samples in the
+// output of a decoder which also decodes the Info frame itself (as a frame of silence).
+func (x xingHeader) gapless(h frameHeader) (start, length int, ok bool) {
+	if !x.lame || x.flags&xingFrames == 0 || x.frames == 0 {
+		return 0, 0, false
+	}
+	spf := h.samplesPerFrame()
+	start = spf + x.delay + decoderDelay
+	length = x.frames*spf - x.delay - x.padding
+	if length <= 0 {
+		return 0, 0, false
+	}
+	return start, length, true
+}
+
+// head is the parsed beginning of an MP3 stream.
+type head struct {
+	offset int // offset of the first frame from the beginning of the stream
+	h      frameHeader
+	x      xingHeader
+	xing   bool
+	id3    []byte // the ID3v2 tag, nil if there is none
+}
+
+// readHead reads the beginning of r: it reads an ID3v2 tag, finds the first frame and parses its
+// Xing/Info tag, if any. It returns the bytes read starting from the first frame, so that they can
+// be fed to the decoder if r can not be rewound.
+func readHead(r io.Reader) (hd head, frame []byte, err error) {
+	var id3 [10]byte
+	n, err := io.ReadFull(r, id3[:])
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return hd, nil, err
+	}
+	buf := id3[:n]
+	if n == len(id3) && string(id3[:3]) == "ID3" {
+		size := int(id3[6]&0x7f)<<21 | int(id3[7]&0x7f)<<14 | int(id3[8]&0x7f)<<7 | int(id3[9]&0x7f)
+		if id3[5]&0x10 != 0 { // footer present
+			size += 10
+		}
+		if size <= maxID3Size {
+			hd.id3 = make([]byte, len(id3)+size)
+			copy(hd.id3, id3[:])
+			if _, err := io.ReadFull(r, hd.id3[len(id3):]); err != nil {
+				return hd, nil, err
+			}
+		} else if _, err := io.CopyN(io.Discard, r, int64(size)); err != nil {
+			return hd, nil, err
+		}
+		hd.offset = len(id3) + size
+		buf = nil
+	}
+
+	// Scan for the first frame sync and read the whole frame.
+	scan := make([]byte, maxHeadScan)
+	n, err = io.ReadFull(r, scan[:maxHeadScan-len(buf)])
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return hd, nil, err
+	}
+	buf = append(buf, scan[:n]...)
+	for i := 0; i+4 <= len(buf); i++ {
+		h, ok := parseFrameHeader(buf[i:])
+		if !ok {
+			continue
+		}
+		hd.offset += i
+		hd.h = h
+		if end := i + h.size(); end <= len(buf) {
+			hd.x, hd.xing = parseXing(h, buf[i:end])
+		}
+		return hd, buf[i:], nil
+	}
+	// No frame found, let the decoder deal with it.
+	return hd, buf, nil
+}
@@ -0,0 +1,146 @@
+package pcm
+
+import (
+	"fmt"
+	"io"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/rickcollette/megasound"
+)
+
+// Decode takes a Reader containing headerless PCM data with the given format and sample
+// encoding and returns a StreamSeekCloser, which streams that audio. The data starts at the
+// current position of r.
+//
+// Only the first two channels are streamed, mono data is streamed as both channels. Integer
+// samples are 1 to 4 bytes long, floating point samples 4 or 8.
+//
+// If r is io.Seeker, the length of the stream is determined from the size of the data and the
+// returned StreamSeekCloser is seekable. Otherwise, Len returns 0, as the length is unknown
+// until all data has been read, and Seek returns an error.
+//
+// Do not close the supplied Reader, instead, use the Close method of the returned
+// StreamSeekCloser when you want to release the resources.
+func Decode(r io.Reader, format megasound.Format, encoding Encoding) (s megasound.StreamSeekCloser, err error) {
+	defer func() { // hacky way to always close r if an error occurred
+		if err != nil {
+			if closer, ok := r.(io.Closer); ok {
+				closer.Close()
+			}
+			err = pkgerrors.Wrap(err, "pcm")
+		}
+	}()
+	if err := check(format, encoding); err != nil {
+		return nil, err
+	}
+	d := &decoder{r: r, format: format, enc: encoding}
+	if seeker, ok := r.(io.Seeker); ok {
+		d.seeker = seeker
+		if d.start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		end, err := seeker.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := seeker.Seek(d.start, io.SeekStart); err != nil {
+			return nil, err
+		}
+		d.frames = int((end - d.start) / int64(format.Width()))
+	}
+	return d, nil
+}
+
+type decoder struct {
+	r      io.Reader
+	seeker io.Seeker // nil if r is not io.Seeker
+	format megasound.Format
+	enc    Encoding
+	start  int64 // offset of the first sample
+	frames int   // number of samples, 0 if r is not io.Seeker
+	buf    []byte
+	pos    int
+	eof    bool
+	err    error
+}
+
+func (d *decoder) Stream(samples [][2]float64) (n int, ok bool) {
+	if d.err != nil || d.eof {
+		return 0, false
+	}
+	if d.seeker != nil {
+		if d.pos >= d.frames {
+			return 0, false
+		}
+		if remains := d.frames - d.pos; len(samples) > remains {
+			samples = samples[:remains]
+		}
+	}
+	width := d.format.Width()
+	if size := len(samples) * width; cap(d.buf) < size {
+		d.buf = make([]byte, size)
+	}
+	p := d.buf[:len(samples)*width]
+	nb, err := io.ReadFull(d.r, p)
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		d.eof = true // an incomplete last sample is dropped
+	case err != nil:
+		d.err = pkgerrors.Wrap(err, "pcm")
+	}
+	n = nb / width
+	p = p[:n*width]
+	if d.enc.bigEndian() {
+		swap(p, d.format.Precision)
+	}
+	for i := range samples[:n] {
+		frame := p[i*width:]
+		switch d.enc {
+		case SignedLE, SignedBE:
+			samples[i], _ = d.format.DecodeSigned(frame)
+		case UnsignedLE, UnsignedBE:
+			samples[i], _ = d.format.DecodeUnsigned(frame)
+		default:
+			samples[i] = decodeFloat(d.format, frame)
+		}
+	}
+	d.pos += n
+	return n, n > 0
+}
+
+func (d *decoder) Err() error {
+	return d.err
+}
+
+func (d *decoder) Len() int {
+	return d.frames
+}
+
+func (d *decoder) Position() int {
+	return d.pos
+}
+
+func (d *decoder) Seek(p int) error {
+	if d.seeker == nil {
+		return pkgerrors.New("pcm: seek: resource is not io.Seeker")
+	}
+	if p < 0 || d.frames < p {
+		return fmt.Errorf("pcm: seek position %v out of range [%v, %v]", p, 0, d.frames)
+	}
+	if _, err := d.seeker.Seek(d.start+int64(p*d.format.Width()), io.SeekStart); err != nil {
+		return pkgerrors.Wrap(err, "pcm: seek error")
+	}
+	d.pos = p
+	d.eof = false
+	return nil
+}
+
+func (d *decoder) Close() error {
+	if closer, ok := d.r.(io.Closer); ok {
+		err := closer.Close()
+		if err != nil {
+			return pkgerrors.Wrap(err, "pcm")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,2 @@
+// Package pcm implements audio data decoding and encoding of headerless (raw) PCM data.
+package pcm
@@ -0,0 +1,62 @@
+package pcm
+
+import (
+	"bufio"
+	"io"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/rickcollette/megasound"
+)
+
+// Encode writes all audio streamed from s to w as headerless PCM data with the given format and
+// sample encoding.
+//
+// The Format.SampleRate is not stored anywhere, it is only used to validate the format. Integer
+// samples are 1 to 4 bytes long and clipped to [-1, 1], floating point samples are 4 or 8 bytes
+// long and not clipped. Mono data is the average of both channels, channels after the second
+// are silent.
+func Encode(w io.Writer, s megasound.Streamer, format megasound.Format, encoding Encoding) (err error) {
+	defer func() {
+		if err != nil {
+			err = pkgerrors.Wrap(err, "pcm")
+		}
+	}()
+
+	if err := check(format, encoding); err != nil {
+		return err
+	}
+	if format.SampleRate <= 0 {
+		return pkgerrors.New("invalid sample rate (less than 1)")
+	}
+	var (
+		bw      = bufio.NewWriter(w)
+		samples = make([][2]float64, 512)
+		buffer  = make([]byte, len(samples)*format.Width())
+	)
+	for {
+		n, ok := s.Stream(samples)
+		if !ok {
+			break
+		}
+		buf := buffer
+		for _, sample := range samples[:n] {
+			switch encoding {
+			case SignedLE, SignedBE:
+				buf = buf[format.EncodeSigned(buf, sample):]
+			case UnsignedLE, UnsignedBE:
+				buf = buf[format.EncodeUnsigned(buf, sample):]
+			default:
+				encodeFloat(format, buf, sample)
+				buf = buf[format.Width():]
+			}
+		}
+		p := buffer[:n*format.Width()]
+		if encoding.bigEndian() {
+			swap(p, format.Precision)
+		}
+		if _, err := bw.Write(p); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,116 @@
+package pcm
+
+import (
+	"fmt"
+	"math"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/rickcollette/megasound"
+)
+
+// Encoding is the encoding of the samples of raw PCM data. The number of bytes of a sample is
+// the Precision of the Format the data is decoded or encoded with. Samples of all channels are
+// interleaved.
+type Encoding int
+
+// Sample encodings.
+const (
+	SignedLE   Encoding = iota // signed integers, little-endian
+	SignedBE                   // signed integers, big-endian
+	UnsignedLE                 // unsigned integers, little-endian
+	UnsignedBE                 // unsigned integers, big-endian
+	FloatLE                    // IEEE 754 floating point numbers, little-endian
+	FloatBE                    // IEEE 754 floating point numbers, big-endian
+)
+
+var encodingNames = [...]string{"SignedLE", "SignedBE", "UnsignedLE", "UnsignedBE", "FloatLE", "FloatBE"}
+
+func (e Encoding) String() string {
+	if e < 0 || int(e) >= len(encodingNames) {
+		return fmt.Sprintf("Encoding(%d)", int(e))
+	}
+	return encodingNames[e]
+}
+
+func (e Encoding) bigEndian() bool {
+	return e == SignedBE || e == UnsignedBE || e == FloatBE
+}
+
+func (e Encoding) float() bool {
+	return e == FloatLE || e == FloatBE
+}
+
+// check returns an error if the samples of format can't be stored in encoding e.
+func check(format megasound.Format, e Encoding) error {
+	switch {
+	case format.NumChannels <= 0:
+		return pkgerrors.New("invalid number of channels (less than 1)")
+	case e < SignedLE || e > FloatBE:
+		return fmt.Errorf("unknown encoding %v", e)
+	case e.float() && format.Precision != 4 && format.Precision != 8:
+		return pkgerrors.New("unsupported precision for floating point samples, 4 or 8 is supported")
+	case !e.float() && (format.Precision < 1 || format.Precision > 4):
+		return pkgerrors.New("unsupported precision, 1, 2, 3 or 4 is supported")
+	}
+	return nil
+}
+
+// swap reverses the byte order of each sample in p.
+func swap(p []byte, precision int) {
+	for i := 0; i+precision <= len(p); i += precision {
+		for a, b := i, i+precision-1; a < b; a, b = a+1, b-1 {
+			p[a], p[b] = p[b], p[a]
+		}
+	}
+}
+
+// decodeFloat decodes a frame of little-endian floating point samples. Like
+// Format.DecodeSigned, it streams mono as both channels and drops channels after the second.
+func decodeFloat(format megasound.Format, p []byte) (sample [2]float64) {
+	read := func(p []byte) float64 {
+		if format.Precision == 4 {
+			return float64(math.Float32frombits(uint32(p[0]) | uint32(p[1])<<8 | uint32(p[2])<<16 | uint32(p[3])<<24))
+		}
+		var x uint64
+		for i := 7; i >= 0; i-- {
+			x = x<<8 | uint64(p[i])
+		}
+		return math.Float64frombits(x)
+	}
+	sample[0] = read(p)
+	if format.NumChannels == 1 {
+		sample[1] = sample[0]
+	} else {
+		sample[1] = read(p[format.Precision:])
+	}
+	return sample
+}
+
+// encodeFloat encodes a frame of little-endian floating point samples to p. Like
+// Format.EncodeSigned, it mixes both channels for mono and writes silence to channels after
+// the second. Samples are not clipped.
+func encodeFloat(format megasound.Format, p []byte, sample [2]float64) {
+	write := func(p []byte, x float64) {
+		var bits uint64
+		if format.Precision == 4 {
+			bits = uint64(math.Float32bits(float32(x)))
+		} else {
+			bits = math.Float64bits(x)
+		}
+		for i := 0; i < format.Precision; i++ {
+			p[i] = byte(bits)
+			bits >>= 8
+		}
+	}
+	if format.NumChannels == 1 {
+		write(p, (sample[0]+sample[1])/2)
+		return
+	}
+	for c := 0; c < format.NumChannels; c++ {
+		var x float64
+		if c < len(sample) {
+			x = sample[c]
+		}
+		write(p[c*format.Precision:], x)
+	}
+}
@@ -0,0 +1,189 @@
+package pcm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/rickcollette/megasound"
+)
+
+// testSignal returns a Streamer of n samples of a stereo test signal.
+func testSignal(n int) megasound.Streamer {
+	i := 0
+	return megasound.Take(n, megasound.StreamerFunc(func(samples [][2]float64) (int, bool) {
+		for j := range samples {
+			samples[j] = [2]float64{math.Sin(float64(i) / 7), 0.5 * math.Cos(float64(i)/3)}
+			i++
+		}
+		return len(samples), true
+	}))
+}
+
+// readOnly hides the io.Seeker of a reader.
+type readOnly struct {
+	io.Reader
+}
+
+func TestEncodeDecode(t *testing.T) {
+	const n = 1000
+	want := make([][2]float64, n)
+	testSignal(n).Stream(want)
+	for e := SignedLE; e <= FloatBE; e++ {
+		precisions := []int{1, 2, 3, 4}
+		if e.float() {
+			precisions = []int{4, 8}
+		}
+		for _, precision := range precisions {
+			for _, channels := range []int{1, 2, 3} {
+				format := megasound.Format{SampleRate: 44100, NumChannels: channels, Precision: precision}
+				var b bytes.Buffer
+				if err := Encode(&b, testSignal(n), format, e); err != nil {
+					t.Fatal(err)
+				}
+				if b.Len() != n*format.Width() {
+					t.Fatalf("%v, %d bytes, %d channels: encoded %d bytes, want %d", e, precision, channels, b.Len(), n*format.Width())
+				}
+				tol := 1 / (math.Exp2(float64(precision*8-1)) - 1)
+				if e.float() {
+					tol = 1e-7
+				}
+				s, err := Decode(readOnly{bytes.NewReader(b.Bytes())}, format, e)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got [][2]float64
+				buf := make([][2]float64, 333)
+				for {
+					k, ok := s.Stream(buf)
+					got = append(got, buf[:k]...)
+					if !ok {
+						break
+					}
+				}
+				if len(got) != n {
+					t.Fatalf("%v, %d bytes, %d channels: decoded %d samples, want %d", e, precision, channels, len(got), n)
+				}
+				for i, x := range got {
+					w := want[i]
+					if channels == 1 {
+						m := (w[0] + w[1]) / 2
+						w = [2]float64{m, m}
+					}
+					if math.Abs(x[0]-w[0]) > tol || math.Abs(x[1]-w[1]) > tol {
+						t.Fatalf("%v, %d bytes, %d channels: sample %d is %v, want %v", e, precision, channels, i, x, w)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestByteOrder(t *testing.T) {
+	for _, tc := range []struct {
+		e         Encoding
+		precision int
+		data      any
+		want      [2]float64
+	}{
+		{SignedLE, 2, []int16{-32767, 32767}, [2]float64{-1, 1}},
+		{SignedBE, 2, []int16{-32767, 32767}, [2]float64{-1, 1}},
+		{SignedLE, 4, []int32{0, -math.MaxInt32}, [2]float64{0, -1}},
+		{UnsignedLE, 2, []uint16{0, 65535}, [2]float64{-1, 1}},
+		{UnsignedBE, 2, []uint16{0, 65535}, [2]float64{-1, 1}},
+		{FloatLE, 4, []float32{0.25, -0.75}, [2]float64{0.25, -0.75}},
+		{FloatBE, 8, []float64{0.25, -0.75}, [2]float64{0.25, -0.75}},
+	} {
+		var order binary.ByteOrder = binary.LittleEndian
+		if tc.e.bigEndian() {
+			order = binary.BigEndian
+		}
+		var b bytes.Buffer
+		binary.Write(&b, order, tc.data)
+		s, err := Decode(&b, megasound.Format{SampleRate: 44100, NumChannels: 2, Precision: tc.precision}, tc.e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([][2]float64, 2)
+		if n, _ := s.Stream(buf); n != 1 || math.Abs(buf[0][0]-tc.want[0]) > 1e-4 || math.Abs(buf[0][1]-tc.want[1]) > 1e-4 {
+			t.Errorf("%v: decoded %d samples %v, want %v", tc.e, n, buf[:n], tc.want)
+		}
+	}
+}
+
+func TestSeek(t *testing.T) {
+	const n = 1000
+	format := megasound.Format{SampleRate: 44100, NumChannels: 2, Precision: 2}
+	var b bytes.Buffer
+	b.WriteString("header")
+	if err := Encode(&b, testSignal(n), format, SignedBE); err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(b.Bytes())
+	// the data starts at the current position of the reader
+	if _, err := r.Seek(int64(len("header")), io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Decode(r, format, SignedBE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != n {
+		t.Fatalf("Len() = %d, want %d", s.Len(), n)
+	}
+	all := make([][2]float64, n)
+	if k, _ := s.Stream(all); k != n {
+		t.Fatalf("streamed %d samples, want %d", k, n)
+	}
+	buf := make([][2]float64, 10)
+	for _, p := range []int{500, 0, 999, 1} {
+		if err := s.Seek(p); err != nil {
+			t.Fatal(err)
+		}
+		k, _ := s.Stream(buf)
+		if want := n - p; k != len(buf) && k != want {
+			t.Fatalf("Seek(%d): streamed %d samples", p, k)
+		}
+		for i, x := range buf[:k] {
+			if x != all[p+i] {
+				t.Fatalf("Seek(%d): sample %d is %v, want %v", p, p+i, x, all[p+i])
+			}
+		}
+		if s.Position() != p+k {
+			t.Errorf("Seek(%d): Position() = %d after streaming %d samples", p, s.Position(), k)
+		}
+	}
+	if err := s.Seek(n + 1); err == nil {
+		t.Error("Seek past the end succeeded")
+	}
+
+	s, err = Decode(readOnly{bytes.NewReader(b.Bytes())}, format, SignedBE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 0 || s.Seek(0) == nil {
+		t.Error("stream of a reader without io.Seeker has a length or is seekable")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for _, tc := range []struct {
+		e         Encoding
+		precision int
+	}{
+		{FloatLE, 3},
+		{SignedLE, 8},
+		{UnsignedBE, 0},
+		{Encoding(6), 2},
+	} {
+		format := megasound.Format{SampleRate: 44100, NumChannels: 2, Precision: tc.precision}
+		if _, err := Decode(bytes.NewReader(nil), format, tc.e); err == nil {
+			t.Errorf("decoding %v with precision %d succeeded", tc.e, tc.precision)
+		}
+		if err := Encode(io.Discard, testSignal(1), format, tc.e); err == nil {
+			t.Errorf("encoding %v with precision %d succeeded", tc.e, tc.precision)
+		}
+	}
+}
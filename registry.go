@@ -0,0 +1,164 @@
+package megasound
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrFormat indicates that decoding encountered an unknown format.
+var ErrFormat = errors.New("megasound: unknown format")
+
+// SniffLen is the number of bytes from the beginning of the data passed to the match functions
+// of registered formats. Less bytes are passed if the data is shorter. If the data starts with
+// an ID3v2 tag, the tag and the SniffLen bytes following it are passed.
+const SniffLen = 4096
+
+// maxSniffID3 is the size of the largest ID3v2 tag sniffed along with the data following it.
+const maxSniffID3 = 16 << 20
+
+// DecodeFunc decodes audio data of a registered format, see RegisterFormat.
+type DecodeFunc func(rc io.ReadCloser) (StreamSeekCloser, Format, error)
+
+type registeredFormat struct {
+	name   string
+	match  func(head []byte) bool
+	decode DecodeFunc
+}
+
+var (
+	formatsMu sync.Mutex
+	formats   []registeredFormat
+)
+
+// RegisterFormat registers an audio format for use by Decode. Name is the name of the format,
+// like "wav" or "mp3". Match reports whether data starting with head is in the format, head
+// holds the first SniffLen bytes of the data, after an ID3v2 tag if there is one (see
+// SniffLen). Decode decodes the data.
+//
+// Formats are tried in the order they were registered. Codec packages register their format in
+// their init function, so it's enough to import them, like this:
+//
+//	import _ "github.com/rickcollette/megasound/wav"
+func RegisterFormat(name string, match func(head []byte) bool, decode DecodeFunc) {
+	formatsMu.Lock()
+	formats = append(formats, registeredFormat{name, match, decode})
+	formatsMu.Unlock()
+}
+
+// Decode detects the format of the audio data in r from its content and decodes it with the
+// decoder of the registered format. It returns the StreamSeekCloser streaming the audio, its
+// Format and the name of the format. ErrFormat is returned if no registered format matches.
+//
+// The data starts at the current offset of r. If r is io.Seeker, the returned StreamSeekCloser
+// is seekable, and the decoder sees offset 0 at the start of the data. If r is io.Closer, it is
+// closed by the Close method of the returned StreamSeekCloser.
+func Decode(r io.Reader) (s StreamSeekCloser, format Format, name string, err error) {
+	head, rc, err := sniff(r)
+	if err != nil {
+		return nil, Format{}, "", err
+	}
+	formatsMu.Lock()
+	fs := formats
+	formatsMu.Unlock()
+	for _, f := range fs {
+		if f.match(head) {
+			s, format, err := f.decode(rc)
+			return s, format, f.name, err
+		}
+	}
+	return nil, Format{}, "", ErrFormat
+}
+
+// sniff returns the head of r passed to the match functions, see SniffLen, and a ReadCloser
+// reading all of r. The ReadCloser is io.Seeker if r is, with offset 0 at the current offset
+// of r.
+func sniff(r io.Reader) (head []byte, rc io.ReadCloser, err error) {
+	closer, ok := r.(io.Closer)
+	if !ok {
+		closer = nopCloser{}
+	}
+	if rs, ok := r.(io.ReadSeeker); ok {
+		start, err := rs.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, nil, err
+		}
+		if head, err = readHead(rs); err != nil {
+			return nil, nil, err
+		}
+		if _, err := rs.Seek(start, io.SeekStart); err != nil {
+			return nil, nil, err
+		}
+		return head, readSeekCloser{&offsetReadSeeker{rs, start}, closer}, nil
+	}
+	if head, err = readHead(r); err != nil {
+		return nil, nil, err
+	}
+	return head, readCloser{io.MultiReader(bytes.NewReader(head), r), closer}, nil
+}
+
+// readHead reads the head of r, see SniffLen.
+func readHead(r io.Reader) ([]byte, error) {
+	head := make([]byte, SniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+	if size, ok := id3v2Size(head); ok && size <= maxSniffID3 && n == SniffLen {
+		head = append(head, make([]byte, size)...)
+		m, err := io.ReadFull(r, head[n:])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		head = head[:n+m]
+	}
+	return head, nil
+}
+
+// id3v2Size returns the size of the ID3v2 tag at the start of p, including its header and
+// footer. It reports false if p doesn't start with an ID3v2 header.
+func id3v2Size(p []byte) (int, bool) {
+	if len(p) < 10 || string(p[:3]) != "ID3" || p[3] == 0xff || p[4] == 0xff {
+		return 0, false
+	}
+	size := 10 + (int(p[6]&0x7f)<<21 | int(p[7]&0x7f)<<14 | int(p[8]&0x7f)<<7 | int(p[9]&0x7f))
+	if p[5]&0x10 != 0 { // footer present
+		size += 10
+	}
+	return size, true
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type readSeekCloser struct {
+	io.ReadSeeker
+	io.Closer
+}
+
+// offsetReadSeeker is an io.ReadSeeker whose offset 0 is at the offset start of the wrapped
+// io.ReadSeeker, like io.SectionReader without an end.
+type offsetReadSeeker struct {
+	rs    io.ReadSeeker
+	start int64
+}
+
+func (o *offsetReadSeeker) Read(p []byte) (int, error) {
+	return o.rs.Read(p)
+}
+
+func (o *offsetReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekStart {
+		offset += o.start
+	}
+	pos, err := o.rs.Seek(offset, whence)
+	return pos - o.start, err
+}
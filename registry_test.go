@@ -0,0 +1,93 @@
+package megasound_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/rickcollette/megasound"
+)
+
+type testDecoder struct {
+	megasound.StreamSeeker
+	data     []byte
+	seekable bool
+}
+
+func (d *testDecoder) Close() error { return nil }
+
+func init() {
+	megasound.RegisterFormat("test", func(head []byte) bool {
+		if len(head) >= 10 && string(head[:3]) == "ID3" {
+			head = head[10+(int(head[8])<<7|int(head[9])):]
+		}
+		return bytes.HasPrefix(head, []byte("TEST"))
+	}, func(rc io.ReadCloser) (megasound.StreamSeekCloser, megasound.Format, error) {
+		_, seekable := rc.(io.Seeker)
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			return nil, megasound.Format{}, err
+		}
+		s, _ := randomDataStreamer(len(data))
+		return &testDecoder{s, data, seekable}, megasound.Format{SampleRate: 44100, NumChannels: 1, Precision: 1}, nil
+	})
+}
+
+func TestDecode(t *testing.T) {
+	data := append([]byte("TEST"), make([]byte, 2*megasound.SniffLen)...)
+	for _, tc := range []struct {
+		r        io.Reader
+		seekable bool
+	}{
+		{bytes.NewReader(data), true},
+		{bytes.NewBuffer(data), false},
+	} {
+		s, format, name, err := megasound.Decode(tc.r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := s.(*testDecoder)
+		if name != "test" || format.SampleRate != 44100 {
+			t.Errorf("Decode returned format %v named %q", format, name)
+		}
+		if !bytes.Equal(d.data, data) || d.seekable != tc.seekable {
+			t.Errorf("Decode did not pass all of the data (seekable %v)", tc.seekable)
+		}
+	}
+
+	if _, _, _, err := megasound.Decode(bytes.NewReader([]byte("none"))); err != megasound.ErrFormat {
+		t.Errorf("Decode of an unknown format returned %v, want ErrFormat", err)
+	}
+}
+
+func TestDecodeOffset(t *testing.T) {
+	data := append([]byte("TEST"), make([]byte, 100)...)
+	r := bytes.NewReader(append([]byte("junk"), data...))
+	r.Seek(4, io.SeekStart)
+	s, _, _, err := megasound.Decode(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := s.(*testDecoder)
+	if !bytes.Equal(d.data, data) {
+		t.Fatal("Decode did not pass the data from the offset of the reader")
+	}
+}
+
+func TestDecodeID3v2(t *testing.T) {
+	// an ID3v2 tag longer than SniffLen, followed by the data
+	tag := make([]byte, 10+2*megasound.SniffLen)
+	copy(tag, "ID3\x03\x00\x00")
+	size := len(tag) - 10
+	tag[8], tag[9] = byte(size>>7), byte(size&0x7f)
+	data := append(tag, "TEST"...)
+	for _, r := range []io.Reader{bytes.NewReader(data), bytes.NewBuffer(data)} {
+		s, _, name, err := megasound.Decode(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := s.(*testDecoder); name != "test" || !bytes.Equal(d.data, data) {
+			t.Errorf("Decode returned the format %q", name)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+// Package sf2 implements loading of SoundFont 2 banks and a sampler synthesizer playing them.
+//
+// A Synth plays notes with the presets of a SoundFont: their zones map keys and velocities to
+// samples, which are played with their loops, volume and modulation envelopes, LFOs, low-pass
+// filter and modulators. A Synth is a midi.Instrument, so MIDI files can be rendered with a
+// midi.Sequencer. The reverb and chorus sends of SoundFonts are ignored.
+package sf2
@@ -0,0 +1,212 @@
+package sf2
+
+import "math"
+
+// Generators, by their index in SoundFont files. Generators not used by the synth are left
+// out.
+const (
+	genStartOffset       = 0
+	genEndOffset         = 1
+	genLoopStartOffset   = 2
+	genLoopEndOffset     = 3
+	genStartCoarseOffset = 4
+	genModLFOToPitch     = 5
+	genVibLFOToPitch     = 6
+	genModEnvToPitch     = 7
+	genFilterFc          = 8
+	genFilterQ           = 9
+	genModLFOToFilterFc  = 10
+	genModEnvToFilterFc  = 11
+	genEndCoarseOffset   = 12
+	genModLFOToVolume    = 13
+	genPan               = 17
+	genModLFODelay       = 21
+	genModLFOFreq        = 22
+	genVibLFODelay       = 23
+	genVibLFOFreq        = 24
+	genModEnvDelay       = 25
+	genModEnvAttack      = 26
+	genModEnvHold        = 27
+	genModEnvDecay       = 28
+	genModEnvSustain     = 29
+	genModEnvRelease     = 30
+	genKeyToModEnvHold   = 31
+	genKeyToModEnvDecay  = 32
+	genVolEnvDelay       = 33
+	genVolEnvAttack      = 34
+	genVolEnvHold        = 35
+	genVolEnvDecay       = 36
+	genVolEnvSustain     = 37
+	genVolEnvRelease     = 38
+	genKeyToVolEnvHold   = 39
+	genKeyToVolEnvDecay  = 40
+	genInstrument        = 41
+	genKeyRange          = 43
+	genVelRange          = 44
+	genLoopStartCoarse   = 45
+	genKeynum            = 46
+	genVelocity          = 47
+	genAttenuation       = 48
+	genLoopEndCoarse     = 50
+	genCoarseTune        = 51
+	genFineTune          = 52
+	genSampleID          = 53
+	genSampleModes       = 54
+	genScaleTuning       = 56
+	genExclusiveClass    = 57
+	genOverridingRootKey = 58
+	genPitch             = 59 // not in files: the destination of the pitch wheel modulator
+	genCount             = 60
+)
+
+// defaultGenerators holds the default values of the generators of instrument zones.
+var defaultGenerators = func() (g [genCount]int) {
+	g[genFilterFc] = 13500
+	for _, i := range []int{
+		genModLFODelay, genVibLFODelay,
+		genModEnvDelay, genModEnvAttack, genModEnvHold, genModEnvDecay, genModEnvRelease,
+		genVolEnvDelay, genVolEnvAttack, genVolEnvHold, genVolEnvDecay, genVolEnvRelease,
+	} {
+		g[i] = -12000
+	}
+	g[genScaleTuning] = 100
+	g[genKeynum], g[genVelocity], g[genOverridingRootKey] = -1, -1, -1
+	return g
+}()
+
+// additive reports whether the preset level value of generator i is added to the instrument
+// level value. The others are only valid in instrument zones.
+func additive(i int) bool {
+	switch i {
+	case genStartOffset, genEndOffset, genLoopStartOffset, genLoopEndOffset,
+		genStartCoarseOffset, genEndCoarseOffset, genLoopStartCoarse, genLoopEndCoarse,
+		genInstrument, genKeyRange, genVelRange, genKeynum, genVelocity, genSampleID,
+		genSampleModes, genExclusiveClass, genOverridingRootKey:
+		return false
+	}
+	return true
+}
+
+// modulator is a modulator: its contribution to the generator dest is amount times the
+// mapped values of the sources src and amtSrc.
+type modulator struct {
+	src, dest, amtSrc, trans uint16
+	amount                   float64
+}
+
+// Modulator sources.
+const (
+	srcNone         = 0
+	srcVelocity     = 2
+	srcKey          = 3
+	srcPolyPressure = 10
+	srcChanPressure = 13
+	srcPitchWheel   = 14
+	srcBendRange    = 16
+	srcCC           = 0x80 // flag of MIDI controller sources
+	srcNegative     = 0x100
+	srcBipolar      = 0x200
+	srcConcave      = 1 << 10
+)
+
+// defaultModulators are the default modulators of SoundFont 2.01.
+var defaultModulators = []modulator{
+	{src: srcConcave | srcNegative | srcVelocity, dest: genAttenuation, amount: 960},
+	{src: srcNegative | srcVelocity, dest: genFilterFc, amount: -2400},
+	{src: srcChanPressure, dest: genVibLFOToPitch, amount: 50},
+	{src: srcCC | 1, dest: genVibLFOToPitch, amount: 50},
+	{src: srcConcave | srcNegative | srcCC | 7, dest: genAttenuation, amount: 960},
+	{src: srcBipolar | srcCC | 10, dest: genPan, amount: 1000},
+	{src: srcConcave | srcNegative | srcCC | 11, dest: genAttenuation, amount: 960},
+	{src: srcCC | 91, dest: 16, amount: 200},
+	{src: srcCC | 93, dest: 15, amount: 200},
+	{src: srcBipolar | srcPitchWheel, dest: genPitch, amount: 12700, amtSrc: srcBendRange},
+}
+
+// mergeModulator adds m to mods, replacing an identical modulator.
+func mergeModulator(mods []modulator, m modulator) []modulator {
+	for i, o := range mods {
+		if o.src == m.src && o.dest == m.dest && o.amtSrc == m.amtSrc && o.trans == m.trans {
+			mods[i] = m
+			return mods
+		}
+	}
+	return append(mods, m)
+}
+
+// value returns the contribution of m with the controllers of ch for a note of key and
+// velocity vel.
+func (m *modulator) value(ch *channel, key, vel int) float64 {
+	if m.src == srcNone || m.dest >= genCount {
+		return 0 // linked modulators are not supported
+	}
+	v := m.amount * source(m.src, ch, key, vel)
+	if m.amtSrc != srcNone {
+		v *= source(m.amtSrc, ch, key, vel)
+	}
+	if m.trans == 2 {
+		v = math.Abs(v)
+	}
+	return v
+}
+
+// source returns the mapped value of the modulator source src.
+func source(src uint16, ch *channel, key, vel int) float64 {
+	var x float64
+	if i := int(src & 0x7f); src&srcCC != 0 {
+		x = float64(ch.cc[i]) / 127
+	} else {
+		switch i {
+		case srcVelocity:
+			x = float64(vel) / 128
+		case srcKey:
+			x = float64(key) / 128
+		case srcPolyPressure:
+			x = float64(ch.keyPressure[key]) / 128
+		case srcChanPressure:
+			x = float64(ch.pressure) / 128
+		case srcPitchWheel:
+			x = float64(ch.bend+8192) / 16384
+		case srcBendRange:
+			x = ch.bendRange / 12700
+		default:
+			return 0
+		}
+	}
+	if src&srcNegative != 0 {
+		x = 1 - x
+	}
+	shape := int(src >> 10)
+	if src&srcBipolar == 0 {
+		return curve(shape, x)
+	}
+	if x >= 0.5 {
+		return curve(shape, 2*x-1)
+	}
+	return -curve(shape, 1-2*x)
+}
+
+// curve applies a modulator source curve to x, from 0 to 1.
+func curve(shape int, x float64) float64 {
+	switch shape {
+	case 1: // concave
+		return concave(x)
+	case 2: // convex
+		return 1 - concave(1-x)
+	case 3: // switch
+		if x >= 0.5 {
+			return 1
+		}
+		return 0
+	}
+	return x
+}
+
+// concave is the concave curve of SoundFont modulators, following the attenuation of a
+// key struck with velocity x.
+func concave(x float64) float64 {
+	if x >= 1 {
+		return 1
+	}
+	return math.Max(0, -20.0/96*math.Log10((1-x)*(1-x)))
+}
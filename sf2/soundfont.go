@@ -0,0 +1,343 @@
+package sf2
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+	"strings"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+// SoundFont is a SoundFont 2 bank loaded by Load. Its presets are played by a Synth.
+type SoundFont struct {
+	// Name is the name of the bank.
+	Name string
+
+	// Presets lists the presets of the bank, sorted by bank and program.
+	Presets []Preset
+
+	presets map[int]*preset // by bank<<7 | program
+	data    []byte          // 16-bit little-endian sample data
+	low     []byte          // optional low bytes of 24-bit sample data
+}
+
+// Preset identifies a preset of a SoundFont. Presets are selected by bank and program, like
+// with MIDI bank select and program change messages. Bank 128 holds percussion kits.
+type Preset struct {
+	Name    string
+	Bank    int
+	Program int
+}
+
+// PercussionBank is the bank of percussion kits.
+const PercussionBank = 128
+
+type preset struct {
+	zones []*zone
+}
+
+type instrument struct {
+	zones []*zone
+}
+
+// zone is a zone of a preset or an instrument. The generators and modulators of the global
+// zone are merged into the other zones when loading.
+type zone struct {
+	keyLo, keyHi int
+	velLo, velHi int
+	gens         [genCount]int
+	set          [genCount]bool
+	mods         []modulator
+	inst         *instrument // preset zones
+	smp          *sample     // instrument zones
+}
+
+// matches reports whether z plays the key at velocity vel.
+func (z *zone) matches(key, vel int) bool {
+	return key >= z.keyLo && key <= z.keyHi && vel >= z.velLo && vel <= z.velHi
+}
+
+// sample is a sample of the sample data.
+type sample struct {
+	start, end         int // frames in the sample data, end exclusive
+	loopStart, loopEnd int
+	rate               int
+	key                int // original pitch
+	correction         int // pitch correction in cents
+}
+
+// maxFileSize is the size limit of SoundFont files.
+const maxFileSize = 1 << 30
+
+// Load loads a SoundFont 2 file. 24-bit samples are supported; compressed samples (SF3) and
+// samples in ROM are not.
+func Load(r io.Reader) (*SoundFont, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxFileSize+1))
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "sf2")
+	}
+	if len(data) > maxFileSize {
+		return nil, pkgerrors.New("sf2: file too large")
+	}
+	sf, err := parse(data)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "sf2")
+	}
+	return sf, nil
+}
+
+// chunks returns the sub-chunks of a RIFF chunk by id. Lists are returned by list type.
+func chunks(p []byte) map[string][]byte {
+	m := make(map[string][]byte)
+	for len(p) >= 8 {
+		id := string(p[:4])
+		size := int(binary.LittleEndian.Uint32(p[4:8]))
+		p = p[8:]
+		if size > len(p) {
+			size = len(p)
+		}
+		c := p[:size]
+		if id == "LIST" && len(c) >= 4 {
+			id, c = string(c[:4]), c[4:]
+		}
+		if _, ok := m[id]; !ok {
+			m[id] = c
+		}
+		if size+size&1 > len(p) {
+			break
+		}
+		p = p[size+size&1:]
+	}
+	return m
+}
+
+func parse(data []byte) (*SoundFont, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "sfbk" {
+		return nil, pkgerrors.New("not a SoundFont file")
+	}
+	top := chunks(data[12:])
+	info, sdta, pdta := chunks(top["INFO"]), chunks(top["sdta"]), chunks(top["pdta"])
+	if v := info["ifil"]; len(v) >= 2 && binary.LittleEndian.Uint16(v) >= 3 {
+		return nil, pkgerrors.New("compressed SoundFonts (SF3) are not supported")
+	}
+
+	sf := &SoundFont{
+		Name:    strings.TrimRight(string(info["INAM"]), "\x00"),
+		presets: make(map[int]*preset),
+		data:    sdta["smpl"],
+	}
+	sf.data = sf.data[:len(sf.data)&^1]
+	frames := len(sf.data) / 2
+	if low := sdta["sm24"]; len(low) >= frames {
+		sf.low = low[:frames]
+	}
+
+	samples, err := parseSamples(pdta["shdr"], frames)
+	if err != nil {
+		return nil, err
+	}
+	insts, err := parseHeaders(pdta["inst"], 22, pdta["ibag"], pdta["igen"], pdta["imod"], "instrument")
+	if err != nil {
+		return nil, err
+	}
+	instruments := make([]*instrument, len(insts))
+	for i, h := range insts {
+		zones := buildZones(h.zones, genSampleID, true)
+		ins := &instrument{}
+		for _, z := range zones {
+			if id := z.gens[genSampleID]; id < len(samples) && samples[id] != nil {
+				z.smp = samples[id]
+				ins.zones = append(ins.zones, z)
+			}
+		}
+		instruments[i] = ins
+	}
+
+	presets, err := parseHeaders(pdta["phdr"], 38, pdta["pbag"], pdta["pgen"], pdta["pmod"], "preset")
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range presets {
+		p := &preset{}
+		for _, z := range buildZones(h.zones, genInstrument, false) {
+			if id := z.gens[genInstrument]; id < len(instruments) {
+				z.inst = instruments[id]
+				p.zones = append(p.zones, z)
+			}
+		}
+		program := int(binary.LittleEndian.Uint16(h.record[20:22]))
+		bank := int(binary.LittleEndian.Uint16(h.record[22:24]))
+		if program > 127 || bank > PercussionBank {
+			continue
+		}
+		key := bank<<7 | program
+		if _, ok := sf.presets[key]; ok {
+			continue
+		}
+		sf.presets[key] = p
+		sf.Presets = append(sf.Presets, Preset{Name: h.name, Bank: bank, Program: program})
+	}
+	if len(sf.Presets) == 0 {
+		return nil, pkgerrors.New("no presets")
+	}
+	sort.Slice(sf.Presets, func(i, j int) bool {
+		a, b := sf.Presets[i], sf.Presets[j]
+		return a.Bank < b.Bank || a.Bank == b.Bank && a.Program < b.Program
+	})
+	return sf, nil
+}
+
+// parseSamples parses the sample headers. Samples that cannot be played are nil.
+func parseSamples(p []byte, frames int) ([]*sample, error) {
+	if len(p)%46 != 0 || len(p) < 46 {
+		return nil, pkgerrors.New("invalid sample headers")
+	}
+	n := len(p)/46 - 1 // the last record is a terminator
+	samples := make([]*sample, n)
+	for i := range samples {
+		r := p[i*46 : (i+1)*46]
+		s := &sample{
+			start:      int(binary.LittleEndian.Uint32(r[20:24])),
+			end:        int(binary.LittleEndian.Uint32(r[24:28])),
+			loopStart:  int(binary.LittleEndian.Uint32(r[28:32])),
+			loopEnd:    int(binary.LittleEndian.Uint32(r[32:36])),
+			rate:       int(binary.LittleEndian.Uint32(r[36:40])),
+			key:        int(r[40]),
+			correction: int(int8(r[41])),
+		}
+		typ := binary.LittleEndian.Uint16(r[44:46])
+		if typ&0x8000 != 0 || typ&0x10 != 0 || s.start >= s.end || s.end > frames || s.rate <= 0 {
+			continue
+		}
+		if s.key > 127 {
+			s.key = 60
+		}
+		samples[i] = s
+	}
+	return samples, nil
+}
+
+// header is a preset or instrument header with the generators and modulators of its zones.
+type header struct {
+	name   string
+	record []byte
+	zones  []rawZone
+}
+
+type rawZone struct {
+	gens []byte // 4-byte records
+	mods []byte // 10-byte records
+}
+
+// parseHeaders parses the preset (38 bytes) or instrument (22 bytes) headers in hdrs and the
+// bags, generators and modulators of their zones.
+func parseHeaders(hdrs []byte, size int, bags, gens, mods []byte, what string) ([]header, error) {
+	if len(hdrs)%size != 0 || len(hdrs) < size || len(bags)%4 != 0 || len(bags) < 4 ||
+		len(gens)%4 != 0 || len(mods)%10 != 0 {
+		return nil, pkgerrors.Errorf("invalid %s chunks", what)
+	}
+	bagIndex := func(i int) int {
+		off := 20
+		if size == 38 {
+			off = 24
+		}
+		return int(binary.LittleEndian.Uint16(hdrs[i*size+off:]))
+	}
+	numBags := len(bags)/4 - 1
+	bag := func(i int) (gen, mod int) {
+		return int(binary.LittleEndian.Uint16(bags[i*4:])), int(binary.LittleEndian.Uint16(bags[i*4+2:]))
+	}
+	n := len(hdrs)/size - 1
+	headers := make([]header, n)
+	for i := range headers {
+		r := hdrs[i*size : (i+1)*size]
+		h := header{name: strings.TrimRight(string(r[:20]), "\x00 "), record: r}
+		first, last := bagIndex(i), bagIndex(i+1)
+		if first > last || last > numBags {
+			return nil, pkgerrors.Errorf("invalid %s bag index", what)
+		}
+		for b := first; b < last; b++ {
+			g0, m0 := bag(b)
+			g1, m1 := bag(b + 1)
+			if g0 > g1 || g1 > len(gens)/4 || m0 > m1 || m1 > len(mods)/10 {
+				return nil, pkgerrors.Errorf("invalid %s zone", what)
+			}
+			h.zones = append(h.zones, rawZone{gens: gens[g0*4 : g1*4], mods: mods[m0*10 : m1*10]})
+		}
+		headers[i] = h
+	}
+	return headers, nil
+}
+
+// buildZones builds the zones of a preset or instrument. Zones without the terminal
+// generator term are dropped, and the first one is the global zone, merged into the others.
+// The modulators of instrument zones are merged with the default modulators.
+func buildZones(raw []rawZone, term int, instrument bool) []*zone {
+	global := &zone{keyHi: 127, velHi: 127}
+	if instrument {
+		global.mods = append(global.mods, defaultModulators...)
+	}
+	var zones []*zone
+	for i, r := range raw {
+		z := &zone{keyLo: global.keyLo, keyHi: global.keyHi, velLo: global.velLo, velHi: global.velHi}
+		z.gens, z.set = global.gens, global.set
+		z.mods = append(z.mods, global.mods...)
+		terminal := false
+		for g := r.gens; len(g) >= 4; g = g[4:] {
+			op := int(binary.LittleEndian.Uint16(g))
+			amount := binary.LittleEndian.Uint16(g[2:])
+			switch {
+			case op == genKeyRange:
+				z.keyLo, z.keyHi = int(amount&0xff), int(amount>>8)
+			case op == genVelRange:
+				z.velLo, z.velHi = int(amount&0xff), int(amount>>8)
+			case op == term:
+				z.gens[op], z.set[op] = int(amount), true
+				terminal = true
+			case op < genCount && op != genInstrument && op != genSampleID:
+				z.gens[op], z.set[op] = int(int16(amount)), true
+			}
+			if terminal {
+				break // generators after the terminal one are ignored
+			}
+		}
+		for m := r.mods; len(m) >= 10; m = m[10:] {
+			z.mods = mergeModulator(z.mods, modulator{
+				src:    binary.LittleEndian.Uint16(m),
+				dest:   binary.LittleEndian.Uint16(m[2:]),
+				amount: float64(int16(binary.LittleEndian.Uint16(m[4:]))),
+				amtSrc: binary.LittleEndian.Uint16(m[6:]),
+				trans:  binary.LittleEndian.Uint16(m[8:]),
+			})
+		}
+		switch {
+		case terminal:
+			zones = append(zones, z)
+		case i == 0:
+			global = z
+		}
+	}
+	return zones
+}
+
+// lookup returns the preset of bank and program. Missing melodic presets fall back to bank
+// 0 and missing percussion kits to the standard kit, program 0.
+func (sf *SoundFont) lookup(bank, program int) *preset {
+	if p, ok := sf.presets[bank<<7|program]; ok {
+		return p
+	}
+	if bank == PercussionBank {
+		return sf.presets[PercussionBank<<7]
+	}
+	return sf.presets[program]
+}
+
+// frame returns the sample at index i of the sample data, from -1 to 1.
+func (sf *SoundFont) frame(i int) float64 {
+	v := int(int16(binary.LittleEndian.Uint16(sf.data[2*i:])))
+	if sf.low != nil {
+		return float64(v<<8|int(sf.low[i])) / (1 << 23)
+	}
+	return float64(v) / (1 << 15)
+}
@@ -0,0 +1,107 @@
+package sf2
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// genList returns the generator records of the pairs of generators and amounts in g.
+func genList(g ...int) []byte {
+	var p []byte
+	for i := 0; i+1 < len(g); i += 2 {
+		p = binary.LittleEndian.AppendUint16(p, uint16(g[i]))
+		p = binary.LittleEndian.AppendUint16(p, uint16(g[i+1]))
+	}
+	return p
+}
+
+// modRecord returns the record of m.
+func modRecord(m modulator) []byte {
+	var p []byte
+	for _, v := range []uint16{m.src, m.dest, uint16(int16(m.amount)), m.amtSrc, m.trans} {
+		p = binary.LittleEndian.AppendUint16(p, v)
+	}
+	return p
+}
+
+func TestBuildZones(t *testing.T) {
+	velocity := defaultModulators[0]
+	velocity.amount = 480
+	zones := buildZones([]rawZone{
+		// global zone
+		{gens: genList(genKeyRange, 36|96<<8, genAttenuation, 100, genPan, -200), mods: modRecord(velocity)},
+		{gens: genList(genKeyRange, 36|59<<8, genPan, 300, genSampleID, 0)},
+		{gens: genList(genVolEnvRelease, 1200, genSampleID, 1, genCoarseTune, 12)},
+		// zones without a sample are dropped, except the first one
+		{gens: genList(genPan, 100)},
+	}, genSampleID, true)
+	if len(zones) != 2 {
+		t.Fatalf("%d zones, want 2", len(zones))
+	}
+	low, high := zones[0], zones[1]
+	if low.keyLo != 36 || low.keyHi != 59 || high.keyLo != 36 || high.keyHi != 96 {
+		t.Errorf("key ranges %d-%d and %d-%d, want 36-59 and 36-96", low.keyLo, low.keyHi, high.keyLo, high.keyHi)
+	}
+	for _, tc := range []struct {
+		z         *zone
+		gen, want int
+	}{
+		{low, genPan, 300},
+		{low, genAttenuation, 100},
+		{high, genPan, -200},
+		{high, genAttenuation, 100},
+		{high, genVolEnvRelease, 1200},
+		{high, genSampleID, 1},
+	} {
+		if !tc.z.set[tc.gen] || tc.z.gens[tc.gen] != tc.want {
+			t.Errorf("generator %d is %d (set %v), want %d", tc.gen, tc.z.gens[tc.gen], tc.z.set[tc.gen], tc.want)
+		}
+	}
+	if high.set[genCoarseTune] {
+		t.Error("generator after the sample ID was not ignored")
+	}
+	for _, z := range zones {
+		if len(z.mods) != len(defaultModulators) {
+			t.Fatalf("%d modulators, want the %d default ones", len(z.mods), len(defaultModulators))
+		}
+		if z.mods[0].amount != 480 {
+			t.Errorf("global modulator did not replace the default one: amount %v", z.mods[0].amount)
+		}
+	}
+}
+
+func TestVoiceGenerators(t *testing.T) {
+	sf := &SoundFont{Presets: []Preset{{Name: "Test"}}, data: make([]byte, 2*1000)}
+	smp := &sample{start: 0, end: 1000, loopStart: 100, loopEnd: 900, rate: 44100, key: 60}
+	iz := &zone{keyHi: 127, velHi: 127, smp: smp}
+	iz.gens[genAttenuation], iz.set[genAttenuation] = 100, true
+	iz.gens[genSampleModes], iz.set[genSampleModes] = 1, true
+	iz.gens[genStartOffset], iz.set[genStartOffset] = 10, true
+	pz := &zone{keyHi: 127, velHi: 127}
+	pz.gens[genAttenuation], pz.set[genAttenuation] = 50, true
+	pz.gens[genVolEnvRelease], pz.set[genVolEnvRelease] = 1200, true
+	// not additive, only valid at the instrument level
+	pz.gens[genSampleModes], pz.set[genSampleModes] = 3, true
+	pz.gens[genStartOffset], pz.set[genStartOffset] = 20, true
+
+	v := newVoice(NewSynth(sf, 44100), 0, 60, 100, pz, iz)
+	if v == nil {
+		t.Fatal("no voice")
+	}
+	for _, tc := range []struct {
+		gen, want int
+	}{
+		{genAttenuation, 150},                         // added
+		{genVolEnvRelease, -12000 + 1200},             // added to the default
+		{genSampleModes, 1},                           // instrument only
+		{genStartOffset, 10},                          // instrument only
+		{genFilterFc, defaultGenerators[genFilterFc]}, // default
+	} {
+		if v.base[tc.gen] != tc.want {
+			t.Errorf("generator %d is %d, want %d", tc.gen, v.base[tc.gen], tc.want)
+		}
+	}
+	if v.start != 10 || v.mode != 1 {
+		t.Errorf("voice starts at %d with mode %d, want 10 and 1", v.start, v.mode)
+	}
+}
@@ -0,0 +1,309 @@
+package sf2
+
+import (
+	"github.com/rickcollette/megasound"
+	"github.com/rickcollette/megasound/midi"
+)
+
+// Synth plays the presets of a SoundFont on 16 MIDI channels. It implements midi.Instrument,
+// so it can play MIDI files with a midi.Sequencer, and notes can be played directly with
+// NoteOn and NoteOff, for example for the music of a game. The stream of a Synth never ends.
+//
+// Channel 10 (index 9) plays the percussion kits of bank 128. Each channel follows the volume
+// (7), pan (10), expression (11), sustain (64) and bank select (0) controllers, pitch bends
+// with the pitch bend range set with RPN 0, and channel and key pressure. The modulators of
+// the SoundFont apply to all controllers.
+type Synth struct {
+	sf       *SoundFont
+	sr       megasound.SampleRate
+	gain     float64
+	maxVoice int
+	channels [16]channel
+	voices   []*voice
+}
+
+// channel is the state of a MIDI channel.
+type channel struct {
+	bank, program int
+	cc            [128]int
+	keyPressure   [128]int
+	pressure      int
+	bend          int     // -8192 to 8191
+	bendRange     float64 // in cents
+	rpn           int     // selected registered parameter, -1 for none
+}
+
+// reset resets the controllers of ch to their default values.
+func (ch *channel) reset() {
+	ch.cc = [128]int{}
+	ch.cc[7], ch.cc[10], ch.cc[11] = 100, 64, 127
+	ch.keyPressure = [128]int{}
+	ch.pressure, ch.bend, ch.bendRange, ch.rpn = 0, 0, 200, -1
+}
+
+// DefaultPolyphony is the default maximum number of voices of a Synth. A note plays a voice
+// for each of the samples of its preset it maps to.
+const DefaultPolyphony = 256
+
+// NewSynth returns a Synth playing sf at the sample rate sr, with the first preset of the
+// SoundFont selected on all channels but the percussion channel.
+func NewSynth(sf *SoundFont, sr megasound.SampleRate) *Synth {
+	s := &Synth{sf: sf, sr: sr, gain: 0.5, maxVoice: DefaultPolyphony}
+	s.Reset()
+	return s
+}
+
+// SetGain sets the gain applied to the output, 0.5 by default.
+func (s *Synth) SetGain(gain float64) {
+	s.gain = gain
+}
+
+// SetPolyphony sets the maximum number of voices. When a note needs more, the quietest
+// released voices, or else the oldest, are stopped.
+func (s *Synth) SetPolyphony(n int) {
+	if n < 1 {
+		n = 1
+	}
+	s.maxVoice = n
+}
+
+// Voices returns the number of voices playing.
+func (s *Synth) Voices() int {
+	return len(s.voices)
+}
+
+// HandleEvent plays a MIDI event. Events other than channel messages are ignored.
+func (s *Synth) HandleEvent(e midi.Event) {
+	if e.Channel < 0 || e.Channel >= len(s.channels) {
+		return
+	}
+	switch e.Kind {
+	case midi.NoteOn:
+		s.NoteOn(e.Channel, e.Data1, e.Data2)
+	case midi.NoteOff:
+		s.NoteOff(e.Channel, e.Data1)
+	case midi.ControlChange:
+		s.ControlChange(e.Channel, e.Data1, e.Data2)
+	case midi.ProgramChange:
+		s.ProgramChange(e.Channel, e.Data1)
+	case midi.PitchBend:
+		s.PitchBend(e.Channel, e.Data1)
+	case midi.ChannelPressure:
+		s.channels[e.Channel].pressure = e.Data1 & 0x7f
+		s.modulate(e.Channel)
+	case midi.PolyPressure:
+		s.channels[e.Channel].keyPressure[e.Data1&0x7f] = e.Data2 & 0x7f
+		s.modulate(e.Channel)
+	}
+}
+
+// NoteOn starts a note of key at velocity vel on channel, with the preset selected on the
+// channel. A velocity of 0 releases the note.
+func (s *Synth) NoteOn(channel, key, vel int) {
+	if channel < 0 || channel >= len(s.channels) || key < 0 || key > 127 || vel > 127 {
+		return
+	}
+	if vel <= 0 {
+		s.NoteOff(channel, key)
+		return
+	}
+	ch := &s.channels[channel]
+	bank := ch.bank
+	if channel == 9 {
+		bank = PercussionBank
+	}
+	p := s.sf.lookup(bank, ch.program)
+	if p == nil {
+		return
+	}
+	playing := len(s.voices)
+	for _, pz := range p.zones {
+		if !pz.matches(key, vel) {
+			continue
+		}
+		for _, iz := range pz.inst.zones {
+			if !iz.matches(key, vel) {
+				continue
+			}
+			v := newVoice(s, channel, key, vel, pz, iz)
+			if v == nil {
+				continue
+			}
+			if class := v.gens[genExclusiveClass]; class != 0 {
+				for _, o := range s.voices[:playing] {
+					if o.channel == channel && o.gens[genExclusiveClass] == class {
+						o.kill()
+					}
+				}
+			}
+			s.steal()
+			s.voices = append(s.voices, v)
+		}
+	}
+}
+
+// steal stops a voice if the maximum number of voices is reached.
+func (s *Synth) steal() {
+	n := 0
+	for _, v := range s.voices {
+		if !v.killed {
+			n++
+		}
+	}
+	if n < s.maxVoice {
+		return
+	}
+	var victim *voice
+	for _, v := range s.voices {
+		switch {
+		case v.killed:
+		case victim == nil:
+			victim = v
+		case v.released && !victim.released:
+			victim = v
+		case v.released && victim.released && v.amp < victim.amp:
+			victim = v
+		}
+	}
+	victim.kill()
+}
+
+// NoteOff releases the notes of key on channel. With the sustain pedal down, they are
+// released when the pedal is.
+func (s *Synth) NoteOff(channel, key int) {
+	if channel < 0 || channel >= len(s.channels) {
+		return
+	}
+	sustain := s.channels[channel].cc[64] >= 64
+	for _, v := range s.voices {
+		if v.channel == channel && v.key == key && !v.released {
+			if sustain {
+				v.sustained = true
+			} else {
+				v.release()
+			}
+		}
+	}
+}
+
+// ProgramChange selects program on channel, in the bank selected with controller 0. It
+// applies to the following notes.
+func (s *Synth) ProgramChange(channel, program int) {
+	if channel >= 0 && channel < len(s.channels) {
+		s.channels[channel].program = program & 0x7f
+	}
+}
+
+// PitchBend sets the pitch bend of channel, from -8192 to 8191.
+func (s *Synth) PitchBend(channel, bend int) {
+	if channel < 0 || channel >= len(s.channels) {
+		return
+	}
+	if bend < -8192 {
+		bend = -8192
+	} else if bend > 8191 {
+		bend = 8191
+	}
+	s.channels[channel].bend = bend
+	s.modulate(channel)
+}
+
+// ControlChange sets controller to value on channel.
+func (s *Synth) ControlChange(channel, controller, value int) {
+	if channel < 0 || channel >= len(s.channels) || controller < 0 || controller > 127 {
+		return
+	}
+	ch := &s.channels[channel]
+	value &= 0x7f
+	ch.cc[controller] = value
+	switch controller {
+	case 0: // bank select
+		ch.bank = value
+	case 6, 38: // data entry
+		if ch.rpn == 0 {
+			ch.bendRange = float64(ch.cc[6]*100 + ch.cc[38])
+		}
+	case 64: // sustain
+		if value < 64 {
+			for _, v := range s.voices {
+				if v.channel == channel && v.sustained {
+					v.sustained = false
+					v.release()
+				}
+			}
+		}
+	case 98, 99: // NRPN
+		ch.rpn = -1
+	case 100, 101: // RPN
+		ch.rpn = ch.cc[101]<<7 | ch.cc[100]
+	case 120: // all sound off
+		for _, v := range s.voices {
+			if v.channel == channel {
+				v.kill()
+			}
+		}
+	case 121: // reset all controllers
+		bank, program := ch.bank, ch.program
+		ch.reset()
+		ch.bank, ch.program = bank, program
+	case 123, 124, 125, 126, 127: // all notes off
+		for _, v := range s.voices {
+			if v.channel == channel {
+				v.sustained = false
+				v.release()
+			}
+		}
+	}
+	s.modulate(channel)
+}
+
+// modulate updates the modulated generators of the voices of channel.
+func (s *Synth) modulate(channel int) {
+	for _, v := range s.voices {
+		if v.channel == channel {
+			v.modulate(&s.channels[channel])
+		}
+	}
+}
+
+// Reset stops all voices at once and resets the channels, selecting the first preset of the
+// SoundFont.
+func (s *Synth) Reset() {
+	s.voices = nil
+	first := s.sf.Presets[0]
+	for i := range s.channels {
+		ch := &s.channels[i]
+		ch.reset()
+		ch.bank, ch.program = first.Bank, first.Program
+		if i == 9 {
+			ch.bank, ch.program = 0, 0
+		}
+	}
+}
+
+// Stream streams the sum of the voices.
+func (s *Synth) Stream(samples [][2]float64) (n int, ok bool) {
+	for i := range samples {
+		samples[i] = [2]float64{}
+	}
+	voices := s.voices[:0]
+	for _, v := range s.voices {
+		if v.render(samples) {
+			voices = append(voices, v)
+		}
+	}
+	for i := len(voices); i < len(s.voices); i++ {
+		s.voices[i] = nil
+	}
+	s.voices = voices
+	for i := range samples {
+		samples[i][0] *= s.gain
+		samples[i][1] *= s.gain
+	}
+	return len(samples), true
+}
+
+// Err always returns nil.
+func (s *Synth) Err() error {
+	return nil
+}
@@ -0,0 +1,422 @@
+package sf2
+
+import "math"
+
+// block is the number of frames between updates of the pitch, filter and gains of voices.
+const block = 64
+
+// voice plays a sample of a note with the generators of an instrument zone and a preset zone.
+type voice struct {
+	sf      *SoundFont
+	sr      float64
+	channel int
+	key     int // key of the note, for note offs
+	k, vel  int // key and velocity for the generators and modulators
+
+	base  [genCount]int     // generators without modulators
+	gens  [genCount]float64 // modulated generators
+	mods  []modulator       // instrument zone modulators
+	pmods []modulator       // preset zone modulators, added
+
+	smp                *sample
+	start, end         int
+	loopStart, loopEnd int
+	mode               int // sample mode: 1 loops, 3 loops until released
+	root               int
+	pos                float64
+
+	volEnv, modEnv envelope
+	modLFO, vibLFO lfo
+	filter         lowpass
+	filtered       bool
+
+	released  bool
+	sustained bool // released while the sustain pedal is down
+	killed    bool
+	amp       float64 // current gain, for voice stealing
+	prevL     float64 // gains of the previous block, ramped from
+	prevR     float64
+}
+
+func newVoice(s *Synth, channel, key, vel int, pz, iz *zone) *voice {
+	v := &voice{
+		sf:      s.sf,
+		sr:      float64(s.sr),
+		channel: channel,
+		key:     key,
+		k:       key,
+		vel:     vel,
+		mods:    iz.mods,
+		pmods:   pz.mods,
+		smp:     iz.smp,
+	}
+	g := &v.base
+	*g = defaultGenerators
+	for i := range g {
+		if iz.set[i] {
+			g[i] = iz.gens[i]
+		}
+		if pz.set[i] && additive(i) {
+			g[i] += pz.gens[i]
+		}
+	}
+	if g[genKeynum] >= 0 && g[genKeynum] <= 127 {
+		v.k = g[genKeynum]
+	}
+	if g[genVelocity] > 0 && g[genVelocity] <= 127 {
+		v.vel = g[genVelocity]
+	}
+
+	frames := len(s.sf.data) / 2
+	v.start = clamp(v.smp.start+g[genStartOffset]+32768*g[genStartCoarseOffset], 0, frames)
+	v.end = clamp(v.smp.end+g[genEndOffset]+32768*g[genEndCoarseOffset], 0, frames)
+	if v.end <= v.start {
+		return nil
+	}
+	v.loopStart = clamp(v.smp.loopStart+g[genLoopStartOffset]+32768*g[genLoopStartCoarse], v.start, v.end)
+	v.loopEnd = clamp(v.smp.loopEnd+g[genLoopEndOffset]+32768*g[genLoopEndCoarse], v.start, v.end)
+	if v.mode = g[genSampleModes] & 3; v.loopEnd-v.loopStart < 2 {
+		v.mode = 0
+	}
+	v.root = v.smp.key
+	if g[genOverridingRootKey] >= 0 && g[genOverridingRootKey] <= 127 {
+		v.root = g[genOverridingRootKey]
+	}
+	v.pos = float64(v.start)
+
+	v.modulate(&s.channels[channel])
+	keyScale := float64(60 - v.k)
+	v.volEnv = envelope{
+		db: true,
+		dur: [envDone]float64{
+			envDelay:   v.time(v.gens[genVolEnvDelay]),
+			envAttack:  v.time(v.gens[genVolEnvAttack]),
+			envHold:    v.time(v.gens[genVolEnvHold] + v.gens[genKeyToVolEnvHold]*keyScale),
+			envDecay:   v.time(v.gens[genVolEnvDecay] + v.gens[genKeyToVolEnvDecay]*keyScale),
+			envRelease: v.time(v.gens[genVolEnvRelease]),
+		},
+		sustain: clampf(1-v.gens[genVolEnvSustain]/960, 0, 1),
+	}
+	v.modEnv = envelope{
+		dur: [envDone]float64{
+			envDelay:   v.time(v.gens[genModEnvDelay]),
+			envAttack:  v.time(v.gens[genModEnvAttack]),
+			envHold:    v.time(v.gens[genModEnvHold] + v.gens[genKeyToModEnvHold]*keyScale),
+			envDecay:   v.time(v.gens[genModEnvDecay] + v.gens[genKeyToModEnvDecay]*keyScale),
+			envRelease: v.time(v.gens[genModEnvRelease]),
+		},
+		sustain: clampf(1-v.gens[genModEnvSustain]/1000, 0, 1),
+	}
+	v.modLFO = lfo{delay: v.time(v.gens[genModLFODelay]), step: hz(v.gens[genModLFOFreq]) / v.sr}
+	v.vibLFO = lfo{delay: v.time(v.gens[genVibLFODelay]), step: hz(v.gens[genVibLFOFreq]) / v.sr}
+	return v
+}
+
+// modulate computes the modulated generators with the controllers of ch.
+func (v *voice) modulate(ch *channel) {
+	for i, g := range v.base {
+		v.gens[i] = float64(g)
+	}
+	for _, mods := range [2][]modulator{v.mods, v.pmods} {
+		for i := range mods {
+			if m := &mods[i]; m.dest < genCount {
+				v.gens[m.dest] += m.value(ch, v.k, v.vel)
+			}
+		}
+	}
+}
+
+// time converts timecents to samples.
+func (v *voice) time(tc float64) float64 {
+	return v.sr * math.Exp2(clampf(tc, -12000, 8000)/1200)
+}
+
+// hz converts absolute cents to hertz.
+func hz(cents float64) float64 {
+	return 8.176 * math.Exp2(cents/1200)
+}
+
+// release releases the note of v.
+func (v *voice) release() {
+	if !v.released {
+		v.released = true
+		v.volEnv.release()
+		v.modEnv.release()
+	}
+}
+
+// kill stops v quickly, avoiding a click.
+func (v *voice) kill() {
+	v.killed, v.released = true, true
+	v.volEnv.dur[envRelease] = 0.005 * v.sr
+	v.volEnv.release()
+}
+
+// looping reports whether v is playing its loop.
+func (v *voice) looping() bool {
+	return v.mode == 1 || v.mode == 3 && !v.released
+}
+
+// frame returns the frame at index i, which may be beyond the loop end, following the loop.
+func (v *voice) frame(i int) float64 {
+	if i >= v.loopEnd && v.looping() {
+		i = v.loopStart + (i-v.loopEnd)%(v.loopEnd-v.loopStart)
+	}
+	if i < v.start || i >= v.end {
+		return 0
+	}
+	return v.sf.frame(i)
+}
+
+// value returns the sample at the position of v, interpolated with a Catmull-Rom spline.
+func (v *voice) value() float64 {
+	i := int(v.pos)
+	t := v.pos - float64(i)
+	y0, y1, y2, y3 := v.frame(i-1), v.frame(i), v.frame(i+1), v.frame(i+2)
+	return y1 + 0.5*t*(y2-y0+t*(2*y0-5*y1+4*y2-y3+t*(3*(y1-y2)+y3-y0)))
+}
+
+// render adds the output of v to buf. It returns false when v has ended.
+func (v *voice) render(buf [][2]float64) bool {
+	for len(buf) > 0 {
+		n := len(buf)
+		if n > block {
+			n = block
+		}
+		if !v.renderBlock(buf[:n]) {
+			return false
+		}
+		buf = buf[n:]
+	}
+	return true
+}
+
+// renderBlock renders a block of frames with the current control values.
+func (v *voice) renderBlock(out [][2]float64) bool {
+	g := &v.gens
+	modEnv, modLFO, vibLFO := v.modEnv.level, v.modLFO.value(), v.vibLFO.value()
+
+	cents := float64(v.k-v.root)*g[genScaleTuning] + g[genCoarseTune]*100 + g[genFineTune] +
+		float64(v.smp.correction) + g[genPitch] +
+		modEnv*g[genModEnvToPitch] + modLFO*g[genModLFOToPitch] + vibLFO*g[genVibLFOToPitch]
+	step := math.Exp2(cents/1200) * float64(v.smp.rate) / v.sr
+
+	fc := clampf(g[genFilterFc]+modEnv*g[genModEnvToFilterFc]+modLFO*g[genModLFOToFilterFc], 1500, 13500)
+	q := clampf(g[genFilterQ], 0, 960)
+	if fc < 13500 || q > 0 || v.filtered {
+		v.filtered = true
+		v.filter.set(math.Min(hz(fc), 0.45*v.sr)/v.sr, q)
+	}
+
+	done := v.volEnv.stage == envDone
+	atten := clampf(g[genAttenuation]+modLFO*g[genModLFOToVolume], 0, 1440)
+	v.amp = 0
+	if !done {
+		v.amp = v.volEnv.amp() * math.Pow(10, -atten/200)
+	}
+	angle := (clampf(g[genPan], -500, 500) + 500) / 1000 * math.Pi / 2
+	gainL, gainR := v.amp*math.Cos(angle), v.amp*math.Sin(angle)
+	if v.filtered {
+		gainL *= v.filter.gain
+		gainR *= v.filter.gain
+	}
+
+	looping := v.looping()
+	loopLen := float64(v.loopEnd - v.loopStart)
+	for i := range out {
+		t := float64(i+1) / float64(len(out))
+		l := v.prevL + (gainL-v.prevL)*t
+		r := v.prevR + (gainR-v.prevR)*t
+		x := v.value()
+		if v.filtered {
+			x = v.filter.process(x)
+		}
+		out[i][0] += l * x
+		out[i][1] += r * x
+		v.pos += step
+		if looping && v.pos >= float64(v.loopEnd) {
+			v.pos = float64(v.loopStart) + math.Mod(v.pos-float64(v.loopEnd), loopLen)
+		} else if v.pos >= float64(v.end) {
+			return false
+		}
+	}
+	v.prevL, v.prevR = gainL, gainR
+
+	n := float64(len(out))
+	v.volEnv.advance(n)
+	v.modEnv.advance(n)
+	v.modLFO.advance(n)
+	v.vibLFO.advance(n)
+	return !done
+}
+
+// Envelope stages.
+const (
+	envDelay = iota
+	envAttack
+	envHold
+	envDecay
+	envSustain
+	envRelease
+	envDone
+)
+
+// envelope is a DAHDSR envelope. Its level rises from 0 to 1 in the attack stage and falls
+// linearly to the sustain level in the decay stage and to 0 in the release stage; the decay
+// and release durations are those of a fall from 1 to 0. The levels of volume envelopes are
+// in decibels, from -96 dB to 0 dB, except in the attack stage, where the amplitude rises
+// linearly.
+type envelope struct {
+	db      bool
+	stage   int
+	t       float64          // samples spent in the stage
+	dur     [envDone]float64 // durations of the stages in samples
+	sustain float64
+	level   float64
+	from    float64 // level at the release
+}
+
+// advance advances e by n samples.
+func (e *envelope) advance(n float64) {
+	e.t += n
+	for {
+		switch e.stage {
+		case envDelay, envAttack, envHold:
+			d := e.dur[e.stage]
+			if e.t < d {
+				if e.stage == envAttack {
+					e.level = e.t / d
+				}
+				return
+			}
+			e.t -= d
+			e.stage++
+			if e.stage > envAttack {
+				e.level = 1
+			}
+		case envDecay:
+			if d := e.dur[envDecay]; d > 0 {
+				e.level = 1 - e.t/d
+			}
+			if e.level > e.sustain && e.dur[envDecay] > 0 {
+				return
+			}
+			e.level, e.stage = e.sustain, envSustain
+			return
+		case envRelease:
+			e.level = 0
+			if d := e.dur[envRelease]; d > 0 {
+				e.level = e.from - e.t/d
+			}
+			if e.level <= 0 {
+				e.level, e.stage = 0, envDone
+			}
+			return
+		default:
+			return
+		}
+	}
+}
+
+// release starts the release stage of e.
+func (e *envelope) release() {
+	if e.stage == envDone {
+		return
+	}
+	if e.db && e.stage <= envAttack && e.level > 0 {
+		e.level = math.Max(0, 1+math.Log10(e.level)/4.8)
+	}
+	e.stage, e.t, e.from = envRelease, 0, e.level
+	if e.from <= 0 {
+		e.stage = envDone
+	}
+}
+
+// amp returns the amplitude of the current level of e.
+func (e *envelope) amp() float64 {
+	if !e.db || e.stage <= envAttack {
+		return e.level
+	}
+	if e.level <= 0 {
+		return 0
+	}
+	return math.Pow(10, (e.level-1)*4.8) // 96 dB range
+}
+
+// lfo is a triangle low-frequency oscillator starting at 0 after its delay.
+type lfo struct {
+	delay float64 // remaining delay in samples
+	step  float64 // cycles per sample
+	phase float64
+}
+
+func (l *lfo) advance(n float64) {
+	if l.delay > 0 {
+		d := math.Min(n, l.delay)
+		l.delay, n = l.delay-d, n-d
+	}
+	l.phase = math.Mod(l.phase+n*l.step, 1)
+}
+
+// value returns the value of l, from -1 to 1.
+func (l *lfo) value() float64 {
+	switch p := l.phase; {
+	case l.delay > 0:
+		return 0
+	case p < 0.25:
+		return 4 * p
+	case p < 0.75:
+		return 2 - 4*p
+	default:
+		return 4*p - 4
+	}
+}
+
+// lowpass is the resonant low-pass filter of a voice, a biquad filter.
+type lowpass struct {
+	f, q               float64 // frequency relative to the sample rate and resonance in cB
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+	gain               float64 // DC gain compensating the resonance
+}
+
+// set sets the cutoff frequency f, relative to the sample rate, and the resonance q in
+// centibels.
+func (lp *lowpass) set(f, q float64) {
+	if f == lp.f && q == lp.q && lp.gain != 0 {
+		return
+	}
+	lp.f, lp.q = f, q
+	res := math.Max(math.Sqrt2/2, math.Pow(10, q/200))
+	w := 2 * math.Pi * f
+	alpha := math.Sin(w) / (2 * res)
+	cos := math.Cos(w)
+	a0 := 1 + alpha
+	lp.b0 = (1 - cos) / 2 / a0
+	lp.b1 = (1 - cos) / a0
+	lp.b2 = lp.b0
+	lp.a1 = -2 * cos / a0
+	lp.a2 = (1 - alpha) / a0
+	lp.gain = math.Pow(10, -q/400) // the DC gain is lowered by half the resonance
+}
+
+func (lp *lowpass) process(x float64) float64 {
+	y := lp.b0*x + lp.b1*lp.x1 + lp.b2*lp.x2 - lp.a1*lp.y1 - lp.a2*lp.y2
+	lp.x2, lp.x1 = lp.x1, x
+	lp.y2, lp.y1 = lp.y1, y
+	return y
+}
+
+func clamp(x, lo, hi int) int {
+	if x < lo {
+		return lo
+	}
+	if x > hi {
+		return hi
+	}
+	return x
+}
+
+func clampf(x, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(x, hi))
+}
@@ -0,0 +1,68 @@
+package spatial
+
+import "math"
+
+// DistanceModel is how the gain of an emitter falls with its distance to the listener. The
+// models are those of OpenAL, clamped between the minimum and maximum distances.
+type DistanceModel int
+
+const (
+	// InverseDistance is the physical model of a point source: the gain halves when the
+	// distance doubles, with a Rolloff of 1.
+	InverseDistance DistanceModel = iota
+
+	// LinearDistance falls linearly from 1 at MinDistance to 0 at MaxDistance, with a Rolloff
+	// of 1.
+	LinearDistance
+
+	// ExponentialDistance falls as the distance to the power of -Rolloff.
+	ExponentialDistance
+
+	// NoDistance keeps a gain of 1.
+	NoDistance
+)
+
+// Attenuation is the distance attenuation of an emitter.
+type Attenuation struct {
+	Model DistanceModel
+
+	// MinDistance is the distance below which the gain is 1, and MaxDistance the distance
+	// above which the gain does not fall further.
+	MinDistance, MaxDistance float64
+
+	// Rolloff scales how fast the gain falls.
+	Rolloff float64
+}
+
+// DefaultAttenuation is the attenuation of new emitters: InverseDistance from 1 meter to 1000
+// meters with a Rolloff of 1.
+var DefaultAttenuation = Attenuation{
+	Model:       InverseDistance,
+	MinDistance: 1,
+	MaxDistance: 1000,
+	Rolloff:     1,
+}
+
+// Gain returns the gain at the distance d in meters.
+func (a Attenuation) Gain(d float64) float64 {
+	lo, hi := math.Max(a.MinDistance, 1e-3), a.MaxDistance
+	if hi < lo {
+		hi = lo
+	}
+	d = math.Max(lo, math.Min(d, hi))
+	var g float64
+	switch a.Model {
+	case InverseDistance:
+		g = lo / (lo + a.Rolloff*(d-lo))
+	case LinearDistance:
+		if hi == lo {
+			return 1
+		}
+		g = 1 - a.Rolloff*(d-lo)/(hi-lo)
+	case ExponentialDistance:
+		g = math.Pow(d/lo, -a.Rolloff)
+	default:
+		return 1
+	}
+	return math.Max(0, math.Min(g, 1))
+}
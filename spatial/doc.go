@@ -0,0 +1,9 @@
+// Package spatial positions sounds in 3D space around a listener, for games and virtual
+// scenes.
+//
+// A Scene has a listener and emitters. Each Emitter plays a Streamer as heard by the listener:
+// attenuated by distance following a DistanceModel, muffled by air absorption, with interaural
+// time and level differences placing it to the left or the right, and with the Doppler effect
+// of the velocities of the emitter and the listener. Positions and velocities can be updated
+// from the game loop while the emitters play through a megasound.Mixer.
+package spatial
@@ -0,0 +1,214 @@
+package spatial
+
+import (
+	"math"
+	"time"
+
+	"github.com/rickcollette/megasound"
+	"github.com/rickcollette/megasound/effects"
+)
+
+const (
+	// headRadius is the radius in meters of the head of the listener, for interaural time
+	// differences.
+	headRadius = 0.0875
+
+	// maxFrequency is the cutoff frequency of the filters when they are open, and
+	// shadowFrequency the cutoff of the ear hidden by the head from a sound at its side.
+	maxFrequency    = 20000.0
+	shadowFrequency = 2000.0
+
+	// shadowGain is the gain of the ear hidden by the head from a sound at its side.
+	shadowGain = 0.5
+
+	// updateBlock is the largest number of samples streamed between updates of the emitter,
+	// over which changes are ramped.
+	updateBlock = 256
+
+	// maxDopplerRatio limits the pitch change of the Doppler effect, up and down.
+	maxDopplerRatio = 4
+)
+
+// Emitter is a sound in a Scene. It streams its Streamer, mixed to mono, as heard by the
+// listener of the Scene: attenuated with distance, muffled by the air, with the time and level
+// differences between the ears of a sound to one side, and with the change of pitch of the
+// Doppler effect from the velocities of the emitter and of the listener.
+//
+// The methods of Emitter can be called from any goroutine while it is playing, without
+// locking the speaker. Changes are ramped over a few milliseconds.
+type Emitter struct {
+	scene *Scene
+
+	// guarded by scene.mu
+	position, velocity Vector
+	attenuation        Attenuation
+	gain               float64
+
+	s       megasound.Streamer
+	r       *megasound.Resampler
+	line    *effects.DelayLine
+	cur     earParams
+	lp      [2]float64 // states of the low-pass filters of the ears
+	started bool
+}
+
+// earParams are the parameters of the rendering of both ears.
+type earParams struct {
+	gain  [2]float64
+	delay [2]float64 // in samples
+	coef  [2]float64 // coefficients of the one-pole low-pass filters, 1 when open
+}
+
+func newEmitter(scene *Scene, s megasound.Streamer) *Emitter {
+	return &Emitter{
+		scene:       scene,
+		attenuation: DefaultAttenuation,
+		gain:        1,
+		s:           s,
+		r:           megasound.ResampleRatio(4, 1, s),
+		line:        effects.NewDelayLine(scene.sr.N(time.Millisecond) + 2),
+	}
+}
+
+// SetPosition moves e to the position p.
+func (e *Emitter) SetPosition(p Vector) {
+	e.scene.mu.Lock()
+	e.position = p
+	e.scene.mu.Unlock()
+}
+
+// Position returns the position of e.
+func (e *Emitter) Position() Vector {
+	e.scene.mu.Lock()
+	defer e.scene.mu.Unlock()
+	return e.position
+}
+
+// SetVelocity sets the velocity of e in meters per second, for the Doppler effect. The
+// velocity does not move e.
+func (e *Emitter) SetVelocity(v Vector) {
+	e.scene.mu.Lock()
+	e.velocity = v
+	e.scene.mu.Unlock()
+}
+
+// Velocity returns the velocity of e.
+func (e *Emitter) Velocity() Vector {
+	e.scene.mu.Lock()
+	defer e.scene.mu.Unlock()
+	return e.velocity
+}
+
+// SetAttenuation sets the distance attenuation of e.
+func (e *Emitter) SetAttenuation(a Attenuation) {
+	e.scene.mu.Lock()
+	e.attenuation = a
+	e.scene.mu.Unlock()
+}
+
+// SetGain sets the gain of e, before the distance attenuation.
+func (e *Emitter) SetGain(g float64) {
+	e.scene.mu.Lock()
+	e.gain = g
+	e.scene.mu.Unlock()
+}
+
+// update returns the parameters of the ears and the Doppler ratio for the current positions.
+func (e *Emitter) update() (p earParams, ratio float64) {
+	sc := e.scene
+	sc.mu.Lock()
+	l, pos, vel := sc.listener, e.position, e.velocity
+	att, gain := e.attenuation, e.gain
+	doppler, air := sc.doppler, sc.airAbsorption
+	sc.mu.Unlock()
+
+	sr := float64(sc.sr)
+	rel := pos.Sub(l.Position)
+	d := rel.Len()
+	right := l.Forward.Cross(l.Up).Normalize()
+	lateral := 0.0
+	if d > 0 {
+		lateral = math.Max(-1, math.Min(rel.Dot(right)/d, 1))
+	}
+	g := att.Gain(d) * gain
+	airCutoff := maxFrequency / (1 + air*d)
+	// Woodworth's model of the interaural time difference
+	itd := headRadius / SpeedOfSound * (math.Asin(math.Abs(lateral)) + math.Abs(lateral)) * sr
+
+	for ear, side := range [2]float64{-1, 1} {
+		facing := lateral * side // 1 when the sound faces the ear, -1 when the head hides it
+		p.gain[ear] = g
+		p.delay[ear] = 1
+		cutoff := airCutoff
+		if facing < 0 {
+			p.gain[ear] *= 1 - (1-shadowGain)*-facing
+			p.delay[ear] += itd
+			cutoff = math.Min(cutoff, maxFrequency*math.Pow(shadowFrequency/maxFrequency, -facing))
+		}
+		p.coef[ear] = 1
+		if cutoff < maxFrequency {
+			p.coef[ear] = 1 - math.Exp(-2*math.Pi*math.Min(cutoff, 0.45*sr)/sr)
+		}
+	}
+
+	ratio = 1
+	if doppler > 0 && d > 0 {
+		// the Doppler shift of OpenAL, from the velocities along the line from e to the listener
+		c := SpeedOfSound / doppler
+		toListener := rel.Scale(-1 / d)
+		vl := math.Min(l.Velocity.Dot(toListener), 0.99*c)
+		vs := math.Min(vel.Dot(toListener), 0.99*c)
+		ratio = (c - vl) / (c - vs)
+		ratio = math.Max(1/maxDopplerRatio, math.Min(ratio, maxDopplerRatio))
+	}
+	return p, ratio
+}
+
+// Stream streams the Streamer of e as heard by the listener.
+func (e *Emitter) Stream(samples [][2]float64) (n int, ok bool) {
+	for n < len(samples) {
+		block := samples[n:]
+		if len(block) > updateBlock {
+			block = block[:updateBlock]
+		}
+		target, ratio := e.update()
+		if !e.started {
+			e.cur, e.started = target, true
+		}
+		e.r.SetRatio(ratio)
+		m, rok := e.r.Stream(block)
+		if !rok {
+			break
+		}
+		e.render(block[:m], target)
+		n += m
+		if m < len(block) {
+			break
+		}
+	}
+	return n, n > 0
+}
+
+// render renders the mono mix of samples for both ears in place, ramping the parameters from
+// the current ones to target.
+func (e *Emitter) render(samples [][2]float64, target earParams) {
+	from := e.cur
+	for i := range samples {
+		t := float64(i+1) / float64(len(samples))
+		mono := (samples[i][0] + samples[i][1]) / 2
+		e.line.Write([2]float64{mono, mono})
+		for ear := range samples[i] {
+			delay := from.delay[ear] + (target.delay[ear]-from.delay[ear])*t
+			coef := from.coef[ear] + (target.coef[ear]-from.coef[ear])*t
+			gain := from.gain[ear] + (target.gain[ear]-from.gain[ear])*t
+			e.lp[ear] += coef * (e.line.Read(delay)[ear] - e.lp[ear])
+			samples[i][ear] = e.lp[ear] * gain
+		}
+	}
+	e.cur = target
+}
+
+// Err propagates the errors of the Streamer of e.
+func (e *Emitter) Err() error {
+	return e.r.Err()
+}
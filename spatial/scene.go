@@ -0,0 +1,88 @@
+package spatial
+
+import (
+	"sync"
+
+	"github.com/rickcollette/megasound"
+)
+
+// SpeedOfSound is the speed of sound in air in meters per second.
+const SpeedOfSound = 343.0
+
+// Listener is the point of view of a Scene: its position and velocity, and the directions of
+// its front and of its top.
+type Listener struct {
+	Position, Velocity Vector
+	Forward, Up        Vector
+}
+
+// DefaultListener is the listener of new scenes: at the origin, facing -Z with +Y up.
+var DefaultListener = Listener{
+	Forward: Vector{0, 0, -1},
+	Up:      Vector{0, 1, 0},
+}
+
+// Scene is a space where emitters are heard by a listener. The listener and the emitters can
+// be updated from any goroutine while the emitters are playing, without locking the speaker.
+type Scene struct {
+	sr megasound.SampleRate
+
+	mu            sync.Mutex
+	listener      Listener
+	doppler       float64
+	airAbsorption float64
+}
+
+// NewScene returns a Scene at the sample rate sr with the DefaultListener, a Doppler factor
+// of 1 and an air absorption of 0.005.
+func NewScene(sr megasound.SampleRate) *Scene {
+	return &Scene{
+		sr:            sr,
+		listener:      DefaultListener,
+		doppler:       1,
+		airAbsorption: 0.005,
+	}
+}
+
+// SetListener moves the listener of s.
+func (s *Scene) SetListener(l Listener) {
+	s.mu.Lock()
+	s.listener = l
+	s.mu.Unlock()
+}
+
+// Listener returns the listener of s.
+func (s *Scene) Listener() Listener {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listener
+}
+
+// SetDopplerFactor scales the Doppler effect of the velocities: 0 disables it, 1 is
+// physical and larger factors exaggerate it.
+func (s *Scene) SetDopplerFactor(k float64) {
+	s.mu.Lock()
+	if k < 0 {
+		k = 0
+	}
+	s.doppler = k
+	s.mu.Unlock()
+}
+
+// SetAirAbsorption sets how air muffles distant emitters: the cutoff frequency of the
+// absorption low-pass filter is divided by 1+k·d at the distance d in meters. 0 disables it.
+func (s *Scene) SetAirAbsorption(k float64) {
+	s.mu.Lock()
+	if k < 0 {
+		k = 0
+	}
+	s.airAbsorption = k
+	s.mu.Unlock()
+}
+
+// NewEmitter returns an Emitter of st in s, at the origin with the DefaultAttenuation. Both
+// channels of st are mixed into one, and the Emitter streams st as heard by the listener. Play
+// emitters through a megasound.Mixer.
+func (s *Scene) NewEmitter(st megasound.Streamer) *Emitter {
+	return newEmitter(s, st)
+}
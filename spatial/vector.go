@@ -0,0 +1,49 @@
+package spatial
+
+import "math"
+
+// Vector is a position, a direction or a velocity in space, in meters and meters per second.
+// The coordinates are right-handed: by default, the listener faces -Z with +Y up and +X on
+// its right.
+type Vector struct {
+	X, Y, Z float64
+}
+
+// Add returns v+w.
+func (v Vector) Add(w Vector) Vector {
+	return Vector{v.X + w.X, v.Y + w.Y, v.Z + w.Z}
+}
+
+// Sub returns v-w.
+func (v Vector) Sub(w Vector) Vector {
+	return Vector{v.X - w.X, v.Y - w.Y, v.Z - w.Z}
+}
+
+// Scale returns v multiplied by k.
+func (v Vector) Scale(k float64) Vector {
+	return Vector{v.X * k, v.Y * k, v.Z * k}
+}
+
+// Dot returns the dot product of v and w.
+func (v Vector) Dot(w Vector) float64 {
+	return v.X*w.X + v.Y*w.Y + v.Z*w.Z
+}
+
+// Cross returns the cross product of v and w.
+func (v Vector) Cross(w Vector) Vector {
+	return Vector{v.Y*w.Z - v.Z*w.Y, v.Z*w.X - v.X*w.Z, v.X*w.Y - v.Y*w.X}
+}
+
+// Len returns the length of v.
+func (v Vector) Len() float64 {
+	return math.Sqrt(v.Dot(v))
+}
+
+// Normalize returns v scaled to a length of 1, or v if its length is 0.
+func (v Vector) Normalize() Vector {
+	l := v.Len()
+	if l == 0 {
+		return v
+	}
+	return v.Scale(1 / l)
+}
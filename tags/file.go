@@ -0,0 +1,221 @@
+package tags
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+// ErrFormat indicates that the format of a file is not supported.
+var ErrFormat = pkgerrors.New("tags: unknown format")
+
+// Read reads the tags of a WAVE, MP3, FLAC, Ogg Vorbis, Ogg Opus or MP4 (M4A) file from r,
+// detecting the format from its content.
+//
+// If r is io.Seeker, chunks which are not needed are skipped by seeking, which makes reading the
+// tags of WAVE files fast, and the ID3v1 tag at the end of MP3 files is read too. The position
+// of r is undefined afterwards.
+func Read(r io.Reader) (Tags, error) {
+	head, r, err := peek(r, 10)
+	if err != nil {
+		return Tags{}, err
+	}
+	switch {
+	case string(head[:4]) == "RIFF":
+		return readWAV(r)
+	case string(head[:4]) == "fLaC":
+		return readFLAC(r)
+	case string(head[:4]) == "OggS":
+		return readOgg(r)
+	case string(head[4:8]) == "ftyp":
+		return readMP4(r)
+	}
+	if size, ok := ID3v2Size(head); ok {
+		p, err := readChunk(r, int64(size))
+		if err != nil {
+			return Tags{}, pkgerrors.Wrap(err, "tags")
+		}
+		t, err := ParseID3v2(p)
+		if err != nil {
+			return Tags{}, err
+		}
+		next, r, err := peek(r, 4)
+		if err == nil && string(next) == "fLaC" {
+			// some taggers put an ID3v2 tag in front of FLAC files
+			flac, err := readFLAC(r)
+			if err != nil {
+				return Tags{}, err
+			}
+			flac.Merge(t)
+			return flac, nil
+		}
+		if rs, ok := r.(io.ReadSeeker); ok {
+			if v1, ok := ReadID3v1(rs); ok {
+				t.Merge(v1)
+			}
+		}
+		return t, nil
+	}
+	if head[0] == 0xff && head[1]&0xe0 == 0xe0 { // MP3 frame sync
+		if rs, ok := r.(io.ReadSeeker); ok {
+			if t, ok := ReadID3v1(rs); ok {
+				return t, nil
+			}
+		}
+		return Tags{}, nil
+	}
+	return Tags{}, ErrFormat
+}
+
+// ReadID3v1 reads the ID3v1 tag at the end of rs. It reports false if there is none. The
+// position of rs is restored.
+func ReadID3v1(rs io.ReadSeeker) (Tags, bool) {
+	pos, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return Tags{}, false
+	}
+	defer rs.Seek(pos, io.SeekStart)
+	if _, err := rs.Seek(-128, io.SeekEnd); err != nil {
+		return Tags{}, false
+	}
+	p := make([]byte, 128)
+	if _, err := io.ReadFull(rs, p); err != nil {
+		return Tags{}, false
+	}
+	return parseID3v1(p)
+}
+
+// peek returns the first n bytes of r and a reader reading all of r. The reader is r itself if
+// r is io.Seeker.
+func peek(r io.Reader, n int) ([]byte, io.Reader, error) {
+	if rs, ok := r.(io.ReadSeeker); ok {
+		head := make([]byte, n)
+		if _, err := io.ReadFull(rs, head); err != nil {
+			return nil, nil, pkgerrors.Wrap(err, "tags")
+		}
+		if _, err := rs.Seek(int64(-n), io.SeekCurrent); err != nil {
+			return nil, nil, pkgerrors.Wrap(err, "tags")
+		}
+		return head, rs, nil
+	}
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	head, err := br.Peek(n)
+	if err != nil {
+		return nil, nil, pkgerrors.Wrap(err, "tags")
+	}
+	return head, br, nil
+}
+
+// Write copies the audio file in src to dst, replacing its tags with t. The format of src is
+// detected like by Read.
+//
+// WAVE files get a LIST INFO chunk and an ID3v2 chunk, MP3 files an ID3v2.4 tag replacing the
+// ID3v2 and ID3v1 tags, FLAC files a VORBIS_COMMENT block and PICTURE blocks replacing the old
+// ones and their padding, and Ogg Vorbis and Opus files a new comment header. The audio data is
+// copied unchanged.
+func Write(dst io.Writer, src io.ReadSeeker, t Tags) error {
+	head, _, err := peek(src, 10)
+	if err != nil {
+		return err
+	}
+	switch {
+	case string(head[:4]) == "RIFF":
+		return writeWAV(dst, src, t)
+	case string(head[:4]) == "fLaC":
+		return writeFLAC(dst, src, t)
+	case string(head[:4]) == "OggS":
+		return writeOgg(dst, src, t)
+	case string(head[4:8]) == "ftyp":
+		return pkgerrors.New("tags: writing MP4 files is not supported")
+	}
+	start, err := src.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return pkgerrors.Wrap(err, "tags")
+	}
+	if size, ok := ID3v2Size(head); ok {
+		start, err = src.Seek(int64(size), io.SeekCurrent)
+		if err != nil {
+			return pkgerrors.Wrap(err, "tags")
+		}
+		next, _, err := peek(src, 4)
+		if err == nil && string(next) == "fLaC" {
+			return writeFLAC(dst, src, t)
+		}
+	} else if head[0] != 0xff || head[1]&0xe0 != 0xe0 {
+		return ErrFormat
+	}
+
+	// MP3
+	end, err := src.Seek(0, io.SeekEnd)
+	if err != nil {
+		return pkgerrors.Wrap(err, "tags")
+	}
+	if _, ok := ReadID3v1(src); ok {
+		end -= 128
+	}
+	if _, err := src.Seek(start, io.SeekStart); err != nil {
+		return pkgerrors.Wrap(err, "tags")
+	}
+	if _, err := dst.Write(AppendID3v2(nil, t)); err != nil {
+		return pkgerrors.Wrap(err, "tags")
+	}
+	_, err = io.CopyN(dst, src, end-start)
+	return pkgerrors.Wrap(err, "tags")
+}
+
+// UpdateFile reads the tags of the file at path, calls update to change them and writes them
+// back. The file is replaced by a new one, written next to it, only when writing succeeded.
+//
+// For example, to store the tempo detected for a track:
+//
+//	err := tags.UpdateFile(path, func(t *tags.Tags) { t.BPM = 128 })
+func UpdateFile(path string, update func(t *Tags)) (err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return pkgerrors.Wrap(err, "tags")
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return pkgerrors.Wrap(err, "tags")
+	}
+	t, err := Read(f)
+	if err != nil {
+		return err
+	}
+	update(&t)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return pkgerrors.Wrap(err, "tags")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return pkgerrors.Wrap(err, "tags")
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	bw := bufio.NewWriter(tmp)
+	if err := Write(bw, f, t); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return pkgerrors.Wrap(err, "tags")
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		return pkgerrors.Wrap(err, "tags")
+	}
+	if err := tmp.Close(); err != nil {
+		return pkgerrors.Wrap(err, "tags")
+	}
+	return pkgerrors.Wrap(os.Rename(tmp.Name(), path), "tags")
+}
@@ -0,0 +1,128 @@
+package tags
+
+import (
+	"io"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/rickcollette/megasound/internal/vorbiscomment"
+)
+
+// FLAC metadata block types.
+const (
+	flacStreamInfo    = 0
+	flacPadding       = 1
+	flacVorbisComment = 4
+	flacPicture       = 6
+)
+
+// flacBlock is a FLAC metadata block.
+type flacBlock struct {
+	typ  byte
+	data []byte
+}
+
+// readFLACBlocks reads the "fLaC" marker and the metadata blocks of the FLAC stream in r, leaving
+// r at the first audio frame.
+func readFLACBlocks(r io.Reader) ([]flacBlock, error) {
+	var h [4]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return nil, pkgerrors.Wrap(err, "tags")
+	}
+	if string(h[:]) != "fLaC" {
+		return nil, pkgerrors.New("tags: not a FLAC stream")
+	}
+	var blocks []flacBlock
+	for {
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			return nil, pkgerrors.Wrap(err, "tags")
+		}
+		last, typ := h[0]&0x80 != 0, h[0]&0x7f
+		size := int64(h[1])<<16 | int64(h[2])<<8 | int64(h[3])
+		data, err := readChunk(r, size)
+		if err != nil {
+			return nil, pkgerrors.Wrap(err, "tags")
+		}
+		blocks = append(blocks, flacBlock{typ, data})
+		if last {
+			return blocks, nil
+		}
+	}
+}
+
+// readFLAC reads the tags of the FLAC stream in r from its VORBIS_COMMENT and PICTURE blocks.
+func readFLAC(r io.Reader) (Tags, error) {
+	blocks, err := readFLACBlocks(r)
+	if err != nil {
+		return Tags{}, err
+	}
+	return flacTags(blocks), nil
+}
+
+func flacTags(blocks []flacBlock) Tags {
+	var t Tags
+	var pictures []Picture
+	for _, b := range blocks {
+		switch b.typ {
+		case flacVorbisComment:
+			if c, err := vorbiscomment.Parse(b.data); err == nil {
+				t = FromComments(c.Fields)
+			}
+		case flacPicture:
+			if pic, err := vorbiscomment.ParsePicture(b.data); err == nil {
+				pictures = append(pictures, pic)
+			}
+		}
+	}
+	// PICTURE blocks are the standard place, some taggers use comments
+	t.Pictures = append(pictures, t.Pictures...)
+	return t
+}
+
+// writeFLAC copies the FLAC stream in src to dst, replacing its VORBIS_COMMENT, PICTURE and
+// PADDING blocks with blocks holding t.
+func writeFLAC(dst io.Writer, src io.Reader, t Tags) error {
+	blocks, err := readFLACBlocks(src)
+	if err != nil {
+		return err
+	}
+	vendor := "megasound"
+	var kept []flacBlock
+	for _, b := range blocks {
+		switch b.typ {
+		case flacVorbisComment:
+			if c, err := vorbiscomment.Parse(b.data); err == nil {
+				vendor = c.Vendor
+			}
+		case flacPicture, flacPadding:
+		default:
+			kept = append(kept, b)
+		}
+	}
+	if len(kept) == 0 || kept[0].typ != flacStreamInfo {
+		return pkgerrors.New("tags: FLAC stream has no STREAMINFO block")
+	}
+	comments := vorbiscomment.Comments{Vendor: vendor, Fields: t.Comments(false)}
+	kept = append(kept, flacBlock{flacVorbisComment, vorbiscomment.Append(nil, comments)})
+	for _, pic := range t.Pictures {
+		kept = append(kept, flacBlock{flacPicture, vorbiscomment.AppendPicture(nil, pic)})
+	}
+
+	out := []byte("fLaC")
+	for i, b := range kept {
+		if len(b.data) >= 1<<24 {
+			return pkgerrors.New("tags: FLAC metadata block too large")
+		}
+		typ := b.typ
+		if i == len(kept)-1 {
+			typ |= 0x80
+		}
+		n := len(b.data)
+		out = append(out, typ, byte(n>>16), byte(n>>8), byte(n))
+		out = append(out, b.data...)
+	}
+	if _, err := dst.Write(out); err != nil {
+		return pkgerrors.Wrap(err, "tags")
+	}
+	_, err = io.Copy(dst, src)
+	return pkgerrors.Wrap(err, "tags")
+}
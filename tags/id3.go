@@ -0,0 +1,451 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+// id3Frames maps ID3v2.3 and ID3v2.4 text frames to field names.
+var id3Frames = map[string]string{
+	"TIT2": fieldTitle,
+	"TPE1": fieldArtist,
+	"TALB": fieldAlbum,
+	"TPE2": fieldAlbumArtist,
+	"TCON": fieldGenre,
+	"TDRC": fieldDate,
+	"TYER": fieldDate,
+	"TRCK": fieldTrack,
+	"TPOS": fieldDisc,
+	"TBPM": fieldBPM,
+	"TKEY": fieldKey,
+	"TCOM": "COMPOSER",
+	"TIT1": "GROUPING",
+	"TIT3": "SUBTITLE",
+	"TPUB": "LABEL",
+	"TSRC": "ISRC",
+	"TCOP": "COPYRIGHT",
+	"TENC": "ENCODED-BY",
+	"TSSE": "ENCODER",
+	"TLAN": "LANGUAGE",
+	"TEXT": "LYRICIST",
+	"TPE3": "CONDUCTOR",
+	"TPE4": "REMIXER",
+}
+
+// id3v22Frames maps ID3v2.2 frames to their ID3v2.3 counterparts.
+var id3v22Frames = map[string]string{
+	"TT2": "TIT2", "TP1": "TPE1", "TAL": "TALB", "TP2": "TPE2", "TCO": "TCON", "TYE": "TYER",
+	"TRK": "TRCK", "TPA": "TPOS", "TBP": "TBPM", "TKE": "TKEY", "TCM": "TCOM", "TT1": "TIT1",
+	"TT3": "TIT3", "TPB": "TPUB", "TRC": "TSRC", "TCR": "TCOP", "TEN": "TENC", "TSS": "TSSE",
+	"TLA": "TLAN", "TXT": "TEXT", "TP3": "TPE3", "TP4": "TPE4", "TXX": "TXXX", "COM": "COMM",
+	"PIC": "APIC",
+}
+
+// ID3v2Size returns the size of the ID3v2 tag starting with the 10 byte header h, including the
+// header and footer, which is the offset of the audio data following the tag. It reports false
+// if h is not an ID3v2 header.
+func ID3v2Size(h []byte) (int, bool) {
+	if len(h) < 10 || string(h[:3]) != "ID3" || h[3] == 0xff || h[4] == 0xff {
+		return 0, false
+	}
+	size := 10 + syncsafe(h[6:10])
+	if h[5]&0x10 != 0 { // footer present
+		size += 10
+	}
+	return size, true
+}
+
+func syncsafe(p []byte) int {
+	return int(p[0]&0x7f)<<21 | int(p[1]&0x7f)<<14 | int(p[2]&0x7f)<<7 | int(p[3]&0x7f)
+}
+
+// ParseID3v2 parses a whole ID3v2.2, ID3v2.3 or ID3v2.4 tag, including its header.
+func ParseID3v2(p []byte) (Tags, error) {
+	var t Tags
+	size, ok := ID3v2Size(p)
+	if !ok {
+		return t, pkgerrors.New("tags: not an ID3v2 tag")
+	}
+	if len(p) < size {
+		return t, pkgerrors.New("tags: ID3v2 tag truncated")
+	}
+	version, flags := p[3], p[5]
+	if version < 2 || version > 4 {
+		return t, pkgerrors.Errorf("tags: unsupported ID3v2 version 2.%d", version)
+	}
+	body := p[10 : 10+syncsafe(p[6:10])]
+	if flags&0x80 != 0 && version < 4 {
+		body = unsync(body)
+	}
+	if flags&0x40 != 0 && version > 2 { // extended header
+		if len(body) < 4 {
+			return t, pkgerrors.New("tags: ID3v2 tag truncated")
+		}
+		n := int(binary.BigEndian.Uint32(body)) + 4
+		if version == 4 {
+			n = syncsafe(body)
+		}
+		if n > len(body) {
+			return t, pkgerrors.New("tags: ID3v2 tag truncated")
+		}
+		body = body[n:]
+	}
+
+	headerLen := 10
+	if version == 2 {
+		headerLen = 6
+	}
+	for len(body) >= headerLen && body[0] != 0 {
+		var (
+			id   string
+			size int
+			fl   uint16
+		)
+		if version == 2 {
+			id = string(body[:3])
+			size = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		} else {
+			id = string(body[:4])
+			size = int(binary.BigEndian.Uint32(body[4:]))
+			if version == 4 {
+				size = syncsafe(body[4:8])
+			}
+			fl = binary.BigEndian.Uint16(body[8:])
+		}
+		if size > len(body)-headerLen {
+			break
+		}
+		data := body[headerLen : headerLen+size]
+		body = body[headerLen+size:]
+		if version == 2 {
+			v3, ok := id3v22Frames[id]
+			if !ok {
+				continue
+			}
+			id = v3
+		}
+		switch {
+		case version == 3 && fl&0x00c0 != 0, version == 4 && fl&0x000c != 0:
+			continue // compressed or encrypted
+		case version == 3 && fl&0x0020 != 0:
+			if len(data) < 1 {
+				continue
+			}
+			data = data[1:] // group identifier
+		case version == 4:
+			if fl&0x0040 != 0 && len(data) > 0 {
+				data = data[1:] // group identifier
+			}
+			if fl&0x0001 != 0 && len(data) >= 4 {
+				data = data[4:] // data length indicator
+			}
+			if fl&0x0002 != 0 {
+				data = unsync(data)
+			}
+		}
+		if version == 2 && id == "APIC" {
+			data = picV22(data)
+		}
+		t.addID3Frame(id, data)
+	}
+	return t, nil
+}
+
+// addID3Frame adds the value of the frame id with the given data to t.
+func (t *Tags) addID3Frame(id string, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	enc, data := data[0], data[1:]
+	switch {
+	case id == "TXXX":
+		desc, value := splitText(enc, data)
+		if desc == "" {
+			return
+		}
+		for _, v := range decodeTextList(enc, value) {
+			t.add(desc, v)
+		}
+	case id == "COMM":
+		if len(data) < 3 {
+			return
+		}
+		desc, text := splitText(enc, data[3:])
+		if desc == "" {
+			t.Comment = decodeText(enc, text)
+		} else if !strings.HasPrefix(desc, "iTun") {
+			t.add(desc, decodeText(enc, text))
+		}
+	case id == "APIC":
+		mime, rest, ok := bytes.Cut(data, []byte{0})
+		if !ok || len(rest) < 1 {
+			return
+		}
+		typ := rest[0]
+		desc, pic := splitText(enc, rest[1:])
+		t.Pictures = append(t.Pictures, Picture{
+			Type:        uint32(typ),
+			MIME:        string(mime),
+			Description: desc,
+			Data:        append([]byte(nil), pic...),
+		})
+	case id[0] == 'T':
+		values := decodeTextList(enc, data)
+		if len(values) == 0 {
+			return
+		}
+		name, ok := id3Frames[id]
+		if !ok {
+			name = id
+		}
+		if name == fieldGenre {
+			for i, v := range values {
+				values[i] = genre(v)
+			}
+		}
+		for _, v := range values {
+			t.add(name, v)
+		}
+	}
+}
+
+// picV22 converts the data of an ID3v2.2 PIC frame to the layout of an APIC frame.
+func picV22(data []byte) []byte {
+	if len(data) < 5 {
+		return nil
+	}
+	mime := "image/" + strings.ToLower(string(data[1:4]))
+	if mime == "image/jpg" {
+		mime = "image/jpeg"
+	}
+	out := append([]byte{data[0]}, mime...)
+	out = append(out, 0)
+	return append(out, data[4:]...)
+}
+
+// genre resolves ID3v1 genre references like "(17)" or "17".
+func genre(v string) string {
+	ref := v
+	if strings.HasPrefix(v, "(") {
+		end := strings.IndexByte(v, ')')
+		if end < 0 {
+			return v
+		}
+		if rest := v[end+1:]; rest != "" {
+			return rest // refinement
+		}
+		ref = v[1:end]
+	}
+	if n, err := strconv.Atoi(ref); err == nil && 0 <= n && n < len(id3v1Genres) {
+		return id3v1Genres[n]
+	}
+	return v
+}
+
+// unsync reverses the unsynchronisation scheme, which inserts a zero byte after each 0xff.
+func unsync(p []byte) []byte {
+	if !bytes.Contains(p, []byte{0xff, 0x00}) {
+		return p
+	}
+	out := make([]byte, 0, len(p))
+	for i := 0; i < len(p); i++ {
+		out = append(out, p[i])
+		if p[i] == 0xff && i+1 < len(p) && p[i+1] == 0 {
+			i++
+		}
+	}
+	return out
+}
+
+// splitText splits data at the first string terminator of the given text encoding and returns
+// the decoded first string and the rest.
+func splitText(enc byte, data []byte) (string, []byte) {
+	if enc == 1 || enc == 2 {
+		for i := 0; i+1 < len(data); i += 2 {
+			if data[i] == 0 && data[i+1] == 0 {
+				return decodeText(enc, data[:i]), data[i+2:]
+			}
+		}
+		return decodeText(enc, data), nil
+	}
+	s, rest, _ := bytes.Cut(data, []byte{0})
+	return decodeText(enc, s), rest
+}
+
+// decodeTextList decodes a list of NUL separated strings.
+func decodeTextList(enc byte, data []byte) []string {
+	var values []string
+	for len(data) > 0 {
+		var s string
+		s, data = splitText(enc, data)
+		if s = strings.TrimSpace(s); s != "" {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
+// decodeText decodes a string in one of the ID3v2 text encodings: ISO-8859-1, UTF-16 with a
+// byte order mark, UTF-16BE or UTF-8.
+func decodeText(enc byte, p []byte) string {
+	switch enc {
+	case 0:
+		return latin1(p)
+	case 1, 2:
+		big := enc == 2
+		if len(p) >= 2 {
+			switch {
+			case p[0] == 0xfe && p[1] == 0xff:
+				big, p = true, p[2:]
+			case p[0] == 0xff && p[1] == 0xfe:
+				big, p = false, p[2:]
+			}
+		}
+		u := make([]uint16, len(p)/2)
+		for i := range u {
+			if big {
+				u[i] = binary.BigEndian.Uint16(p[2*i:])
+			} else {
+				u[i] = binary.LittleEndian.Uint16(p[2*i:])
+			}
+		}
+		return strings.TrimRight(string(utf16.Decode(u)), "\x00")
+	default:
+		return strings.TrimRight(string(p), "\x00")
+	}
+}
+
+func latin1(p []byte) string {
+	r := make([]rune, 0, len(p))
+	for _, b := range p {
+		if b == 0 {
+			break
+		}
+		r = append(r, rune(b))
+	}
+	return string(r)
+}
+
+// AppendID3v2 appends t encoded as an ID3v2.4 tag with UTF-8 text to b.
+func AppendID3v2(b []byte, t Tags) []byte {
+	var body []byte
+	frame := func(id string, data []byte) {
+		body = append(body, id...)
+		n := len(data)
+		body = append(body, byte(n>>21&0x7f), byte(n>>14&0x7f), byte(n>>7&0x7f), byte(n&0x7f), 0, 0)
+		body = append(body, data...)
+	}
+	text := func(id string, values ...string) {
+		data := []byte{3}
+		data = append(data, strings.Join(values, "\x00")...)
+		frame(id, data)
+	}
+	for _, f := range t.fields() {
+		name, value := f[0], f[1]
+		switch name {
+		case fieldComment:
+			data := append([]byte{3}, "eng\x00"...)
+			frame("COMM", append(data, value...))
+		case fieldTrack:
+			if t.TrackTotal > 0 {
+				value += "/" + strconv.Itoa(t.TrackTotal)
+			}
+			text("TRCK", value)
+		case fieldDisc:
+			if t.DiscTotal > 0 {
+				value += "/" + strconv.Itoa(t.DiscTotal)
+			}
+			text("TPOS", value)
+		case fieldTrackTotal, fieldDiscTotal:
+			// stored with the track and disc number
+		case fieldBPM:
+			text("TBPM", strconv.Itoa(int(math.Round(t.BPM))))
+			if t.BPM != math.Round(t.BPM) {
+				text("TXXX", "BPM", value) // keep the fraction
+			}
+		default:
+			if id := id3Frame(name); id != "" {
+				text(id, value)
+			} else {
+				text("TXXX", name, value)
+			}
+		}
+	}
+	for _, name := range t.otherFields() {
+		values := t.Fields[name]
+		switch {
+		case len(name) == 4 && name[0] == 'T' && name != "TXXX":
+			text(name, values...)
+		case id3Frame(name) != "":
+			text(id3Frame(name), values...)
+		default:
+			text("TXXX", append([]string{name}, values...)...)
+		}
+	}
+	for _, pic := range t.Pictures {
+		data := append([]byte{3}, pic.MIME...)
+		data = append(data, 0, byte(pic.Type))
+		data = append(data, pic.Description...)
+		data = append(data, 0)
+		frame("APIC", append(data, pic.Data...))
+	}
+
+	n := len(body)
+	b = append(b, 'I', 'D', '3', 4, 0, 0)
+	b = append(b, byte(n>>21&0x7f), byte(n>>14&0x7f), byte(n>>7&0x7f), byte(n&0x7f))
+	return append(b, body...)
+}
+
+// id3Frame returns the ID3v2.4 text frame for the field name, or "" if there is none.
+func id3Frame(name string) string {
+	for id, n := range id3Frames {
+		if n == name && id != "TYER" {
+			return id
+		}
+	}
+	return ""
+}
+
+// parseID3v1 parses a 128 byte ID3v1 or ID3v1.1 tag.
+func parseID3v1(p []byte) (Tags, bool) {
+	var t Tags
+	if len(p) != 128 || string(p[:3]) != "TAG" {
+		return t, false
+	}
+	str := func(p []byte) string {
+		return strings.TrimSpace(latin1(p))
+	}
+	t.Title = str(p[3:33])
+	t.Artist = str(p[33:63])
+	t.Album = str(p[63:93])
+	t.Date = str(p[93:97])
+	t.Comment = str(p[97:127])
+	if p[125] == 0 && p[126] != 0 { // ID3v1.1
+		t.Comment = str(p[97:125])
+		t.Track = int(p[126])
+	}
+	if int(p[127]) < len(id3v1Genres) {
+		t.Genre = id3v1Genres[p[127]]
+	}
+	return t, true
+}
+
+var id3v1Genres = [...]string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop", "Jazz",
+	"Metal", "New Age", "Oldies", "Other", "Pop", "R&B", "Rap", "Reggae", "Rock", "Techno",
+	"Industrial", "Alternative", "Ska", "Death Metal", "Pranks", "Soundtrack", "Euro-Techno",
+	"Ambient", "Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance", "Classical", "Instrumental",
+	"Acid", "House", "Game", "Sound Clip", "Gospel", "Noise", "AlternRock", "Bass", "Soul", "Punk",
+	"Space", "Meditative", "Instrumental Pop", "Instrumental Rock", "Ethnic", "Gothic",
+	"Darkwave", "Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap", "Pop/Funk", "Jungle",
+	"Native American", "Cabaret", "New Wave", "Psychadelic", "Rave", "Showtunes", "Trailer",
+	"Lo-Fi", "Tribal", "Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll",
+	"Hard Rock",
+}
@@ -0,0 +1,98 @@
+package tags
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/rickcollette/megasound/internal/mp4"
+)
+
+// mp4Fields maps the text items of iTunes metadata to field names.
+var mp4Fields = map[string]string{
+	"©nam": fieldTitle,
+	"©ART": fieldArtist,
+	"©alb": fieldAlbum,
+	"aART": fieldAlbumArtist,
+	"©gen": fieldGenre,
+	"©day": fieldDate,
+	"©cmt": fieldComment,
+	"©wrt": "COMPOSER",
+	"©grp": "GROUPING",
+	"©lyr": "LYRICS",
+	"©too": "ENCODER",
+	"cprt": "COPYRIGHT",
+}
+
+// readMP4 reads the iTunes metadata of an MP4 (M4A) file.
+func readMP4(r io.Reader) (Tags, error) {
+	f, err := mp4.Read(r)
+	if err != nil {
+		return Tags{}, pkgerrors.Wrap(err, "tags")
+	}
+	return mp4Tags(f.Meta), nil
+}
+
+// mp4Tags converts iTunes metadata items to Tags.
+func mp4Tags(items []mp4.MetaItem) Tags {
+	var t Tags
+	for _, item := range items {
+		if name, ok := mp4Fields[item.Name]; ok {
+			t.add(name, string(item.Data))
+			continue
+		}
+		switch item.Name {
+		case "trkn", "disk":
+			if len(item.Data) < 6 {
+				continue
+			}
+			n := int(binary.BigEndian.Uint16(item.Data[2:]))
+			total := int(binary.BigEndian.Uint16(item.Data[4:]))
+			if item.Name == "trkn" {
+				t.Track, t.TrackTotal = n, total
+			} else {
+				t.Disc, t.DiscTotal = n, total
+			}
+		case "tmpo":
+			if bpm := mp4Int(item.Data); bpm > 0 {
+				t.BPM = float64(bpm)
+			}
+		case "gnre":
+			// ID3v1 genre index plus one
+			if n := mp4Int(item.Data); 0 < n && int(n) <= len(id3v1Genres) && t.Genre == "" {
+				t.Genre = id3v1Genres[n-1]
+			}
+		case "covr":
+			pic := Picture{Type: 3, Data: item.Data}
+			switch item.Type {
+			case 13:
+				pic.MIME = "image/jpeg"
+			case 14:
+				pic.MIME = "image/png"
+			}
+			t.Pictures = append(t.Pictures, pic)
+		default:
+			if strings.HasPrefix(item.Name, "----:") && item.Type == 1 {
+				name := item.Name[strings.LastIndexByte(item.Name, ':')+1:]
+				t.add(name, string(item.Data))
+			}
+		}
+	}
+	return t
+}
+
+// mp4Int decodes the big-endian signed integers of iTunes metadata.
+func mp4Int(p []byte) int64 {
+	switch len(p) {
+	case 1:
+		return int64(int8(p[0]))
+	case 2:
+		return int64(int16(binary.BigEndian.Uint16(p)))
+	case 4:
+		return int64(int32(binary.BigEndian.Uint32(p)))
+	case 8:
+		return int64(binary.BigEndian.Uint64(p))
+	}
+	return 0
+}
@@ -0,0 +1,156 @@
+package tags
+
+import (
+	"bytes"
+	"io"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/rickcollette/megasound/internal/ogg"
+	"github.com/rickcollette/megasound/internal/vorbiscomment"
+)
+
+// oggCodec describes the header packets of a codec in an Ogg stream.
+type oggCodec struct {
+	id, comment string // prefixes of the identification and comment packets
+	headers     int    // number of header packets
+	framing     bool   // the comment packet ends with a framing bit
+}
+
+var oggCodecs = []oggCodec{
+	{"\x01vorbis", "\x03vorbis", 3, true},
+	{"OpusHead", "OpusTags", 2, false},
+}
+
+func findOggCodec(id []byte) (oggCodec, bool) {
+	for _, c := range oggCodecs {
+		if bytes.HasPrefix(id, []byte(c.id)) {
+			return c, true
+		}
+	}
+	return oggCodec{}, false
+}
+
+// readOgg reads the tags of the first Vorbis or Opus stream in the Ogg stream in r.
+func readOgg(r io.Reader) (Tags, error) {
+	c, err := readOggComments(ogg.NewReader(r))
+	if err != nil {
+		return Tags{}, err
+	}
+	return FromComments(c.Fields), nil
+}
+
+func readOggComments(r *ogg.Reader) (vorbiscomment.Comments, error) {
+	var (
+		codec  oggCodec
+		serial uint32
+		found  bool
+	)
+	for {
+		pkt, err := r.ReadPacket()
+		if err != nil {
+			if err == io.EOF {
+				err = pkgerrors.New("no Vorbis or Opus stream")
+			}
+			return vorbiscomment.Comments{}, pkgerrors.Wrap(err, "tags")
+		}
+		if !found {
+			if pkt.BOS {
+				codec, found = findOggCodec(pkt.Data)
+				serial = pkt.Serial
+			}
+			continue
+		}
+		if pkt.Serial != serial {
+			continue
+		}
+		if !bytes.HasPrefix(pkt.Data, []byte(codec.comment)) {
+			return vorbiscomment.Comments{}, pkgerrors.New("tags: invalid comment header")
+		}
+		c, err := vorbiscomment.Parse(pkt.Data[len(codec.comment):])
+		return c, pkgerrors.Wrap(err, "tags")
+	}
+}
+
+// writeOgg copies the Ogg stream in src to dst, replacing the comment header of its first Vorbis
+// or Opus stream with one holding t. The header pages of that stream following the first one
+// are laid out again and its later pages renumbered, all other pages are copied unchanged.
+func writeOgg(dst io.Writer, src io.Reader, t Tags) error {
+	r := ogg.NewReader(src)
+	var (
+		codec   oggCodec
+		serial  uint32
+		found   bool
+		done    bool     // the header pages were replaced
+		packets [][]byte // header packets of the stream
+		partial []byte
+		seq     uint32 // sequence number of the next header page
+		seqDiff uint32 // difference of the new and old page sequence numbers
+		buf     []byte
+	)
+	for {
+		p, err := r.ReadPage()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return pkgerrors.Wrap(err, "tags")
+		}
+		var pages []*ogg.Page
+		switch {
+		case !found && p.Flags&ogg.FlagBOS != 0:
+			codec, found = findOggCodec(p.Body)
+			if found {
+				// the identification header is alone on the first page
+				serial = p.Serial
+				seq = p.Seq + 1
+				packets = [][]byte{p.Body}
+			}
+			pages = []*ogg.Page{p}
+		case !found || p.Serial != serial:
+			pages = []*ogg.Page{p}
+		case done:
+			p.Seq += seqDiff
+			pages = []*ogg.Page{p}
+		default:
+			body := p.Body
+			for _, l := range p.Lacing {
+				partial = append(partial, body[:l]...)
+				body = body[l:]
+				if l < 255 {
+					packets = append(packets, partial)
+					partial = nil
+				}
+			}
+			if len(packets) < codec.headers || partial != nil {
+				continue
+			}
+			comments, err := vorbiscomment.Parse(bytes.TrimPrefix(packets[1], []byte(codec.comment)))
+			if err != nil || !bytes.HasPrefix(packets[1], []byte(codec.comment)) {
+				return pkgerrors.New("tags: invalid comment header")
+			}
+			comments.Fields = t.Comments(true)
+			packet := append([]byte(codec.comment), vorbiscomment.Append(nil, comments)...)
+			if codec.framing {
+				packet = append(packet, 1)
+			}
+			packets[1] = packet
+			pages = ogg.Paginate(packets[1:], serial, seq, p.Granule, 0)
+			seqDiff = seq + uint32(len(pages)) - (p.Seq + 1)
+			done = true
+		}
+		buf = buf[:0]
+		for _, p := range pages {
+			buf = ogg.AppendPage(buf, p)
+		}
+		if _, err := dst.Write(buf); err != nil {
+			return pkgerrors.Wrap(err, "tags")
+		}
+	}
+	if !found {
+		return pkgerrors.New("tags: no Vorbis or Opus stream")
+	}
+	if !done {
+		return pkgerrors.New("tags: Ogg stream truncated")
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+package tags
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+// infoFields maps RIFF INFO chunk IDs to field names.
+var infoFields = map[string]string{
+	"INAM": fieldTitle,
+	"IART": fieldArtist,
+	"IPRD": fieldAlbum,
+	"IGNR": fieldGenre,
+	"ICRD": fieldDate,
+	"ICMT": fieldComment,
+	"ITRK": fieldTrack,
+	"IPRT": fieldTrack,
+	"ICOP": "COPYRIGHT",
+	"IENG": "ENGINEER",
+	"ISFT": "ENCODER",
+	"ISRC": "SOURCE",
+	"IKEY": "KEYWORDS",
+	"ILNG": "LANGUAGE",
+}
+
+// ParseRIFFInfo parses the body of a RIFF LIST chunk of type INFO, starting with "INFO".
+func ParseRIFFInfo(p []byte) (Tags, error) {
+	var t Tags
+	if len(p) < 4 || string(p[:4]) != "INFO" {
+		return t, pkgerrors.New("tags: not a RIFF INFO list")
+	}
+	p = p[4:]
+	for len(p) >= 8 {
+		id := string(p[:4])
+		size := int(binary.LittleEndian.Uint32(p[4:]))
+		if size > len(p)-8 {
+			return t, pkgerrors.New("tags: RIFF INFO list truncated")
+		}
+		value := strings.TrimSpace(strings.TrimRight(string(p[8:8+size]), "\x00"))
+		p = p[8+size+size%2:]
+		if value == "" {
+			continue
+		}
+		name, ok := infoFields[id]
+		if !ok {
+			continue
+		}
+		if name == fieldTrack && t.Track > 0 {
+			continue // ITRK and IPRT both present
+		}
+		t.add(name, value)
+	}
+	return t, nil
+}
+
+// appendRIFFInfo appends a LIST chunk of type INFO holding the fields of t which have an INFO
+// chunk ID to b.
+func appendRIFFInfo(b []byte, t Tags) []byte {
+	ids := map[string]string{}
+	for id, name := range infoFields {
+		if id != "IPRT" {
+			ids[name] = id
+		}
+	}
+	var body []byte
+	chunk := func(id, value string) {
+		body = append(body, id...)
+		body = binary.LittleEndian.AppendUint32(body, uint32(len(value)+1))
+		body = append(body, value...)
+		body = append(body, 0)
+		if (len(value)+1)%2 != 0 {
+			body = append(body, 0)
+		}
+	}
+	for _, f := range t.fields() {
+		if id, ok := ids[f[0]]; ok {
+			chunk(id, f[1])
+		}
+	}
+	for _, name := range t.otherFields() {
+		if id, ok := ids[name]; ok {
+			chunk(id, strings.Join(t.Fields[name], "; "))
+		}
+	}
+	if len(body) == 0 {
+		return b
+	}
+	b = append(b, "LIST"...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(4+len(body)))
+	b = append(b, "INFO"...)
+	return append(b, body...)
+}
+
+// readWAV reads the tags of a WAVE file from its LIST INFO and ID3v2 chunks. ID3v2 values take
+// precedence.
+func readWAV(r io.Reader) (Tags, error) {
+	var t, info Tags
+	err := walkRIFF(r, func(id string, size int64, body io.Reader) error {
+		switch {
+		case id == "id3 " || id == "ID3 ":
+			p, err := readChunk(body, size)
+			if err != nil {
+				return err
+			}
+			if id3, err := ParseID3v2(p); err == nil {
+				t = id3
+			}
+		case id == "LIST" && size >= 4:
+			p, err := readChunk(body, size)
+			if err != nil {
+				return err
+			}
+			if string(p[:4]) == "INFO" {
+				if i, err := ParseRIFFInfo(p); err == nil {
+					info = i
+				}
+			}
+		}
+		return nil
+	})
+	t.Merge(info)
+	return t, err
+}
+
+// maxChunkSize limits the size of metadata chunks read into memory.
+const maxChunkSize = 64 << 20
+
+func readChunk(r io.Reader, size int64) ([]byte, error) {
+	if size > maxChunkSize {
+		return nil, pkgerrors.New("tags: chunk too large")
+	}
+	p := make([]byte, size)
+	_, err := io.ReadFull(r, p)
+	return p, err
+}
+
+// walkRIFF calls chunk for each chunk of the RIFF WAVE file in r. The body reader is limited to
+// the chunk, the rest of the chunk is skipped after chunk returns. Chunks are skipped by seeking
+// if r is io.Seeker.
+func walkRIFF(r io.Reader, chunk func(id string, size int64, body io.Reader) error) error {
+	var h [12]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return pkgerrors.Wrap(err, "tags")
+	}
+	if string(h[:4]) != "RIFF" || string(h[8:]) != "WAVE" {
+		return pkgerrors.New("tags: not a WAVE file")
+	}
+	for {
+		if _, err := io.ReadFull(r, h[:8]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil // a truncated last chunk is common, ignore it
+			}
+			return pkgerrors.Wrap(err, "tags")
+		}
+		id := string(h[:4])
+		size := int64(binary.LittleEndian.Uint32(h[4:]))
+		body := &io.LimitedReader{R: r, N: size}
+		if err := chunk(id, size, body); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return pkgerrors.Wrap(err, "tags")
+		}
+		if err := skip(r, body.N+size%2); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return pkgerrors.Wrap(err, "tags")
+		}
+	}
+}
+
+// skip skips n bytes of r.
+func skip(r io.Reader, n int64) error {
+	if n == 0 {
+		return nil
+	}
+	if s, ok := r.(io.Seeker); ok {
+		_, err := s.Seek(n, io.SeekCurrent)
+		return err
+	}
+	_, err := io.CopyN(io.Discard, r, n)
+	return err
+}
+
+// riffChunk is the location of a chunk in a RIFF file.
+type riffChunk struct {
+	id   string
+	off  int64 // offset of the chunk body
+	size int64
+}
+
+// writeWAV copies the WAVE file in src to dst, replacing its LIST INFO and ID3v2 chunks with
+// ones holding t. The new metadata chunks are written before the data chunk, so that readers
+// which stop at the data chunk find them.
+func writeWAV(dst io.Writer, src io.ReadSeeker, t Tags) error {
+	start, err := src.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return pkgerrors.Wrap(err, "tags")
+	}
+	var chunks []riffChunk
+	err = walkRIFF(src, func(id string, size int64, body io.Reader) error {
+		off, err := src.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		switch id {
+		case "id3 ", "ID3 ":
+			return nil
+		case "LIST":
+			var typ [4]byte
+			if _, err := io.ReadFull(body, typ[:]); err == nil && string(typ[:]) == "INFO" {
+				return nil
+			}
+		}
+		chunks = append(chunks, riffChunk{id, off, size})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	end, err := src.Seek(0, io.SeekEnd)
+	if err != nil {
+		return pkgerrors.Wrap(err, "tags")
+	}
+	for i := range chunks {
+		// the size of the data chunk of streamed files may be unknown
+		chunks[i].size = min(chunks[i].size, end-chunks[i].off)
+	}
+
+	meta := appendRIFFInfo(nil, t)
+	meta = appendChunk(meta, "id3 ", AppendID3v2(nil, t))
+	data := len(chunks)
+	riffSize := int64(4 + len(meta))
+	for i, c := range chunks {
+		if c.id == "data" && data == len(chunks) {
+			data = i
+		}
+		riffSize += 8 + c.size + c.size%2
+	}
+	if data == len(chunks) {
+		return pkgerrors.New("tags: WAVE file has no data chunk")
+	}
+
+	h := []byte("RIFF")
+	h = binary.LittleEndian.AppendUint32(h, uint32(riffSize))
+	h = append(h, "WAVE"...)
+	if _, err := dst.Write(h); err != nil {
+		return pkgerrors.Wrap(err, "tags")
+	}
+	for i, c := range chunks {
+		if i == data {
+			if _, err := dst.Write(meta); err != nil {
+				return pkgerrors.Wrap(err, "tags")
+			}
+		}
+		if err := copyChunk(dst, src, c); err != nil {
+			return pkgerrors.Wrap(err, "tags")
+		}
+	}
+	_, err = src.Seek(start, io.SeekStart)
+	return pkgerrors.Wrap(err, "tags")
+}
+
+// copyChunk copies chunk c of src to dst, padding it to an even size.
+func copyChunk(dst io.Writer, src io.ReadSeeker, c riffChunk) error {
+	h := append([]byte(c.id), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(h[4:], uint32(c.size))
+	if _, err := dst.Write(h); err != nil {
+		return err
+	}
+	if _, err := src.Seek(c.off, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(dst, src, c.size); err != nil {
+		return err
+	}
+	if c.size%2 != 0 {
+		_, err := dst.Write([]byte{0})
+		return err
+	}
+	return nil
+}
+
+func appendChunk(b []byte, id string, p []byte) []byte {
+	b = append(b, id...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(p)))
+	b = append(b, p...)
+	if len(p)%2 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
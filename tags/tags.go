@@ -0,0 +1,376 @@
+// Package tags implements a common model of the metadata stored in audio files and reads and
+// writes it in WAVE (RIFF INFO and ID3v2 chunks), MP3 (ID3v2 and ID3v1), FLAC and Ogg Vorbis and
+// Opus files. The iTunes metadata of MP4 (M4A) files is read, but not written.
+//
+// The decoders of the wav, mp3, flac, vorbis and alac packages return streamers implementing
+// Tagger, so the tags of a decoded stream are available without reading the file again.
+package tags
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rickcollette/megasound/internal/vorbiscomment"
+)
+
+// Tags is the metadata of an audio file.
+//
+// The well known fields are stored in the struct fields, all other fields are kept in Fields.
+// When a file gives a well known text field, like ARTIST or GENRE, more than once, the values
+// are joined with "; " in the struct field.
+// Field names follow the Vorbis comment conventions, like "TITLE" or "TRACKNUMBER", and are
+// mapped to the native names of each format when reading and writing.
+type Tags struct {
+	Title       string
+	Artist      string
+	Album       string
+	AlbumArtist string
+	Genre       string
+	Date        string
+	Comment     string
+
+	Track, TrackTotal int // 0 if unknown
+	Disc, DiscTotal   int // 0 if unknown
+
+	// BPM is the tempo in beats per minute, 0 if unknown.
+	BPM float64
+
+	// Key is the initial musical key, like "Am" or "F#".
+	Key string
+
+	// ReplayGain is nil if the file holds no ReplayGain information.
+	ReplayGain *ReplayGain
+
+	// Pictures holds the embedded images, like the cover art.
+	Pictures []Picture
+
+	// Fields holds all other fields by upper case name.
+	Fields map[string][]string
+}
+
+// ReplayGain holds the ReplayGain 2.0 loudness normalization values.
+type ReplayGain struct {
+	TrackGain, AlbumGain float64 // in dB
+	TrackPeak, AlbumPeak float64 // linear, 1 is full scale
+}
+
+// Picture is an embedded image. Type is the picture type as defined by ID3v2 APIC frames, 3 is
+// the front cover.
+type Picture = vorbiscomment.Picture
+
+// Tagger is implemented by the streamers returned by decoders which read tags.
+type Tagger interface {
+	Tags() Tags
+}
+
+// Names of the well known fields.
+const (
+	fieldTitle       = "TITLE"
+	fieldArtist      = "ARTIST"
+	fieldAlbum       = "ALBUM"
+	fieldAlbumArtist = "ALBUMARTIST"
+	fieldGenre       = "GENRE"
+	fieldDate        = "DATE"
+	fieldComment     = "COMMENT"
+	fieldTrack       = "TRACKNUMBER"
+	fieldTrackTotal  = "TRACKTOTAL"
+	fieldDisc        = "DISCNUMBER"
+	fieldDiscTotal   = "DISCTOTAL"
+	fieldBPM         = "BPM"
+	fieldKey         = "INITIALKEY"
+	fieldTrackGain   = "REPLAYGAIN_TRACK_GAIN"
+	fieldTrackPeak   = "REPLAYGAIN_TRACK_PEAK"
+	fieldAlbumGain   = "REPLAYGAIN_ALBUM_GAIN"
+	fieldAlbumPeak   = "REPLAYGAIN_ALBUM_PEAK"
+	fieldPicture     = "METADATA_BLOCK_PICTURE"
+)
+
+// aliases maps alternative field names to the names used by Tags.
+var aliases = map[string]string{
+	"ALBUM ARTIST": fieldAlbumArtist,
+	"ALBUM_ARTIST": fieldAlbumArtist,
+	"YEAR":         fieldDate,
+	"DESCRIPTION":  fieldComment,
+	"TOTALTRACKS":  fieldTrackTotal,
+	"TOTALDISCS":   fieldDiscTotal,
+	"TEMPO":        fieldBPM,
+	"KEY":          fieldKey,
+}
+
+// Set sets the field with the given name to values, replacing its previous values. Field names
+// are case-insensitive. Setting no values removes the field.
+func (t *Tags) Set(name string, values ...string) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if alias, ok := aliases[name]; ok {
+		name = alias
+	}
+	first := ""
+	if len(values) > 0 {
+		first = strings.TrimSpace(values[0])
+	}
+	switch name {
+	case fieldTitle:
+		t.Title = first
+	case fieldArtist:
+		t.Artist = first
+	case fieldAlbum:
+		t.Album = first
+	case fieldAlbumArtist:
+		t.AlbumArtist = first
+	case fieldGenre:
+		t.Genre = first
+	case fieldDate:
+		t.Date = first
+	case fieldComment:
+		t.Comment = first
+	case fieldTrack:
+		t.Track, t.TrackTotal = parseNumber(first, t.TrackTotal)
+	case fieldTrackTotal:
+		t.TrackTotal, _ = strconv.Atoi(first)
+	case fieldDisc:
+		t.Disc, t.DiscTotal = parseNumber(first, t.DiscTotal)
+	case fieldDiscTotal:
+		t.DiscTotal, _ = strconv.Atoi(first)
+	case fieldBPM:
+		t.BPM, _ = strconv.ParseFloat(first, 64)
+	case fieldKey:
+		t.Key = first
+	case fieldTrackGain, fieldTrackPeak, fieldAlbumGain, fieldAlbumPeak:
+		t.setReplayGain(name, first)
+	case fieldPicture:
+		t.Pictures = t.Pictures[:0]
+		for _, v := range values {
+			p, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				continue
+			}
+			if pic, err := vorbiscomment.ParsePicture(p); err == nil {
+				t.Pictures = append(t.Pictures, pic)
+			}
+		}
+	default:
+		if len(values) == 0 {
+			delete(t.Fields, name)
+			return
+		}
+		if t.Fields == nil {
+			t.Fields = make(map[string][]string)
+		}
+		t.Fields[name] = append([]string(nil), values...)
+	}
+}
+
+// Get returns the values of the field with the given name. Field names are case-insensitive.
+func (t Tags) Get(name string) []string {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if alias, ok := aliases[name]; ok {
+		name = alias
+	}
+	for _, f := range t.fields() {
+		if f[0] == name {
+			return []string{f[1]}
+		}
+	}
+	return t.Fields[name]
+}
+
+// valueSeparator joins the values of a well known text field given more than once, like the
+// artists of a track with several.
+const valueSeparator = "; "
+
+// add adds a value to the field with the given name, keeping its previous values.
+func (t *Tags) add(name, value string) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if alias, ok := aliases[name]; ok {
+		name = alias
+	}
+	if values, ok := t.Fields[name]; ok {
+		t.Fields[name] = append(values, value)
+		return
+	}
+	if field := t.text(name); field != nil && *field != "" {
+		value = strings.TrimSpace(value)
+		for _, v := range strings.Split(*field, valueSeparator) {
+			if v == value {
+				return
+			}
+		}
+		if value != "" {
+			*field += valueSeparator + value
+		}
+		return
+	}
+	t.Set(name, value)
+}
+
+// text returns the struct field holding the well known text field with the given name, or nil
+// if it is not one.
+func (t *Tags) text(name string) *string {
+	switch name {
+	case fieldTitle:
+		return &t.Title
+	case fieldArtist:
+		return &t.Artist
+	case fieldAlbum:
+		return &t.Album
+	case fieldAlbumArtist:
+		return &t.AlbumArtist
+	case fieldGenre:
+		return &t.Genre
+	case fieldDate:
+		return &t.Date
+	case fieldComment:
+		return &t.Comment
+	}
+	return nil
+}
+
+// parseNumber parses numbers like "3" or "3/12", returning total if the value has no total.
+func parseNumber(s string, total int) (int, int) {
+	n, tot, ok := strings.Cut(s, "/")
+	x, _ := strconv.Atoi(strings.TrimSpace(n))
+	if ok {
+		total, _ = strconv.Atoi(strings.TrimSpace(tot))
+	}
+	return x, total
+}
+
+func (t *Tags) setReplayGain(name, value string) {
+	x, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "dB")), 64)
+	if err != nil {
+		return
+	}
+	if t.ReplayGain == nil {
+		t.ReplayGain = new(ReplayGain)
+	}
+	switch name {
+	case fieldTrackGain:
+		t.ReplayGain.TrackGain = x
+	case fieldTrackPeak:
+		t.ReplayGain.TrackPeak = x
+	case fieldAlbumGain:
+		t.ReplayGain.AlbumGain = x
+	case fieldAlbumPeak:
+		t.ReplayGain.AlbumPeak = x
+	}
+}
+
+// fields returns the well known fields which are set, except pictures, as name and value pairs.
+func (t Tags) fields() [][2]string {
+	var f [][2]string
+	str := func(name, value string) {
+		if value != "" {
+			f = append(f, [2]string{name, value})
+		}
+	}
+	num := func(name string, value int) {
+		if value > 0 {
+			f = append(f, [2]string{name, strconv.Itoa(value)})
+		}
+	}
+	str(fieldTitle, t.Title)
+	str(fieldArtist, t.Artist)
+	str(fieldAlbum, t.Album)
+	str(fieldAlbumArtist, t.AlbumArtist)
+	str(fieldGenre, t.Genre)
+	str(fieldDate, t.Date)
+	str(fieldComment, t.Comment)
+	num(fieldTrack, t.Track)
+	num(fieldTrackTotal, t.TrackTotal)
+	num(fieldDisc, t.Disc)
+	num(fieldDiscTotal, t.DiscTotal)
+	if t.BPM > 0 {
+		str(fieldBPM, strconv.FormatFloat(t.BPM, 'f', -1, 64))
+	}
+	str(fieldKey, t.Key)
+	if rg := t.ReplayGain; rg != nil {
+		// a peak of 0 can only mean the value is missing
+		if rg.TrackGain != 0 || rg.TrackPeak != 0 {
+			str(fieldTrackGain, fmt.Sprintf("%.2f dB", rg.TrackGain))
+			str(fieldTrackPeak, fmt.Sprintf("%.6f", rg.TrackPeak))
+		}
+		if rg.AlbumGain != 0 || rg.AlbumPeak != 0 {
+			str(fieldAlbumGain, fmt.Sprintf("%.2f dB", rg.AlbumGain))
+			str(fieldAlbumPeak, fmt.Sprintf("%.6f", rg.AlbumPeak))
+		}
+	}
+	return f
+}
+
+// otherFields returns the names of Fields in a stable order.
+func (t Tags) otherFields() []string {
+	names := make([]string, 0, len(t.Fields))
+	for name := range t.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FromComments returns the Tags stored in Vorbis comment fields in the "NAME=value" form, as
+// used by FLAC, Ogg Vorbis and Ogg Opus.
+func FromComments(fields []string) Tags {
+	var t Tags
+	var pictures []string
+	for _, f := range fields {
+		name, value, ok := strings.Cut(f, "=")
+		if !ok {
+			continue
+		}
+		if strings.EqualFold(name, fieldPicture) {
+			pictures = append(pictures, value)
+			continue
+		}
+		t.add(name, value)
+	}
+	if len(pictures) > 0 {
+		t.Set(fieldPicture, pictures...)
+	}
+	return t
+}
+
+// Comments returns t as Vorbis comment fields in the "NAME=value" form. Pictures are included
+// as METADATA_BLOCK_PICTURE fields if withPictures is true.
+func (t Tags) Comments(withPictures bool) []string {
+	var fields []string
+	for _, f := range t.fields() {
+		fields = append(fields, f[0]+"="+f[1])
+	}
+	for _, name := range t.otherFields() {
+		for _, v := range t.Fields[name] {
+			fields = append(fields, name+"="+v)
+		}
+	}
+	if withPictures {
+		for _, pic := range t.Pictures {
+			p := vorbiscomment.AppendPicture(nil, pic)
+			fields = append(fields, fieldPicture+"="+base64.StdEncoding.EncodeToString(p))
+		}
+	}
+	return fields
+}
+
+// Merge sets the fields of t which are not set to their values in o. Pictures are taken from o
+// if t has none.
+func (t *Tags) Merge(o Tags) {
+	have := make(map[string]bool)
+	for _, f := range t.fields() {
+		have[f[0]] = true
+	}
+	for _, f := range o.fields() {
+		if !have[f[0]] {
+			t.Set(f[0], f[1])
+		}
+	}
+	for _, name := range o.otherFields() {
+		if _, ok := t.Fields[name]; !ok {
+			t.Set(name, o.Fields[name]...)
+		}
+	}
+	if len(t.Pictures) == 0 {
+		t.Pictures = o.Pictures
+	}
+}
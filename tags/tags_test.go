@@ -0,0 +1,24 @@
+package tags
+
+import "testing"
+
+func TestFromCommentsRepeatedFields(t *testing.T) {
+	tg := FromComments([]string{
+		"ARTIST=Alice", "artist=Bob", "ARTIST=Alice",
+		"GENRE=Jazz", "GENRE=Funk",
+		"TITLE=Song",
+		"PERFORMER=Carol", "PERFORMER=Dave",
+	})
+	if tg.Artist != "Alice; Bob" {
+		t.Errorf("Artist = %q, want %q", tg.Artist, "Alice; Bob")
+	}
+	if tg.Genre != "Jazz; Funk" {
+		t.Errorf("Genre = %q, want %q", tg.Genre, "Jazz; Funk")
+	}
+	if tg.Title != "Song" {
+		t.Errorf("Title = %q, want %q", tg.Title, "Song")
+	}
+	if p := tg.Fields["PERFORMER"]; len(p) != 2 || p[0] != "Carol" || p[1] != "Dave" {
+		t.Errorf("PERFORMER = %q, want both values", p)
+	}
+}
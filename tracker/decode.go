@@ -0,0 +1,52 @@
+package tracker
+
+import (
+	"io"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/rickcollette/megasound"
+)
+
+func init() {
+	megasound.RegisterFormat("tracker", func(head []byte) bool {
+		return detect(head) != ""
+	}, func(rc io.ReadCloser) (megasound.StreamSeekCloser, megasound.Format, error) {
+		return Decode(rc)
+	})
+}
+
+// SampleRate is the sample rate of the streamers returned by Decode.
+const SampleRate megasound.SampleRate = 44100
+
+// Decode takes a Reader containing a module in one of the formats supported by Load and returns
+// a StreamSeekCloser, which plays that module at SampleRate with the default settings of
+// NewPlayer. The module is read completely by Decode.
+//
+// Do not close the supplied Reader, instead, use the Close method of the returned
+// StreamSeekCloser when you want to release the resources.
+func Decode(r io.Reader) (s megasound.StreamSeekCloser, format megasound.Format, err error) {
+	m, err := Load(r)
+	if err != nil {
+		if closer, ok := r.(io.Closer); ok {
+			closer.Close()
+		}
+		return nil, megasound.Format{}, err
+	}
+	format = megasound.Format{SampleRate: SampleRate, NumChannels: 2, Precision: 2}
+	return &decoder{Player: NewPlayer(m, SampleRate), r: r}, format, nil
+}
+
+type decoder struct {
+	*Player
+	r io.Reader
+}
+
+func (d *decoder) Close() error {
+	if closer, ok := d.r.(io.Closer); ok {
+		err := closer.Close()
+		if err != nil {
+			return pkgerrors.Wrap(err, "tracker")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,3 @@
+// Package tracker implements loading and playback of tracker modules: ProTracker (MOD), Scream
+// Tracker 3 (S3M), FastTracker II (XM) and Impulse Tracker (IT) modules.
+package tracker
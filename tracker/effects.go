@@ -0,0 +1,754 @@
+package tracker
+
+// channel is the state of a pattern channel.
+type channel struct {
+	index   int
+	inst    *instrument
+	smp     *sample
+	note    int     // note played, 0 to 119
+	period  float64 // see Player.period
+	target  float64 // tone portamento target period
+	c5speed float64
+	volume  int // 0 to 64
+	chanVol int // 0 to 64
+	pan     int // 0 to 256
+	voice   *voice
+
+	// effect of the current row, with the parameter taken from the memory if needed
+	fx, param int
+	vc, vp    int
+
+	// effect memories
+	porta, portaUp, portaDown        int
+	finePortaUp, finePortaDown       int
+	extraFinePorta                   int
+	tonePorta                        int
+	volSlide, fineVolUp, fineVolDown int
+	chanVolSlide, globalVolSlide     int
+	panSlide                         int
+	offset, highOffset               int
+	arp, retrig, tremor              int
+	tempoSlide, sCmd                 int
+	vibSpeed, vibDepth               int
+	vibWave, vibPos                  int
+	fineVibrato                      bool
+	tremSpeed, tremDepth             int
+	tremWave, tremPos                int
+	panbSpeed, panbDepth             int
+	panbWave, panbPos                int
+
+	// state of the current row
+	delayTick   int // tick of a delayed note, -1 if none
+	delayed     cell
+	cutTick     int // tick of a note cut, -1 if none
+	offTick     int // tick of a key off, -1 if none
+	loopRow     int // start row of a pattern loop
+	loopCount   int
+	retrigCount int
+	tremorCount int
+	tremorOff   bool
+
+	// modulation of the current tick
+	vibDelta float64
+	volDelta int
+	panDelta int
+	arpNote  int
+}
+
+// playRow plays the first tick of the current row.
+func (p *Player) playRow() {
+	m := p.m
+	pat := &m.patterns[m.Orders[p.order]]
+	p.jumpOrder, p.breakRow, p.loopRow = -1, -1, -1
+	p.extraTicks = 0
+	for i, c := range p.channels {
+		cl := pat.cells[p.row*m.Channels+i]
+		p.rowGlobal(c, cl)
+		c.delayTick, c.cutTick, c.offTick = -1, -1, -1
+		c.tremorOff = false
+		if delay := noteDelay(cl); delay > 0 {
+			c.delayTick, c.delayed = delay, cl
+			c.fx, c.vc = fxNone, vcNone
+			continue
+		}
+		p.rowChannel(c, cl)
+	}
+}
+
+// noteDelay returns the note delay in ticks of cl, 0 if none.
+func noteDelay(cl cell) int {
+	if cl.fx == fxModExtended && cl.param>>4 == 0xd || cl.fx == fxS3MExtended && cl.param>>4 == 0xd {
+		return int(cl.param & 0xf)
+	}
+	return 0
+}
+
+// playTick plays the current tick after the first one of the row.
+func (p *Player) playTick() {
+	for _, c := range p.channels {
+		if c.delayTick > 0 {
+			if p.tick < c.delayTick || p.repeat {
+				continue
+			}
+			if p.tick == c.delayTick {
+				c.delayTick = -1
+				p.rowChannel(c, c.delayed)
+				continue
+			}
+		}
+		p.tickChannel(c)
+	}
+}
+
+// rowGlobal applies the effects of cl changing the song position, speed and global volume.
+func (p *Player) rowGlobal(c *channel, cl cell) {
+	param := int(cl.param)
+	switch cl.fx {
+	case fxPositionJump:
+		p.jumpOrder = param
+	case fxPatternBreak:
+		p.breakRow = param
+	case fxSpeed:
+		if param > 0 {
+			p.speed = param
+		}
+	case fxTempo:
+		switch {
+		case param >= 0x20:
+			p.tempo = param
+		case p.it:
+			// slides on the following ticks
+			if param == 0 {
+				param = c.tempoSlide
+			}
+			c.tempoSlide = param
+		}
+	case fxGlobalVolume:
+		p.globalVol = clamp(param, 0, 128)
+	case fxModExtended, fxS3MExtended:
+		if cl.fx == fxS3MExtended && p.it {
+			if param == 0 {
+				param = c.sCmd
+			}
+			c.sCmd = param
+		}
+		x := param & 0xf
+		switch cmd := param >> 4; {
+		case cmd == 0x6 && cl.fx == fxModExtended, cmd == 0xb && cl.fx == fxS3MExtended:
+			p.patternLoop(c, x)
+		case cmd == 0xe:
+			if !p.repeat && p.patDelay == 0 {
+				p.patDelay = x
+			}
+		case cmd == 0x6:
+			p.extraTicks += x
+		}
+	}
+}
+
+// patternLoop applies a pattern loop command with parameter x.
+func (p *Player) patternLoop(c *channel, x int) {
+	switch {
+	case x == 0:
+		c.loopRow = p.row
+	case c.loopCount == 0:
+		c.loopCount = x
+		p.loopRow = c.loopRow
+	default:
+		c.loopCount--
+		if c.loopCount > 0 {
+			p.loopRow = c.loopRow
+		}
+	}
+}
+
+// rowChannel plays cell cl on channel c, on the first tick of the row or of a note delay.
+func (p *Player) rowChannel(c *channel, cl cell) {
+	m := p.m
+	c.fx, c.param = int(cl.fx), p.memory(c, cl)
+	c.vc, c.vp = int(cl.vc), int(cl.vp)
+	porta := c.fx == fxTonePorta || c.fx == fxTonePortaVol || c.vc == vcTonePorta
+	if c.fx == fxS3MExtended && c.param>>4 == 0xa {
+		c.highOffset = c.param & 0xf
+	}
+
+	var ins *instrument
+	if cl.inst > 0 {
+		if int(cl.inst) <= len(m.instruments) {
+			ins = m.instruments[cl.inst-1]
+		}
+		c.inst = ins
+	}
+	if cl.hasNote() && c.inst != nil {
+		k := c.inst.keymap[cl.note-1]
+		var smp *sample
+		if k.sample > 0 && k.sample <= len(m.samples) {
+			smp = m.samples[k.sample-1]
+		}
+		switch {
+		case porta && c.voice != nil && c.voice.active:
+			c.target = p.period(int(k.note), c.c5speed)
+		case smp == nil:
+			c.voice.stop()
+			c.voice = nil
+		default:
+			c.smp, c.c5speed, c.note = smp, smp.c5speed, int(k.note)
+			c.period = p.period(c.note, c.c5speed)
+			c.target = c.period
+			offset := 0
+			if c.fx == fxOffset {
+				offset = c.highOffset<<16 | c.param<<8
+			}
+			p.trigger(c, offset)
+		}
+	}
+	if cl.inst > 0 && c.inst != nil {
+		// the default volume and panning of the sample of the instrument
+		if k := c.inst.keymap[c.note]; k.sample > 0 && k.sample <= len(m.samples) {
+			s := m.samples[k.sample-1]
+			c.volume = s.volume
+			switch {
+			case s.pan >= 0:
+				c.pan = s.pan
+			case c.inst.pan >= 0:
+				c.pan = c.inst.pan
+			}
+		}
+		if p.xm && !cl.hasNote() && c.voice != nil {
+			c.voice.resetEnvelopes()
+		}
+	}
+	switch cl.note {
+	case noteOff:
+		p.release(c.voice)
+	case noteCut:
+		c.voice.stop()
+		c.voice = nil
+	case noteFade:
+		if c.voice != nil {
+			c.voice.fading = true
+		}
+	}
+
+	// volume column
+	switch c.vc {
+	case vcVolume:
+		c.volume = clamp(c.vp, 0, 64)
+	case vcPanning:
+		c.pan = clamp(4*c.vp, 0, 256)
+	case vcFineVolUp:
+		c.volume = clamp(c.volume+c.vp, 0, 64)
+	case vcFineVolDown:
+		c.volume = clamp(c.volume-c.vp, 0, 64)
+	case vcVibratoSpeed:
+		if c.vp > 0 {
+			c.vibSpeed = c.vp
+		}
+	case vcVibratoDepth:
+		if c.vp > 0 {
+			c.vibDepth = c.vp
+		}
+		c.fineVibrato = false
+	case vcTonePorta:
+		if c.vp > 0 {
+			c.tonePorta = c.vp
+		}
+	}
+
+	x, y := c.param>>4, c.param&0xf
+	switch c.fx {
+	case fxPortaUp, fxPortaDown:
+		if !p.mod && !p.xm {
+			// fine and extra fine slides
+			d := 0
+			switch {
+			case c.param >= 0xf0:
+				d = 4 * y
+			case c.param >= 0xe0:
+				d = y
+			}
+			if c.fx == fxPortaUp {
+				d = -d
+			}
+			p.slidePeriod(c, float64(d))
+		}
+	case fxVibrato, fxFineVibrato:
+		if x > 0 {
+			c.vibSpeed = x
+		}
+		if y > 0 {
+			c.vibDepth = y
+		}
+		c.fineVibrato = c.fx == fxFineVibrato
+	case fxTremolo:
+		if x > 0 {
+			c.tremSpeed = x
+		}
+		if y > 0 {
+			c.tremDepth = y
+		}
+	case fxPanbrello:
+		if x > 0 {
+			c.panbSpeed = x
+		}
+		if y > 0 {
+			c.panbDepth = y
+		}
+	case fxPanning:
+		c.pan = c.param * 256 / 255
+	case fxVolSlide, fxTonePortaVol, fxVibratoVol:
+		if p.s3m || p.it {
+			c.volume = p.slide(c.volume, c.param, 64, 1, true, true)
+		}
+	case fxChanVolSlide:
+		c.chanVol = p.slide(c.chanVol, c.param, 64, 1, true, true)
+	case fxGlobalVolSlide:
+		if p.it {
+			p.globalVol = p.slide(p.globalVol, c.param, 128, 1, true, true)
+		}
+	case fxPanSlide:
+		if p.it {
+			c.pan = p.slide(c.pan, c.param, 256, -4, true, true)
+		}
+	case fxVolume:
+		c.volume = clamp(c.param, 0, 64)
+	case fxChannelVolume:
+		c.chanVol = clamp(c.param, 0, 64)
+	case fxKeyOff:
+		if c.param == 0 {
+			p.release(c.voice)
+		} else {
+			c.offTick = c.param
+		}
+	case fxEnvPosition:
+		if v := c.voice; v != nil {
+			v.volTick, v.panTick = c.param, c.param
+		}
+	case fxExtraFinePorta:
+		switch x {
+		case 1:
+			p.slidePeriod(c, float64(-y))
+		case 2:
+			p.slidePeriod(c, float64(y))
+		}
+	case fxRetrig:
+		if p.xm && cl.hasNote() {
+			c.retrigCount = 0
+		}
+	case fxModExtended:
+		p.modExtended(c, x, y)
+	case fxS3MExtended:
+		p.s3mExtended(c, x, y)
+	}
+}
+
+// memory returns the parameter of the effect of cl, taken from the memory of the effect if the
+// format has one and the parameter is 0, and updates the memory.
+func (p *Player) memory(c *channel, cl cell) int {
+	param := int(cl.param)
+	mem := func(m *int) int {
+		if param == 0 {
+			return *m
+		}
+		*m = param
+		return param
+	}
+	switch cl.fx {
+	case fxPortaUp, fxPortaDown:
+		switch {
+		case p.mod:
+		case p.xm && cl.fx == fxPortaUp:
+			return mem(&c.portaUp)
+		case p.xm:
+			return mem(&c.portaDown)
+		default:
+			return mem(&c.porta)
+		}
+	case fxVolSlide, fxTonePortaVol, fxVibratoVol:
+		if !p.mod {
+			return mem(&c.volSlide)
+		}
+	case fxOffset:
+		return mem(&c.offset)
+	case fxArpeggio:
+		return mem(&c.arp)
+	case fxRetrig:
+		return mem(&c.retrig)
+	case fxTremor:
+		return mem(&c.tremor)
+	case fxChanVolSlide:
+		return mem(&c.chanVolSlide)
+	case fxGlobalVolSlide:
+		return mem(&c.globalVolSlide)
+	case fxPanSlide:
+		return mem(&c.panSlide)
+	case fxTonePorta:
+		return mem(&c.tonePorta)
+	case fxExtraFinePorta:
+		if param&0xf == 0 {
+			return param | c.extraFinePorta
+		}
+		c.extraFinePorta = param & 0xf
+	case fxS3MExtended:
+		if p.it {
+			return mem(&c.sCmd)
+		}
+	}
+	return param
+}
+
+// modExtended applies the MOD and XM E commands on the first tick.
+func (p *Player) modExtended(c *channel, x, y int) {
+	// fine slides have a memory in XM modules
+	mem := func(m *int) int {
+		if p.xm {
+			if y == 0 {
+				return *m
+			}
+			*m = y
+		}
+		return y
+	}
+	switch x {
+	case 0x1:
+		p.slidePeriod(c, float64(-4*mem(&c.finePortaUp)))
+	case 0x2:
+		p.slidePeriod(c, float64(4*mem(&c.finePortaDown)))
+	case 0x4:
+		c.vibWave = y
+	case 0x7:
+		c.tremWave = y
+	case 0x8:
+		c.pan = y * 256 / 15
+	case 0xa:
+		c.volume = clamp(c.volume+mem(&c.fineVolUp), 0, 64)
+	case 0xb:
+		c.volume = clamp(c.volume-mem(&c.fineVolDown), 0, 64)
+	case 0xc:
+		p.noteCut(c, y)
+	}
+}
+
+// s3mExtended applies the S3M and IT S commands on the first tick.
+func (p *Player) s3mExtended(c *channel, x, y int) {
+	switch x {
+	case 0x3:
+		c.vibWave = y
+	case 0x4:
+		c.tremWave = y
+	case 0x5:
+		c.panbWave = y
+	case 0x7:
+		p.instrumentControl(c, y)
+	case 0x8:
+		c.pan = y * 256 / 15
+	case 0xc:
+		if y == 0 && p.it {
+			y = 1
+		}
+		p.noteCut(c, y)
+	}
+}
+
+// instrumentControl applies an IT S7x command, controlling new note actions and envelopes.
+func (p *Player) instrumentControl(c *channel, x int) {
+	v := c.voice
+	switch {
+	case x <= 2:
+		// past note actions
+		for _, bv := range p.voices {
+			if bv.ch != c.index || !bv.active {
+				continue
+			}
+			switch x {
+			case 0:
+				bv.stop()
+			case 1:
+				p.release(bv)
+			case 2:
+				bv.fading = true
+			}
+		}
+	case v == nil:
+	case x <= 6:
+		v.nna = [...]int{nnaCut, nnaContinue, nnaOff, nnaFade}[x-3]
+	case x <= 0xc && v.ins != nil:
+		e := [...]*envelope{&v.ins.volEnv, &v.ins.panEnv, &v.ins.pitchEnv}[(x-7)/2]
+		v.envOn[(x-7)/2] = (x-7)%2 == 1 && len(e.points) > 0
+	}
+}
+
+// noteCut cuts the note of c at tick t of the row.
+func (p *Player) noteCut(c *channel, t int) {
+	if t == 0 {
+		c.volume = 0
+		return
+	}
+	c.cutTick = t
+}
+
+// tickChannel applies the effects of c on ticks after the first one of the row.
+func (p *Player) tickChannel(c *channel) {
+	x, y := c.param>>4, c.param&0xf
+	switch c.fx {
+	case fxArpeggio:
+		switch p.tick % 3 {
+		case 1:
+			c.arpNote = x
+		case 2:
+			c.arpNote = y
+		}
+	case fxPortaUp, fxPortaDown:
+		if (p.s3m || p.it) && c.param >= 0xe0 {
+			break
+		}
+		d := float64(4 * c.param)
+		if c.fx == fxPortaUp {
+			d = -d
+		}
+		p.slidePeriod(c, d)
+	case fxTonePorta:
+		p.tonePortamento(c, c.tonePorta)
+	case fxTonePortaVol:
+		p.tonePortamento(c, c.tonePorta)
+		c.volume = p.slide(c.volume, c.param, 64, 1, p.s3m || p.it, false)
+	case fxVibrato, fxFineVibrato:
+		p.vibrato(c)
+	case fxVibratoVol:
+		p.vibrato(c)
+		c.volume = p.slide(c.volume, c.param, 64, 1, p.s3m || p.it, false)
+	case fxTremolo:
+		c.volDelta = p.wave(c.tremWave&3, c.tremPos) * c.tremDepth >> 6
+		c.tremPos += c.tremSpeed
+	case fxPanbrello:
+		c.panDelta = p.wave(c.panbWave&3, c.panbPos) * c.panbDepth >> 5
+		c.panbPos += c.panbSpeed
+	case fxVolSlide:
+		c.volume = p.slide(c.volume, c.param, 64, 1, p.s3m || p.it, false)
+	case fxChanVolSlide:
+		c.chanVol = p.slide(c.chanVol, c.param, 64, 1, true, false)
+	case fxGlobalVolSlide:
+		if p.it {
+			p.globalVol = p.slide(p.globalVol, c.param, 128, 1, true, false)
+		} else {
+			// XM global volume is doubled
+			p.globalVol = p.slide(p.globalVol, c.param, 128, 2, false, false)
+		}
+	case fxPanSlide:
+		if p.it {
+			c.pan = p.slide(c.pan, c.param, 256, -4, true, false)
+		} else {
+			c.pan = p.slide(c.pan, c.param, 256, 1, false, false)
+		}
+	case fxTempo:
+		if p.it && c.param < 0x20 {
+			if x == 0 {
+				p.tempo = clamp(p.tempo-y, 32, 255)
+			} else {
+				p.tempo = clamp(p.tempo+y, 32, 255)
+			}
+		}
+	case fxRetrig:
+		if y > 0 {
+			c.retrigCount++
+			if c.retrigCount >= y {
+				c.retrigCount = 0
+				p.retrigger(c, x)
+			}
+		}
+	case fxTremor:
+		on, off := x+1, y+1
+		if p.it && !p.m.oldEffects {
+			on, off = x, y
+			if on == 0 {
+				on = 1
+			}
+			if off == 0 {
+				off = 1
+			}
+		}
+		c.tremorOff = c.tremorCount%(on+off) >= on
+		c.tremorCount++
+	case fxModExtended:
+		if x == 0x9 && y > 0 && p.tick%y == 0 {
+			p.retrigger(c, 0)
+		}
+	}
+
+	switch c.vc {
+	case vcVolSlideUp:
+		c.volume = clamp(c.volume+c.vp, 0, 64)
+	case vcVolSlideDown:
+		c.volume = clamp(c.volume-c.vp, 0, 64)
+	case vcPanSlideLeft:
+		c.pan = clamp(c.pan-4*c.vp, 0, 256)
+	case vcPanSlideRight:
+		c.pan = clamp(c.pan+4*c.vp, 0, 256)
+	case vcVibratoDepth:
+		p.vibrato(c)
+	case vcTonePorta:
+		p.tonePortamento(c, c.tonePorta)
+	case vcPortaUp:
+		p.slidePeriod(c, float64(-4*c.vp))
+	case vcPortaDown:
+		p.slidePeriod(c, float64(4*c.vp))
+	}
+
+	switch p.tick {
+	case c.cutTick:
+		if p.it {
+			c.voice.stop()
+			c.voice = nil
+		} else {
+			c.volume = 0
+		}
+	case c.offTick:
+		p.release(c.voice)
+	}
+}
+
+// slide returns v slid by a volume slide command with parameter param, limited to 0 to max.
+// Commands xF and Fy are fine slides on the first tick if fine is set, other commands slide on
+// the following ticks. The amount is multiplied by unit, which is negative for commands
+// sliding down with x.
+func (p *Player) slide(v, param, max, unit int, fine, first bool) int {
+	x, y := param>>4, param&0xf
+	switch {
+	case fine && y == 0xf && x != 0:
+		if first {
+			v += x * unit
+		}
+	case fine && x == 0xf && y != 0:
+		if first {
+			v -= y * unit
+		}
+	case first:
+	case x != 0:
+		v += x * unit
+	default:
+		v -= y * unit
+	}
+	return clamp(v, 0, max)
+}
+
+// slidePeriod adds d to the period of c.
+func (p *Player) slidePeriod(c *channel, d float64) {
+	c.period += d
+	if p.mod {
+		// the limits of ProTracker
+		if c.period < 113*4 {
+			c.period = 113 * 4
+		}
+		if c.period > 856*4 {
+			c.period = 856 * 4
+		}
+	}
+	if !p.m.linear && c.period < 1 {
+		c.period = 1
+	}
+}
+
+// tonePortamento slides the period of c towards the target period.
+func (p *Player) tonePortamento(c *channel, speed int) {
+	d := float64(4 * speed)
+	switch {
+	case c.period < c.target:
+		c.period += d
+		if c.period > c.target {
+			c.period = c.target
+		}
+	case c.period > c.target:
+		c.period -= d
+		if c.period < c.target {
+			c.period = c.target
+		}
+	}
+}
+
+// vibrato applies the vibrato of c to the current tick.
+func (p *Player) vibrato(c *channel) {
+	d := p.wave(c.vibWave&3, c.vibPos) * c.vibDepth
+	if c.fineVibrato {
+		c.vibDelta = float64(d) / 128
+	} else {
+		c.vibDelta = float64(d) / 32
+	}
+	c.vibPos += c.vibSpeed
+}
+
+// trigger starts playing the sample of c at offset, ending the note played before.
+func (p *Player) trigger(c *channel, offset int) {
+	if old := c.voice; old != nil && old.active {
+		// the old voice is ramped to silence or, with IT new note actions, continues
+		switch {
+		case !p.it || old.nna == nnaCut:
+			old.stop()
+		case old.nna == nnaOff:
+			p.release(old)
+		case old.nna == nnaFade:
+			old.fading = true
+		}
+		p.background(old)
+	}
+	v := newVoice(c.index, c.smp, c.inst)
+	if offset > 0 {
+		if offset >= c.smp.length() {
+			if p.it && p.m.oldEffects || p.s3m {
+				offset = 0
+			} else {
+				v.active = false
+			}
+		}
+		v.pos = float64(offset)
+	}
+	c.voice = v
+	if c.vibWave&4 == 0 {
+		c.vibPos = 0
+	}
+	if c.tremWave&4 == 0 {
+		c.tremPos = 0
+	}
+	c.tremorCount = 0
+}
+
+// retrigger restarts the note of c, changing the volume as given by the retrigger command x.
+func (p *Player) retrigger(c *channel, x int) {
+	if c.smp == nil {
+		return
+	}
+	p.trigger(c, 0)
+	switch {
+	case x >= 1 && x <= 5:
+		c.volume -= 1 << (x - 1)
+	case x == 6:
+		c.volume = c.volume * 2 / 3
+	case x == 7:
+		c.volume /= 2
+	case x >= 9 && x <= 0xd:
+		c.volume += 1 << (x - 9)
+	case x == 0xe:
+		c.volume = c.volume * 3 / 2
+	case x == 0xf:
+		c.volume *= 2
+	}
+	c.volume = clamp(c.volume, 0, 64)
+}
+
+// release releases the note of v, a key off.
+func (p *Player) release(v *voice) {
+	if v == nil || !v.active {
+		return
+	}
+	v.released = true
+	switch {
+	case v.ins == nil:
+	case p.xm:
+		if v.envOn[0] {
+			v.fading = true
+		} else {
+			v.stop()
+		}
+	case !v.envOn[0] || v.ins.volEnv.loop:
+		v.fading = true
+	}
+}
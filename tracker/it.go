@@ -0,0 +1,605 @@
+package tracker
+
+import (
+	pkgerrors "github.com/pkg/errors"
+)
+
+// loadIT loads an Impulse Tracker module.
+func loadIT(data []byte) (*Module, error) {
+	r := &reader{p: data}
+	r.seek(4)
+	m := &Module{
+		Title:  cstring(r.bytes(26)),
+		Format: "IT",
+	}
+	r.seek(32)
+	numOrders, numInst, numSamples, numPatterns := r.u16(), r.u16(), r.u16(), r.u16()
+	r.u16() // created with tracker version
+	cmwt := r.u16()
+	flags := r.u16()
+	r.u16() // special
+	m.globalVol = r.u8()
+	mixVol := r.u8()
+	m.speed, m.tempo = r.u8(), r.u8()
+	sep := r.u8()
+	r.seek(64)
+	chanPan, chanVol := r.bytes(64), r.bytes(64)
+	if r.eof {
+		return nil, pkgerrors.New("truncated header")
+	}
+	if numOrders > 256 || numInst > 255 || numSamples > 4000 || numPatterns > 256 {
+		return nil, pkgerrors.New("invalid header")
+	}
+	m.linear = flags&8 != 0
+	m.oldEffects = flags&16 != 0
+	m.compatGxx = flags&32 != 0
+	useInstruments := flags&4 != 0
+	if m.globalVol > 128 {
+		m.globalVol = 128
+	}
+	if m.speed == 0 {
+		m.speed = 6
+	}
+	if m.tempo < 32 {
+		m.tempo = 125
+	}
+	m.mixVol = float64(mixVol) / 48
+	if mixVol == 0 || mixVol > 128 {
+		m.mixVol = 1
+	}
+	m.separation = float64(sep) / 128
+	if sep > 128 {
+		m.separation = 1
+	}
+
+	for _, o := range r.bytes(numOrders) {
+		switch {
+		case o == 254:
+			m.Orders = append(m.Orders, OrderSkip)
+		case o == 255:
+			m.Orders = append(m.Orders, OrderEnd)
+		default:
+			m.Orders = append(m.Orders, int(o))
+		}
+	}
+	instPtrs := make([]int, numInst)
+	for i := range instPtrs {
+		instPtrs[i] = r.u32()
+	}
+	samplePtrs := make([]int, numSamples)
+	for i := range samplePtrs {
+		samplePtrs[i] = r.u32()
+	}
+	patPtrs := make([]int, numPatterns)
+	for i := range patPtrs {
+		patPtrs[i] = r.u32()
+	}
+	if r.eof {
+		return nil, pkgerrors.New("truncated header")
+	}
+
+	for i, ptr := range samplePtrs {
+		s, err := loadITSample(data, ptr)
+		if err != nil {
+			return nil, pkgerrors.Wrapf(err, "sample %d", i+1)
+		}
+		m.samples = append(m.samples, s)
+		m.Samples = append(m.Samples, s.name)
+	}
+	if useInstruments {
+		for i, ptr := range instPtrs {
+			ins, err := loadITInstrument(data, ptr, cmwt < 0x200, numSamples)
+			if err != nil {
+				return nil, pkgerrors.Wrapf(err, "instrument %d", i+1)
+			}
+			m.instruments = append(m.instruments, ins)
+			m.Instruments = append(m.Instruments, ins.name)
+		}
+	} else {
+		for i, s := range m.samples {
+			m.instruments = append(m.instruments, sampleInstrument(s.name, i+1))
+		}
+	}
+
+	channels := 0
+	for _, ptr := range patPtrs {
+		pat, used, err := loadITPattern(data, ptr)
+		if err != nil {
+			return nil, err
+		}
+		if used > channels {
+			channels = used
+		}
+		m.patterns = append(m.patterns, pat)
+	}
+	if channels == 0 {
+		channels = 1
+	}
+	// patterns were loaded with 64 channels
+	m.Channels = channels
+	for i, pat := range m.patterns {
+		p := newPattern(pat.rows, channels)
+		for row := 0; row < pat.rows; row++ {
+			copy(p.cells[row*channels:(row+1)*channels], pat.cells[row*64:row*64+channels])
+		}
+		m.patterns[i] = p
+	}
+	for c := 0; c < channels; c++ {
+		pan := int(chanPan[c] & 0x7f)
+		switch {
+		case pan == 100: // surround
+			pan = 128
+		case pan > 64:
+			pan = 128
+		default:
+			pan *= 4
+		}
+		vol := int(chanVol[c])
+		if vol > 64 {
+			vol = 64
+		}
+		m.chanPan = append(m.chanPan, pan)
+		m.chanVol = append(m.chanVol, vol)
+		m.chanMute = append(m.chanMute, chanPan[c]&0x80 != 0)
+	}
+	return m, nil
+}
+
+// loadITPattern loads a pattern with 64 channels and returns the number of channels used.
+func loadITPattern(data []byte, ptr int) (pattern, int, error) {
+	if ptr == 0 {
+		return newPattern(64, 64), 0, nil
+	}
+	r := &reader{p: data}
+	r.seek(ptr)
+	length, rows := r.u16(), r.u16()
+	r.u32()
+	if r.eof || rows < 1 || rows > 200 {
+		return pattern{}, 0, pkgerrors.New("invalid pattern")
+	}
+	pat := newPattern(rows, 64)
+	end := r.pos + length
+	var (
+		masks [64]int
+		last  [64]cell
+		used  int
+	)
+	for row := 0; row < rows && r.pos < end && !r.eof; {
+		cv := r.u8()
+		if cv == 0 {
+			row++
+			continue
+		}
+		c := (cv - 1) & 63
+		if cv&0x80 != 0 {
+			masks[c] = r.u8()
+		}
+		mask := masks[c]
+		cl := &last[c]
+		if mask&1 != 0 {
+			note := r.u8()
+			switch {
+			case note < 120:
+				cl.note = uint8(note + 1)
+			case note == 255:
+				cl.note = noteOff
+			case note == 254:
+				cl.note = noteCut
+			default:
+				cl.note = noteFade
+			}
+		}
+		if mask&2 != 0 {
+			cl.inst = uint8(r.u8())
+		}
+		if mask&4 != 0 {
+			cl.vc, cl.vp = convertITVolume(r.u8())
+		}
+		if mask&8 != 0 {
+			cl.fx, cl.param = convertS3MEffect(r.u8(), r.u8(), true)
+		}
+		var out cell
+		if mask&(1|16) != 0 {
+			out.note = cl.note
+		}
+		if mask&(2|32) != 0 {
+			out.inst = cl.inst
+		}
+		if mask&(4|64) != 0 {
+			out.vc, out.vp = cl.vc, cl.vp
+		}
+		if mask&(8|128) != 0 {
+			out.fx, out.param = cl.fx, cl.param
+		}
+		pat.cells[row*64+c] = out
+		if c+1 > used {
+			used = c + 1
+		}
+	}
+	return pat, used, nil
+}
+
+// convertITVolume converts an IT volume column byte.
+func convertITVolume(v int) (uint8, uint8) {
+	switch {
+	case v <= 64:
+		return vcVolume, uint8(v)
+	case v <= 74:
+		return vcFineVolUp, uint8(v - 65)
+	case v <= 84:
+		return vcFineVolDown, uint8(v - 75)
+	case v <= 94:
+		return vcVolSlideUp, uint8(v - 85)
+	case v <= 104:
+		return vcVolSlideDown, uint8(v - 95)
+	case v <= 114:
+		return vcPortaDown, uint8(4 * (v - 105))
+	case v <= 124:
+		return vcPortaUp, uint8(4 * (v - 115))
+	case v >= 128 && v <= 192:
+		return vcPanning, uint8(v - 128)
+	case v >= 193 && v <= 202:
+		speeds := [...]uint8{0, 1, 4, 8, 16, 32, 64, 96, 128, 255}
+		return vcTonePorta, speeds[v-193]
+	case v >= 203 && v <= 212:
+		return vcVibratoDepth, uint8(v - 203)
+	}
+	return vcNone, 0
+}
+
+// loadITInstrument loads an instrument in the format of Impulse Tracker 2 or, if old is set,
+// Impulse Tracker 1.
+func loadITInstrument(data []byte, ptr int, old bool, numSamples int) (*instrument, error) {
+	r := &reader{p: data}
+	r.seek(ptr)
+	if string(r.bytes(4)) != "IMPI" {
+		return nil, pkgerrors.New("invalid instrument header")
+	}
+	r.bytes(13) // file name
+	ins := &instrument{globalVol: 128, pan: -1}
+	var (
+		volEnv          envelope
+		flags, fadeout  int
+		volLoopStart    int
+		volLoopEnd      int
+		volSusStart     int
+		volSusEnd       int
+		envNodes        []byte
+		panEnv, pitchEn envelope
+	)
+	if old {
+		flags = r.u8()
+		volLoopStart, volLoopEnd, volSusStart, volSusEnd = r.u8(), r.u8(), r.u8(), r.u8()
+		r.u16()
+		fadeout = r.u16()
+		ins.nna = itNNA(r.u8())
+		r.bytes(5)
+	} else {
+		ins.nna = itNNA(r.u8())
+		r.bytes(2) // duplicate check type and action
+		fadeout = r.u16()
+		r.bytes(2) // pitch pan separation and center
+		ins.globalVol = r.u8()
+		if pan := r.u8(); pan&0x80 == 0 && pan <= 64 {
+			ins.pan = 4 * pan
+		}
+		r.bytes(6)
+	}
+	ins.name = cstring(r.bytes(26))
+	r.seek(ptr + 64)
+	keymap := r.bytes(240)
+	for i := range ins.keymap {
+		note, smp := int(keymap[2*i]), int(keymap[2*i+1])
+		if note > 119 {
+			note = i
+		}
+		if smp > numSamples {
+			smp = 0
+		}
+		ins.keymap[i] = keymapEntry{note: uint8(note), sample: smp}
+	}
+	if old {
+		// volume envelope nodes, tick and value pairs ended by a tick of 0xff
+		r.seek(ptr + 504)
+		envNodes = r.bytes(50)
+		volEnv = envelope{
+			enabled:   flags&1 != 0,
+			loop:      flags&2 != 0,
+			sus:       flags&4 != 0,
+			loopStart: volLoopStart,
+			loopEnd:   volLoopEnd,
+			susStart:  volSusStart,
+			susEnd:    volSusEnd,
+		}
+		for i := 0; i+1 < len(envNodes) && envNodes[i] != 0xff; i += 2 {
+			volEnv.points = append(volEnv.points, envPoint{tick: int(envNodes[i]), value: int(envNodes[i+1])})
+		}
+		volEnv.sanitize()
+		ins.fadeout = float64(fadeout) / 512
+	} else {
+		r.seek(ptr + 304)
+		volEnv = itEnvelope(r, false)
+		panEnv = itEnvelope(r, true)
+		pitchEn = itEnvelope(r, true)
+		ins.fadeout = float64(fadeout) / 1024
+		if ins.globalVol > 128 {
+			ins.globalVol = 128
+		}
+	}
+	if r.eof {
+		return nil, pkgerrors.New("truncated instrument")
+	}
+	ins.volEnv, ins.panEnv, ins.pitchEnv = volEnv, panEnv, pitchEn
+	return ins, nil
+}
+
+// itNNA converts the new note action of an IT instrument.
+func itNNA(v int) int {
+	switch v {
+	case 1:
+		return nnaContinue
+	case 2:
+		return nnaOff
+	case 3:
+		return nnaFade
+	}
+	return nnaCut
+}
+
+// itEnvelope reads an envelope of an IT2 instrument. Values of signed envelopes are -32 to 32.
+func itEnvelope(r *reader, signed bool) envelope {
+	flags := r.u8()
+	n := r.u8()
+	e := envelope{
+		enabled:   flags&1 != 0,
+		loop:      flags&2 != 0,
+		sus:       flags&4 != 0,
+		loopStart: r.u8(),
+		loopEnd:   r.u8(),
+		susStart:  r.u8(),
+		susEnd:    r.u8(),
+	}
+	if flags&0x80 != 0 {
+		e.enabled = false // filter envelope, not supported
+	}
+	if n > 25 {
+		n = 25
+	}
+	nodes := r.bytes(75)
+	r.u8()
+	for i := 0; i < n; i++ {
+		v := int(nodes[3*i])
+		if signed {
+			v = int(int8(nodes[3*i]))
+		}
+		tick := int(nodes[3*i+1]) | int(nodes[3*i+2])<<8
+		e.points = append(e.points, envPoint{tick: tick, value: v})
+	}
+	e.sanitize()
+	return e
+}
+
+// loadITSample loads the sample whose header is at ptr.
+func loadITSample(data []byte, ptr int) (*sample, error) {
+	r := &reader{p: data}
+	r.seek(ptr)
+	if string(r.bytes(4)) != "IMPS" {
+		return nil, pkgerrors.New("invalid sample header")
+	}
+	r.bytes(13) // file name
+	globalVol := r.u8()
+	flags := r.u8()
+	volume := r.u8()
+	s := &sample{
+		name:      cstring(r.bytes(26)),
+		volume:    volume,
+		globalVol: globalVol,
+		pan:       -1,
+	}
+	cvt := r.u8()
+	if pan := r.u8(); pan&0x80 != 0 && pan&0x7f <= 64 {
+		s.pan = 4 * int(pan&0x7f)
+	}
+	length, loopStart, loopEnd := r.u32(), r.u32(), r.u32()
+	s.c5speed = float64(r.u32())
+	susStart, susEnd := r.u32(), r.u32()
+	dataPtr := r.u32()
+	s.vibRate, s.vibDepth = r.u8(), r.u8()
+	sweep := r.u8()
+	s.vibType = r.u8() & 3
+	if r.eof {
+		return nil, pkgerrors.New("truncated sample header")
+	}
+	// the sweep is the rate at which the depth grows, the player takes the ticks to full depth
+	if sweep > 0 {
+		s.vibSweep = s.vibDepth * 256 / sweep
+	}
+	if s.volume > 64 {
+		s.volume = 64
+	}
+	if s.globalVol > 64 {
+		s.globalVol = 64
+	}
+	if s.c5speed <= 0 {
+		s.c5speed = 8363
+	}
+	if flags&1 == 0 || length == 0 {
+		return s, nil
+	}
+	if length > 1<<26 {
+		return nil, pkgerrors.New("sample too long")
+	}
+
+	bits16 := flags&2 != 0
+	stereo := flags&4 != 0
+	signed := cvt&1 != 0
+	p := r.tail(dataPtr)
+	if flags&8 != 0 {
+		it215 := cvt&4 != 0
+		var n int
+		s.left, n = itDecompress(p, length, bits16, it215)
+		if stereo {
+			s.right, _ = itDecompress(p[n:], length, bits16, it215)
+		}
+	} else {
+		width := 1
+		if bits16 {
+			width = 2
+		}
+		decode := func(p []byte) []float32 {
+			if bits16 {
+				return pcm16(p, length, signed, false)
+			}
+			return pcm8(p, length, signed, false)
+		}
+		s.left = decode(p)
+		if stereo && len(p) > length*width {
+			s.right = decode(p[length*width:])
+		}
+	}
+
+	if flags&16 != 0 {
+		s.loop = loopForward
+		if flags&64 != 0 {
+			s.loop = loopPingPong
+		}
+		s.loopStart, s.loopEnd = loopStart, loopEnd
+	}
+	if flags&32 != 0 {
+		s.sus = loopForward
+		if flags&128 != 0 {
+			s.sus = loopPingPong
+		}
+		s.susStart, s.susEnd = susStart, susEnd
+	}
+	s.clampLoops()
+	return s, nil
+}
+
+// itDecompress decodes n samples compressed with the IT 2.14 or, if it215 is set, IT 2.15
+// algorithm. It returns the samples and the number of bytes read.
+func itDecompress(p []byte, n int, bits16, it215 bool) ([]float32, int) {
+	out := make([]float32, n)
+	blockLen, fullWidth := 0x8000, 9
+	if bits16 {
+		blockLen, fullWidth = 0x4000, 17
+	}
+	pos := 0
+	for i := 0; i < n; {
+		if pos+2 > len(p) {
+			break
+		}
+		size := int(p[pos]) | int(p[pos+1])<<8
+		pos += 2
+		if pos+size > len(p) {
+			size = len(p) - pos
+		}
+		br := itBitReader{p: p[pos : pos+size]}
+		pos += size
+
+		width := fullWidth
+		var d1, d2 int32
+		for k := 0; k < blockLen && i < n; {
+			if br.overrun() {
+				break
+			}
+			v := br.read(width)
+			switch {
+			case width < 7:
+				// method 1, a value of 1 << (width-1) changes the width
+				if v == 1<<(width-1) {
+					if bits16 {
+						v = br.read(4) + 1
+					} else {
+						v = br.read(3) + 1
+					}
+					if v >= width {
+						v++
+					}
+					width = v
+					continue
+				}
+			case width < fullWidth:
+				// method 2, values around the maximum change the width
+				var border, span int
+				if bits16 {
+					border, span = 0xffff>>(17-width)-8, 16
+				} else {
+					border, span = 0xff>>(9-width)-4, 8
+				}
+				if v > border && v <= border+span {
+					v -= border
+					if v >= width {
+						v++
+					}
+					width = v
+					continue
+				}
+			case width == fullWidth:
+				// method 3, the highest bit set changes the width
+				if v&(1<<(fullWidth-1)) != 0 {
+					width = (v + 1) & 0xff
+					continue
+				}
+			default:
+				k = blockLen // invalid width
+				continue
+			}
+
+			var x int32
+			if bits16 {
+				if width < 16 {
+					shift := uint(16 - width)
+					x = int32(int16(uint16(v)<<shift) >> shift)
+				} else {
+					x = int32(int16(v))
+				}
+				d1 = int32(int16(d1 + x))
+				d2 = int32(int16(d2 + d1))
+			} else {
+				if width < 8 {
+					shift := uint(8 - width)
+					x = int32(int8(uint8(v)<<shift) >> shift)
+				} else {
+					x = int32(int8(v))
+				}
+				d1 = int32(int8(d1 + x))
+				d2 = int32(int8(d2 + d1))
+			}
+			y := d1
+			if it215 {
+				y = d2
+			}
+			if bits16 {
+				out[i] = float32(y) / 32768
+			} else {
+				out[i] = float32(y) / 128
+			}
+			i++
+			k++
+		}
+	}
+	return out, pos
+}
+
+// itBitReader reads the bits of compressed IT samples, least significant bit first.
+type itBitReader struct {
+	p   []byte
+	pos int // in bits
+}
+
+func (br *itBitReader) overrun() bool {
+	return br.pos >= 8*len(br.p)
+}
+
+func (br *itBitReader) read(n int) int {
+	v := 0
+	for i := 0; i < n; i++ {
+		b := br.pos >> 3
+		if b < len(br.p) && br.p[b]>>(br.pos&7)&1 != 0 {
+			v |= 1 << i
+		}
+		br.pos++
+	}
+	return v
+}
@@ -0,0 +1,225 @@
+package tracker
+
+import (
+	"math"
+	"strconv"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+// modChannels returns the number of channels of a MOD file with the given signature, or 0 if
+// it is not a known signature.
+func modChannels(sig string) int {
+	switch sig {
+	case "M.K.", "M!K!", "M&K!", "N.T.", "FLT4", "4CHN":
+		return 4
+	case "FLT8", "CD81", "OKTA", "OCTA":
+		return 8
+	}
+	if sig[1:] == "CHN" && sig[0] >= '1' && sig[0] <= '9' {
+		return int(sig[0] - '0')
+	}
+	if sig[2:] == "CH" || sig[2:] == "CN" {
+		if n, err := strconv.Atoi(sig[:2]); err == nil && n > 0 && n <= 32 {
+			return n
+		}
+	}
+	if sig[:3] == "TDZ" && sig[3] >= '1' && sig[3] <= '9' {
+		return int(sig[3] - '0')
+	}
+	return 0
+}
+
+// loadMOD loads a ProTracker module with 31 samples.
+func loadMOD(data []byte) (*Module, error) {
+	r := &reader{p: data}
+	channels := modChannels(string(data[1080:1084]))
+	m := &Module{
+		Title:      cstring(r.bytes(20)),
+		Format:     "MOD",
+		Channels:   channels,
+		speed:      6,
+		tempo:      125,
+		globalVol:  128,
+		mixVol:     1,
+		separation: 1,
+	}
+
+	type modSample struct {
+		length, loopStart, loopLen int
+	}
+	headers := make([]modSample, 31)
+	for i := range headers {
+		name := cstring(r.bytes(22))
+		h := modSample{length: 2 * beU16(r.bytes(2))}
+		finetune := int(int8(r.u8()<<4)) >> 4
+		volume := r.u8()
+		h.loopStart = 2 * beU16(r.bytes(2))
+		h.loopLen = 2 * beU16(r.bytes(2))
+		headers[i] = h
+		if volume > 64 {
+			volume = 64
+		}
+		s := &sample{
+			name:      name,
+			volume:    volume,
+			globalVol: 64,
+			pan:       -1,
+			c5speed:   8363 * math.Exp2(float64(finetune)/96),
+		}
+		if h.loopLen > 2 && h.loopStart+h.loopLen > 2 {
+			s.loop = loopForward
+			s.loopStart, s.loopEnd = h.loopStart, h.loopStart+h.loopLen
+		}
+		m.samples = append(m.samples, s)
+		m.Samples = append(m.Samples, name)
+		m.instruments = append(m.instruments, sampleInstrument(name, i+1))
+	}
+
+	songLen := r.u8()
+	r.u8() // restart position, the song ends instead of looping
+	orders := r.bytes(128)
+	if songLen == 0 || songLen > 128 {
+		return nil, pkgerrors.New("invalid song length")
+	}
+	numPatterns := 0
+	for _, o := range orders {
+		if int(o) >= numPatterns {
+			numPatterns = int(o) + 1
+		}
+	}
+	for _, o := range orders[:songLen] {
+		m.Orders = append(m.Orders, int(o))
+	}
+	r.seek(1084)
+
+	for i := 0; i < numPatterns; i++ {
+		pat := newPattern(64, channels)
+		for j := range pat.cells {
+			b := r.bytes(4)
+			inst := b[0]&0xf0 | b[2]>>4
+			period := int(b[0]&0x0f)<<8 | int(b[1])
+			c := &pat.cells[j]
+			c.inst = inst
+			if period > 0 {
+				c.note = uint8(periodNote(period) + 1)
+			}
+			c.fx, c.param = convertMODEffect(int(b[2]&0x0f), int(b[3]))
+		}
+		m.patterns = append(m.patterns, pat)
+	}
+	if r.eof {
+		return nil, pkgerrors.New("truncated pattern data")
+	}
+
+	for i, h := range headers {
+		p := r.tail(r.pos) // the last samples are often truncated
+		if len(p) > h.length {
+			p = p[:h.length]
+		}
+		r.pos += h.length
+		m.samples[i].left = pcm8(p, h.length, true, false)
+		m.samples[i].clampLoops()
+	}
+
+	// Amiga channels 0 and 3 are left, 1 and 2 right
+	for c := 0; c < channels; c++ {
+		pan := 64
+		if c%4 == 1 || c%4 == 2 {
+			pan = 192
+		}
+		m.chanPan = append(m.chanPan, pan)
+		m.chanVol = append(m.chanVol, 64)
+		m.chanMute = append(m.chanMute, false)
+	}
+	return m, nil
+}
+
+// beU16 decodes a big-endian 16 bit value.
+func beU16(p []byte) int {
+	return int(p[0])<<8 | int(p[1])
+}
+
+// periodNote returns the note, 0 for C-0, of an Amiga period. Period 428 is note 60, which the
+// player plays at the C-5 speed of the sample, like C-5 of S3M and IT modules.
+func periodNote(period int) int {
+	n := 48 + int(math.Round(12*math.Log2(856/float64(period))))
+	if n < 0 {
+		return 0
+	}
+	if n > 119 {
+		return 119
+	}
+	return n
+}
+
+// convertMODEffect converts a MOD or XM effect.
+func convertMODEffect(fx, param int) (uint8, uint8) {
+	p := uint8(param)
+	switch fx {
+	case 0x0:
+		if param != 0 {
+			return fxArpeggio, p
+		}
+	case 0x1:
+		return fxPortaUp, p
+	case 0x2:
+		return fxPortaDown, p
+	case 0x3:
+		return fxTonePorta, p
+	case 0x4:
+		return fxVibrato, p
+	case 0x5:
+		return fxTonePortaVol, p
+	case 0x6:
+		return fxVibratoVol, p
+	case 0x7:
+		return fxTremolo, p
+	case 0x8:
+		return fxPanning, p
+	case 0x9:
+		return fxOffset, p
+	case 0xa:
+		return fxVolSlide, p
+	case 0xb:
+		return fxPositionJump, p
+	case 0xc:
+		if p > 64 {
+			p = 64
+		}
+		return fxVolume, p
+	case 0xd:
+		return fxPatternBreak, uint8(param>>4*10 + param&0xf)
+	case 0xe:
+		return fxModExtended, p
+	case 0xf:
+		switch {
+		case param == 0:
+		case param < 0x20:
+			return fxSpeed, p
+		default:
+			return fxTempo, p
+		}
+	// XM effects
+	case 'G' - 'A' + 10:
+		if p > 64 {
+			p = 64
+		}
+		return fxGlobalVolume, 2 * p
+	case 'H' - 'A' + 10:
+		return fxGlobalVolSlide, p
+	case 'K' - 'A' + 10:
+		return fxKeyOff, p
+	case 'L' - 'A' + 10:
+		return fxEnvPosition, p
+	case 'P' - 'A' + 10:
+		return fxPanSlide, p
+	case 'R' - 'A' + 10:
+		return fxRetrig, p
+	case 'T' - 'A' + 10:
+		return fxTremor, p
+	case 'X' - 'A' + 10:
+		return fxExtraFinePorta, p
+	}
+	return fxNone, 0
+}
@@ -0,0 +1,439 @@
+package tracker
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+// Module is a tracker module loaded by Load. Its patterns, instruments and samples are
+// converted to a common representation when loading, so all formats are played by the same
+// Player.
+type Module struct {
+	// Title is the song name.
+	Title string
+
+	// Format is the format of the file: "MOD", "S3M", "XM" or "IT".
+	Format string
+
+	// Channels is the number of pattern channels.
+	Channels int
+
+	// Orders holds the pattern played at each position of the song. OrderSkip and OrderEnd
+	// are markers, not patterns.
+	Orders []int
+
+	// Instruments and Samples hold the names of the instruments and samples, which often
+	// carry messages from the author. Formats without instruments have none.
+	Instruments []string
+	Samples     []string
+
+	patterns    []pattern
+	instruments []*instrument // indexed by instrument number - 1
+	samples     []*sample
+
+	speed, tempo int
+	globalVol    int // 0 to 128
+	mixVol       float64
+	linear       bool   // linear frequency slides instead of Amiga periods
+	oldEffects   bool   // IT: old (S3M compatible) effects
+	compatGxx    bool   // IT: separate tone portamento memory
+	chanPan      []int  // initial panning of each channel, 0 to 256
+	chanVol      []int  // initial channel volume of each channel, 0 to 64
+	chanMute     []bool // channels disabled in the file
+	separation   float64
+}
+
+// Order markers of Module.Orders.
+const (
+	OrderSkip = 254 // skipped when playing
+	OrderEnd  = 255 // end of the song
+)
+
+// Patterns returns the number of patterns.
+func (m *Module) Patterns() int {
+	return len(m.patterns)
+}
+
+// PatternRows returns the number of rows of pattern i.
+func (m *Module) PatternRows(i int) int {
+	if i < 0 || i >= len(m.patterns) {
+		return 0
+	}
+	return m.patterns[i].rows
+}
+
+// pattern is a pattern, a grid of cells with a row for each step of the song and a column for
+// each channel.
+type pattern struct {
+	rows  int
+	cells []cell // rows * channels
+}
+
+func newPattern(rows, channels int) pattern {
+	return pattern{rows: rows, cells: make([]cell, rows*channels)}
+}
+
+// cell is a cell of a pattern. Effects and volume column commands are converted to the fx
+// and vc constants when loading.
+type cell struct {
+	note  uint8 // 0 for none, 1 to 120 for C-0 to B-9, or noteOff, noteCut or noteFade
+	inst  uint8 // 0 for none
+	vc    uint8 // volume column command
+	vp    uint8 // volume column parameter
+	fx    uint8 // effect
+	param uint8
+}
+
+// Special notes.
+const (
+	noteFade = 253
+	noteCut  = 254
+	noteOff  = 255
+)
+
+// hasNote reports whether c holds a note to play.
+func (c cell) hasNote() bool {
+	return c.note >= 1 && c.note <= 120
+}
+
+// Effects. The effects of all formats are converted to these when loading; the player applies
+// the rules of the format of the module where they differ, like for the memory of parameters.
+const (
+	fxNone           = iota
+	fxArpeggio       // MOD 0, S3M/IT J
+	fxPortaUp        // MOD 1, S3M/IT F
+	fxPortaDown      // MOD 2, S3M/IT E
+	fxTonePorta      // MOD 3, S3M/IT G
+	fxVibrato        // MOD 4, S3M/IT H
+	fxTonePortaVol   // MOD 5, S3M/IT L
+	fxVibratoVol     // MOD 6, S3M/IT K
+	fxTremolo        // MOD 7, S3M/IT R
+	fxPanning        // MOD 8, S3M/IT X, 0 to 255
+	fxOffset         // MOD 9, S3M/IT O
+	fxVolSlide       // MOD A, S3M/IT D
+	fxPositionJump   // MOD B, S3M/IT B
+	fxVolume         // MOD C
+	fxPatternBreak   // MOD D, S3M/IT C, the row is converted from BCD where needed
+	fxModExtended    // MOD E
+	fxSpeed          // MOD F below 0x20, S3M/IT A
+	fxTempo          // MOD F from 0x20, S3M/IT T
+	fxGlobalVolume   // XM G, S3M/IT V, 0 to 128
+	fxGlobalVolSlide // XM H, IT W
+	fxKeyOff         // XM K
+	fxEnvPosition    // XM L
+	fxPanSlide       // XM/IT P
+	fxRetrig         // XM R, S3M/IT Q
+	fxTremor         // XM T, S3M/IT I
+	fxExtraFinePorta // XM X
+	fxS3MExtended    // S3M/IT S
+	fxChannelVolume  // IT M
+	fxChanVolSlide   // IT N
+	fxFineVibrato    // S3M/IT U
+	fxPanbrello      // IT Y
+)
+
+// Volume column commands.
+const (
+	vcNone          = iota
+	vcVolume        // 0 to 64
+	vcPanning       // 0 to 64
+	vcVolSlideDown  // XM 6x, IT Dx
+	vcVolSlideUp    // XM 7x, IT Cx
+	vcFineVolDown   // XM 8x, IT Bx
+	vcFineVolUp     // XM 9x, IT Ax
+	vcVibratoSpeed  // XM Ax
+	vcVibratoDepth  // XM Bx, IT Hx
+	vcPanSlideLeft  // XM Dx
+	vcPanSlideRight // XM Ex
+	vcTonePorta     // XM Fx, IT Gx, the parameter is the portamento speed
+	vcPortaDown     // IT Ex
+	vcPortaUp       // IT Fx
+)
+
+// loopType is the kind of a sample loop.
+type loopType uint8
+
+const (
+	loopNone loopType = iota
+	loopForward
+	loopPingPong
+)
+
+// sample is a sample with its playback parameters.
+type sample struct {
+	name        string
+	left, right []float32 // right is nil for mono samples
+	loop        loopType
+	loopStart   int
+	loopEnd     int // exclusive
+	sus         loopType
+	susStart    int
+	susEnd      int
+	volume      int // default volume, 0 to 64
+	globalVol   int // 0 to 64
+	pan         int // default panning, 0 to 256, or -1
+	c5speed     float64
+	vibType     int
+	vibSweep    int
+	vibDepth    int
+	vibRate     int
+}
+
+// length returns the number of frames of s.
+func (s *sample) length() int {
+	return len(s.left)
+}
+
+// clampLoops fixes the loops of s to lie within the sample data.
+func (s *sample) clampLoops() {
+	n := s.length()
+	fix := func(t *loopType, start, end *int) {
+		if *end > n {
+			*end = n
+		}
+		if *start < 0 || *start >= *end || *end-*start < 2 && *t == loopPingPong {
+			*t = loopNone
+		}
+		if *t == loopNone {
+			*start, *end = 0, 0
+		}
+	}
+	fix(&s.loop, &s.loopStart, &s.loopEnd)
+	fix(&s.sus, &s.susStart, &s.susEnd)
+}
+
+// instrument maps notes to samples and holds the envelopes played with them. Modules without
+// instruments get an instrument for each sample.
+type instrument struct {
+	name      string
+	keymap    [120]keymapEntry
+	volEnv    envelope
+	panEnv    envelope
+	pitchEnv  envelope
+	fadeout   float64 // fraction of the volume faded per tick after the note is released
+	globalVol int     // 0 to 128
+	pan       int     // default panning, 0 to 256, or -1
+	nna       int     // new note action
+}
+
+// keymapEntry is the note and sample played for a note of an instrument.
+type keymapEntry struct {
+	note   uint8 // 0 to 119
+	sample int   // index in Module.samples + 1, 0 for none
+}
+
+// New note actions, what happens to a playing note when a new note is played on the channel.
+const (
+	nnaCut = iota
+	nnaContinue
+	nnaOff
+	nnaFade
+)
+
+// envelope is a volume, panning or pitch envelope. Values are 0 to 64 for volume and -32 to 32
+// for panning and pitch envelopes.
+type envelope struct {
+	enabled            bool
+	points             []envPoint
+	loop, sus          bool
+	loopStart, loopEnd int // point indexes
+	susStart, susEnd   int
+}
+
+type envPoint struct {
+	tick, value int
+}
+
+// value returns the value of e at tick t.
+func (e *envelope) value(t int) int {
+	ps := e.points
+	if len(ps) == 0 {
+		return 0
+	}
+	if t <= ps[0].tick {
+		return ps[0].value
+	}
+	for i := 1; i < len(ps); i++ {
+		if t < ps[i].tick {
+			a, b := ps[i-1], ps[i]
+			if b.tick == a.tick {
+				return b.value
+			}
+			return a.value + (b.value-a.value)*(t-a.tick)/(b.tick-a.tick)
+		}
+	}
+	return ps[len(ps)-1].value
+}
+
+// sanitize disables e if its points are invalid and clamps the loop and sustain points.
+func (e *envelope) sanitize() {
+	if len(e.points) == 0 {
+		e.enabled = false
+		return
+	}
+	for i := 1; i < len(e.points); i++ {
+		if e.points[i].tick < e.points[i-1].tick {
+			e.points = e.points[:i]
+			break
+		}
+	}
+	last := len(e.points) - 1
+	if e.loopStart > e.loopEnd || e.loopEnd > last {
+		e.loop = false
+	}
+	if e.susStart > e.susEnd || e.susEnd > last {
+		e.sus = false
+	}
+}
+
+// sampleInstrument returns an instrument playing s at all notes.
+func sampleInstrument(name string, s int) *instrument {
+	ins := &instrument{name: name, globalVol: 128, pan: -1}
+	for i := range ins.keymap {
+		ins.keymap[i] = keymapEntry{note: uint8(i), sample: s}
+	}
+	return ins
+}
+
+// Load loads a ProTracker (MOD), Scream Tracker 3 (S3M), FastTracker II (XM) or Impulse
+// Tracker (IT) module from r, detecting the format from its content.
+func Load(r io.Reader) (*Module, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxModuleSize+1))
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "tracker")
+	}
+	if len(data) > maxModuleSize {
+		return nil, pkgerrors.New("tracker: module too large")
+	}
+	var m *Module
+	switch detect(data) {
+	case "XM":
+		m, err = loadXM(data)
+	case "IT":
+		m, err = loadIT(data)
+	case "S3M":
+		m, err = loadS3M(data)
+	case "MOD":
+		m, err = loadMOD(data)
+	default:
+		return nil, pkgerrors.New("tracker: unknown module format")
+	}
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "tracker")
+	}
+	return m, nil
+}
+
+// maxModuleSize is the size limit of module files.
+const maxModuleSize = 256 << 20
+
+// detect returns the format of the module starting with head, or "" if it is not a module.
+func detect(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("Extended Module: ")):
+		return "XM"
+	case bytes.HasPrefix(head, []byte("IMPM")):
+		return "IT"
+	case len(head) >= 48 && string(head[44:48]) == "SCRM":
+		return "S3M"
+	case len(head) >= 1084 && modChannels(string(head[1080:1084])) > 0:
+		return "MOD"
+	}
+	return ""
+}
+
+// cstring returns the string in p up to the first NUL byte, with trailing spaces removed.
+func cstring(p []byte) string {
+	if i := bytes.IndexByte(p, 0); i >= 0 {
+		p = p[:i]
+	}
+	return strings.TrimRight(string(p), " ")
+}
+
+// reader reads little-endian values from a byte slice. Reads beyond the end return zeros and
+// set a flag checked after parsing a structure.
+type reader struct {
+	p   []byte
+	pos int
+	eof bool
+}
+
+func (r *reader) seek(pos int) {
+	r.pos = pos
+}
+
+func (r *reader) bytes(n int) []byte {
+	if n < 0 || r.pos < 0 || r.pos+n > len(r.p) {
+		r.eof = true
+		if n < 0 {
+			n = 0
+		}
+		r.pos += n
+		return make([]byte, n)
+	}
+	b := r.p[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *reader) u8() int {
+	return int(r.bytes(1)[0])
+}
+
+func (r *reader) u16() int {
+	b := r.bytes(2)
+	return int(b[0]) | int(b[1])<<8
+}
+
+func (r *reader) u32() int {
+	b := r.bytes(4)
+	return int(uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24)
+}
+
+// tail returns the data from pos to the end.
+func (r *reader) tail(pos int) []byte {
+	if pos < 0 || pos > len(r.p) {
+		return nil
+	}
+	return r.p[pos:]
+}
+
+// pcm8 converts 8 bit samples to float32, n samples are read from p, missing ones are zero.
+func pcm8(p []byte, n int, signed, delta bool) []float32 {
+	out := make([]float32, n)
+	var acc int8
+	for i := 0; i < n && i < len(p); i++ {
+		v := int8(p[i])
+		if !signed {
+			v = int8(p[i] - 128)
+		}
+		if delta {
+			acc += v
+			v = acc
+		}
+		out[i] = float32(v) / 128
+	}
+	return out
+}
+
+// pcm16 converts little-endian 16 bit samples to float32, n samples are read from p.
+func pcm16(p []byte, n int, signed, delta bool) []float32 {
+	out := make([]float32, n)
+	var acc int16
+	for i := 0; i < n && 2*i+1 < len(p); i++ {
+		u := uint16(p[2*i]) | uint16(p[2*i+1])<<8
+		v := int16(u)
+		if !signed {
+			v = int16(u - 32768)
+		}
+		if delta {
+			acc += v
+			v = acc
+		}
+		out[i] = float32(v) / 32768
+	}
+	return out
+}
@@ -0,0 +1,483 @@
+package tracker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/rickcollette/megasound"
+)
+
+// square returns the data of a 32 frame square wave sample, 8 bit signed.
+func square() []byte {
+	p := make([]byte, 32)
+	for i := range p {
+		p[i] = 100
+		if i >= 16 {
+			p[i] = byte(0x100 - 100)
+		}
+	}
+	return p
+}
+
+// testCell is a cell of a test pattern. The note and effect are numbered as in the format of
+// the module, the note is the period for MOD modules.
+type testCell struct {
+	row, ch    int
+	note, inst int
+	fx, param  int
+}
+
+// testSong is the song of a test module, played with the looped square sample.
+type testSong struct {
+	orders   []int
+	patterns [][]testCell // of 64 rows
+}
+
+// buildMOD returns a 4 channel MOD module of song.
+func buildMOD(song testSong) []byte {
+	var b bytes.Buffer
+	title := make([]byte, 20)
+	copy(title, "mod test")
+	b.Write(title)
+	for i := 0; i < 31; i++ {
+		h := make([]byte, 30)
+		if i == 0 {
+			copy(h, "square")
+			binary.BigEndian.PutUint16(h[22:], 16) // length in words
+			h[25] = 64
+			binary.BigEndian.PutUint16(h[28:], 16) // loop length
+		}
+		b.Write(h)
+	}
+	b.WriteByte(byte(len(song.orders)))
+	b.WriteByte(127)
+	orders := make([]byte, 128)
+	for i, o := range song.orders {
+		orders[i] = byte(o)
+	}
+	b.Write(orders)
+	b.WriteString("M.K.")
+	for _, cells := range song.patterns {
+		pat := make([]byte, 64*4*4)
+		for _, c := range cells {
+			p := pat[(c.row*4+c.ch)*4:]
+			p[0] = byte(c.inst&0xf0 | c.note>>8)
+			p[1] = byte(c.note)
+			p[2] = byte((c.inst&0xf)<<4 | c.fx)
+			p[3] = byte(c.param)
+		}
+		b.Write(pat)
+	}
+	b.Write(square())
+	return b.Bytes()
+}
+
+// buildS3M returns a 2 channel S3M module of song, with the unsigned sample at c2spd.
+func buildS3M(song testSong, c2spd int) []byte {
+	const instOffset, patOffset = 0x100, 0x200
+	sampleOffset := patOffset + 0x100*len(song.patterns)
+	p := make([]byte, sampleOffset)
+	copy(p, "s3m test")
+	p[28], p[29] = 0x1a, 16
+	binary.LittleEndian.PutUint16(p[32:], uint16(len(song.orders)))
+	binary.LittleEndian.PutUint16(p[34:], 1)
+	binary.LittleEndian.PutUint16(p[36:], uint16(len(song.patterns)))
+	binary.LittleEndian.PutUint16(p[40:], 0x1320)
+	binary.LittleEndian.PutUint16(p[42:], 2) // unsigned samples
+	copy(p[44:], "SCRM")
+	p[48], p[49], p[50], p[51] = 64, 6, 125, 0xb0
+	for i := 0; i < 32; i++ {
+		p[64+i] = 255
+	}
+	p[64], p[65] = 0, 8 // left and right
+	h := p[96:]
+	for _, o := range song.orders {
+		h[0] = byte(o)
+		h = h[1:]
+	}
+	binary.LittleEndian.PutUint16(h, instOffset/16)
+	for i := range song.patterns {
+		binary.LittleEndian.PutUint16(h[2+2*i:], uint16((patOffset+0x100*i)/16))
+	}
+
+	ins := p[instOffset:]
+	ins[0] = 1
+	binary.LittleEndian.PutUint16(ins[14:], uint16(sampleOffset/16))
+	binary.LittleEndian.PutUint32(ins[16:], 32)
+	binary.LittleEndian.PutUint32(ins[20:], 0)
+	binary.LittleEndian.PutUint32(ins[24:], 32)
+	ins[28] = 64
+	ins[31] = 1 // looped
+	binary.LittleEndian.PutUint32(ins[32:], uint32(c2spd))
+	copy(ins[48:], "square")
+	copy(ins[76:], "SCRS")
+
+	for i, cells := range song.patterns {
+		var pat []byte
+		for row := 0; row < 64; row++ {
+			for _, c := range cells {
+				if c.row != row {
+					continue
+				}
+				what := byte(c.ch)
+				if c.note != 0 {
+					what |= 0x20
+				}
+				if c.fx != 0 {
+					what |= 0x80
+				}
+				pat = append(pat, what)
+				if c.note != 0 {
+					pat = append(pat, byte(c.note), byte(c.inst))
+				}
+				if c.fx != 0 {
+					pat = append(pat, byte(c.fx), byte(c.param))
+				}
+			}
+			pat = append(pat, 0)
+		}
+		off := patOffset + 0x100*i
+		binary.LittleEndian.PutUint16(p[off:], uint16(len(pat)+2))
+		copy(p[off+2:], pat)
+	}
+	for _, v := range square() {
+		p = append(p, v+128)
+	}
+	return p
+}
+
+// buildXM returns a 2 channel XM module of song, with an instrument playing the sample with
+// the relative note relNote and, if volEnv is set, a sustained volume envelope.
+func buildXM(song testSong, relNote int8, volEnv bool) []byte {
+	var b bytes.Buffer
+	le := func(v ...any) {
+		for _, x := range v {
+			binary.Write(&b, binary.LittleEndian, x)
+		}
+	}
+	b.WriteString("Extended Module: ")
+	name := make([]byte, 20)
+	copy(name, "xm test")
+	b.Write(name)
+	b.WriteByte(0x1a)
+	b.Write(make([]byte, 20))
+	le(uint16(0x0104), uint32(276), uint16(len(song.orders)), uint16(0), uint16(2),
+		uint16(len(song.patterns)), uint16(1), uint16(1), uint16(6), uint16(125))
+	orders := make([]byte, 256)
+	for i, o := range song.orders {
+		orders[i] = byte(o)
+	}
+	b.Write(orders)
+
+	for _, cells := range song.patterns {
+		var grid [64][2]*testCell
+		for i, c := range cells {
+			grid[c.row][c.ch] = &cells[i]
+		}
+		var pat []byte
+		for _, row := range grid {
+			for _, c := range row {
+				if c == nil {
+					pat = append(pat, 0x80)
+					continue
+				}
+				pat = append(pat, 0x80|1|2|8|16, byte(c.note), byte(c.inst), byte(c.fx), byte(c.param))
+			}
+		}
+		le(uint32(9), uint8(0), uint16(64), uint16(len(pat)))
+		b.Write(pat)
+	}
+
+	le(uint32(263))
+	insName := make([]byte, 22)
+	copy(insName, "lead")
+	b.Write(insName)
+	le(uint8(0), uint16(1), uint32(40))
+	b.Write(make([]byte, 96)) // keymap
+	points := make([]byte, 48)
+	binary.LittleEndian.PutUint16(points[2:], 64)
+	binary.LittleEndian.PutUint16(points[4:], 10)
+	binary.LittleEndian.PutUint16(points[6:], 64)
+	b.Write(points)
+	b.Write(make([]byte, 48))
+	typ := uint8(0)
+	if volEnv {
+		typ = 1 | 2 // enabled, sustained
+	}
+	le(uint8(2), uint8(0), uint8(1), uint8(0), uint8(0), uint8(0), uint8(0), uint8(0), typ, uint8(0))
+	le(uint8(0), uint8(0), uint8(0), uint8(0), uint16(0x800))
+	b.Write(make([]byte, 263-241))
+
+	le(uint32(32), uint32(0), uint32(32), uint8(64), int8(0), uint8(1), uint8(128), relNote, uint8(0))
+	smpName := make([]byte, 22)
+	copy(smpName, "square")
+	b.Write(smpName)
+	prev := byte(0)
+	for _, v := range square() {
+		b.WriteByte(v - prev) // delta encoded
+		prev = v
+	}
+	return b.Bytes()
+}
+
+// buildIT returns an IT module of song in sample mode, with the sample compressed if
+// compressed is set.
+func buildIT(song testSong, compressed bool) []byte {
+	const sampleOffset, patOffset = 0x100, 0x200
+	dataOffset := patOffset + 0x100*len(song.patterns)
+	p := make([]byte, dataOffset)
+	copy(p, "IMPM")
+	copy(p[4:], "it test")
+	binary.LittleEndian.PutUint16(p[32:], uint16(len(song.orders)))
+	binary.LittleEndian.PutUint16(p[34:], 0)
+	binary.LittleEndian.PutUint16(p[36:], 1)
+	binary.LittleEndian.PutUint16(p[38:], uint16(len(song.patterns)))
+	binary.LittleEndian.PutUint16(p[40:], 0x214)
+	binary.LittleEndian.PutUint16(p[42:], 0x214)
+	binary.LittleEndian.PutUint16(p[44:], 1|8) // stereo, linear slides
+	p[48], p[49], p[50], p[51], p[52] = 128, 48, 6, 125, 128
+	for i := 0; i < 64; i++ {
+		p[64+i] = 32
+		p[128+i] = 64
+	}
+	h := p[192:]
+	for _, o := range song.orders {
+		h[0] = byte(o)
+		h = h[1:]
+	}
+	binary.LittleEndian.PutUint32(h, sampleOffset)
+	for i := range song.patterns {
+		binary.LittleEndian.PutUint32(h[4+4*i:], uint32(patOffset+0x100*i))
+	}
+
+	s := p[sampleOffset:]
+	copy(s, "IMPS")
+	s[17], s[18], s[19] = 64, 1|16, 64 // looped
+	if compressed {
+		s[18] |= 8
+	}
+	copy(s[20:], "square")
+	s[46] = 1 // signed
+	binary.LittleEndian.PutUint32(s[48:], 32)
+	binary.LittleEndian.PutUint32(s[52:], 0)
+	binary.LittleEndian.PutUint32(s[56:], 32)
+	binary.LittleEndian.PutUint32(s[60:], 8363)
+	binary.LittleEndian.PutUint32(s[72:], uint32(dataOffset))
+
+	for i, cells := range song.patterns {
+		var pat []byte
+		for row := 0; row < 64; row++ {
+			for _, c := range cells {
+				if c.row != row {
+					continue
+				}
+				mask := byte(0)
+				if c.note != 0 {
+					mask |= 1 | 2
+				}
+				if c.fx != 0 {
+					mask |= 8
+				}
+				pat = append(pat, byte(c.ch+1)|0x80, mask)
+				if c.note != 0 {
+					pat = append(pat, byte(c.note), byte(c.inst))
+				}
+				if c.fx != 0 {
+					pat = append(pat, byte(c.fx), byte(c.param))
+				}
+			}
+			pat = append(pat, 0)
+		}
+		pp := p[patOffset+0x100*i:]
+		binary.LittleEndian.PutUint16(pp, uint16(len(pat)))
+		binary.LittleEndian.PutUint16(pp[2:], 64)
+		copy(pp[8:], pat)
+	}
+	if !compressed {
+		return append(p, square()...)
+	}
+	// a single block of 9 bit deltas, least significant bit first
+	var bits []bool
+	prev := byte(0)
+	for _, v := range square() {
+		d := v - prev
+		prev = v
+		for i := 0; i < 9; i++ {
+			bits = append(bits, i < 8 && d>>i&1 != 0)
+		}
+	}
+	block := make([]byte, (len(bits)+7)/8)
+	for i, bit := range bits {
+		if bit {
+			block[i/8] |= 1 << (i % 8)
+		}
+	}
+	p = binary.LittleEndian.AppendUint16(p, uint16(len(block)))
+	return append(p, block...)
+}
+
+// testModule is the test song in the format of a module, with what loading it gives.
+type testModule struct {
+	name        string
+	data        []byte
+	format      string
+	title       string
+	channels    int
+	orders      []int
+	instruments []string
+}
+
+// newTestSong returns the test song: C-5 on channel 0 at speed 3, a break to row 10 of the next
+// order on row 15, and C-5 again with a volume slide in the second pattern. The note and the
+// effects are numbered as in the format of the module, and the orders end with an end marker if
+// end is set.
+func newTestSong(note, speed, brk, brkRow, slide int, end bool) testSong {
+	s := testSong{
+		orders: []int{0, 1},
+		patterns: [][]testCell{
+			{{row: 0, ch: 0, note: note, inst: 1, fx: speed, param: 3}, {row: 15, ch: 1, fx: brk, param: brkRow}},
+			{{row: 0, ch: 0, note: note, inst: 1}, {row: 1, ch: 0, fx: slide, param: 0x0f}},
+		},
+	}
+	if end {
+		s.orders = append(s.orders, 255)
+	}
+	return s
+}
+
+// testModules returns the test song in each format. Played at 44100 Hz, it lasts testSongLen
+// samples and the second order starts at testOrder1Pos.
+func testModules() []testModule {
+	// MOD and XM effects F, D and A, S3M and IT effects A, C and D. The break row is BCD,
+	// except in IT modules.
+	mod := newTestSong(428, 0xf, 0xd, 0x10, 0xa, false)
+	s3m := newTestSong(0x40, 1, 3, 0x10, 4, true)
+	xm := newTestSong(49, 0xf, 0xd, 0x10, 0xa, false)
+	it := newTestSong(60, 1, 3, 10, 4, true)
+	return []testModule{
+		{"MOD", buildMOD(mod), "MOD", "mod test", 4, []int{0, 1}, nil},
+		{"S3M", buildS3M(s3m, 8363), "S3M", "s3m test", 2, []int{0, 1, OrderEnd}, nil},
+		{"XM", buildXM(xm, 0, false), "XM", "xm test", 2, []int{0, 1}, []string{"lead"}},
+		{"IT", buildIT(it, false), "IT", "it test", 2, []int{0, 1, OrderEnd}, nil},
+		{"IT compressed", buildIT(it, true), "IT", "it test", 2, []int{0, 1, OrderEnd}, nil},
+	}
+}
+
+const (
+	testSongLen   = (16 + 54) * 3 * 882
+	testOrder1Pos = 16 * 3 * 882
+)
+
+func loadBytes(t *testing.T, p []byte) *Module {
+	t.Helper()
+	m, err := Load(bytes.NewReader(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestLoad(t *testing.T) {
+	want := map[[3]int]cell{ // pattern, row and channel
+		{0, 0, 0}:  {note: 61, inst: 1, fx: fxSpeed, param: 3},
+		{0, 15, 1}: {fx: fxPatternBreak, param: 10},
+		{1, 0, 0}:  {note: 61, inst: 1},
+		{1, 1, 0}:  {fx: fxVolSlide, param: 0x0f},
+	}
+	for _, tc := range testModules() {
+		m := loadBytes(t, tc.data)
+		if m.Format != tc.format || m.Title != tc.title || m.Channels != tc.channels {
+			t.Errorf("%s: loaded a %d channel %s module %q", tc.name, m.Channels, m.Format, m.Title)
+		}
+		if len(m.Orders) != len(tc.orders) {
+			t.Errorf("%s: orders %v, want %v", tc.name, m.Orders, tc.orders)
+		} else {
+			for j := range m.Orders {
+				if m.Orders[j] != tc.orders[j] {
+					t.Errorf("%s: orders %v, want %v", tc.name, m.Orders, tc.orders)
+					break
+				}
+			}
+		}
+		if len(m.Instruments) != len(tc.instruments) || len(tc.instruments) > 0 && m.Instruments[0] != tc.instruments[0] {
+			t.Errorf("%s: instruments %q, want %q", tc.name, m.Instruments, tc.instruments)
+		}
+		if len(m.Samples) == 0 || m.Samples[0] != "square" {
+			t.Fatalf("%s: samples %q", tc.name, m.Samples)
+		}
+
+		if m.Patterns() != 2 || m.PatternRows(0) != 64 || m.PatternRows(1) != 64 || m.PatternRows(2) != 0 {
+			t.Fatalf("%s: %d patterns of %d and %d rows", tc.name, m.Patterns(), m.PatternRows(0), m.PatternRows(1))
+		}
+		for pi, pat := range m.patterns {
+			for row := 0; row < pat.rows; row++ {
+				for ch := 0; ch < m.Channels; ch++ {
+					if got, want := pat.cells[row*m.Channels+ch], want[[3]int{pi, row, ch}]; got != want {
+						t.Errorf("%s: pattern %d row %d channel %d is %+v, want %+v", tc.name, pi, row, ch, got, want)
+					}
+				}
+			}
+		}
+
+		s := m.samples[0]
+		if len(s.left) != 32 || s.right != nil || s.loop != loopForward || s.loopStart != 0 || s.loopEnd != 32 {
+			t.Errorf("%s: sample of %d frames, loop %d from %d to %d", tc.name, len(s.left), s.loop, s.loopStart, s.loopEnd)
+			continue
+		}
+		for j, v := range square() {
+			if want := float32(int8(v)) / 128; s.left[j] != want {
+				t.Errorf("%s: sample frame %d is %v, want %v", tc.name, j, s.left[j], want)
+				break
+			}
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	data := testModules()[0].data
+	s, format, name, err := megasound.Decode(io.NopCloser(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "tracker" || format.SampleRate != 44100 || format.NumChannels != 2 || s.Len() != testSongLen {
+		t.Errorf("decoded %q %+v of %d samples", name, format, s.Len())
+	}
+	if err := s.Close(); err != nil {
+		t.Error(err)
+	}
+	if _, err := Load(bytes.NewReader([]byte("not a module"))); err == nil {
+		t.Error("loaded invalid data")
+	}
+	for n := 0; n < len(data); n += 97 {
+		Load(bytes.NewReader(data[:n])) // must not panic
+	}
+}
+
+// TestCorrupt loads and plays randomly corrupted modules, which must not panic.
+func TestCorrupt(t *testing.T) {
+	seed := uint32(1)
+	rnd := func(n int) int {
+		seed = seed*1664525 + 1013904223
+		return int(seed>>8) % n
+	}
+	for _, tc := range testModules() {
+		for i := 0; i < 300; i++ {
+			data := append([]byte(nil), tc.data...)
+			for j := 0; j < 1+rnd(8); j++ {
+				data[rnd(len(data))] = byte(rnd(256))
+			}
+			m, err := Load(bytes.NewReader(data))
+			if err != nil {
+				continue
+			}
+			p := NewPlayer(m, 8000)
+			p.Stream(make([][2]float64, 20000))
+			if p.Len() > 0 {
+				p.Seek(rnd(p.Len()))
+				p.Stream(make([][2]float64, 1000))
+			}
+		}
+	}
+}
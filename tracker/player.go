@@ -0,0 +1,538 @@
+package tracker
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/rickcollette/megasound"
+)
+
+// Interpolation is the method used to compute sample values between sample frames.
+type Interpolation int
+
+// Interpolation methods.
+const (
+	Nearest Interpolation = iota // no interpolation, like the hardware of old trackers
+	Linear
+	Cubic
+)
+
+// maxLength is the maximum length of a song. Songs which do not end are cut there.
+const maxLength = 2 * time.Hour
+
+// maxVoices is the maximum number of background voices.
+const maxVoices = 64
+
+// Player plays a Module. It implements megasound.StreamSeeker.
+//
+// A song ends when it reaches the end of its orders, or a row is played a second time, which
+// is where a song which loops would start over. The length of the song is determined by
+// NewPlayer, so Len and Seek work like for other streamers.
+type Player struct {
+	m          *Module
+	sr         megasound.SampleRate
+	interp     Interpolation
+	separation float64
+	mute       []bool
+	it, xm     bool // format rules for effects
+	s3m, mod   bool
+
+	length   int
+	orderPos []int // position of the first row of each order, -1 if not played
+
+	// sequencer
+	order, row   int
+	curOrder     int
+	curRow       int
+	tick         int
+	speed, tempo int
+	extraTicks   int // added to the current row by fine pattern delays
+	globalVol    int
+	patDelay     int  // remaining repetitions of the current row
+	repeat       bool // repeating the current row
+	jumpOrder    int  // set by position jumps, -1 if none
+	breakRow     int  // set by pattern breaks, -1 if none
+	loopRow      int  // set by pattern loops, -1 if none
+	visited      map[int]bool
+	ended        bool
+	channels     []*channel
+	voices       []*voice // background voices
+	active       []*voice // returned by allVoices, reused to not allocate while streaming
+	tickFrac     float64
+	rand         uint32
+
+	buf    [][2]float64
+	bufPos int
+	frames int // frames of the ticks played
+	pos    int
+}
+
+// NewPlayer returns a Player playing m at the sample rate sr, with cubic interpolation.
+func NewPlayer(m *Module, sr megasound.SampleRate) *Player {
+	p := &Player{
+		m:          m,
+		sr:         sr,
+		interp:     Cubic,
+		separation: 1,
+		mute:       append([]bool(nil), m.chanMute...),
+		it:         m.Format == "IT",
+		xm:         m.Format == "XM",
+		s3m:        m.Format == "S3M",
+		mod:        m.Format == "MOD",
+	}
+
+	// determine the length and the position of the orders
+	p.orderPos = make([]int, len(m.Orders))
+	for i := range p.orderPos {
+		p.orderPos[i] = -1
+	}
+	p.reset()
+	for {
+		n := p.step()
+		if n == 0 {
+			break
+		}
+		p.frames += n
+	}
+	p.length = p.frames
+	p.reset()
+	return p
+}
+
+// reset sets p to the start of the song.
+func (p *Player) reset() {
+	m := p.m
+	p.order, p.row, p.curOrder, p.curRow, p.tick = 0, 0, 0, 0, 0
+	p.speed, p.tempo, p.globalVol = m.speed, m.tempo, m.globalVol
+	p.extraTicks, p.patDelay, p.repeat = 0, 0, false
+	p.jumpOrder, p.breakRow, p.loopRow = -1, -1, -1
+	p.visited = make(map[int]bool)
+	p.ended = false
+	p.channels = make([]*channel, m.Channels)
+	for i := range p.channels {
+		p.channels[i] = &channel{index: i, pan: m.chanPan[i], chanVol: m.chanVol[i], delayTick: -1, cutTick: -1, offTick: -1}
+	}
+	p.voices = nil
+	p.tickFrac = 0
+	p.rand = 1
+	p.buf, p.bufPos = p.buf[:0], 0
+	p.frames, p.pos = 0, 0
+}
+
+// SetInterpolation sets the interpolation of sample values.
+func (p *Player) SetInterpolation(interp Interpolation) {
+	p.interp = interp
+}
+
+// SetStereoSeparation sets the stereo separation, from 0 for mono to 1 for the panning of the
+// module.
+func (p *Player) SetStereoSeparation(separation float64) {
+	p.separation = math.Max(0, math.Min(1, separation))
+}
+
+// Mute mutes or unmutes channel ch. Channels disabled in the module are muted initially.
+func (p *Player) Mute(ch int, muted bool) {
+	if ch >= 0 && ch < len(p.mute) {
+		p.mute[ch] = muted
+	}
+}
+
+// Muted reports whether channel ch is muted.
+func (p *Player) Muted(ch int) bool {
+	return ch >= 0 && ch < len(p.mute) && p.mute[ch]
+}
+
+// Order returns the position in Module.Orders of the row being played.
+func (p *Player) Order() int {
+	return p.curOrder
+}
+
+// Row returns the row being played.
+func (p *Player) Row() int {
+	return p.curRow
+}
+
+// Stream streams the song.
+func (p *Player) Stream(samples [][2]float64) (n int, ok bool) {
+	for n < len(samples) {
+		if p.bufPos >= len(p.buf) {
+			k := p.step()
+			if k == 0 {
+				break
+			}
+			p.render(k)
+			p.frames += k
+		}
+		c := copy(samples[n:], p.buf[p.bufPos:])
+		p.bufPos += c
+		n += c
+	}
+	p.pos += n
+	return n, n > 0
+}
+
+// Err always returns nil.
+func (p *Player) Err() error {
+	return nil
+}
+
+// Len returns the length of the song in samples.
+func (p *Player) Len() int {
+	return p.length
+}
+
+// Position returns the current position in samples.
+func (p *Player) Position() int {
+	return p.pos
+}
+
+// Seek sets the position to pos. As the song is played from the start to that position
+// without mixing, seeking takes time in proportion to pos.
+func (p *Player) Seek(pos int) error {
+	if pos < 0 || p.length < pos {
+		return fmt.Errorf("tracker: seek position %v out of range [%v, %v]", pos, 0, p.length)
+	}
+	p.reset()
+	for {
+		n := p.step()
+		if n == 0 {
+			break
+		}
+		if p.frames+n <= pos {
+			for _, v := range p.allVoices() {
+				v.skip(n)
+			}
+			p.frames += n
+			continue
+		}
+		p.render(n)
+		p.bufPos = pos - p.frames
+		p.frames += n
+		break
+	}
+	p.pos = pos
+	return nil
+}
+
+// SeekOrder sets the position to the start of order, a position in Module.Orders.
+func (p *Player) SeekOrder(order int) error {
+	if order < 0 || order >= len(p.orderPos) || p.orderPos[order] < 0 {
+		return pkgerrors.Errorf("tracker: order %d is not played", order)
+	}
+	return p.Seek(p.orderPos[order])
+}
+
+// step plays the current tick and moves to the next one. It returns the length of the tick in
+// frames, or 0 at the end of the song.
+func (p *Player) step() int {
+	if p.ended || p.frames >= p.sr.N(maxLength) {
+		return 0
+	}
+	for _, c := range p.channels {
+		c.vibDelta, c.volDelta, c.panDelta, c.arpNote = 0, 0, 0, 0
+	}
+	if p.tick == 0 && !p.repeat {
+		if !p.enterRow() {
+			p.ended = true
+			return 0
+		}
+		p.playRow()
+	} else {
+		p.playTick()
+	}
+	p.updateVoices()
+
+	length := float64(p.sr)*2.5/float64(p.tempo) + p.tickFrac
+	n := int(length)
+	p.tickFrac = length - float64(n)
+
+	p.tick++
+	if p.tick >= p.speed+p.extraTicks {
+		p.tick = 0
+		if p.patDelay > 0 {
+			p.patDelay--
+			p.repeat = true
+		} else {
+			p.repeat = false
+			p.nextRow()
+		}
+	}
+	return n
+}
+
+// enterRow moves to the row at p.order and p.row, skipping markers. It returns false at the
+// end of the song.
+func (p *Player) enterRow() bool {
+	m := p.m
+	for i := 0; ; i++ {
+		if p.order >= len(m.Orders) || i > len(m.Orders) {
+			return false
+		}
+		o := m.Orders[p.order]
+		if o == OrderEnd {
+			return false
+		}
+		if o != OrderSkip && o < len(m.patterns) {
+			break
+		}
+		p.order++
+		p.row = 0
+	}
+	if p.row >= m.patterns[m.Orders[p.order]].rows {
+		p.row = 0
+	}
+	key := p.order<<8 | p.row
+	if p.visited[key] {
+		return false
+	}
+	p.visited[key] = true
+	if p.orderPos[p.order] < 0 {
+		p.orderPos[p.order] = p.frames
+	}
+	p.curOrder, p.curRow = p.order, p.row
+	return true
+}
+
+// nextRow moves to the row played after the current one.
+func (p *Player) nextRow() {
+	switch {
+	case p.jumpOrder >= 0 || p.breakRow >= 0:
+		if p.jumpOrder >= 0 {
+			p.order = p.jumpOrder
+		} else {
+			p.order++
+		}
+		p.row = 0
+		if p.breakRow >= 0 {
+			p.row = p.breakRow
+		}
+	case p.loopRow >= 0:
+		// the rows of the loop are played again
+		for row := p.loopRow; row <= p.row; row++ {
+			delete(p.visited, p.order<<8|row)
+		}
+		p.row = p.loopRow
+	default:
+		p.row++
+		if p.row >= p.m.patterns[p.m.Orders[p.order]].rows {
+			p.order++
+			p.row = 0
+		}
+	}
+}
+
+// allVoices returns the active voices of the channels and the background voices. The returned
+// slice is only valid until the next call.
+func (p *Player) allVoices() []*voice {
+	vs := p.active[:0]
+	for _, c := range p.channels {
+		if c.voice != nil && c.voice.active {
+			vs = append(vs, c.voice)
+		}
+	}
+	for _, v := range p.voices {
+		if v.active {
+			vs = append(vs, v)
+		}
+	}
+	p.active = vs
+	return vs
+}
+
+// background moves v to the background voices.
+func (p *Player) background(v *voice) {
+	if v == nil || !v.active {
+		return
+	}
+	voices := p.voices[:0]
+	for _, bv := range p.voices {
+		if bv.active {
+			voices = append(voices, bv)
+		}
+	}
+	if len(voices) >= maxVoices {
+		// drop the quietest voice
+		q := 0
+		for i, bv := range voices {
+			if bv.gainL+bv.gainR < voices[q].gainL+voices[q].gainR {
+				q = i
+			}
+		}
+		voices = append(voices[:q], voices[q+1:]...)
+	}
+	p.voices = append(voices, v)
+}
+
+// updateVoices computes the gains and steps of the voices for the current tick.
+func (p *Player) updateVoices() {
+	for _, c := range p.channels {
+		v := c.voice
+		if v == nil || !v.active || c.smp == nil {
+			continue
+		}
+		vol := clamp(c.volume+c.volDelta, 0, 64)
+		if c.tremorOff {
+			vol = 0
+		}
+		v.vol = float64(vol) / 64 * float64(c.chanVol) / 64 * float64(c.smp.globalVol) / 64
+		if c.inst != nil {
+			v.vol *= float64(c.inst.globalVol) / 128
+		}
+		v.pan = clamp(c.pan+c.panDelta, 0, 256)
+		v.freq = p.frequency(c)
+	}
+	gain := p.m.mixVol / math.Sqrt(float64(p.m.Channels)) * float64(p.globalVol) / 128
+	for _, v := range p.allVoices() {
+		p.updateVoice(v, gain)
+	}
+}
+
+// updateVoice computes the gains and step of v and advances its envelopes.
+func (p *Player) updateVoice(v *voice, gain float64) {
+	vol, pan, freq := v.vol, v.pan, v.freq
+	if ins := v.ins; ins != nil {
+		if v.envOn[0] {
+			vol *= float64(ins.volEnv.value(v.volTick)) / 64
+			if p.it && !ins.volEnv.loop && ins.volEnv.ended(v.volTick) {
+				v.fading = true
+				if ins.volEnv.points[len(ins.volEnv.points)-1].value == 0 {
+					v.stop()
+				}
+			}
+			v.volTick = ins.volEnv.advance(v.volTick, v.released)
+		}
+		if v.envOn[1] {
+			e := ins.panEnv.value(v.panTick)
+			pan += e * (128 - abs(pan-128)) / 32
+			v.panTick = ins.panEnv.advance(v.panTick, v.released)
+		}
+		if v.envOn[2] {
+			freq *= math.Exp2(float64(ins.pitchEnv.value(v.pitchTick)) / 24)
+			v.pitchTick = ins.pitchEnv.advance(v.pitchTick, v.released)
+		}
+		vol *= v.fade
+		if v.fading {
+			v.fade = math.Max(0, v.fade-ins.fadeout)
+			if v.fade == 0 {
+				v.stop()
+			}
+		}
+	}
+	if s := v.smp; s.vibDepth > 0 && s.vibRate > 0 {
+		depth := float64(s.vibDepth)
+		if s.vibSweep > 0 && v.autoVibTick < s.vibSweep {
+			depth *= float64(v.autoVibTick) / float64(s.vibSweep)
+		}
+		v.autoVibTick++
+		w := float64(p.wave(s.vibType, v.autoVibPos>>2))
+		v.autoVibPos += s.vibRate
+		freq *= math.Exp2(w / 255 * depth / 64 / 12)
+	}
+
+	if v.stopping || p.Muted(v.ch) {
+		vol = 0
+	}
+	sep := p.separation * p.m.separation
+	x := 128 + (float64(pan)-128)*sep
+	v.gainL = gain * vol * math.Min(1, (256-x)/128)
+	v.gainR = gain * vol * math.Min(1, x/128)
+	v.step = freq / float64(p.sr)
+}
+
+// render mixes n frames of the voices to the buffer.
+func (p *Player) render(n int) {
+	if cap(p.buf) < n {
+		p.buf = make([][2]float64, n)
+	}
+	p.buf, p.bufPos = p.buf[:n], 0
+	for i := range p.buf {
+		p.buf[i] = [2]float64{}
+	}
+	ramp := int(p.sr) / 500
+	if ramp > n {
+		ramp = n
+	}
+	for _, v := range p.allVoices() {
+		v.mix(p.buf, ramp, p.interp)
+	}
+}
+
+// frequency returns the playback frequency of the sample of channel c.
+func (p *Player) frequency(c *channel) float64 {
+	period := c.period + c.vibDelta
+	var freq float64
+	if p.m.linear {
+		freq = c.c5speed * math.Exp2((3840-period)/768)
+	} else {
+		freq = 14317056 / math.Max(period, 1)
+	}
+	if c.arpNote != 0 {
+		freq *= math.Exp2(float64(c.arpNote) / 12)
+	}
+	return freq
+}
+
+// period returns the period of note played at c5speed. Periods are linear, 64 for each
+// semitone, or Amiga periods in quarters of ProTracker periods.
+func (p *Player) period(note int, c5speed float64) float64 {
+	if p.m.linear {
+		return float64(120-note) * 64
+	}
+	return 1712 * 8363 / c5speed * math.Exp2(float64(60-note)/12)
+}
+
+// Vibrato waveforms.
+const (
+	waveSine = iota
+	waveRampDown
+	waveSquare
+	waveRandom
+	waveRampUp
+)
+
+// sineTable holds a cycle of the vibrato sine wave.
+var sineTable = func() (t [64]int) {
+	for i := range t {
+		t[i] = int(math.Round(255 * math.Sin(2*math.Pi*float64(i)/64)))
+	}
+	return t
+}()
+
+// wave returns the value, -255 to 255, of waveform w at pos, 64 positions per cycle.
+func (p *Player) wave(w, pos int) int {
+	pos &= 63
+	switch w {
+	case waveRampDown:
+		return 255 - 8*pos
+	case waveRampUp:
+		return 8*pos - 255
+	case waveSquare:
+		if pos < 32 {
+			return 255
+		}
+		return -255
+	case waveRandom:
+		p.rand = p.rand*1103515245 + 12345
+		return int(p.rand>>16)%511 - 255
+	}
+	return sineTable[pos]
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,246 @@
+package tracker
+
+import (
+	"math"
+	"testing"
+)
+
+// render streams up to n samples from p.
+func render(p *Player, n int) [][2]float64 {
+	out := make([][2]float64, n)
+	k, _ := p.Stream(out)
+	return out[:k]
+}
+
+// frequency estimates the frequency of the left channel of s from its rising zero crossings.
+func frequency(s [][2]float64, sr int) float64 {
+	first, last, n := -1, -1, 0
+	for i := 1; i < len(s); i++ {
+		if s[i-1][0] <= 0 && s[i][0] > 0 {
+			if first < 0 {
+				first = i
+			} else {
+				n++
+			}
+			last = i
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return float64(n) * float64(sr) / float64(last-first)
+}
+
+// peak returns the largest absolute value of s.
+func peak(s [][2]float64) float64 {
+	m := 0.0
+	for _, x := range s {
+		m = math.Max(m, math.Max(math.Abs(x[0]), math.Abs(x[1])))
+	}
+	return m
+}
+
+// modSong returns a song of a single pattern holding cells.
+func modSong(cells ...testCell) testSong {
+	return testSong{orders: []int{0}, patterns: [][]testCell{cells}}
+}
+
+// c5 is the frequency of C-5 played with the 32 frame square sample at 8363 Hz.
+const c5 = 8363.0 / 32
+
+func TestPlayer(t *testing.T) {
+	for _, tc := range testModules() {
+		p := NewPlayer(loadBytes(t, tc.data), 44100)
+		if p.Len() != testSongLen {
+			t.Errorf("%s: Len() = %d, want %d", tc.name, p.Len(), testSongLen)
+		}
+		all := render(p, p.Len()+100)
+		if len(all) != p.Len() {
+			t.Fatalf("%s: streamed %d samples, want %d", tc.name, len(all), p.Len())
+		}
+		if f := frequency(all[:testOrder1Pos], 44100); math.Abs(f-c5) > 3 {
+			t.Errorf("%s: frequency %.1f Hz, want %.1f Hz", tc.name, f, c5)
+		}
+
+		// seeking gives the same samples as streaming from the start
+		check := func(what string, pos int) {
+			got := render(p, 500)
+			want := all[pos:]
+			if len(want) > 500 {
+				want = want[:500]
+			}
+			if len(got) != len(want) {
+				t.Fatalf("%s: %s: streamed %d samples, want %d", tc.name, what, len(got), len(want))
+			}
+			for i := range got {
+				if math.Abs(got[i][0]-want[i][0]) > 1e-9 || math.Abs(got[i][1]-want[i][1]) > 1e-9 {
+					t.Fatalf("%s: %s: sample %d is %v, want %v", tc.name, what, pos+i, got[i], want[i])
+				}
+			}
+		}
+		for _, pos := range []int{0, 1, 881, 882, 12345, testOrder1Pos, p.Len() - 10} {
+			if err := p.Seek(pos); err != nil {
+				t.Fatal(err)
+			}
+			if p.Position() != pos {
+				t.Errorf("%s: Seek(%d): Position() = %d", tc.name, pos, p.Position())
+			}
+			check("Seek", pos)
+		}
+		if err := p.SeekOrder(1); err != nil {
+			t.Fatal(err)
+		}
+		if p.Position() != testOrder1Pos || p.Order() != 1 || p.Row() != 10 {
+			t.Errorf("%s: SeekOrder(1): position %d, order %d, row %d, want %d, 1, 10", tc.name, p.Position(), p.Order(), p.Row(), testOrder1Pos)
+		}
+		check("SeekOrder(1)", testOrder1Pos)
+		if err := p.SeekOrder(len(p.m.Orders)); err == nil {
+			t.Errorf("%s: seeking past the last order succeeded", tc.name)
+		}
+		if err := p.Seek(p.Len() + 1); err == nil {
+			t.Errorf("%s: seeking past the end succeeded", tc.name)
+		}
+
+		p.Mute(0, true)
+		if !p.Muted(0) || p.Muted(1) {
+			t.Errorf("%s: channel 0 muted %v, channel 1 muted %v", tc.name, p.Muted(0), p.Muted(1))
+		}
+		if err := p.Seek(0); err != nil {
+			t.Fatal(err)
+		}
+		if pk := peak(render(p, testSongLen)[1000:]); pk != 0 {
+			t.Errorf("%s: peak %v with the channel of the notes muted", tc.name, pk)
+		}
+		p.Mute(0, false)
+		if err := p.Seek(0); err != nil {
+			t.Fatal(err)
+		}
+		check("Mute(0, false)", 0)
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	m := loadBytes(t, testModules()[0].data)
+	for _, interp := range []Interpolation{Nearest, Linear, Cubic} {
+		p := NewPlayer(m, 44100)
+		p.SetInterpolation(interp)
+		all := render(p, p.Len())
+		if f := frequency(all[:testOrder1Pos], 44100); math.Abs(f-c5) > 3 {
+			t.Errorf("interpolation %d: frequency %.1f Hz, want %.1f Hz", interp, f, c5)
+		}
+		if err := p.Seek(12345); err != nil {
+			t.Fatal(err)
+		}
+		for i, x := range render(p, 500) {
+			if math.Abs(x[0]-all[12345+i][0]) > 1e-9 || math.Abs(x[1]-all[12345+i][1]) > 1e-9 {
+				t.Fatalf("interpolation %d: sample %d is %v after seeking, want %v", interp, 12345+i, x, all[12345+i])
+			}
+		}
+	}
+}
+
+func TestLen(t *testing.T) {
+	note := testCell{row: 0, ch: 0, note: 428, inst: 1}
+	for _, tc := range []struct {
+		name string
+		song testSong
+		want int
+	}{
+		{"plain", modSong(note), 64 * 6 * 882},
+		{"jump back", testSong{orders: []int{0, 0}, patterns: [][]testCell{{note, {row: 63, ch: 0, fx: 0xb}}}}, 64 * 6 * 882},
+		{"pattern loop", modSong(note,
+			testCell{row: 0, ch: 1, fx: 0xf, param: 250}, // tempo
+			testCell{row: 4, ch: 1, fx: 0xe, param: 0x60},
+			testCell{row: 7, ch: 1, fx: 0xe, param: 0x63},
+		), (64 + 3*4) * 6 * 441},
+		{"pattern delay", modSong(note, testCell{row: 2, ch: 3, fx: 0xe, param: 0xe2}), (64 + 2) * 6 * 882},
+		{"break to the next order", testSong{orders: []int{0, 0}, patterns: [][]testCell{{note, {row: 31, ch: 1, fx: 0xd, param: 0x60}}}}, (32 + 4) * 6 * 882},
+	} {
+		p := NewPlayer(loadBytes(t, buildMOD(tc.song)), 44100)
+		if p.Len() != tc.want {
+			t.Errorf("%s: Len() = %d, want %d", tc.name, p.Len(), tc.want)
+		}
+		if n := len(render(p, tc.want+100)); n != tc.want {
+			t.Errorf("%s: streamed %d samples, want %d", tc.name, n, tc.want)
+		}
+	}
+
+	// the second order of the jump back is never played
+	p := NewPlayer(loadBytes(t, buildMOD(testSong{orders: []int{0, 0}, patterns: [][]testCell{{{row: 63, ch: 0, fx: 0xb}}}})), 44100)
+	if err := p.SeekOrder(1); err == nil {
+		t.Error("seeking to an order not played succeeded")
+	}
+}
+
+func TestEffects(t *testing.T) {
+	const tick = 882
+	slide := []testCell{{row: 0, ch: 0, note: 428, inst: 1}}
+	porta := []testCell{{row: 0, ch: 0, note: 428, inst: 1}}
+	for row := 1; row < 10; row++ {
+		slide = append(slide, testCell{row: row, ch: 0, fx: 0xa, param: 0x0f})
+	}
+	for row := 1; row < 64; row++ {
+		porta = append(porta, testCell{row: row, ch: 0, fx: 0x1, param: 1})
+	}
+	for _, tc := range []struct {
+		name  string
+		cells []testCell
+		check func(s [][2]float64) bool
+	}{
+		{"note cut", []testCell{{row: 0, ch: 0, note: 428, inst: 1, fx: 0xe, param: 0xc2}}, func(s [][2]float64) bool {
+			return peak(s[:tick]) > 0 && peak(s[3*tick:6*tick]) == 0
+		}},
+		{"note delay", []testCell{{row: 0, ch: 0, note: 428, inst: 1, fx: 0xe, param: 0xd3}}, func(s [][2]float64) bool {
+			return peak(s[:3*tick]) == 0 && peak(s[3*tick:6*tick]) > 0
+		}},
+		{"volume slide", slide, func(s [][2]float64) bool {
+			return peak(s[:6*tick]) > 0 && peak(s[11*6*tick:12*6*tick]) == 0
+		}},
+		{"portamento up", porta, func(s [][2]float64) bool {
+			// the period slides from 428 to the lowest one, 113
+			return frequency(s[len(s)-20000:], 44100) > 2.5*frequency(s[:20000], 44100)
+		}},
+	} {
+		p := NewPlayer(loadBytes(t, buildMOD(modSong(tc.cells...))), 44100)
+		if !tc.check(render(p, p.Len())) {
+			t.Errorf("%s: wrong output", tc.name)
+		}
+	}
+}
+
+func TestPitch(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want float64
+	}{
+		// C-4 at twice the C2SPD
+		{"S3M", buildS3M(modSong(testCell{row: 0, ch: 0, note: 0x30, inst: 1}), 2*8363), c5},
+		// C-3 with a relative note of an octave
+		{"XM", buildXM(modSong(testCell{row: 0, ch: 0, note: 37, inst: 1}), 12, false), c5},
+		// half the period of C-5
+		{"MOD", buildMOD(modSong(testCell{row: 0, ch: 0, note: 214, inst: 1})), 2 * c5},
+	} {
+		p := NewPlayer(loadBytes(t, tc.data), 44100)
+		if f := frequency(render(p, 44100), 44100); math.Abs(f-tc.want) > 3 {
+			t.Errorf("%s: frequency %.1f Hz, want %.1f Hz", tc.name, f, tc.want)
+		}
+	}
+}
+
+func TestXMKeyOff(t *testing.T) {
+	const row = 6 * 882
+	song := modSong(testCell{row: 0, ch: 0, note: 49, inst: 1}, testCell{row: 32, ch: 0, note: 97})
+	// with a volume envelope, the note fades out by 0x800/32768 per tick after the key off
+	p := NewPlayer(loadBytes(t, buildXM(song, 0, true)), 44100)
+	s := render(p, p.Len())
+	if peak(s[31*row:32*row]) == 0 || peak(s[32*row+20*882:]) != 0 {
+		t.Errorf("peak %v before the key off and %v after the fadeout", peak(s[31*row:32*row]), peak(s[32*row+20*882:]))
+	}
+	// without one, the key off cuts the note
+	p = NewPlayer(loadBytes(t, buildXM(song, 0, false)), 44100)
+	s = render(p, p.Len())
+	if peak(s[31*row:32*row]) == 0 || peak(s[32*row+882:]) != 0 {
+		t.Errorf("peak %v before the key off and %v after", peak(s[31*row:32*row]), peak(s[32*row+882:]))
+	}
+}
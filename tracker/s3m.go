@@ -0,0 +1,305 @@
+package tracker
+
+import (
+	pkgerrors "github.com/pkg/errors"
+)
+
+// loadS3M loads a Scream Tracker 3 module.
+func loadS3M(data []byte) (*Module, error) {
+	r := &reader{p: data}
+	m := &Module{
+		Title:      cstring(r.bytes(28)),
+		Format:     "S3M",
+		separation: 1,
+	}
+	r.seek(32)
+	numOrders, numInst, numPatterns := r.u16(), r.u16(), r.u16()
+	r.u16()        // flags
+	r.u16()        // tracker version
+	ffi := r.u16() // sample format, 1 for signed and 2 for unsigned samples
+	r.seek(48)
+	m.globalVol = 2 * r.u8()
+	m.speed, m.tempo = r.u8(), r.u8()
+	masterVol := r.u8()
+	r.u8() // ultra click removal
+	defaultPan := r.u8() == 252
+	r.seek(64)
+	settings := r.bytes(32)
+	if r.eof {
+		return nil, pkgerrors.New("truncated header")
+	}
+	if m.globalVol > 128 {
+		m.globalVol = 128
+	}
+	if m.speed == 0 || m.speed == 255 {
+		m.speed = 6
+	}
+	if m.tempo < 33 {
+		m.tempo = 125
+	}
+	m.mixVol = float64(masterVol&0x7f) / 48
+	if m.mixVol == 0 {
+		m.mixVol = 1
+	}
+	stereo := masterVol&0x80 != 0
+
+	// the channels are the used ones among the 32 channel settings
+	var channels []int // settings index of each channel
+	for i, s := range settings {
+		if s&0x7f < 16 {
+			channels = append(channels, i)
+		}
+	}
+	m.Channels = len(channels)
+	if m.Channels == 0 {
+		return nil, pkgerrors.New("no channels")
+	}
+	chanOf := make(map[int]int)
+	for c, i := range channels {
+		chanOf[i] = c
+		pan := 64
+		if settings[i]&0x7f >= 8 {
+			pan = 192
+		}
+		if !stereo {
+			pan = 128
+		}
+		m.chanPan = append(m.chanPan, pan)
+		m.chanVol = append(m.chanVol, 64)
+		m.chanMute = append(m.chanMute, settings[i]&0x80 != 0)
+	}
+
+	for _, o := range r.bytes(numOrders) {
+		switch {
+		case o == 254:
+			m.Orders = append(m.Orders, OrderSkip)
+		case o == 255:
+			m.Orders = append(m.Orders, OrderEnd)
+		default:
+			m.Orders = append(m.Orders, int(o))
+		}
+	}
+	instPtrs := make([]int, numInst)
+	for i := range instPtrs {
+		instPtrs[i] = 16 * r.u16()
+	}
+	patPtrs := make([]int, numPatterns)
+	for i := range patPtrs {
+		patPtrs[i] = 16 * r.u16()
+	}
+	if defaultPan {
+		pans := r.bytes(32)
+		for c, i := range channels {
+			if pans[i]&0x20 != 0 && stereo {
+				m.chanPan[c] = int(pans[i]&0xf) * 256 / 15
+			}
+		}
+	}
+	if r.eof {
+		return nil, pkgerrors.New("truncated header")
+	}
+
+	for i, ptr := range instPtrs {
+		s, err := loadS3MSample(data, ptr, ffi != 1)
+		if err != nil {
+			return nil, pkgerrors.Wrapf(err, "sample %d", i+1)
+		}
+		m.samples = append(m.samples, s)
+		m.Samples = append(m.Samples, s.name)
+		m.instruments = append(m.instruments, sampleInstrument(s.name, i+1))
+	}
+
+	for _, ptr := range patPtrs {
+		pat := newPattern(64, m.Channels)
+		if ptr == 0 {
+			m.patterns = append(m.patterns, pat)
+			continue
+		}
+		r.seek(ptr + 2) // packed length
+		for row := 0; row < 64 && !r.eof; {
+			what := r.u8()
+			if what == 0 {
+				row++
+				continue
+			}
+			var cl cell
+			if what&0x20 != 0 {
+				note, inst := r.u8(), r.u8()
+				switch {
+				case note == 254:
+					cl.note = noteCut
+				case note < 0xa0 && note&0xf < 12:
+					// octave and note, C-4 is played at the C2SPD of the sample
+					cl.note = uint8(12*(int(note>>4)+1)+int(note&0xf)) + 1
+				}
+				cl.inst = uint8(inst)
+			}
+			if what&0x40 != 0 {
+				if v := r.u8(); v <= 64 {
+					cl.vc, cl.vp = vcVolume, uint8(v)
+				}
+			}
+			if what&0x80 != 0 {
+				cmd, info := r.u8(), r.u8()
+				cl.fx, cl.param = convertS3MEffect(cmd, info, false)
+			}
+			if c, ok := chanOf[int(what&0x1f)]; ok {
+				pat.cells[row*m.Channels+c] = cl
+			}
+		}
+		m.patterns = append(m.patterns, pat)
+	}
+	return m, nil
+}
+
+// loadS3MSample loads the sample whose header is at off.
+func loadS3MSample(data []byte, off int, unsigned bool) (*sample, error) {
+	r := &reader{p: data}
+	r.seek(off)
+	typ := r.u8()
+	r.bytes(12) // file name
+	memseg := r.bytes(3)
+	length, loopStart, loopEnd := r.u32(), r.u32(), r.u32()
+	volume := r.u8()
+	r.u8()
+	packed := r.u8()
+	flags := r.u8()
+	c2spd := r.u32()
+	r.bytes(12)
+	s := &sample{
+		name:      cstring(r.bytes(28)),
+		volume:    volume,
+		globalVol: 64,
+		pan:       -1,
+		c5speed:   float64(c2spd & 0xffff),
+	}
+	if r.eof {
+		return nil, pkgerrors.New("truncated sample header")
+	}
+	if s.volume > 64 {
+		s.volume = 64
+	}
+	if s.c5speed == 0 {
+		s.c5speed = 8363
+	}
+	if typ != 1 || packed != 0 || length == 0 {
+		return s, nil // not a PCM sample, like AdLib instruments, or empty
+	}
+	if length > 1<<26 {
+		return nil, pkgerrors.New("sample too long")
+	}
+	ptr := (int(memseg[0])<<16 | int(memseg[1]) | int(memseg[2])<<8) * 16
+	p := r.tail(ptr)
+	if flags&4 != 0 {
+		s.left = pcm16(p, length, !unsigned, false)
+		if flags&2 != 0 {
+			s.right = pcm16(r.tail(ptr+2*length), length, !unsigned, false)
+		}
+	} else {
+		s.left = pcm8(p, length, !unsigned, false)
+		if flags&2 != 0 {
+			s.right = pcm8(r.tail(ptr+length), length, !unsigned, false)
+		}
+	}
+	if flags&1 != 0 {
+		s.loop = loopForward
+		s.loopStart, s.loopEnd = loopStart, loopEnd
+	}
+	s.clampLoops()
+	return s, nil
+}
+
+// convertS3MEffect converts an S3M or IT effect, cmd 1 is A. The pattern break row is BCD in
+// S3M files.
+func convertS3MEffect(cmd, info int, it bool) (uint8, uint8) {
+	p := uint8(info)
+	switch cmd + 'A' - 1 {
+	case 'A':
+		if info != 0 {
+			return fxSpeed, p
+		}
+	case 'B':
+		return fxPositionJump, p
+	case 'C':
+		if !it {
+			p = uint8(info>>4*10 + info&0xf)
+		}
+		return fxPatternBreak, p
+	case 'D':
+		return fxVolSlide, p
+	case 'E':
+		return fxPortaDown, p
+	case 'F':
+		return fxPortaUp, p
+	case 'G':
+		return fxTonePorta, p
+	case 'H':
+		return fxVibrato, p
+	case 'I':
+		return fxTremor, p
+	case 'J':
+		return fxArpeggio, p
+	case 'K':
+		return fxVibratoVol, p
+	case 'L':
+		return fxTonePortaVol, p
+	case 'M':
+		if it {
+			return fxChannelVolume, p
+		}
+	case 'N':
+		if it {
+			return fxChanVolSlide, p
+		}
+	case 'O':
+		return fxOffset, p
+	case 'P':
+		if it {
+			return fxPanSlide, p
+		}
+	case 'Q':
+		return fxRetrig, p
+	case 'R':
+		return fxTremolo, p
+	case 'S':
+		return fxS3MExtended, p
+	case 'T':
+		return fxTempo, p
+	case 'U':
+		return fxFineVibrato, p
+	case 'V':
+		if !it {
+			if p > 64 {
+				p = 64
+			}
+			p *= 2
+		} else if p > 128 {
+			p = 128
+		}
+		return fxGlobalVolume, p
+	case 'W':
+		if it {
+			return fxGlobalVolSlide, p
+		}
+	case 'X':
+		if !it {
+			// 0 to 0x80, 0xa4 is surround
+			switch {
+			case info == 0xa4:
+				p = 128
+			case info > 0x80:
+				return fxNone, 0
+			case info == 0x80:
+				p = 255
+			default:
+				p = uint8(2 * info)
+			}
+		}
+		return fxPanning, p
+	case 'Y':
+		if it {
+			return fxPanbrello, p
+		}
+	}
+	return fxNone, 0
+}
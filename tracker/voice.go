@@ -0,0 +1,210 @@
+package tracker
+
+import "math"
+
+// voice plays a sample. Each channel has a voice for its current note; with the new note
+// actions of IT instruments, previous notes continue as background voices.
+type voice struct {
+	ch       int // channel index
+	smp      *sample
+	ins      *instrument
+	nna      int
+	pos      float64 // in frames
+	backward bool    // playing a ping-pong loop backwards
+	active   bool
+	stopping bool // ramped to silence in the next tick, then stopped
+	released bool // key off
+	fading   bool
+	fade     float64 // 1 to 0
+
+	envOn                       [3]bool // volume, panning and pitch envelopes
+	volTick, panTick, pitchTick int
+	autoVibPos, autoVibTick     int
+
+	// state of the channel while the voice is its current voice, kept by background voices
+	vol  float64 // 0 to 1, without envelope and fade
+	pan  int     // 0 to 256, without envelope
+	freq float64 // without pitch envelope and autovibrato
+
+	// computed for each tick
+	step         float64 // frames per output sample
+	gainL, gainR float64
+	prevL, prevR float64 // gains of the previous tick, ramped from
+}
+
+func newVoice(ch int, smp *sample, ins *instrument) *voice {
+	v := &voice{ch: ch, smp: smp, ins: ins, active: true, fade: 1}
+	if ins != nil {
+		v.nna = ins.nna
+		v.envOn = [3]bool{ins.volEnv.enabled, ins.panEnv.enabled, ins.pitchEnv.enabled}
+	}
+	return v
+}
+
+// stop stops v after ramping it to silence.
+func (v *voice) stop() {
+	if v != nil {
+		v.stopping = true
+	}
+}
+
+// resetEnvelopes restarts the envelopes of v and cancels its release.
+func (v *voice) resetEnvelopes() {
+	v.volTick, v.panTick, v.pitchTick = 0, 0, 0
+	v.released, v.fading, v.fade = false, false, 1
+}
+
+// loop returns the loop played by v, the sustain loop until the note is released. Without a
+// loop, start is 0 and end is the length of the sample.
+func (v *voice) loop() (typ loopType, start, end int) {
+	s := v.smp
+	switch {
+	case s.sus != loopNone && !v.released:
+		return s.sus, s.susStart, s.susEnd
+	case s.loop != loopNone:
+		return s.loop, s.loopStart, s.loopEnd
+	}
+	return loopNone, 0, s.length()
+}
+
+// move moves v by d frames in the direction of playback. It returns false if the end of the
+// sample is reached.
+func (v *voice) move(d float64) bool {
+	typ, ls, le := v.loop()
+	start, end := float64(ls), float64(le)
+	if typ != loopPingPong {
+		v.backward = false
+	}
+	if v.backward {
+		v.pos -= d
+	} else {
+		v.pos += d
+	}
+	switch typ {
+	case loopNone:
+		if v.pos >= end || v.pos < 0 {
+			v.active = false
+			return false
+		}
+	case loopForward:
+		if v.pos >= end {
+			v.pos = start + math.Mod(v.pos-end, end-start)
+		}
+	case loopPingPong:
+		n := end - start
+		switch {
+		case !v.backward && v.pos >= end:
+			if over := math.Mod(v.pos-end, 2*n); over < n {
+				v.pos, v.backward = end-over, true
+			} else {
+				v.pos = start + over - n
+			}
+		case v.backward && v.pos < start:
+			if over := math.Mod(start-v.pos, 2*n); over < n {
+				v.pos, v.backward = start+over, false
+			} else {
+				v.pos = end - (over - n)
+			}
+		}
+	}
+	return true
+}
+
+// frame returns the frame at index i, which may be beyond the loop end, following the loop.
+func (v *voice) frame(i int, typ loopType, ls, le int) (float32, float32) {
+	switch {
+	case i >= le && typ == loopForward:
+		i = ls + (i-le)%(le-ls)
+	case i >= le && typ == loopPingPong:
+		i = le - 1 - (i-le)%(le-ls)
+	case i < ls && typ == loopPingPong && v.backward:
+		i = ls + (ls-1-i)%(le-ls)
+	}
+	s := v.smp
+	if i < 0 || i >= s.length() {
+		return 0, 0
+	}
+	if s.right == nil {
+		return s.left[i], s.left[i]
+	}
+	return s.left[i], s.right[i]
+}
+
+// value returns the interpolated frame at the position of v.
+func (v *voice) value(interp Interpolation) (float64, float64) {
+	typ, ls, le := v.loop()
+	i := int(math.Floor(v.pos))
+	t := v.pos - float64(i)
+	switch interp {
+	case Nearest:
+		l, r := v.frame(i, typ, ls, le)
+		return float64(l), float64(r)
+	case Linear:
+		l0, r0 := v.frame(i, typ, ls, le)
+		l1, r1 := v.frame(i+1, typ, ls, le)
+		return float64(l0) + (float64(l1)-float64(l0))*t, float64(r0) + (float64(r1)-float64(r0))*t
+	}
+	lm, rm := v.frame(i-1, typ, ls, le)
+	l0, r0 := v.frame(i, typ, ls, le)
+	l1, r1 := v.frame(i+1, typ, ls, le)
+	l2, r2 := v.frame(i+2, typ, ls, le)
+	return cubic(lm, l0, l1, l2, t), cubic(rm, r0, r1, r2, t)
+}
+
+// cubic interpolates between b and c with a Catmull-Rom spline.
+func cubic(a, b, c, d float32, t float64) float64 {
+	y0, y1, y2, y3 := float64(a), float64(b), float64(c), float64(d)
+	return y1 + 0.5*t*(y2-y0+t*(2*y0-5*y1+4*y2-y3+t*(3*(y1-y2)+y3-y0)))
+}
+
+// mix adds the output of v to buf, ramping the gains over the first ramp frames.
+func (v *voice) mix(buf [][2]float64, ramp int, interp Interpolation) {
+	if v.gainL == 0 && v.gainR == 0 && v.prevL == 0 && v.prevR == 0 {
+		v.skip(len(buf))
+		return
+	}
+	for i := range buf {
+		gl, gr := v.gainL, v.gainR
+		if i < ramp {
+			t := float64(i+1) / float64(ramp)
+			gl = v.prevL + (v.gainL-v.prevL)*t
+			gr = v.prevR + (v.gainR-v.prevR)*t
+		}
+		l, r := v.value(interp)
+		buf[i][0] += gl * l
+		buf[i][1] += gr * r
+		if !v.move(v.step) {
+			break
+		}
+	}
+	v.prevL, v.prevR = v.gainL, v.gainR
+	if v.stopping {
+		v.active = false
+	}
+}
+
+// skip advances v by n frames without mixing.
+func (v *voice) skip(n int) {
+	v.move(v.step * float64(n))
+	v.prevL, v.prevR = v.gainL, v.gainR
+	if v.stopping {
+		v.active = false
+	}
+}
+
+// advance returns the tick following tick t of envelope e.
+func (e *envelope) advance(t int, released bool) int {
+	t++
+	switch {
+	case e.sus && !released && t > e.points[e.susEnd].tick:
+		t = e.points[e.susStart].tick
+	case e.loop && t > e.points[e.loopEnd].tick:
+		t = e.points[e.loopStart].tick
+	}
+	return t
+}
+
+// ended reports whether tick t is past the last point of e.
+func (e *envelope) ended(t int) bool {
+	return t > e.points[len(e.points)-1].tick
+}
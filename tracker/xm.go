@@ -0,0 +1,300 @@
+package tracker
+
+import (
+	"math"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+// loadXM loads a FastTracker II module.
+func loadXM(data []byte) (*Module, error) {
+	r := &reader{p: data}
+	r.seek(17)
+	m := &Module{
+		Title:      cstring(r.bytes(20)),
+		Format:     "XM",
+		mixVol:     1,
+		separation: 1,
+	}
+	r.seek(58)
+	if version := r.u16(); version < 0x0104 {
+		return nil, pkgerrors.Errorf("unsupported XM version %x", version)
+	}
+	headerSize := r.u32()
+	songLen := r.u16()
+	r.u16() // restart position, the song ends instead of looping
+	channels := r.u16()
+	numPatterns := r.u16()
+	numInst := r.u16()
+	flags := r.u16()
+	m.speed, m.tempo = r.u16(), r.u16()
+	orders := r.bytes(256)
+	if r.eof {
+		return nil, pkgerrors.New("truncated header")
+	}
+	if channels < 1 || channels > 64 {
+		return nil, pkgerrors.Errorf("invalid number of channels %d", channels)
+	}
+	if songLen > 256 || numPatterns > 256 || numInst > 128 {
+		return nil, pkgerrors.New("invalid header")
+	}
+	m.Channels = channels
+	m.linear = flags&1 != 0
+	m.globalVol = 128
+	if m.speed == 0 {
+		m.speed = 6
+	}
+	if m.tempo < 32 {
+		m.tempo = 125
+	}
+	for _, o := range orders[:songLen] {
+		m.Orders = append(m.Orders, int(o))
+	}
+	for c := 0; c < channels; c++ {
+		m.chanPan = append(m.chanPan, 128)
+		m.chanVol = append(m.chanVol, 64)
+		m.chanMute = append(m.chanMute, false)
+	}
+
+	r.seek(60 + headerSize)
+	for i := 0; i < numPatterns; i++ {
+		start := r.pos
+		length := r.u32()
+		r.u8() // packing type
+		rows := r.u16()
+		size := r.u16()
+		if r.eof {
+			return nil, pkgerrors.Errorf("truncated pattern %d", i)
+		}
+		if rows == 0 || rows > 256 {
+			rows = 64
+		}
+		pat := newPattern(rows, channels)
+		r.seek(start + length)
+		end := r.pos + size
+		for j := 0; size > 0 && j < len(pat.cells) && r.pos < end; j++ {
+			var note, inst, vol, fx, param int
+			b := r.u8()
+			if b&0x80 != 0 {
+				if b&1 != 0 {
+					note = r.u8()
+				}
+				if b&2 != 0 {
+					inst = r.u8()
+				}
+				if b&4 != 0 {
+					vol = r.u8()
+				}
+				if b&8 != 0 {
+					fx = r.u8()
+				}
+				if b&16 != 0 {
+					param = r.u8()
+				}
+			} else {
+				note = b
+				inst, vol, fx, param = r.u8(), r.u8(), r.u8(), r.u8()
+			}
+			c := &pat.cells[j]
+			switch {
+			case note == 97:
+				c.note = noteOff
+			case note > 0 && note < 97:
+				// C-4 is played at the speed of the sample
+				c.note = uint8(note + 12)
+			}
+			c.inst = uint8(inst)
+			c.vc, c.vp = convertXMVolume(vol)
+			c.fx, c.param = convertMODEffect(fx, param)
+		}
+		r.seek(end)
+		m.patterns = append(m.patterns, pat)
+	}
+
+	for i := 0; i < numInst; i++ {
+		ins, smps, err := loadXMInstrument(r, len(m.samples))
+		if err != nil {
+			return nil, pkgerrors.Wrapf(err, "instrument %d", i+1)
+		}
+		m.instruments = append(m.instruments, ins)
+		m.Instruments = append(m.Instruments, ins.name)
+		for _, s := range smps {
+			m.samples = append(m.samples, s)
+			m.Samples = append(m.Samples, s.name)
+		}
+	}
+	return m, nil
+}
+
+// loadXMInstrument loads an instrument and its samples. base is the number of samples loaded
+// before.
+func loadXMInstrument(r *reader, base int) (*instrument, []*sample, error) {
+	start := r.pos
+	size := r.u32()
+	ins := &instrument{name: cstring(r.bytes(22)), globalVol: 128, pan: -1}
+	r.u8() // type
+	numSamples := r.u16()
+	if r.eof {
+		return nil, nil, pkgerrors.New("truncated instrument header")
+	}
+	if numSamples == 0 {
+		r.seek(start + size)
+		return ins, nil, nil
+	}
+	if numSamples > 16 {
+		return nil, nil, pkgerrors.New("too many samples")
+	}
+	r.u32() // sample header size
+	keymap := r.bytes(96)
+	volPoints, panPoints := r.bytes(48), r.bytes(48)
+	numVol, numPan := r.u8(), r.u8()
+	volSus, volLoopStart, volLoopEnd := r.u8(), r.u8(), r.u8()
+	panSus, panLoopStart, panLoopEnd := r.u8(), r.u8(), r.u8()
+	volType, panType := r.u8(), r.u8()
+	vibType, vibSweep, vibDepth, vibRate := xmVibratoTypes[r.u8()&3], r.u8(), r.u8(), r.u8()
+	fadeout := r.u16()
+	if r.eof {
+		return nil, nil, pkgerrors.New("truncated instrument header")
+	}
+	ins.fadeout = float64(fadeout) / 32768
+	for i := 0; i < 96; i++ {
+		ins.keymap[i+12] = keymapEntry{note: uint8(i + 12), sample: 0}
+		if int(keymap[i]) < numSamples {
+			ins.keymap[i+12].sample = base + int(keymap[i]) + 1
+		}
+	}
+	// notes outside of the XM range play the samples of the nearest notes
+	for i := 0; i < 12; i++ {
+		ins.keymap[i] = keymapEntry{note: uint8(i), sample: ins.keymap[12].sample}
+	}
+	for i := 108; i < 120; i++ {
+		ins.keymap[i] = keymapEntry{note: uint8(i), sample: ins.keymap[107].sample}
+	}
+	ins.volEnv = xmEnvelope(volPoints, numVol, volType, volSus, volLoopStart, volLoopEnd, 0)
+	ins.panEnv = xmEnvelope(panPoints, numPan, panType, panSus, panLoopStart, panLoopEnd, 32)
+	r.seek(start + size)
+
+	type xmSample struct {
+		length, loopStart, loopLen, typ int
+	}
+	headers := make([]xmSample, numSamples)
+	smps := make([]*sample, numSamples)
+	for i := range smps {
+		var h xmSample
+		h.length, h.loopStart, h.loopLen = r.u32(), r.u32(), r.u32()
+		volume := r.u8()
+		finetune := int(int8(r.u8()))
+		h.typ = r.u8()
+		pan := r.u8()
+		relNote := int(int8(r.u8()))
+		r.u8()
+		s := &sample{
+			name:      cstring(r.bytes(22)),
+			volume:    volume,
+			globalVol: 64,
+			pan:       pan,
+			// C-4 plays at 8363 Hz with relative note 0 and finetune 0
+			c5speed:  8363 * math.Exp2((float64(relNote)+float64(finetune)/128)/12),
+			vibType:  vibType,
+			vibSweep: vibSweep,
+			vibDepth: vibDepth,
+			vibRate:  vibRate,
+		}
+		if s.volume > 64 {
+			s.volume = 64
+		}
+		if h.typ&16 != 0 {
+			// lengths are in bytes
+			h.length, h.loopStart, h.loopLen = h.length/2, h.loopStart/2, h.loopLen/2
+		}
+		switch h.typ & 3 {
+		case 1:
+			s.loop = loopForward
+		case 2:
+			s.loop = loopPingPong
+		}
+		if h.loopLen == 0 {
+			s.loop = loopNone
+		}
+		s.loopStart, s.loopEnd = h.loopStart, h.loopStart+h.loopLen
+		headers[i], smps[i] = h, s
+	}
+	if r.eof {
+		return nil, nil, pkgerrors.New("truncated sample header")
+	}
+	for i, h := range headers {
+		if h.length > 1<<26 {
+			return nil, nil, pkgerrors.New("sample too long")
+		}
+		p := r.tail(r.pos)
+		if h.typ&16 != 0 {
+			smps[i].left = pcm16(p, h.length, true, true)
+			r.pos += 2 * h.length
+		} else {
+			smps[i].left = pcm8(p, h.length, true, true)
+			r.pos += h.length
+		}
+		smps[i].clampLoops()
+	}
+	return ins, smps, nil
+}
+
+// xmVibratoTypes maps the XM autovibrato waveforms, sine, square, ramp down and ramp up, to
+// the waveforms of the player.
+var xmVibratoTypes = [4]int{waveSine, waveSquare, waveRampDown, waveRampUp}
+
+// xmEnvelope decodes an XM envelope. Values are offset by sub.
+func xmEnvelope(points []byte, n, typ, sus, loopStart, loopEnd, sub int) envelope {
+	e := envelope{
+		enabled:   typ&1 != 0,
+		sus:       typ&2 != 0,
+		loop:      typ&4 != 0,
+		susStart:  sus,
+		susEnd:    sus,
+		loopStart: loopStart,
+		loopEnd:   loopEnd,
+	}
+	if n > 12 {
+		n = 12
+	}
+	for i := 0; i < n; i++ {
+		tick := int(points[4*i]) | int(points[4*i+1])<<8
+		value := int(points[4*i+2]) | int(points[4*i+3])<<8
+		if value > 64 {
+			value = 64
+		}
+		e.points = append(e.points, envPoint{tick: tick, value: value - sub})
+	}
+	e.sanitize()
+	return e
+}
+
+// convertXMVolume converts an XM volume column byte.
+func convertXMVolume(v int) (uint8, uint8) {
+	x := uint8(v & 0xf)
+	switch {
+	case v >= 0x10 && v <= 0x50:
+		return vcVolume, uint8(v - 0x10)
+	case v>>4 == 0x6:
+		return vcVolSlideDown, x
+	case v>>4 == 0x7:
+		return vcVolSlideUp, x
+	case v>>4 == 0x8:
+		return vcFineVolDown, x
+	case v>>4 == 0x9:
+		return vcFineVolUp, x
+	case v>>4 == 0xa:
+		return vcVibratoSpeed, x
+	case v>>4 == 0xb:
+		return vcVibratoDepth, x
+	case v>>4 == 0xc:
+		return vcPanning, x * 64 / 15
+	case v>>4 == 0xd:
+		return vcPanSlideLeft, x
+	case v>>4 == 0xe:
+		return vcPanSlideRight, x
+	case v>>4 == 0xf:
+		return vcTonePorta, x << 4
+	}
+	return vcNone, 0
+}
@@ -0,0 +1,10 @@
+package vorbis
+
+import "github.com/rickcollette/megasound/internal/vorbiscomment"
+
+// Comments is the comment header of a vorbis stream. Use its Get method to look up fields by name
+// and Pictures to decode embedded METADATA_BLOCK_PICTURE images.
+type Comments = vorbiscomment.Comments
+
+// Picture is an image embedded in a METADATA_BLOCK_PICTURE comment field.
+type Picture = vorbiscomment.Picture
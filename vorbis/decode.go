@@ -2,92 +2,471 @@
 package vorbis
 
 import (
+	"fmt"
 	"io"
+	"sort"
 
 	"github.com/rickcollette/megasound"
-	"github.com/jfreymuth/oggvorbis"
+	"github.com/rickcollette/megasound/internal/ogg"
+	"github.com/rickcollette/megasound/tags"
+	"github.com/jfreymuth/vorbis"
 	pkgerrors "github.com/pkg/errors"
 )
 
-const (
-	govorbisNumChannels = 2
-	govorbisPrecision   = 2
-)
+// govorbisPrecision is the precision reported for decoded streams. Vorbis decodes to 32 bit
+// floats, which are streamed without any conversion.
+const govorbisPrecision = 4
+
+// seekMargin is how many samples before the target decoding starts when seeking, so that the
+// overlap of the first decoded block is complete. It's the largest vorbis block size.
+const seekMargin = 8192
+
+func init() {
+	megasound.RegisterFormat("vorbis", func(head []byte) bool {
+		return isIdentification(ogg.FirstBody(head))
+	}, Decode)
+}
 
 // Decode takes a ReadCloser containing audio data in ogg/vorbis format and returns a StreamSeekCloser,
-// which streams that audio. The Seek method will panic if rc is not io.Seeker.
+// which streams that audio. The Seek method returns an error if rc is not io.Seeker.
+//
+// The returned Format reports the channel count of the stream. Mono streams are streamed with
+// both channels equal, only the front left and right channels of streams with more channels are
+// streamed.
+//
+// Chained streams (several logical streams one after another, like concatenated files or
+// internet radio dumps) are streamed as a whole. Use Open to get notified when a new logical
+// stream starts.
+//
+// The returned StreamSeekCloser implements tags.Tagger, see Decoder.Tags.
 //
 // Do not close the supplied ReadSeekCloser, instead, use the Close method of the returned
 // StreamSeekCloser when you want to release the resources.
 func Decode(rc io.ReadCloser) (s megasound.StreamSeekCloser, format megasound.Format, err error) {
+	d, format, err := Open(rc)
+	if err != nil {
+		return nil, megasound.Format{}, err
+	}
+	return d, format, nil
+}
+
+// Open is like Decode, but returns a *Decoder, which gives access to the vorbis comments and
+// reports the logical streams of chained files.
+func Open(rc io.ReadCloser) (d *Decoder, format megasound.Format, err error) {
 	defer func() {
 		if err != nil {
 			err = pkgerrors.Wrap(err, "ogg/vorbis")
 		}
 	}()
-	d, err := oggvorbis.NewReader(rc)
+	d = &Decoder{
+		closer: rc,
+		or:     ogg.NewReader(rc),
+		next:   -1,
+	}
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		if d.links, err = scanLinks(rs); err != nil {
+			return nil, megasound.Format{}, err
+		}
+		if len(d.links) == 0 {
+			return nil, megasound.Format{}, pkgerrors.New("no vorbis stream found")
+		}
+		if err := d.or.SeekPage(d.links[0].offset); err != nil {
+			return nil, megasound.Format{}, err
+		}
+	}
+	for d.vd == nil {
+		pkt, err := d.or.ReadPacket()
+		if err == io.EOF {
+			return nil, megasound.Format{}, pkgerrors.New("no vorbis stream found")
+		}
+		if err != nil {
+			return nil, megasound.Format{}, err
+		}
+		if pkt.BOS && isIdentification(pkt.Data) {
+			if err := d.startLink(pkt); err != nil {
+				return nil, megasound.Format{}, err
+			}
+		}
+	}
+	return d, d.format, nil
+}
+
+// Link describes a logical stream of a (possibly chained) Ogg Vorbis stream.
+type Link struct {
+	Format   megasound.Format
+	Comments Comments
+
+	// Start is the position of the first sample of the logical stream in the whole stream.
+	Start int
+}
+
+// Decoder is a StreamSeekCloser decoding an Ogg Vorbis stream. It is returned by Open.
+type Decoder struct {
+	closer io.Closer
+	or     *ogg.Reader
+	links  []link // nil if the source is not seekable
+	link   int    // index of the current link
+
+	serial   uint32
+	vd       *vorbis.Decoder
+	format   megasound.Format
+	comments Comments
+	start    int // position of the current link in the whole stream
+	onLink   func(Link)
+
+	buf    []float32
+	pend   [][2]float64 // decoded samples whose position is not known yet
+	out    [][2]float64 // samples ready to be streamed
+	outPos int
+	next   int64 // position of pend[0] in the current link, -1 if not known yet
+	skipTo int64 // samples of the current link before this position are dropped
+
+	fromStart bool // true if decoding started at the beginning of the current link
+
+	pos int
+	err error
+}
+
+// link is a logical stream found by scanLinks.
+type link struct {
+	serial uint32
+	offset int64  // offset of the BOS page
+	pages  []page // pages of the logical stream with a granule position
+	start  int    // position of the first sample in the whole stream
+	length int
+}
+
+type page struct {
+	end     int64 // offset just after the page
+	granule int64
+}
+
+// isIdentification reports whether p is a vorbis identification header.
+func isIdentification(p []byte) bool {
+	return len(p) >= 7 && p[0] == 1 && string(p[1:7]) == "vorbis"
+}
+
+// scanLinks finds all logical vorbis streams in rs.
+func scanLinks(rs io.ReadSeeker) ([]link, error) {
+	pages, err := ogg.Scan(rs)
 	if err != nil {
-		return nil, megasound.Format{}, err
+		return nil, err
+	}
+	var (
+		links []link
+		cur   *link
+		start int
+	)
+	for _, p := range pages {
+		if p.Flags&ogg.FlagBOS != 0 && isIdentification(p.Head) {
+			if cur != nil {
+				start += cur.length
+			}
+			links = append(links, link{serial: p.Serial, offset: p.Offset, start: start})
+			cur = &links[len(links)-1]
+			continue
+		}
+		if cur == nil || p.Serial != cur.serial || p.Granule < 0 {
+			continue
+		}
+		cur.pages = append(cur.pages, page{end: p.Offset + p.Size, granule: p.Granule})
+		if int(p.Granule) > cur.length {
+			cur.length = int(p.Granule)
+		}
+	}
+	return links, nil
+}
+
+// OnLink sets a function, which is called from Stream whenever a new logical stream starts,
+// and from Seek when seeking into a different logical stream. The format may change between
+// logical streams.
+func (d *Decoder) OnLink(f func(Link)) {
+	d.onLink = f
+}
+
+// Comments returns the comment header of the current logical stream.
+func (d *Decoder) Comments() Comments {
+	return d.comments
+}
+
+// Tags returns the tags stored in the comment header of the current logical stream.
+func (d *Decoder) Tags() tags.Tags {
+	return tags.FromComments(d.comments.Fields)
+}
+
+// Format returns the format of the current logical stream.
+func (d *Decoder) Format() megasound.Format {
+	return d.format
+}
+
+// startLink reads the headers of the logical stream starting with the identification header
+// pkt and sets up decoding of it.
+func (d *Decoder) startLink(pkt ogg.Packet) error {
+	vd := new(vorbis.Decoder)
+	if err := vd.ReadHeader(pkt.Data); err != nil {
+		return err
+	}
+	for !vd.HeadersRead() {
+		hp, err := d.or.ReadPacket()
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		if hp.Serial != pkt.Serial {
+			continue
+		}
+		if err := vd.ReadHeader(hp.Data); err != nil {
+			return err
+		}
 	}
-	format = megasound.Format{
-		SampleRate:  megasound.SampleRate(d.SampleRate()),
-		NumChannels: govorbisNumChannels,
+	d.serial = pkt.Serial
+	d.vd = vd
+	d.format = megasound.Format{
+		SampleRate:  megasound.SampleRate(vd.SampleRate()),
+		NumChannels: vd.Channels(),
 		Precision:   govorbisPrecision,
 	}
-	return &decoder{rc, d, format, nil}, format, nil
+	d.comments = Comments{
+		Vendor: vd.Vendor,
+		Fields: vd.Comments,
+	}
+	if cap(d.buf) < vd.BufferSize() {
+		d.buf = make([]float32, vd.BufferSize())
+	}
+	d.next = -1
+	d.skipTo = 0
+	d.fromStart = true
+	return nil
 }
 
-type decoder struct {
-	closer io.Closer
-	d      *oggvorbis.Reader
-	f      megasound.Format
-	err    error
+// notify calls the OnLink function for the current logical stream.
+func (d *Decoder) notify() {
+	if d.onLink != nil {
+		d.onLink(Link{Format: d.format, Comments: d.comments, Start: d.start})
+	}
 }
 
-func (d *decoder) Stream(samples [][2]float64) (n int, ok bool) {
+// Stream streams the decoded audio.
+func (d *Decoder) Stream(samples [][2]float64) (n int, ok bool) {
 	if d.err != nil {
 		return 0, false
 	}
-	var tmp [2]float32
-	for i := range samples {
-		dn, err := d.d.Read(tmp[:])
-		if dn == 2 {
-			samples[i][0], samples[i][1] = float64(tmp[0]), float64(tmp[1])
-			n++
-			ok = true
+	for n < len(samples) {
+		if d.outPos < len(d.out) {
+			c := copy(samples[n:], d.out[d.outPos:])
+			d.outPos += c
+			d.pos += c
+			n += c
+			continue
 		}
-		if err == io.EOF {
+		d.out, d.outPos = d.out[:0], 0
+		if !d.decode() {
 			break
 		}
-		if err != nil {
+	}
+	return n, n > 0
+}
+
+// decode reads and decodes the next packet. It returns false when there is nothing left to decode
+// or an error occurred.
+func (d *Decoder) decode() bool {
+	pkt, err := d.or.ReadPacket()
+	if err == io.EOF {
+		d.release()
+		return len(d.out) > 0
+	}
+	if err != nil {
+		d.err = pkgerrors.Wrap(err, "ogg/vorbis")
+		return false
+	}
+	if pkt.BOS {
+		if !isIdentification(pkt.Data) {
+			return true // another codec multiplexed in the stream
+		}
+		d.release()
+		start := d.start + d.outLen()
+		if err := d.startLink(pkt); err != nil {
 			d.err = pkgerrors.Wrap(err, "ogg/vorbis")
-			break
+			return false
 		}
+		d.link++
+		if d.links != nil && d.link < len(d.links) {
+			start = d.links[d.link].start
+		}
+		d.start = start
+		d.notify()
+		return true
+	}
+	if pkt.Serial != d.serial || vorbis.IsHeader(pkt.Data) {
+		return true
+	}
+
+	out, err := d.vd.DecodeInto(pkt.Data, d.buf)
+	if err != nil {
+		d.err = pkgerrors.Wrap(err, "ogg/vorbis")
+		return false
+	}
+	channels := d.vd.Channels()
+	right := rightChannel(channels)
+	for i := 0; i+channels <= len(out); i += channels {
+		d.pend = append(d.pend, [2]float64{float64(out[i]), float64(out[i+right])})
 	}
-	return n, ok
+
+	if pkt.Granule >= 0 {
+		switch {
+		case d.next < 0 && pkt.EOS && d.fromStart:
+			// a stream short enough to fit in a single page, the start is the true start
+			d.next = 0
+			if int64(len(d.pend)) > pkt.Granule {
+				d.pend = d.pend[:pkt.Granule]
+			}
+		case d.next < 0:
+			// the position of the decoded samples is known from the end
+			d.next = pkt.Granule - int64(len(d.pend))
+		case pkt.EOS && d.next+int64(len(d.pend)) > pkt.Granule:
+			// the last page tells the true end of the stream
+			keep := pkt.Granule - d.next
+			if keep < 0 {
+				keep = 0
+			}
+			d.pend = d.pend[:keep]
+		}
+		d.release()
+	}
+	return true
 }
 
-func (d *decoder) Err() error {
+// outLen returns the length of the current link streamed or ready to be streamed.
+func (d *Decoder) outLen() int {
+	return d.pos - d.start + len(d.out) - d.outPos
+}
+
+// release moves the pending samples to the output, dropping those before skipTo.
+func (d *Decoder) release() {
+	if d.next < 0 {
+		d.next = 0
+	}
+	p := d.pend
+	if drop := d.skipTo - d.next; drop > 0 {
+		if drop > int64(len(p)) {
+			drop = int64(len(p))
+		}
+		p = p[drop:]
+	}
+	d.out = append(d.out, p...)
+	d.next += int64(len(d.pend))
+	d.pend = d.pend[:0]
+}
+
+// rightChannel returns the index of the front right channel in the vorbis channel order.
+func rightChannel(channels int) int {
+	switch channels {
+	case 1:
+		return 0
+	case 3, 5, 6, 7, 8:
+		return 2 // front left, center, front right, ...
+	default:
+		return 1
+	}
+}
+
+// Err returns an error which occurred during decoding.
+func (d *Decoder) Err() error {
 	return d.err
 }
 
-func (d *decoder) Len() int {
-	return int(d.d.Length())
+// Len returns the total number of samples of all logical streams, 0 if the source is not
+// seekable.
+func (d *Decoder) Len() int {
+	if len(d.links) == 0 {
+		return 0
+	}
+	last := d.links[len(d.links)-1]
+	return last.start + last.length
 }
 
-func (d *decoder) Position() int {
-	return int(d.d.Position())
+// Position returns the current position in the whole stream.
+func (d *Decoder) Position() int {
+	return d.pos
 }
 
-func (d *decoder) Seek(p int) error {
-	err := d.d.SetPosition(int64(p))
-	if err != nil {
-		return pkgerrors.Wrap(err, "ogg/vorbis")
+// Seek sets the position in the whole stream.
+func (d *Decoder) Seek(p int) error {
+	if d.links == nil {
+		return pkgerrors.New("ogg/vorbis: seek: resource is not io.Seeker")
+	}
+	if p < 0 || d.Len() < p {
+		return fmt.Errorf("ogg/vorbis: seek position %v out of range [%v, %v]", p, 0, d.Len())
+	}
+	li := sort.Search(len(d.links), func(i int) bool { return d.links[i].start > p }) - 1
+	if li < 0 {
+		li = 0
+	}
+	l := d.links[li]
+	t := int64(p - l.start)
+
+	// the last page completely before the target, with some margin for the overlap. Decoding
+	// must not start right before the last page, as the position of the decoded samples is only
+	// known from the granule position of a page which is not the last one.
+	pi := sort.Search(len(l.pages), func(i int) bool { return l.pages[i].granule > t-seekMargin }) - 1
+	if pi > len(l.pages)-3 {
+		pi = len(l.pages) - 3
+	}
+
+	if pi < 0 || li != d.link {
+		if err := d.seekLink(li); err != nil {
+			return pkgerrors.Wrap(err, "ogg/vorbis")
+		}
+	}
+	if pi >= 0 {
+		if err := d.or.SeekPage(l.pages[pi].end); err != nil {
+			return pkgerrors.Wrap(err, "ogg/vorbis")
+		}
+		d.vd.Clear()
+		d.fromStart = false
+	}
+	d.pend = d.pend[:0]
+	d.out, d.outPos = d.out[:0], 0
+	d.next = -1
+	d.skipTo = t
+	d.pos = p
+	return nil
+}
+
+// seekLink positions the decoder at the start of the li-th logical stream and reads its headers.
+func (d *Decoder) seekLink(li int) error {
+	l := d.links[li]
+	if err := d.or.SeekPage(l.offset); err != nil {
+		return err
+	}
+	for {
+		pkt, err := d.or.ReadPacket()
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		if pkt.BOS && pkt.Serial == l.serial {
+			if err := d.startLink(pkt); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	changed := d.link != li
+	d.link = li
+	d.start = l.start
+	if changed {
+		d.notify()
 	}
 	return nil
 }
 
-func (d *decoder) Close() error {
+// Close closes the underlying resource.
+func (d *Decoder) Close() error {
 	err := d.closer.Close()
 	if err != nil {
 		return pkgerrors.Wrap(err, "ogg/vorbis")
@@ -0,0 +1,180 @@
+package vorbis
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/rickcollette/megasound"
+)
+
+// readSeekCloser is a bytes.Reader with a Close method.
+type readSeekCloser struct {
+	*bytes.Reader
+}
+
+func (readSeekCloser) Close() error { return nil }
+
+// readCloser hides the io.Seeker of a reader.
+type readCloser struct {
+	io.Reader
+}
+
+func (readCloser) Close() error { return nil }
+
+// readAll streams all of d.
+func readAll(t *testing.T, d *Decoder) [][2]float64 {
+	t.Helper()
+	var out [][2]float64
+	buf := make([][2]float64, 777)
+	for {
+		n, ok := d.Stream(buf)
+		out = append(out, buf[:n]...)
+		if !ok {
+			break
+		}
+	}
+	if d.Err() != nil {
+		t.Fatal(d.Err())
+	}
+	return out
+}
+
+// compare reports the first sample of got differing from want.
+func compare(t *testing.T, what string, got, want [][2]float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: %d samples, want %d", what, len(got), len(want))
+	}
+	for i := 0; i < len(got) && i < len(want); i++ {
+		if math.Abs(got[i][0]-want[i][0]) > 1e-6 || math.Abs(got[i][1]-want[i][1]) > 1e-6 {
+			t.Errorf("%s: sample %d is %v, want %v", what, i, got[i], want[i])
+			return
+		}
+	}
+}
+
+// testLinks returns the chain of the mono and the stereo test file and their decoded samples.
+func testLinks(t *testing.T) ([]byte, [2][][2]float64) {
+	var (
+		chain   []byte
+		samples [2][][2]float64
+	)
+	for i, name := range []string{"testdata/mono.ogg", "testdata/stereo.ogg"} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, _, err := Open(readSeekCloser{bytes.NewReader(data)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		samples[i] = readAll(t, d)
+		if d.Len() != len(samples[i]) {
+			t.Fatalf("%s: Len() = %d, decoded %d samples", name, d.Len(), len(samples[i]))
+		}
+		chain = append(chain, data...)
+	}
+	return chain, samples
+}
+
+func TestChain(t *testing.T) {
+	chain, samples := testLinks(t)
+	want := append(append([][2]float64(nil), samples[0]...), samples[1]...)
+	second := len(samples[0])
+
+	d, format, err := Open(readSeekCloser{bytes.NewReader(chain)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format.NumChannels != 1 || format.SampleRate != 44100 {
+		t.Errorf("format %+v, want the mono format of the first link", format)
+	}
+	if d.Len() != len(want) {
+		t.Errorf("Len() = %d, want %d", d.Len(), len(want))
+	}
+	var links []Link
+	d.OnLink(func(l Link) {
+		links = append(links, l)
+		// the callback is called before the samples of the link are streamed
+		if d.Position() > l.Start {
+			t.Errorf("link at %d reported at position %d", l.Start, d.Position())
+		}
+	})
+	compare(t, "chain", readAll(t, d), want)
+	if len(links) != 1 || links[0].Start != second || links[0].Format.NumChannels != 2 {
+		t.Errorf("links %+v, want the stereo link at %d", links, second)
+	}
+	if d.Format().NumChannels != 2 || d.Comments().Vendor != links[0].Comments.Vendor {
+		t.Errorf("format %+v and comments %+v of the last link", d.Format(), d.Comments())
+	}
+
+	// seeking gives the same samples as streaming from the start, and reports changes of link
+	d.OnLink(func(l Link) { links = append(links, l) })
+	for _, tc := range []struct {
+		pos   int
+		links []int // starts of the links reported
+	}{
+		{second + 3000, nil},
+		{second, nil},
+		{10, []int{0}},
+		{second - 100, []int{second}}, // the stereo link starts while streaming
+		{len(want) - 100, nil},
+		{0, []int{0}},
+	} {
+		links = links[:0]
+		if err := d.Seek(tc.pos); err != nil {
+			t.Fatal(err)
+		}
+		if d.Position() != tc.pos {
+			t.Errorf("Seek(%d): Position() = %d", tc.pos, d.Position())
+		}
+		buf := make([][2]float64, 200)
+		n, _ := d.Stream(buf)
+		end := tc.pos + 200
+		if end > len(want) {
+			end = len(want)
+		}
+		compare(t, "chain after seeking", buf[:n], want[tc.pos:end])
+		if len(links) != len(tc.links) {
+			t.Errorf("Seek(%d): links %+v reported, want starts %v", tc.pos, links, tc.links)
+			continue
+		}
+		for i, l := range links {
+			if l.Start != tc.links[i] || l.Format.NumChannels != 1+l.Start/second {
+				t.Errorf("Seek(%d): link %+v reported, want the link at %d", tc.pos, l, tc.links[i])
+			}
+		}
+	}
+}
+
+func TestChainNotSeekable(t *testing.T) {
+	chain, samples := testLinks(t)
+	want := append(append([][2]float64(nil), samples[0]...), samples[1]...)
+	d, _, err := Open(readCloser{bytes.NewReader(chain)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var starts []int
+	d.OnLink(func(l Link) { starts = append(starts, l.Start) })
+	if d.Len() != 0 || d.Seek(0) == nil {
+		t.Error("stream of a reader without io.Seeker has a length or is seekable")
+	}
+	compare(t, "chain", readAll(t, d), want)
+	if len(starts) != 1 || starts[0] != len(samples[0]) {
+		t.Errorf("links reported at %v, want %d", starts, len(samples[0]))
+	}
+}
+
+func TestDecodeRegistered(t *testing.T) {
+	chain, samples := testLinks(t)
+	s, format, name, err := megasound.Decode(bytes.NewReader(chain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "vorbis" || format.NumChannels != 1 || s.Len() != len(samples[0])+len(samples[1]) {
+		t.Errorf("decoded %q %+v of %d samples", name, format, s.Len())
+	}
+}
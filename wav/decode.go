@@ -8,12 +8,25 @@ import (
 	"time"
 
 	"github.com/rickcollette/megasound"
+	"github.com/rickcollette/megasound/tags"
 	pkgerrors "github.com/pkg/errors"
 )
 
+func init() {
+	megasound.RegisterFormat("wav", func(head []byte) bool {
+		return len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WAVE"
+	}, func(rc io.ReadCloser) (megasound.StreamSeekCloser, megasound.Format, error) {
+		return Decode(rc)
+	})
+}
+
 // Decode takes a Reader containing audio data in WAVE format and returns a StreamSeekCloser,
 // which streams that audio. The Seek method will panic if rc is not io.Seeker.
 //
+// The returned StreamSeekCloser implements tags.Tagger. Its Tags method returns the tags of the
+// LIST INFO and ID3v2 chunks of the file. If r is not io.Seeker, only chunks before the data
+// chunk are read.
+//
 // Do not close the supplied Reader, instead, use the Close method of the returned
 // StreamSeekCloser when you want to release the resources.
 func Decode(r io.Reader) (s megasound.StreamSeekCloser, format megasound.Format, err error) {
@@ -46,6 +59,7 @@ func Decode(r io.Reader) (s megasound.StreamSeekCloser, format megasound.Format,
 	}
 
 	// check each formtypes
+	var info tags.Tags // tags of a LIST INFO chunk
 	ft := [4]byte{0, 0, 0, 0}
 	var fs int32
 	d.hsz = 4 + 4 + 4 // add size of (RiffMark + FileSize + WaveMark)
@@ -128,6 +142,16 @@ func Decode(r io.Reader) (s megasound.StreamSeekCloser, format megasound.Format,
 			if err := binary.Read(r, binary.LittleEndian, trash); err != nil {
 				return nil, megasound.Format{}, pkgerrors.Wrap(err, "wav: missing unknown chunk body")
 			}
+			switch string(ft[:]) {
+			case "LIST":
+				if t, err := tags.ParseRIFFInfo(trash); err == nil {
+					info = t
+				}
+			case "id3 ", "ID3 ":
+				if t, err := tags.ParseID3v2(trash); err == nil {
+					d.tags = t
+				}
+			}
 			d.hsz += 4 + fs //add size of (Unknown formtype + formsize)
 		}
 	}
@@ -152,6 +176,19 @@ func Decode(r io.Reader) (s megasound.StreamSeekCloser, format megasound.Format,
 		NumChannels: int(d.h.NumChans),
 		Precision:   int(d.h.BitsPerSample / 8),
 	}
+	d.tags.Merge(info)
+	if rs, ok := r.(io.ReadSeeker); ok {
+		// metadata chunks are often written after the data chunk
+		if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			return nil, megasound.Format{}, pkgerrors.Wrap(err, "wav")
+		}
+		if t, err := tags.Read(rs); err == nil {
+			d.tags = t
+		}
+		if _, err := rs.Seek(int64(d.hsz), io.SeekStart); err != nil {
+			return nil, megasound.Format{}, pkgerrors.Wrap(err, "wav")
+		}
+	}
 	return &d, format, nil
 }
 
@@ -195,11 +232,12 @@ type header struct {
 }
 
 type decoder struct {
-	r   io.Reader
-	h   header
-	hsz int32
-	pos int32
-	err error
+	r    io.Reader
+	h    header
+	hsz  int32
+	pos  int32
+	err  error
+	tags tags.Tags
 }
 
 func (d *decoder) Stream(samples [][2]float64) (n int, ok bool) {
@@ -286,6 +324,11 @@ func (d *decoder) Seek(p int) error {
 	return nil
 }
 
+// Tags returns the tags of the LIST INFO and ID3v2 chunks of the file.
+func (d *decoder) Tags() tags.Tags {
+	return d.tags
+}
+
 func (d *decoder) Close() error {
 	if closer, ok := d.r.(io.Closer); ok {
 		err := closer.Close()